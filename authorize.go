@@ -0,0 +1,48 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identify
+
+import (
+	"github.com/akb/identify/internal/identity"
+)
+
+// Authorize checks that an authenticated identity holds a role granting a
+// permission, for commands that act on identities other than itself.
+func Authorize(store identity.Store, i identity.PublicIdentity, permission string) error {
+	permitted, err := store.Permits(i.Roles(), permission)
+	if err != nil {
+		return err
+	}
+	if !permitted {
+		return ErrorUnauthorized
+	}
+	return nil
+}
+
+// AuthorizeTarget checks that an authenticated identity may act on another
+// identity, by id or alias. Identities may always act on themselves.
+func AuthorizeTarget(store identity.Store, i identity.PublicIdentity, target, permission string) error {
+	public, err := store.GetIdentity(target)
+	if err != nil {
+		return err
+	}
+	if public.String() == i.String() {
+		return nil
+	}
+	return Authorize(store, i, permission)
+}
@@ -17,6 +17,7 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/justinas/nosurf v1.1.1
 	github.com/kr/pty v1.1.8 // indirect
+	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.6.1 // indirect
 	github.com/tebeka/selenium v0.9.9
@@ -0,0 +1,447 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package backup writes encrypted archives of identify's databases and
+// restores them.
+//
+// An archive starts with a line of JSON describing how its key is derived from
+// a passphrase, followed by a stream of authenticated chunks encrypted with
+// that key. The stream holds a sequence of JSON records: a manifest naming the
+// databases in the archive, then for each database a record naming it, its
+// buckets each followed by their entries, and a record with the number of
+// entries and a SHA-256 checksum of the database.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/stream"
+)
+
+const (
+	archiveFormat  = "identify-backup"
+	archiveVersion = 1
+)
+
+const (
+	recordManifest = "manifest"
+	recordDatabase = "database"
+	recordBucket   = "bucket"
+	recordEntry    = "entry"
+	recordEnd      = "end"
+)
+
+var (
+	ErrorInvalidArchive = fmt.Errorf("file is not an identify backup or is corrupt")
+	ErrorPassphrase     = fmt.Errorf("unable to decrypt backup with this passphrase")
+)
+
+// ErrorChecksum is returned when the contents of a database in an archive
+// don't match the checksum recorded with them.
+type ErrorChecksum struct {
+	Database string
+}
+
+func (err ErrorChecksum) Error() string {
+	return fmt.Sprintf("checksum of database '%s' doesn't match its contents", err.Database)
+}
+
+// ErrorUnknownDatabase is returned when restoring an archive that holds a
+// database there's nowhere to restore to.
+type ErrorUnknownDatabase struct {
+	Database string
+}
+
+func (err ErrorUnknownDatabase) Error() string {
+	return fmt.Sprintf("backup holds unknown database '%s'", err.Database)
+}
+
+// Database is a database to back up, named so that it can be restored to the
+// right place.
+type Database struct {
+	Name string
+	DB   database.DB
+}
+
+// Summary describes the contents of an archive.
+type Summary struct {
+	Created   time.Time
+	Databases []DatabaseSummary
+}
+
+type DatabaseSummary struct {
+	Name    string
+	Buckets int
+	Entries int
+}
+
+type header struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+	Prefix    []byte `json:"prefix"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+type record struct {
+	Type      string     `json:"type"`
+	Created   *time.Time `json:"created,omitempty"`
+	Databases []string   `json:"databases,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Key       []byte     `json:"key,omitempty"`
+	Value     []byte     `json:"value,omitempty"`
+	Entries   int        `json:"entries,omitempty"`
+	Checksum  []byte     `json:"checksum,omitempty"`
+}
+
+// Write takes a consistent snapshot of each database, in a read transaction,
+// and writes them to an archive encrypted with a key derived from the
+// passphrase.
+func Write(w io.Writer, passphrase string, databases []Database) (*Summary, error) {
+	h := header{
+		Format:    archiveFormat,
+		Version:   archiveVersion,
+		Algorithm: "argon2id",
+		Salt:      make([]byte, 16),
+		Time:      identity.DefaultKDFParameters.Time,
+		Memory:    identity.DefaultKDFParameters.Memory,
+		Threads:   identity.DefaultKDFParameters.Threads,
+		Prefix:    make([]byte, stream.PrefixSize),
+		ChunkSize: stream.DefaultChunkSize,
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Prefix); err != nil {
+		return nil, err
+	}
+
+	marshaled, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(marshaled, '\n')); err != nil {
+		return nil, err
+	}
+
+	key, prefix := h.key(passphrase)
+	sw, err := stream.NewWriter(w, key, prefix, h.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(sw)
+
+	summary := Summary{Created: time.Now().UTC()}
+	manifest := record{Type: recordManifest, Created: &summary.Created}
+	for _, d := range databases {
+		manifest.Databases = append(manifest.Databases, d.Name)
+	}
+	if err := encoder.Encode(manifest); err != nil {
+		return nil, err
+	}
+
+	for _, d := range databases {
+		s, err := writeDatabase(encoder, d)
+		if err != nil {
+			return nil, fmt.Errorf("%s database: %s", d.Name, err)
+		}
+		summary.Databases = append(summary.Databases, *s)
+	}
+
+	if err := sw.Close(); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func writeDatabase(encoder *json.Encoder, d Database) (*DatabaseSummary, error) {
+	summary := DatabaseSummary{Name: d.Name}
+	checksum := sha256.New()
+
+	err := d.DB.View(func(tx database.Tx) error {
+		if err := encoder.Encode(record{Type: recordDatabase, Name: d.Name}); err != nil {
+			return err
+		}
+
+		err := tx.ForEach(func(name []byte, b database.Bucket) error {
+			summary.Buckets++
+			sum(checksum, recordBucket, name)
+			if err := encoder.Encode(record{Type: recordBucket, Key: name}); err != nil {
+				return err
+			}
+
+			return b.ForEach(func(key, value []byte) error {
+				summary.Entries++
+				sum(checksum, recordEntry, key, value)
+				return encoder.Encode(record{Type: recordEntry, Key: key, Value: value})
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		return encoder.Encode(record{
+			Type:     recordEnd,
+			Name:     d.Name,
+			Entries:  summary.Entries,
+			Checksum: checksum.Sum(nil),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// WriteFile writes an archive to a file, which is only put in place once the
+// archive is complete.
+func WriteFile(path, passphrase string, databases []Database) (*Summary, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	summary, err := Write(w, passphrase, databases)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Verify decrypts an archive and checks the contents of every database in it
+// against their checksums.
+func Verify(r io.Reader, passphrase string) (*Summary, error) {
+	return read(r, passphrase, func(string) (database.DB, error) { return nil, nil })
+}
+
+// Restore verifies an archive and then restores each database in it to the
+// database of the same name, each of which must be empty. Each database is
+// restored in a single transaction.
+func Restore(r io.ReadSeeker, passphrase string, databases map[string]database.DB) (*Summary, error) {
+	summary, err := Verify(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// every database is checked before any is restored, so that a database
+	// that isn't empty doesn't leave the others restored
+	for _, s := range summary.Databases {
+		db := databases[s.Name]
+		if db == nil {
+			return nil, ErrorUnknownDatabase{s.Name}
+		}
+		if err := db.View(requireEmpty); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return read(r, passphrase, func(name string) (database.DB, error) {
+		return databases[name], nil
+	})
+}
+
+// read decodes an archive, writing each database in it to the database
+// returned by open, if any.
+func read(r io.Reader, passphrase string, open func(string) (database.DB, error)) (*Summary, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, ErrorInvalidArchive
+	}
+
+	var h header
+	if err := json.Unmarshal(line, &h); err != nil || !h.valid() {
+		return nil, ErrorInvalidArchive
+	}
+
+	key, prefix := h.key(passphrase)
+	sr, err := stream.NewReader(br, key, prefix, h.ChunkSize)
+	if err != nil {
+		return nil, ErrorInvalidArchive
+	}
+	decoder := json.NewDecoder(sr)
+
+	var manifest record
+	if err := decoder.Decode(&manifest); err == stream.ErrorCorrupt {
+		return nil, ErrorPassphrase
+	} else if err != nil || manifest.Type != recordManifest || manifest.Created == nil {
+		return nil, ErrorInvalidArchive
+	}
+
+	summary := Summary{Created: *manifest.Created}
+	for _, name := range manifest.Databases {
+		db, err := open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var s *DatabaseSummary
+		if db == nil {
+			s, err = readDatabase(decoder, name, nil)
+		} else {
+			err = db.Update(func(tx database.Tx) error {
+				if err := requireEmpty(tx); err != nil {
+					return err
+				}
+				var err error
+				s, err = readDatabase(decoder, name, tx)
+				return err
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		summary.Databases = append(summary.Databases, *s)
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrorInvalidArchive
+	}
+	return &summary, nil
+}
+
+// readDatabase decodes the records of a database, writing them to a
+// transaction if one is given.
+func readDatabase(decoder *json.Decoder, name string, tx database.Tx) (*DatabaseSummary, error) {
+	var begin record
+	if err := decoder.Decode(&begin); err != nil || begin.Type != recordDatabase || begin.Name != name {
+		return nil, ErrorInvalidArchive
+	}
+
+	summary := DatabaseSummary{Name: name}
+	checksum := sha256.New()
+	var bucket database.Bucket
+	for {
+		var r record
+		if err := decoder.Decode(&r); err != nil {
+			return nil, ErrorInvalidArchive
+		}
+
+		switch r.Type {
+		case recordBucket:
+			summary.Buckets++
+			sum(checksum, recordBucket, r.Key)
+			if tx != nil {
+				var err error
+				if bucket, err = tx.CreateBucketIfNotExists(r.Key); err != nil {
+					return nil, err
+				}
+			}
+
+		case recordEntry:
+			if summary.Buckets == 0 {
+				return nil, ErrorInvalidArchive
+			}
+			summary.Entries++
+			sum(checksum, recordEntry, r.Key, r.Value)
+			if tx != nil {
+				if err := bucket.Put(r.Key, r.Value); err != nil {
+					return nil, err
+				}
+			}
+
+		case recordEnd:
+			if r.Name != name || r.Entries != summary.Entries ||
+				!bytes.Equal(r.Checksum, checksum.Sum(nil)) {
+				return nil, ErrorChecksum{name}
+			}
+			return &summary, nil
+
+		default:
+			return nil, ErrorInvalidArchive
+		}
+	}
+}
+
+func requireEmpty(tx database.Tx) error {
+	empty := true
+	err := tx.ForEach(func([]byte, database.Bucket) error {
+		empty = false
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return database.ErrorNotEmpty
+	}
+	return nil
+}
+
+// sum adds a record to a checksum, prefixing each value with its length so
+// that different records can't have the same sum.
+func sum(h hash.Hash, kind string, values ...[]byte) {
+	h.Write([]byte(kind))
+	for _, v := range values {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(v)))
+		h.Write(length[:])
+		h.Write(v)
+	}
+}
+
+// valid checks the header before its key is derived, bounding the costs an
+// archive can make restoring it take as identity envelopes are.
+func (h header) valid() bool {
+	params := identity.KDFParameters{Time: h.Time, Memory: h.Memory, Threads: h.Threads}
+	return h.Format == archiveFormat && h.Version == archiveVersion &&
+		h.Algorithm == "argon2id" && len(h.Salt) > 0 && len(h.Prefix) == stream.PrefixSize &&
+		params.Valid()
+}
+
+func (h header) key(passphrase string) (*[32]byte, *[stream.PrefixSize]byte) {
+	var key [32]byte
+	copy(key[:], argon2.IDKey([]byte(passphrase), h.Salt, h.Time, h.Memory, h.Threads, 32))
+
+	var prefix [stream.PrefixSize]byte
+	copy(prefix[:], h.Prefix)
+	return &key, &prefix
+}
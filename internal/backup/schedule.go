@@ -0,0 +1,77 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Schedule writes an archive of the databases to a directory at an interval
+// until the context is done, keeping only the most recent archives. Failed
+// backups are logged and retried at the next interval.
+func Schedule(
+	ctx context.Context, dir string, interval time.Duration, keep int,
+	passphrase string, databases []Database,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := scheduled(dir, keep, passphrase, databases); err != nil {
+				log.Printf("error while backing up databases: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+func scheduled(dir string, keep int, passphrase string, databases []Database) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// archives are named by when they were made, so they sort by age
+	path := filepath.Join(dir, fmt.Sprintf("identify-%s.backup",
+		time.Now().UTC().Format("20060102T150405.000Z")))
+	if _, err := WriteFile(path, passphrase, databases); err != nil {
+		return err
+	}
+	log.Printf("backed up databases to %s\n", path)
+
+	archives, err := filepath.Glob(filepath.Join(dir, "identify-*.backup"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(archives)
+	for len(archives) > keep {
+		if err := os.Remove(archives[0]); err != nil {
+			return err
+		}
+		archives = archives[1:]
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package alias
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type ListAliasesCommand struct{}
+
+func (ListAliasesCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify alias list <id>")
+	fmt.Println("")
+	fmt.Println("List the aliases of an identity")
+}
+
+func (c ListAliasesCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "alias list requires an identity"}
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	public, err := store.GetIdentity(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, a := range public.Aliases() {
+		s.Println(a)
+	}
+
+	return nil
+}
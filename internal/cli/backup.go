@@ -0,0 +1,107 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/backup"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/token"
+)
+
+type BackupCommand struct{}
+
+func (BackupCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify backup <file>")
+	fmt.Println("")
+	fmt.Println("Write a consistent snapshot of the identity and token databases to an")
+	fmt.Println("archive encrypted with a backup passphrase, which is read from")
+	fmt.Println("IDENTIFY_BACKUP_PASSPHRASE if it's set. Restore it with 'identify restore'.")
+	fmt.Println("")
+	fmt.Println("Bolt databases can only be backed up while no other process has them open;")
+	fmt.Println("use 'identify listen -backup-interval' to back them up while serving, or")
+	fmt.Println("SQLite databases, which can be backed up at any time.")
+}
+
+func (c BackupCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "backup requires a file to write to"}
+	}
+
+	passphrase := config.GetBackupPassphrase(s)
+	if passphrase == "" {
+		var err error
+		passphrase, err = identify.ReadConfirmedPassphrase(s,
+			"Backup passphrase: ", "Confirm backup passphrase: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	tokenDBPath, err := config.GetTokenDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	tokenStore, err := token.NewLocalStore(tokenDBPath)
+	if err != nil {
+		return err
+	}
+	defer tokenStore.Close()
+
+	summary, err := backup.WriteFile(args[0], passphrase, []backup.Database{
+		{Name: "identity", DB: store.Database()},
+		{Name: "token", DB: tokenStore.Database()},
+	})
+	if err != nil {
+		return err
+	}
+
+	s.Printf("Backed up %s to %s\n", describe(summary), args[0])
+	return nil
+}
+
+func describe(summary *backup.Summary) string {
+	var databases []string
+	for _, d := range summary.Databases {
+		databases = append(databases, fmt.Sprintf("the %s database (%d buckets, %d entries)",
+			d.Name, d.Buckets, d.Entries))
+	}
+	return strings.Join(databases, " and ")
+}
@@ -0,0 +1,110 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type DecryptCommand struct {
+	output *string
+}
+
+func (c *DecryptCommand) Flags(f *flag.FlagSet) {
+	c.output = f.String("output", "", "file to write the decrypted file to")
+}
+
+func (DecryptCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify decrypt [-output=<file>] [<file>]")
+	fmt.Println("")
+	fmt.Println("Decrypt a file encrypted with 'identify encrypt', or standard input if")
+	fmt.Println("no file or '-' is given. The sender must be in the identity database.")
+	fmt.Println("The result is written to the output file if given, which is removed if")
+	fmt.Println("the encrypted file turns out to be modified or truncated, or printed")
+	fmt.Println("otherwise.")
+}
+
+func (c DecryptCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) > 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "decrypt accepts at most one file"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	data, err := openData(args)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	encrypted := bufio.NewReader(data)
+	header, err := identity.ReadEncryptionHeader(encrypted)
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	sender, err := store.GetIdentity(header.Sender)
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := i.Decrypt(header, sender, encrypted)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutput(*c.output, s, func(w io.Writer) error {
+		_, err := io.Copy(w, decrypted)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if *c.output != "" {
+		s.Printf("Decrypted %s from %s\n", *c.output, sender)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package deletecmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type DeleteAttributeCommand struct{}
+
+func (DeleteAttributeCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify delete attribute <name>")
+	fmt.Println("")
+	fmt.Println("Remove a profile attribute from an identity")
+}
+
+func (c DeleteAttributeCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "delete attribute requires the name of an attribute"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.RemoveAttribute(i.String(), args[0])
+}
@@ -0,0 +1,93 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package deletecmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type DeleteIdentityCommand struct{}
+
+func (DeleteIdentityCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify delete identity [<id>]
+
+Permanently delete an identity, the authenticated identity by default. Its
+aliases are freed, and its id is never reused. Deleting another identity
+requires the identities:manage permission, and every permission granted by its
+roles. The server identity and the last enabled administrator can't be deleted.
+
+The identity leaves its groups, which are re-keyed so that it can't read what
+is shared with them later. Only members of a group can re-encrypt its secrets,
+so other identities must have it removed from such groups first.`)
+}
+
+func (c DeleteIdentityCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	target := i.String()
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	var confirmation string
+	s.Printf("Permanently delete identity %s? ", target)
+	if _, err := s.Scan(&confirmation); err != nil {
+		return err
+	}
+	confirmation = strings.ToLower(strings.TrimSpace(confirmation))
+	if len(confirmation) == 0 || confirmation[0] != 'y' {
+		return nil
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	err = identify.AuthorizeTarget(store, i, target, identity.PermissionManageIdentities)
+	if err != nil {
+		return err
+	}
+
+	if err := store.DeleteIdentity(i, target); err != nil {
+		return err
+	}
+
+	s.Printf("Deleted identity %s\n", target)
+
+	return nil
+}
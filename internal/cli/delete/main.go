@@ -21,6 +21,8 @@ import (
 	"fmt"
 
 	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
 )
 
 type DeleteCommand struct{}
@@ -35,6 +37,9 @@ func (DeleteCommand) Help() {
 
 func (DeleteCommand) Subcommands() cli.CLI {
 	return cli.CLI{
-		"token": &DeleteTokenCommand{},
+		"token":     &DeleteTokenCommand{},
+		"identity":  identify.RequiresCLIUserAuth(&DeleteIdentityCommand{}),
+		"attribute": identify.RequiresCLIUserAuth(&DeleteAttributeCommand{}),
+		"totp":      identify.RequiresCLIUserAuth(&DeleteTOTPCommand{}),
 	}
 }
@@ -0,0 +1,80 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package disable
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type DisableIdentityCommand struct{}
+
+func (DisableIdentityCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify disable identity [<id>]
+
+Disable an identity, the authenticated identity by default. Disabled identities
+can not authenticate or be issued tokens, but keep their aliases. Use
+'identify enable identity' to reactivate it. Disabling another identity
+requires the identities:manage permission, and every permission granted by its
+roles. The server identity and the last enabled administrator can't be
+disabled.`)
+}
+
+func (c DisableIdentityCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	target := i.String()
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	err = identify.AuthorizeTarget(store, i, target, identity.PermissionManageIdentities)
+	if err != nil {
+		return err
+	}
+
+	if err := store.DisableIdentity(i, target); err != nil {
+		return err
+	}
+
+	s.Printf("Disabled identity %s\n", target)
+
+	return nil
+}
@@ -0,0 +1,75 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package enable
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type EnableIdentityCommand struct{}
+
+func (EnableIdentityCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify enable identity <id>
+
+Reactivate a disabled identity. Requires the identities:manage permission, and
+every permission granted by the identity's roles.`)
+}
+
+func (c EnableIdentityCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "enable identity requires the id of an identity"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := identify.Authorize(store, i, identity.PermissionManageIdentities); err != nil {
+		return err
+	}
+
+	if err := store.EnableIdentity(i, args[0]); err != nil {
+		return err
+	}
+
+	s.Printf("Enabled identity %s\n", args[0])
+
+	return nil
+}
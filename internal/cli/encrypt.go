@@ -0,0 +1,133 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type EncryptCommand struct {
+	recipients *string
+	output     *string
+}
+
+func (c *EncryptCommand) Flags(f *flag.FlagSet) {
+	c.recipients = f.String("recipients", "", "comma-separated ids or aliases to encrypt to")
+	c.output = f.String("output", "", "file to write the encrypted file to")
+}
+
+func (EncryptCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify encrypt -recipients=<id>[,<id>...] [-output=<file>] [<file>]")
+	fmt.Println("")
+	fmt.Println("Encrypt a file, or standard input if no file or '-' is given, so that")
+	fmt.Println("each of the recipients can decrypt it with 'identify decrypt'. Files of")
+	fmt.Println("any size are encrypted in chunks without being read into memory. The")
+	fmt.Println("result is written to the output file if given, or printed otherwise.")
+	fmt.Println("")
+	fmt.Println("Recipients must decrypt files before they rotate their keys.")
+}
+
+func (c EncryptCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) > 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "encrypt accepts at most one file"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var recipients []identity.PublicIdentity
+	for _, id := range identity.SplitAliases(*c.recipients) {
+		recipient, err := store.GetIdentity(id)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	data, err := openData(args)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	return writeOutput(*c.output, s, func(w io.Writer) error {
+		encrypted, err := i.Encrypt(w, recipients)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(encrypted, data); err != nil {
+			return err
+		}
+		return encrypted.Close()
+	})
+}
+
+// writeOutput calls write with the output file, which is removed if write
+// fails, or with standard output if no file is given.
+func writeOutput(output string, s cli.System, write func(io.Writer) error) error {
+	if output == "" {
+		return write(systemWriter{s})
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(output)
+		return err
+	}
+	return f.Close()
+}
+
+// systemWriter writes to the output of a command.
+type systemWriter struct {
+	cli.System
+}
+
+func (w systemWriter) Write(p []byte) (int, error) {
+	return w.Print(string(p))
+}
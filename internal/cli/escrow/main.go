@@ -0,0 +1,47 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package escrow
+
+import (
+	"fmt"
+
+	"github.com/akb/go-cli"
+	"github.com/akb/identify"
+)
+
+type EscrowCommand struct{}
+
+func (EscrowCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify escrow <split|recover> <trustee>...
+
+Escrow the private keys of an identity with trustees, any threshold of whom
+can recover the identity together without any one of them holding its keys.
+
+Subcommands:
+split <trustee>...    split the authenticated identity into shares sealed to each trustee
+recover <trustee>...  recover an escrowed identity with the passphrases of its trustees`)
+}
+
+func (c EscrowCommand) Subcommands() cli.CLI {
+	return cli.CLI{
+		"split":   identify.RequiresCLIUserAuth(&SplitCommand{}),
+		"recover": &RecoverCommand{},
+	}
+}
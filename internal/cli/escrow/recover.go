@@ -0,0 +1,99 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package escrow
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type RecoverCommand struct {
+	id *string
+}
+
+func (c *RecoverCommand) Flags(f *flag.FlagSet) {
+	c.id = f.String("id", "", "the escrowed identity")
+}
+
+func (RecoverCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify escrow recover -id=<id> <trustee>...")
+	fmt.Println("")
+	fmt.Println("Recover an escrowed identity and set its passphrase. Each trustee is")
+	fmt.Println("prompted for their passphrase in turn.")
+}
+
+func (c RecoverCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) < 2 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "escrow recover requires at least two trustees"}
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var trustees []identity.PrivateIdentity
+	for _, id := range args {
+		public, err := store.GetIdentity(id)
+		if err != nil {
+			return err
+		}
+
+		s.Printf("Passphrase for %s: ", id)
+		passphrase, err := s.ReadPassword()
+		s.Println()
+		if err != nil {
+			return err
+		}
+
+		trustee, err := public.Authenticate(passphrase)
+		if err != nil {
+			return err
+		}
+		trustees = append(trustees, trustee)
+	}
+
+	passphrase, err := identify.ReadNewPassphrase(s)
+	if err != nil {
+		return err
+	}
+
+	private, err := store.RecoverEscrow(*c.id, trustees, passphrase)
+	if err != nil {
+		return err
+	}
+
+	s.Printf("Recovered identity %s\n", private)
+	return nil
+}
@@ -0,0 +1,88 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package escrow
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type SplitCommand struct {
+	threshold *int
+}
+
+func (c *SplitCommand) Flags(f *flag.FlagSet) {
+	c.threshold = f.Int("threshold", 2, "number of trustees required to recover the identity")
+}
+
+func (SplitCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify escrow split [-threshold=<m>] <trustee>...")
+	fmt.Println("")
+	fmt.Println("Split the private keys of an identity into shares sealed to each trustee.")
+	fmt.Println("Any threshold of the trustees can recover the identity. Splitting again")
+	fmt.Println("replaces the previous shares.")
+}
+
+func (c SplitCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) < 2 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "escrow split requires at least two trustees"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var trustees []identity.PublicIdentity
+	for _, id := range args {
+		trustee, err := store.GetIdentity(id)
+		if err != nil {
+			return err
+		}
+		trustees = append(trustees, trustee)
+	}
+
+	if err := store.EscrowIdentity(i, *c.threshold, trustees); err != nil {
+		return err
+	}
+
+	s.Printf("Escrowed %s: any %d of %d trustees can recover it.\n",
+		i, *c.threshold, len(trustees))
+	return nil
+}
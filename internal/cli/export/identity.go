@@ -0,0 +1,90 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package export
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type ExportIdentityCommand struct {
+	secrets *bool
+}
+
+func (c *ExportIdentityCommand) Flags(f *flag.FlagSet) {
+	c.secrets = f.Bool("secrets", false, "include the secrets of the identity")
+}
+
+func (ExportIdentityCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify export identity [-secrets] <file>")
+	fmt.Println("")
+	fmt.Println("Write an identity, its aliases and optionally its secrets to a bundle")
+	fmt.Println("encrypted with a bundle passphrase, which can be imported into another")
+	fmt.Println("identity database with 'identify import identity'.")
+}
+
+func (c ExportIdentityCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "export identity requires a file to write to"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	passphrase, err := identify.ReadConfirmedPassphrase(s,
+		"Bundle passphrase: ", "Confirm bundle passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	bundle, err := store.ExportIdentity(i, passphrase, *c.secrets)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(args[0], bundle, 0600); err != nil {
+		return err
+	}
+
+	s.Printf("Exported identity %s to %s\n", i, args[0])
+	return nil
+}
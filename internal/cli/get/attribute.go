@@ -0,0 +1,98 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package get
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/identity"
+)
+
+type GetAttributeCommand struct{}
+
+func (GetAttributeCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify get attribute <name>")
+	fmt.Println("")
+	fmt.Println("Get the value of a profile attribute, opening it if it is sealed")
+}
+
+func (c GetAttributeCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "get attribute requires the name of an attribute"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	for _, a := range i.Attributes() {
+		if a.Name == args[0] {
+			value, err := a.Open(i)
+			if err != nil {
+				return err
+			}
+			s.Println(value)
+			return nil
+		}
+	}
+
+	return identity.ErrorUnknownAttribute
+}
+
+type GetAttributesCommand struct{}
+
+func (GetAttributesCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify get attributes")
+	fmt.Println("")
+	fmt.Println("List the profile attributes of an identity, one name=value pair per line")
+}
+
+func (c GetAttributesCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	for _, a := range i.Attributes() {
+		value, err := a.Open(i)
+		if err != nil {
+			return err
+		}
+
+		line := fmt.Sprintf("%s=%s", a.Name, value)
+		if a.Sealed {
+			line += " (sealed)"
+		}
+		if a.Claim {
+			line += " (claim)"
+		}
+		s.Println(line)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package get
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/did"
+	"github.com/akb/identify/internal/identity"
+)
+
+type GetDIDCommand struct {
+	document *bool
+	seal     *bool
+}
+
+func (c *GetDIDCommand) Flags(f *flag.FlagSet) {
+	c.document = f.Bool("document", false, "print the DID document instead")
+	c.seal = f.Bool("seal", false, "use the did:key of the seal key")
+}
+
+func (GetDIDCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify get did [-document] [-seal] <id>")
+	fmt.Println("")
+	fmt.Println("Print the did:key identifier derived from the Ed25519 key of an identity,")
+	fmt.Println("or its DID document. With -seal, the did:key of its seal key is used")
+	fmt.Println("instead, whose document lists the key for key agreement. The identity may")
+	fmt.Println("be given by id, alias or a did:key identifier of one of its current keys.")
+}
+
+func (c GetDIDCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "get did requires an identity"}
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	public, err := store.GetIdentity(args[0])
+	if err != nil {
+		return err
+	}
+
+	identifier := identity.DID(public)
+	if *c.seal {
+		identifier = identity.SealDID(public)
+	}
+
+	if !*c.document {
+		s.Println(identifier)
+		return nil
+	}
+
+	document, err := did.NewDocument(identifier)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.Println(string(marshaled))
+	return nil
+}
@@ -36,6 +36,10 @@ func (GetCommand) Help() {
 
 func (c GetCommand) Subcommands() cli.CLI {
 	return cli.CLI{
-		"secret": identify.RequiresCLIUserAuth(&GetSecretCommand{}),
+		"secret":     identify.RequiresCLIUserAuth(&GetSecretCommand{}),
+		"attribute":  identify.RequiresCLIUserAuth(&GetAttributeCommand{}),
+		"attributes": identify.RequiresCLIUserAuth(&GetAttributesCommand{}),
+		"did":        &GetDIDCommand{},
+		"ssh-key":    &GetSSHKeyCommand{},
 	}
 }
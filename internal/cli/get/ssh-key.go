@@ -0,0 +1,83 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package get
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type GetSSHKeyCommand struct {
+	certAuthority *string
+}
+
+func (c *GetSSHKeyCommand) Flags(f *flag.FlagSet) {
+	c.certAuthority = f.String("cert-authority", "",
+		"print a known_hosts line trusting the key to certify hosts matching a pattern")
+}
+
+func (GetSSHKeyCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify get ssh-key [-cert-authority=<host pattern>] <id>")
+	fmt.Println("")
+	fmt.Println("Print the Ed25519 key of an identity as a line of an SSH authorized_keys")
+	fmt.Println("file, commented with the identity's id. The key of the identity signing SSH")
+	fmt.Println("certificates, usually the server identity, is printed the same way for")
+	fmt.Println("sshd's TrustedUserCAKeys file, or as a @cert-authority line of a known_hosts")
+	fmt.Println("file given the hosts it certifies.")
+}
+
+func (c GetSSHKeyCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "get ssh-key requires an identity"}
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	public, err := store.GetIdentity(args[0])
+	if err != nil {
+		return err
+	}
+
+	line, err := identity.AuthorizedKey(public)
+	if err != nil {
+		return err
+	}
+	if *c.certAuthority != "" {
+		line = fmt.Sprintf("@cert-authority %s %s", *c.certAuthority, line)
+	}
+	s.Println(line)
+	return nil
+}
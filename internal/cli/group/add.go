@@ -0,0 +1,74 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package group
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type AddMembersCommand struct{}
+
+func (AddMembersCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify group add <group> <id>...")
+	fmt.Println("")
+	fmt.Println("Add identities to a group owned by the authenticated identity. New")
+	fmt.Println("members can read the secrets already shared with the group.")
+}
+
+func (c AddMembersCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) < 2 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "group add requires a group and at least one identity"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for _, id := range args[1:] {
+		member, err := store.GetIdentity(id)
+		if err != nil {
+			return err
+		}
+		if err := store.AddGroupMember(i, args[0], member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
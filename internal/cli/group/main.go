@@ -0,0 +1,55 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package group
+
+import (
+	"fmt"
+
+	"github.com/akb/go-cli"
+	"github.com/akb/identify"
+)
+
+type GroupCommand struct{}
+
+func (GroupCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify group <new|delete|add|remove|members|list> [<group>] [<id>...]
+
+Manage groups of identities. Groups are referred to by id or name, and
+identities by id or alias.
+
+Subcommands:
+new <name>               create a group owned by the authenticated identity
+delete <group>           delete a group owned by the authenticated identity
+add <group> <id>...      add identities to a group
+remove <group> <id>...   remove identities from a group
+members <group>          list the members of a group
+list <id>                list the groups of an identity`)
+}
+
+func (c GroupCommand) Subcommands() cli.CLI {
+	return cli.CLI{
+		"new":     identify.RequiresCLIUserAuth(&NewGroupCommand{}),
+		"delete":  identify.RequiresCLIUserAuth(&DeleteGroupCommand{}),
+		"add":     identify.RequiresCLIUserAuth(&AddMembersCommand{}),
+		"remove":  identify.RequiresCLIUserAuth(&RemoveMembersCommand{}),
+		"members": &MembersCommand{},
+		"list":    &ListGroupsCommand{},
+	}
+}
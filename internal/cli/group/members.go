@@ -0,0 +1,74 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package group
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type MembersCommand struct{}
+
+func (MembersCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify group members <group>")
+	fmt.Println("")
+	fmt.Println("List the members of a group, with their aliases. The owner is marked.")
+}
+
+func (c MembersCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "group members requires a group"}
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	g, err := store.GetGroup(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, id := range g.Members() {
+		line := id
+		if public, err := store.GetIdentity(id); err == nil && len(public.Aliases()) > 0 {
+			line = fmt.Sprintf("%s %s", id, strings.Join(public.Aliases(), ","))
+		}
+		if id == g.Owner {
+			line += " (owner)"
+		}
+		s.Println(line)
+	}
+	return nil
+}
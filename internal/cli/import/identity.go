@@ -0,0 +1,119 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package importcmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type ImportIdentityCommand struct {
+	id               *string
+	replace          *bool
+	skipTakenAliases *bool
+}
+
+func (c *ImportIdentityCommand) Flags(f *flag.FlagSet) {
+	c.id = f.String("id", "", "your identity, required to replace an existing identity")
+	c.replace = f.Bool("replace", false,
+		"replace an existing identity with the same id, and secrets with the same keys")
+	c.skipTakenAliases = f.Bool("skip-taken-aliases", false,
+		"import without aliases that belong to other identities")
+}
+
+func (ImportIdentityCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify import identity [-replace -id=<id>] [-skip-taken-aliases] <file>")
+	fmt.Println("")
+	fmt.Println("Load an identity bundle written by 'identify export identity'.")
+	fmt.Println("Replacing an existing identity requires authenticating as that identity,")
+	fmt.Println("or as an identity with the identities:manage permission. Roles are kept only")
+	fmt.Println("if the bundle holds the same signing key as the identity it replaces.")
+	fmt.Println("Secrets with the same keys are only replaced if they were shared with the")
+	fmt.Println("imported identity or with the authenticated one. Bundles holding the keys")
+	fmt.Println("of another identity are refused.")
+}
+
+func (c ImportIdentityCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "import identity requires a file to read from"}
+	}
+
+	if *c.replace && *c.id == "" {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "import identity -replace requires -id"}
+	}
+
+	bundle, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	s.Print("Bundle passphrase: ")
+	passphrase, err := s.ReadPassword()
+	s.Println()
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var replacer identity.PrivateIdentity
+	if *c.replace {
+		replacer, err = identify.Authenticate(store, *c.id, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	public, err := store.ImportIdentity(bundle, passphrase, identity.ImportOptions{
+		Replace:          *c.replace,
+		Replacer:         replacer,
+		SkipTakenAliases: *c.skipTakenAliases,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(public.Aliases()) == 0 {
+		s.Printf("Imported identity %s\n", public)
+	} else {
+		s.Printf("Imported identity %s, a.k.a. %s\n", public, strings.Join(public.Aliases(), ", "))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package list
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type ListIdentitiesCommand struct {
+	prefix *string
+	alias  *string
+	cursor *string
+	limit  *int
+}
+
+func (c *ListIdentitiesCommand) Flags(f *flag.FlagSet) {
+	c.prefix = f.String("prefix", "", "only list identities whose id or an alias starts with prefix")
+	c.alias = f.String("alias", "", "only list the identity with this alias")
+	c.cursor = f.String("cursor", "", "continue a previous listing from this cursor")
+	c.limit = f.Int("limit", identity.DefaultListLimit, "maximum number of identities to list")
+}
+
+func (ListIdentitiesCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify list identities [-prefix=<prefix>] [-alias=<alias>] [-cursor=<cursor>] [-limit=<n>]")
+	fmt.Println("")
+	fmt.Println("List identities, one per line, followed by their aliases.")
+	fmt.Println("If there are more identities, the cursor for the next page is printed last.")
+	fmt.Println("Requires the identities:list permission.")
+}
+
+func (c ListIdentitiesCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := identify.Authorize(store, i, identity.PermissionListIdentities); err != nil {
+		return err
+	}
+
+	identities, next, err := store.ListIdentities(identity.ListOptions{
+		Prefix: *c.prefix,
+		Alias:  *c.alias,
+		Cursor: *c.cursor,
+		Limit:  *c.limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, i := range identities {
+		line := i.String()
+		if len(i.Aliases()) > 0 {
+			line += " " + strings.Join(i.Aliases(), ",")
+		}
+		if i.Disabled() {
+			line += " (disabled)"
+		}
+		s.Println(line)
+	}
+
+	if next != "" {
+		s.Printf("next: -cursor=%s\n", next)
+	}
+
+	return nil
+}
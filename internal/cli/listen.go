@@ -20,32 +20,52 @@ package cli
 import (
 	"context"
 	"crypto/tls"
+	"flag"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/akb/go-cli"
 
 	"github.com/akb/identify"
+	"github.com/akb/identify/internal/backup"
 	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/database"
 	"github.com/akb/identify/internal/identity"
 	"github.com/akb/identify/internal/token"
 	"github.com/akb/identify/web"
 )
 
-type ListenCommand struct{}
+type ListenCommand struct {
+	backupInterval *time.Duration
+	backupDir      *string
+	backupKeep     *int
+}
+
+func (c *ListenCommand) Flags(f *flag.FlagSet) {
+	c.backupInterval = f.Duration("backup-interval", 0, "how often to back up the databases, if at all")
+	c.backupDir = f.String("backup-dir", "", "directory to keep backups in, beside the identity database by default")
+	c.backupKeep = f.Int("backup-keep", 7, "number of backups to keep")
+}
 
 func (ListenCommand) Help() {
 	fmt.Println("identify - authentication and authorization service")
 	fmt.Println("")
-	fmt.Println("Usage: identify listen")
+	fmt.Println("Usage: identify listen [-backup-interval=<duration>] [-backup-dir=<dir>] [-backup-keep=<n>]")
+	fmt.Println("")
+	fmt.Println("Listen for HTTPS traffic. With a backup interval, such as 24h, the identity")
+	fmt.Println("and token databases are also backed up while listening, encrypted with the")
+	fmt.Println("passphrase in IDENTIFY_BACKUP_PASSPHRASE.")
 	fmt.Println("")
-	fmt.Println("Listen for HTTPS traffic")
+	fmt.Println("Passkeys are only offered once IDENTIFY_WEBAUTHN_ORIGIN is set to the origin")
+	fmt.Println("browsers reach the server at, such as https://identify.example.com.")
 }
 
 func (c ListenCommand) Command(ctx context.Context, args []string, s cli.System) error {
 	address := config.GetHTTPAddress(s)
 	realm := config.GetRealm(s)
+	origin := config.GetWebAuthnOrigin(s)
 
 	dbPath, err := config.GetDBPath(s)
 	if err != nil {
@@ -67,6 +87,17 @@ func (c ListenCommand) Command(ctx context.Context, args []string, s cli.System)
 		return err
 	}
 
+	backupPassphrase := config.GetBackupPassphrase(s)
+	if *c.backupInterval > 0 && backupPassphrase == "" {
+		return fmt.Errorf("scheduled backups require a passphrase in IDENTIFY_BACKUP_PASSPHRASE")
+	}
+
+	backupDir := *c.backupDir
+	if backupDir == "" {
+		_, path := database.Parse(dbPath)
+		backupDir = filepath.Join(filepath.Dir(path), "backups")
+	}
+
 	store, err := identity.NewLocalStore(dbPath)
 	if err != nil {
 		return err
@@ -79,15 +110,28 @@ func (c ListenCommand) Command(ctx context.Context, args []string, s cli.System)
 	}
 	defer tokenStore.Close()
 
-	identity := identify.IdentityFromContext(ctx)
-	if identity == nil {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
 		return identify.ErrorUnauthorized
 	}
 
+	// the server identity becomes an administrator while there is none, as it
+	// was the only identity able to administer others before roles
+	if err := store.BootstrapAdministrator(i); err != nil {
+		return err
+	}
+
+	// the server identity signs SSH certificates, so its key is never served
+	// by `identify ssh-agent`
+	if err := store.RecordServerIdentity(i); err != nil {
+		return err
+	}
+
 	handler, err := web.NewHandler(&web.Config{
-		Identity:      identity,
+		Identity:      i,
 		IdentityStore: store,
 		TokenStore:    tokenStore,
+		Origin:        origin,
 	})
 	if err != nil {
 		return err
@@ -99,6 +143,14 @@ func (c ListenCommand) Command(ctx context.Context, args []string, s cli.System)
 		TLSConfig: &tls.Config{ServerName: realm},
 	}
 
+	if *c.backupInterval > 0 {
+		go backup.Schedule(ctx, backupDir, *c.backupInterval, *c.backupKeep, backupPassphrase,
+			[]backup.Database{
+				{Name: "identity", DB: store.Database()},
+				{Name: "token", DB: tokenStore.Database()},
+			})
+	}
+
 	go func() {
 		s.Printf("Listening for HTTP requests on %s...\n", address)
 		err = server.ListenAndServeTLS(certPath, keyPath)
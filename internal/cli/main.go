@@ -24,9 +24,22 @@ import (
 	"github.com/akb/go-cli"
 
 	"github.com/akb/identify"
+	"github.com/akb/identify/internal/cli/alias"
 	"github.com/akb/identify/internal/cli/delete"
+	"github.com/akb/identify/internal/cli/disable"
+	"github.com/akb/identify/internal/cli/enable"
+	"github.com/akb/identify/internal/cli/escrow"
+	"github.com/akb/identify/internal/cli/export"
 	"github.com/akb/identify/internal/cli/get"
+	"github.com/akb/identify/internal/cli/group"
+	"github.com/akb/identify/internal/cli/import"
+	"github.com/akb/identify/internal/cli/list"
 	"github.com/akb/identify/internal/cli/new"
+	"github.com/akb/identify/internal/cli/policy"
+	"github.com/akb/identify/internal/cli/role"
+	"github.com/akb/identify/internal/cli/rotate"
+	"github.com/akb/identify/internal/cli/secret"
+	"github.com/akb/identify/internal/cli/set"
 )
 
 type IdentifyCommand struct{}
@@ -42,9 +55,32 @@ Subcommands:`)
 
 func (IdentifyCommand) Subcommands() cli.CLI {
 	return map[string]cli.Command{
-		"new":    &newcmd.NewCommand{},
-		"get":    &get.GetCommand{},
-		"delete": &deletecmd.DeleteCommand{},
-		"listen": identify.RequiresCLIUserAuth(&ListenCommand{}),
+		"new":        &newcmd.NewCommand{},
+		"get":        &get.GetCommand{},
+		"list":       &list.ListCommand{},
+		"set":        &set.SetCommand{},
+		"rotate":     &rotate.RotateCommand{},
+		"recover":    &RecoverCommand{},
+		"delete":     &deletecmd.DeleteCommand{},
+		"disable":    &disable.DisableCommand{},
+		"enable":     &enable.EnableCommand{},
+		"alias":      &alias.AliasCommand{},
+		"escrow":     &escrow.EscrowCommand{},
+		"secret":     &secret.SecretCommand{},
+		"group":      &group.GroupCommand{},
+		"role":       &role.RoleCommand{},
+		"policy":     &policy.PolicyCommand{},
+		"export":     &export.ExportCommand{},
+		"import":     &importcmd.ImportCommand{},
+		"migrate":    &MigrateCommand{},
+		"backup":     &BackupCommand{},
+		"restore":    &RestoreCommand{},
+		"verify-log": &VerifyLogCommand{},
+		"encrypt":    identify.RequiresCLIUserAuth(&EncryptCommand{}),
+		"decrypt":    identify.RequiresCLIUserAuth(&DecryptCommand{}),
+		"sign":       identify.RequiresCLIUserAuth(&SignCommand{}),
+		"ssh-agent":  identify.RequiresCLIUserAuth(&SSHAgentCommand{}),
+		"verify":     &VerifyCommand{},
+		"listen":     identify.RequiresCLIUserAuth(&ListenCommand{}),
 	}
 }
@@ -0,0 +1,187 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/token"
+)
+
+type MigrateCommand struct {
+	from   *string
+	dryRun *bool
+	backup *bool
+}
+
+func (c *MigrateCommand) Flags(f *flag.FlagSet) {
+	c.from = f.String("from", "", "database to copy, rather than the identity database")
+	c.dryRun = f.Bool("dry-run", false, "list pending migrations without applying them")
+	c.backup = f.Bool("backup", true, "copy each database before migrating it")
+}
+
+func (MigrateCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify migrate [-dry-run] [-backup=false]")
+	fmt.Println("       identify migrate [-from=<location>] <location>")
+	fmt.Println("")
+	fmt.Println("Upgrade the schemas of the identity and token databases, after copying each")
+	fmt.Println("to a backup beside it. Databases are also upgraded whenever they're opened;")
+	fmt.Println("this lists the pending migrations and keeps a backup first.")
+	fmt.Println("")
+	fmt.Println("Given a location, copy the identity database, or another database such as")
+	fmt.Println("the token database, into a new one instead. Locations are paths to bolt")
+	fmt.Println("files, or paths prefixed with 'sqlite:' for SQLite databases, which several")
+	fmt.Println("processes may open at once. The new database must be empty. Point")
+	fmt.Println("IDENTIFY_DB_PATH or IDENTIFY_TOKEN_DB_PATH at it once it's been copied.")
+}
+
+func (c MigrateCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	switch {
+	case len(args) == 1 && !*c.dryRun:
+		return c.copy(args[0], s)
+	case len(args) == 0 && *c.from == "":
+		return c.upgrade(s)
+	}
+
+	c.Help()
+	return &cli.ExitError{Status: 1, Message: "migrate accepts either a destination or -dry-run"}
+}
+
+func (c MigrateCommand) upgrade(s cli.System) error {
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	tokenDBPath, err := config.GetTokenDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range []struct {
+		name       string
+		location   string
+		migrations []database.Migration
+	}{
+		{"identity", dbPath, identity.Migrations},
+		{"token", tokenDBPath, token.Migrations},
+	} {
+		if err := c.upgradeDatabase(d.name, d.location, d.migrations, s); err != nil {
+			return fmt.Errorf("%s database: %s", d.name, err)
+		}
+	}
+	return nil
+}
+
+func (c MigrateCommand) upgradeDatabase(
+	name, location string, migrations []database.Migration, s cli.System,
+) error {
+	db, err := database.Open(location)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, pending, err := database.Pending(db, migrations)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		s.Printf("The %s database is up to date at schema version %d\n", name, version)
+		return nil
+	}
+
+	s.Printf("The %s database is at schema version %d; pending migrations:\n", name, version)
+	for n, m := range pending {
+		s.Printf("%d %s\n", version+n+1, m.Description)
+	}
+	if *c.dryRun {
+		return nil
+	}
+
+	if *c.backup {
+		backup := fmt.Sprintf("%s.%s.bak", location, time.Now().UTC().Format("20060102T150405Z"))
+		if err := copyDatabase(backup, db); err != nil {
+			return fmt.Errorf("backup failed: %s", err)
+		}
+		s.Printf("Backed up the %s database to %s\n", name, backup)
+	}
+
+	if err := database.Migrate(db, migrations); err != nil {
+		return err
+	}
+	s.Printf("Migrated the %s database to schema version %d\n", name, len(migrations))
+	return nil
+}
+
+func (c MigrateCommand) copy(to string, s cli.System) error {
+	from := *c.from
+	if from == "" {
+		var err error
+		if from, err = config.GetDBPath(s); err != nil {
+			return err
+		}
+	}
+
+	if sameDatabase(from, to) {
+		return database.ErrorSameDatabase
+	}
+
+	source, err := database.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	if err := copyDatabase(to, source); err != nil {
+		return err
+	}
+
+	s.Printf("Copied %s to %s\n", from, to)
+	return nil
+}
+
+// copyDatabase copies a database into a new one at a location.
+func copyDatabase(location string, source database.DB) error {
+	destination, err := database.Open(location)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	return database.Copy(destination, source)
+}
+
+func sameDatabase(a, b string) bool {
+	_, a = database.Parse(a)
+	_, b = database.Parse(b)
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
@@ -56,17 +56,25 @@ func (c NewIdentityCommand) Command(ctx context.Context, args []string, s cli.Sy
 		return err
 	}
 
-	var aliases []string
-	if len(*c.alias) > 0 {
-		aliases = append(aliases, *c.alias)
+	aliases := identity.SplitAliases(*c.alias)
+	for _, a := range aliases {
+		if err := identity.ValidateAlias(a); err != nil {
+			return err
+		}
 	}
 
-	public, _, err := store.NewIdentity(string(passphrase), aliases)
+	public, private, err := store.NewIdentity(string(passphrase), aliases)
+	if err != nil {
+		return err
+	}
+
+	codes, err := store.GenerateRecoveryCodes(private)
 	if err != nil {
 		return err
 	}
 
 	s.Println(public.String())
+	printRecoveryCodes(s, codes)
 
 	return nil
 }
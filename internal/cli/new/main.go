@@ -36,15 +36,21 @@ Create new resources.
 
 Resources:
 identity
+recovery-codes
 secret
 certificate
+ssh-certificate
+totp
 `)
 }
 
 func (NewCommand) Subcommands() cli.CLI {
 	return cli.CLI{
-		"identity":    &NewIdentityCommand{},
-		"secret":      identify.RequiresCLIUserAuth(&NewSecretCommand{}),
-		"certificate": identify.RequiresCLIUserAuth(&NewCertificateCommand{}),
+		"identity":        &NewIdentityCommand{},
+		"recovery-codes":  identify.RequiresCLIUserAuth(&NewRecoveryCodesCommand{}),
+		"secret":          identify.RequiresCLIUserAuth(&NewSecretCommand{}),
+		"certificate":     identify.RequiresCLIUserAuth(&NewCertificateCommand{}),
+		"ssh-certificate": identify.RequiresCLIUserAuth(&NewSSHCertificateCommand{}),
+		"totp":            identify.RequiresCLIUserAuth(&NewTOTPCommand{}),
 	}
 }
@@ -0,0 +1,74 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package newcmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type NewRecoveryCodesCommand struct{}
+
+func (NewRecoveryCodesCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify new recovery-codes")
+	fmt.Println("")
+	fmt.Println("Replace the recovery codes of an identity with a new set.")
+	fmt.Println("Any unused recovery codes stop working.")
+}
+
+func (c NewRecoveryCodesCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	codes, err := store.GenerateRecoveryCodes(i)
+	if err != nil {
+		return err
+	}
+
+	printRecoveryCodes(s, codes)
+	return nil
+}
+
+func printRecoveryCodes(s cli.System, codes []string) {
+	s.Println()
+	s.Println("Recovery codes, each of which can be used once to set a new passphrase:")
+	for _, code := range codes {
+		s.Println(code)
+	}
+}
@@ -19,6 +19,7 @@ package newcmd
 
 import (
 	"context"
+	"flag"
 	"fmt"
 
 	"github.com/akb/go-cli"
@@ -28,14 +29,24 @@ import (
 	"github.com/akb/identify/internal/identity"
 )
 
-type NewSecretCommand struct{}
+type NewSecretCommand struct {
+	recipients *string
+	groups     *string
+}
+
+func (c *NewSecretCommand) Flags(f *flag.FlagSet) {
+	c.recipients = f.String("recipients", "",
+		"comma-separated ids or aliases of other identities that can read the secret")
+	c.groups = f.String("groups", "",
+		"comma-separated ids or names of groups whose members can read the secret")
+}
 
 func (NewSecretCommand) Help() {
 	fmt.Println("identify - authentication and authorization service")
 	fmt.Println("")
-	fmt.Println("Usage: identify new secret <key> <value>")
+	fmt.Println("Usage: identify new secret [-recipients=<id>[,<id>...]] [-groups=<group>[,<group>...]] <key> <value>")
 	fmt.Println("")
-	fmt.Println("Set the value of a secret")
+	fmt.Println("Set the value of a secret, which other recipients can also read")
 }
 
 func (c NewSecretCommand) Command(ctx context.Context, args []string, s cli.System) error {
@@ -63,5 +74,21 @@ func (c NewSecretCommand) Command(ctx context.Context, args []string, s cli.Syst
 	}
 	defer store.Close()
 
-	return store.PutSecret(i, key, value)
+	var recipients []identity.Recipient
+	for _, id := range identity.SplitAliases(*c.recipients) {
+		recipient, err := store.GetIdentity(id)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, recipient)
+	}
+	for _, id := range identity.SplitAliases(*c.groups) {
+		group, err := store.GetGroup(id)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, group)
+	}
+
+	return store.PutSecret(i, key, value, recipients...)
 }
@@ -0,0 +1,155 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package newcmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/akb/go-cli"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type NewSSHCertificateCommand struct {
+	host          *bool
+	identity      *string
+	principals    *string
+	validity      *time.Duration
+	forceCommand  *string
+	sourceAddress *string
+	output        *string
+}
+
+func (c *NewSSHCertificateCommand) Flags(f *flag.FlagSet) {
+	c.host = f.Bool("host", false, "issue a host certificate rather than a user certificate")
+	c.identity = f.String("identity", "", "identity to issue the certificate to, the signer by default")
+	c.principals = f.String("principals", "", "comma-separated users or hosts the certificate is valid for")
+	c.validity = f.Duration("validity", 0, "how long the certificate is valid for")
+	c.forceCommand = f.String("force-command", "", "command forced to run by user certificates")
+	c.sourceAddress = f.String("source-address", "",
+		"comma-separated addresses or CIDR blocks user certificates may be used from")
+	c.output = f.String("output", "", "file to write the certificate to")
+}
+
+func (NewSSHCertificateCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify new ssh-certificate [-host] [-identity=<id>] [-principals=<list>]")
+	fmt.Println("           [-validity=<duration>] [-force-command=<command>]")
+	fmt.Println("           [-source-address=<list>] [-output=<file>] [<public key file>]")
+	fmt.Println("")
+	fmt.Println("Issue a short-lived OpenSSH certificate signed by the Ed25519 key of the")
+	fmt.Println("authenticated identity, usually the server identity, acting as a certificate")
+	fmt.Println("authority. The certified key is read from an authorized_keys formatted file,")
+	fmt.Println("or is the SSH key of the identity the certificate is issued to if no file is")
+	fmt.Println("given. The certificate is written next to the key file, as ssh expects, or")
+	fmt.Println("printed otherwise.")
+	fmt.Println("")
+	fmt.Println("User certificates are valid for the UUID of the identity they are issued to")
+	fmt.Println("and the principals set for it with 'identify set ssh-principals', or the")
+	fmt.Println("subset of them given as principals.")
+	fmt.Println("Host certificates name any hosts, but the identity they are issued to must")
+	fmt.Println("hold the host-certificates:issue permission.")
+}
+
+func (c NewSSHCertificateCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) > 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "new ssh-certificate accepts at most one key file"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	requester := *c.identity
+	if requester == "" {
+		requester = i.String()
+	}
+
+	var key ssh.PublicKey
+	output := *c.output
+	if len(args) == 0 {
+		public, err := store.GetIdentity(requester)
+		if err != nil {
+			return err
+		}
+		if key, err = identity.SSHPublicKey(public); err != nil {
+			return err
+		}
+	} else {
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		if key, _, _, _, err = ssh.ParseAuthorizedKey(data); err != nil {
+			return err
+		}
+		if output == "" {
+			output = strings.TrimSuffix(args[0], ".pub") + "-cert.pub"
+		}
+	}
+
+	request := identity.SSHCertificateRequest{
+		Type:            identity.SSHUserCertificate,
+		PublicKey:       key,
+		Principals:      identity.SplitAliases(*c.principals),
+		Validity:        *c.validity,
+		ForceCommand:    *c.forceCommand,
+		SourceAddresses: identity.SplitAliases(*c.sourceAddress),
+	}
+	if *c.host {
+		request.Type = identity.SSHHostCertificate
+	}
+
+	certificate, err := store.IssueSSHCertificate(i, requester, request)
+	if err != nil {
+		return err
+	}
+
+	marshaled := ssh.MarshalAuthorizedKey(certificate)
+	if output == "" {
+		s.Print(string(marshaled))
+		return nil
+	}
+	if err := ioutil.WriteFile(output, marshaled, 0644); err != nil {
+		return err
+	}
+	s.Printf("Certificate written to %s\n", output)
+	return nil
+}
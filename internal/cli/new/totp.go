@@ -0,0 +1,83 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package newcmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/totp"
+)
+
+type NewTOTPCommand struct{}
+
+func (NewTOTPCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify new totp")
+	fmt.Println("")
+	fmt.Println("Enroll an authenticator app as a second factor. Once enrolled, a one-time")
+	fmt.Println("password is required along with the passphrase of the identity.")
+}
+
+func (c NewTOTPCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return err
+	}
+
+	s.Println("Add this secret to your authenticator app:")
+	s.Printf("Secret: %s\n", totp.Encoding.EncodeToString(secret))
+	s.Printf("URI: %s\n", totp.URI(secret, config.GetRealm(s), i.String()))
+	s.Println()
+
+	s.Print("Authentication code: ")
+	code, err := s.ReadPassword()
+	s.Println()
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.EnrollTOTP(i, secret, code); err != nil {
+		return err
+	}
+
+	s.Println("Second factor enrolled.")
+	return nil
+}
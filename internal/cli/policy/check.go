@@ -0,0 +1,98 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package policy
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/policy"
+)
+
+type CheckPolicyCommand struct {
+	explain *bool
+	context *string
+}
+
+func (c *CheckPolicyCommand) Flags(f *flag.FlagSet) {
+	c.explain = f.Bool("explain", false, "print how every rule was evaluated")
+	c.context = f.String("context", "", "comma-separated <name>=<value> context of the request")
+}
+
+func (CheckPolicyCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify policy check [-explain] [-context=<name>=<value>,...] <id> <action> <resource>")
+	fmt.Println("")
+	fmt.Println("Decide whether an identity may perform an action on a resource, using the")
+	fmt.Println("claims an access token for it would carry. Exits with a non-zero status if")
+	fmt.Println("the request is denied.")
+}
+
+func (c CheckPolicyCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 3 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "policy check requires an identity, an action and a resource"}
+	}
+
+	request := policy.Request{Subject: args[0], Action: args[1], Resource: args[2]}
+	for _, pair := range identity.SplitAliases(*c.context) {
+		n := strings.Index(pair, "=")
+		if n < 1 {
+			return &cli.ExitError{Status: 1, Message: fmt.Sprintf("context '%s' isn't <name>=<value>", pair)}
+		}
+		if request.Context == nil {
+			request.Context = map[string]interface{}{}
+		}
+		request.Context[pair[:n]] = pair[n+1:]
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	decision, err := store.Authorize(request)
+	if err != nil {
+		return err
+	}
+
+	if *c.explain {
+		for _, step := range decision.Trace {
+			s.Printf("%s@%d %s %s: %s\n", step.Policy, step.Version, step.Rule, step.Effect, step.Reason)
+		}
+	}
+
+	s.Println(decision.Reason)
+	if !decision.Allowed {
+		return &cli.ExitError{Status: 1, Message: "request denied"}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type GetPolicyCommand struct {
+	version *int
+}
+
+func (c *GetPolicyCommand) Flags(f *flag.FlagSet) {
+	c.version = f.Int("version", 0, "version of the policy, rather than the latest")
+}
+
+func (GetPolicyCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify policy get [-version=<version>] <policy>")
+	fmt.Println("")
+	fmt.Println("Print the latest or a given version of a policy document.")
+}
+
+func (c GetPolicyCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "policy get requires a policy"}
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	version, err := store.GetPolicy(args[0], *c.version)
+	if err != nil {
+		return err
+	}
+
+	document, err := json.MarshalIndent(version.Policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.Println(string(document))
+	return nil
+}
@@ -0,0 +1,56 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package policy
+
+import (
+	"fmt"
+
+	"github.com/akb/go-cli"
+	"github.com/akb/identify"
+)
+
+type PolicyCommand struct{}
+
+func (PolicyCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify policy <put|get|history|list|delete|check> [<args>]
+
+Manage policies, which decide whether identities may perform actions on
+resources, and ask for their decisions. Every change to a policy is stored as a
+new version.
+
+Subcommands:
+put <file>                          store a new version of a policy
+get <policy>                        print a version of a policy
+history <policy>                    list the versions of a policy
+list                                list policies and their latest versions
+delete <policy>                     delete a policy and all of its versions
+check <id> <action> <resource>      decide whether an identity may act on a resource`)
+}
+
+func (c PolicyCommand) Subcommands() cli.CLI {
+	return cli.CLI{
+		"put":     identify.RequiresCLIUserAuth(&PutPolicyCommand{}),
+		"get":     &GetPolicyCommand{},
+		"history": &PolicyHistoryCommand{},
+		"list":    &ListPoliciesCommand{},
+		"delete":  identify.RequiresCLIUserAuth(&DeletePolicyCommand{}),
+		"check":   &CheckPolicyCommand{},
+	}
+}
@@ -0,0 +1,83 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/policy"
+)
+
+type PutPolicyCommand struct{}
+
+func (PutPolicyCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify policy put <file>")
+	fmt.Println("")
+	fmt.Println("Store a policy document as the latest version of the policy it names.")
+	fmt.Println("Requires the policies:manage permission.")
+}
+
+func (c PutPolicyCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "policy put requires a policy document"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	document, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	p, err := policy.Parse(document)
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	version, err := store.PutPolicy(i, p)
+	if err != nil {
+		return err
+	}
+
+	s.Printf("Stored version %d of policy %s\n", version, p.Name)
+	return nil
+}
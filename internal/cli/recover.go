@@ -0,0 +1,79 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type RecoverCommand struct {
+	id *string
+}
+
+func (c *RecoverCommand) Flags(f *flag.FlagSet) {
+	c.id = f.String("id", "", "your identity")
+}
+
+func (RecoverCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify recover -id=<id>")
+	fmt.Println("")
+	fmt.Println("Set a new passphrase using a recovery code instead of the current one.")
+}
+
+func (c RecoverCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	s.Print("Recovery code: ")
+	code, err := s.ReadPassword()
+	s.Println()
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := identify.ReadNewPassphrase(s)
+	if err != nil {
+		return err
+	}
+
+	private, err := store.Recover(*c.id, code, passphrase)
+	if err != nil {
+		return err
+	}
+
+	s.Printf("Passphrase changed. %d recovery codes remain.\n", private.RecoveryCodes())
+	return nil
+}
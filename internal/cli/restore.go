@@ -0,0 +1,121 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/backup"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/database"
+)
+
+type RestoreCommand struct {
+	verify *bool
+}
+
+func (c *RestoreCommand) Flags(f *flag.FlagSet) {
+	c.verify = f.Bool("verify", false, "check the archive without restoring it")
+}
+
+func (RestoreCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify restore [-verify] <file>")
+	fmt.Println("")
+	fmt.Println("Check an archive written by 'identify backup' and restore the databases in")
+	fmt.Println("it to IDENTIFY_DB_PATH and IDENTIFY_TOKEN_DB_PATH, which must be new or")
+	fmt.Println("empty. The backup passphrase is read from IDENTIFY_BACKUP_PASSPHRASE if")
+	fmt.Println("it's set.")
+}
+
+func (c RestoreCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "restore requires a backup file"}
+	}
+
+	passphrase := config.GetBackupPassphrase(s)
+	if passphrase == "" {
+		s.Print("Backup passphrase: ")
+		var err error
+		passphrase, err = s.ReadPassword()
+		s.Println()
+		if err != nil {
+			return err
+		}
+	}
+
+	archive, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	if *c.verify {
+		summary, err := backup.Verify(archive, passphrase)
+		if err != nil {
+			return err
+		}
+		s.Printf("Verified %s, backed up at %s\n", describe(summary),
+			summary.Created.Format(time.RFC3339))
+		return nil
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	tokenDBPath, err := config.GetTokenDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	// the databases are opened without migrating them, so that they're empty
+	// until restored; they're migrated the next time a store opens them
+	db, err := database.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tokenDB, err := database.Open(tokenDBPath)
+	if err != nil {
+		return err
+	}
+	defer tokenDB.Close()
+
+	summary, err := backup.Restore(archive, passphrase, map[string]database.DB{
+		"identity": db,
+		"token":    tokenDB,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.Printf("Restored %s, backed up at %s\n", describe(summary),
+		summary.Created.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,54 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package role
+
+import (
+	"fmt"
+
+	"github.com/akb/go-cli"
+	"github.com/akb/identify"
+)
+
+type RoleCommand struct{}
+
+func (RoleCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify role <new|delete|assign|revoke|list> [<role>] [<id>]
+
+Manage roles, which grant identities permissions. The built-in roles are admin,
+which grants every permission, operator, which may create, list and manage
+identities, and user, which every identity holds.
+
+Subcommands:
+new <role>            define a custom role
+delete <role>         delete a custom role
+assign <id> <role>    assign a role to an identity
+revoke <id> <role>    revoke a role from an identity
+list [<id>]           list roles and their permissions, or those of an identity`)
+}
+
+func (c RoleCommand) Subcommands() cli.CLI {
+	return cli.CLI{
+		"new":    identify.RequiresCLIUserAuth(&NewRoleCommand{}),
+		"delete": identify.RequiresCLIUserAuth(&DeleteRoleCommand{}),
+		"assign": identify.RequiresCLIUserAuth(&AssignRoleCommand{}),
+		"revoke": identify.RequiresCLIUserAuth(&RevokeRoleCommand{}),
+		"list":   &ListRolesCommand{},
+	}
+}
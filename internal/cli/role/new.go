@@ -0,0 +1,80 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package role
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type NewRoleCommand struct {
+	permissions *string
+}
+
+func (c *NewRoleCommand) Flags(f *flag.FlagSet) {
+	c.permissions = f.String("permissions", "", "comma-separated permissions the role grants")
+}
+
+func (NewRoleCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify role new -permissions=<permission>[,<permission>...] <role>")
+	fmt.Println("")
+	fmt.Println("Define a custom role. Requires the roles:manage permission. Roles may grant:")
+	fmt.Println("")
+	fmt.Println(strings.Join(identity.Permissions, "\n"))
+}
+
+func (c NewRoleCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "role new requires a name"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	role, err := store.NewRole(i, args[0], identity.SplitAliases(*c.permissions))
+	if err != nil {
+		return err
+	}
+
+	s.Printf("Created role %s: %s\n", role.Name, strings.Join(role.Permissions, ","))
+	return nil
+}
@@ -0,0 +1,68 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package role
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type RevokeRoleCommand struct{}
+
+func (RevokeRoleCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify role revoke <id> <role>")
+	fmt.Println("")
+	fmt.Println("Revoke a role from an identity. Requires the roles:manage permission, and")
+	fmt.Println("every permission the role grants. The user role can't be revoked, and")
+	fmt.Println("there must always be an administrator.")
+	fmt.Println("Access tokens already issued keep the role in their roles claim until they")
+	fmt.Println("expire, but identify itself stops honoring it immediately.")
+}
+
+func (c RevokeRoleCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 2 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "role revoke requires an identity and a role"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.RevokeRole(i, args[0], args[1])
+}
@@ -0,0 +1,74 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rotate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type RotateKeysCommand struct{}
+
+func (RotateKeysCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify rotate keys
+
+Generates new signing and sealing keys for an identity. Previous public keys
+are kept so that tokens signed by them remain valid until they expire, and
+previous sealing keys so that files encrypted to them can still be decrypted.
+Secrets belonging to the identity are resealed to the new keys, and an escrowed
+identity is escrowed again to the same trustees.
+
+Certificates generated from the previous keys must be regenerated.`)
+}
+
+func (c RotateKeysCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	previous := i.KeyID()
+
+	rotated, err := store.RotateKeys(i)
+	if err != nil {
+		return err
+	}
+
+	s.Printf("Rotated keys %s -> %s\n", previous, rotated.KeyID())
+
+	return nil
+}
@@ -0,0 +1,48 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secret
+
+import (
+	"fmt"
+
+	"github.com/akb/go-cli"
+	"github.com/akb/identify"
+)
+
+type SecretCommand struct{}
+
+func (SecretCommand) Help() {
+	fmt.Println(`identify - authentication and authorization service
+
+Usage: identify secret <share|unshare|recipients> <key> [<id>...]
+
+Manage the identities and groups that can read a secret.
+
+Subcommands:
+share <key> <id>...    allow more identities to read a secret
+unshare <key> <id>...  stop identities from reading a secret
+recipients <key>       list the identities that can read a secret`)
+}
+
+func (c SecretCommand) Subcommands() cli.CLI {
+	return cli.CLI{
+		"share":      identify.RequiresCLIUserAuth(&ShareSecretCommand{}),
+		"unshare":    identify.RequiresCLIUserAuth(&UnshareSecretCommand{}),
+		"recipients": identify.RequiresCLIUserAuth(&SecretRecipientsCommand{}),
+	}
+}
@@ -0,0 +1,80 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secret
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type SecretRecipientsCommand struct{}
+
+func (SecretRecipientsCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify secret recipients <key>")
+	fmt.Println("")
+	fmt.Println("List the identities that can read a secret, with their aliases, and the")
+	fmt.Println("groups it is shared with, with their names.")
+}
+
+func (c SecretRecipientsCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "secret recipients requires a key"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	recipients, err := store.SecretRecipients(i, args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, id := range recipients {
+		line := id
+		if public, err := store.GetIdentity(id); err == nil && len(public.Aliases()) > 0 {
+			line = fmt.Sprintf("%s %s", id, strings.Join(public.Aliases(), ","))
+		} else if group, err := store.GetGroup(id); err == nil {
+			line = fmt.Sprintf("%s %s (group)", id, group.Name)
+		}
+		s.Println(line)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secret
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type ShareSecretCommand struct {
+	groups *string
+}
+
+func (c *ShareSecretCommand) Flags(f *flag.FlagSet) {
+	c.groups = f.String("groups", "",
+		"comma-separated ids or names of groups whose members can read the secret")
+}
+
+func (ShareSecretCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify secret share [-groups=<group>[,<group>...]] <key> [<id>...]")
+	fmt.Println("")
+	fmt.Println("Allow more identities, by id or alias, or groups, by id or name, to read")
+	fmt.Println("a secret the authenticated identity can read, without entering its value")
+	fmt.Println("again. Members of a group can read the secret for as long as they belong")
+	fmt.Println("to it.")
+}
+
+func (c ShareSecretCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	groups := identity.SplitAliases(*c.groups)
+	if len(args) < 1 || (len(args) < 2 && len(groups) == 0) {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "secret share requires a key and at least one identity or group"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	recipients, err := getRecipients(store, args[1:], groups)
+	if err != nil {
+		return err
+	}
+
+	return store.AddSecretRecipients(i, args[0], recipients)
+}
+
+// getRecipients looks up identities by id or alias and groups by id or name.
+func getRecipients(store identity.Store, ids, groups []string) ([]identity.Recipient, error) {
+	var recipients []identity.Recipient
+	for _, id := range ids {
+		public, err := store.GetIdentity(id)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, public)
+	}
+	for _, id := range groups {
+		group, err := store.GetGroup(id)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, group)
+	}
+	return recipients, nil
+}
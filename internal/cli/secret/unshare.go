@@ -0,0 +1,86 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secret
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type UnshareSecretCommand struct {
+	groups *string
+}
+
+func (c *UnshareSecretCommand) Flags(f *flag.FlagSet) {
+	c.groups = f.String("groups", "",
+		"comma-separated ids or names of groups to stop sharing the secret with")
+}
+
+func (UnshareSecretCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify secret unshare [-groups=<group>[,<group>...]] <key> [<id>...]")
+	fmt.Println("")
+	fmt.Println("Stop identities, by id or alias, or groups, by id or name, from reading")
+	fmt.Println("a secret. The owner of a secret can remove any other recipient; others")
+	fmt.Println("can only remove themselves. The secret is encrypted with a new key, so")
+	fmt.Println("removed recipients can't read it again.")
+}
+
+func (c UnshareSecretCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	groups := identity.SplitAliases(*c.groups)
+	if len(args) < 1 || (len(args) < 2 && len(groups) == 0) {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "secret unshare requires a key and at least one identity or group"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	recipients, err := getRecipients(store, args[1:], groups)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, r := range recipients {
+		ids = append(ids, r.String())
+	}
+
+	return store.RemoveSecretRecipients(i, args[0], ids)
+}
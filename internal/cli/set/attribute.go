@@ -0,0 +1,80 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package set
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type SetAttributeCommand struct {
+	sealed *bool
+	claim  *bool
+}
+
+func (c *SetAttributeCommand) Flags(f *flag.FlagSet) {
+	c.sealed = f.Bool("sealed", false, "encrypt the attribute to your seal key")
+	c.claim = f.Bool("claim", false, "include the attribute in access tokens")
+}
+
+func (SetAttributeCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify set attribute [-sealed] [-claim] <name> <value>")
+	fmt.Println("")
+	fmt.Println("Set a profile attribute of an identity, such as its name or email.")
+	fmt.Println("Sealed attributes can only be read with the passphrase of the identity.")
+	fmt.Println("Claim attributes are included in access tokens issued to the identity.")
+}
+
+func (c SetAttributeCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) != 2 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "set attribute requires a name and a value"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.SetAttribute(i.String(), identity.Attribute{
+		Name:   args[0],
+		Value:  args[1],
+		Sealed: *c.sealed,
+		Claim:  *c.claim,
+	})
+}
@@ -0,0 +1,76 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package set
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type SetPassphraseCommand struct{}
+
+func (SetPassphraseCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify set passphrase")
+	fmt.Println("")
+	fmt.Println("Change the passphrase of an identity")
+}
+
+func (c SetPassphraseCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	passphrase, err := identify.ReadNewPassphrase(s)
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	hadRecoveryCodes := i.RecoveryCodes() > 0
+	if err := store.ChangePassphrase(i, passphrase); err != nil {
+		return errors.Wrap(err, "unable to change passphrase")
+	}
+
+	s.Println("Passphrase changed.")
+	if hadRecoveryCodes {
+		s.Println("Recovery codes no longer work; generate new ones with 'identify new recovery-codes'.")
+	}
+
+	return nil
+}
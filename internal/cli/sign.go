@@ -0,0 +1,105 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/identity"
+)
+
+type SignCommand struct {
+	algorithm *string
+	output    *string
+}
+
+func (c *SignCommand) Flags(f *flag.FlagSet) {
+	c.algorithm = f.String("algorithm", identity.SignatureEd25519,
+		fmt.Sprintf("signature algorithm, %s or %s",
+			identity.SignatureEd25519, identity.SignatureECDSA))
+	c.output = f.String("output", "", "file to write the signature to")
+}
+
+func (SignCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify sign [-algorithm=<algorithm>] [-output=<file>] [<file>]")
+	fmt.Println("")
+	fmt.Println("Create a detached signature of a file, or of standard input if no file")
+	fmt.Println("or '-' is given. The signature names the identity and key that made it")
+	fmt.Println("and can be checked with 'identify verify'. It is written to the output")
+	fmt.Println("file if given, or printed otherwise.")
+}
+
+func (c SignCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if len(args) > 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "sign accepts at most one file"}
+	}
+
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	data, err := openData(args)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	signature, err := i.Sign(*c.algorithm, data)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(signature)
+	if err != nil {
+		return err
+	}
+
+	if *c.output == "" {
+		s.Println(string(marshaled))
+		return nil
+	}
+
+	if err := ioutil.WriteFile(*c.output, append(marshaled, '\n'), 0644); err != nil {
+		return err
+	}
+
+	s.Printf("Signature written to %s\n", *c.output)
+	return nil
+}
+
+// openData opens the file named by the only argument, or standard input if
+// there is none.
+func openData(args []string) (io.ReadCloser, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(args[0])
+}
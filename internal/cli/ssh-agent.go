@@ -0,0 +1,114 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/sshagent"
+)
+
+type SSHAgentCommand struct {
+	socket *string
+}
+
+func (c *SSHAgentCommand) Flags(f *flag.FlagSet) {
+	c.socket = f.String("socket", "", "unix socket to listen on, in a new temporary directory by default")
+}
+
+func (SSHAgentCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify ssh-agent [-socket=<path>]")
+	fmt.Println("")
+	fmt.Println("Serve the ssh-agent protocol on a unix socket, signing with the Ed25519 key")
+	fmt.Println("of an identity, until interrupted. The identity is unlocked once and its")
+	fmt.Println("key is only held in memory. Point ssh and git at the agent by setting")
+	fmt.Println("SSH_AUTH_SOCK to the socket, as the command prints, and authorize the key")
+	fmt.Println("printed by 'identify get ssh-key'.")
+	fmt.Println("")
+	fmt.Println("The agent only signs requests to authenticate to ssh servers and SSHSIG")
+	fmt.Println("signatures, such as those of git commits. It refuses to serve the key of")
+	fmt.Println("an identity that 'identify listen' has run as, which signs certificates.")
+}
+
+func (c SSHAgentCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	i := identify.IdentityFromContext(ctx)
+	if i == nil {
+		return identify.ErrorUnauthorized
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	served, err := store.IsServerIdentity(i.String())
+	store.Close()
+	if err != nil {
+		return err
+	}
+	if served {
+		return identity.ErrorServerIdentity
+	}
+
+	signer, err := identity.SSHSigner(i)
+	if err != nil {
+		return err
+	}
+
+	socket := *c.socket
+	if socket == "" {
+		dir, err := ioutil.TempDir("", "identify-ssh-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		socket = filepath.Join(dir, "agent.sock")
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	if err := os.Chmod(socket, 0600); err != nil {
+		return err
+	}
+
+	s.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+	s.Log(fmt.Sprintf("serving the ssh key of %s", i))
+
+	return sshagent.Serve(ctx, listener, sshagent.New(signer, i.String()))
+}
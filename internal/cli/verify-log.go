@@ -0,0 +1,372 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/certificate"
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/transparency"
+	"github.com/akb/identify/web"
+)
+
+var (
+	ErrorLogRoot         = fmt.Errorf("the log entries don't match the signed root")
+	ErrorLogInconsistent = fmt.Errorf("the log is not consistent with the one verified before")
+)
+
+// ErrorLogEntry is returned when an entry of the log doesn't follow from the
+// ones before it, such as an identity being changed after it was deleted.
+type ErrorLogEntry struct {
+	Index  int
+	Reason string
+}
+
+func (err ErrorLogEntry) Error() string {
+	return fmt.Sprintf("entry %d of the log %s", err.Index, err.Reason)
+}
+
+// ErrorKeysNotLogged is returned when the keys served for an identity aren't
+// the ones its latest log entry records.
+type ErrorKeysNotLogged struct {
+	Identity string
+}
+
+func (err ErrorKeysNotLogged) Error() string {
+	return fmt.Sprintf("the keys served for %s don't match the log", err.Identity)
+}
+
+// logState is the last head of the log that was verified, which later heads
+// must be consistent with.
+type logState struct {
+	URL    string `json:"url"`
+	Signer string `json:"signer"`
+	Size   int    `json:"size"`
+	Root   string `json:"root"`
+}
+
+type VerifyLogCommand struct {
+	url         *string
+	certificate *string
+}
+
+func (c *VerifyLogCommand) Flags(f *flag.FlagSet) {
+	c.url = f.String("url", "", "URL of the identify server, if not IDENTIFY_URL")
+	c.certificate = f.String("certificate", "",
+		"certificate to trust, if not IDENTIFY_CERTIFICATE_PATH")
+}
+
+func (VerifyLogCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify verify-log [-url=<server>] [-certificate=<file>] [<identity>...]")
+	fmt.Println("")
+	fmt.Println("Audit the key transparency log of an identify server. Every entry of the")
+	fmt.Println("log is downloaded and checked against the root signed by the server")
+	fmt.Println("identity, and the log must extend the one verified last time, which is")
+	fmt.Println("recorded in IDENTIFY_LOG_STATE_PATH. The keys the server serves for each")
+	fmt.Println("identity given, by id or alias, must be the ones its latest entry records.")
+	fmt.Println("")
+	fmt.Println("The first audit trusts whichever identity signs the log; later audits")
+	fmt.Println("require the same one.")
+}
+
+func (c VerifyLogCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	server := *c.url
+	if server == "" {
+		server = config.GetURL(s)
+	}
+	server = strings.TrimSuffix(server, "/")
+
+	client, err := c.client(s)
+	if err != nil {
+		return err
+	}
+
+	statePath, err := config.GetLogStatePath(s)
+	if err != nil {
+		return err
+	}
+
+	state, err := readLogState(statePath)
+	if err != nil {
+		return err
+	}
+	if state != nil && state.URL != server {
+		return fmt.Errorf("%s records the log of %s, not %s", statePath, state.URL, server)
+	}
+
+	var head transparency.TreeHead
+	if err := getJSON(ctx, client, server+"/log", &head); err != nil {
+		return err
+	}
+
+	var leaves [][]byte
+	for len(leaves) < head.Size {
+		var page web.LogEntriesResponse
+		err := getJSON(ctx, client, fmt.Sprintf("%s/log/entries?start=%d&end=%d",
+			server, len(leaves), head.Size), &page)
+		if err != nil {
+			return err
+		}
+		if len(page.Entries) == 0 {
+			return fmt.Errorf("the log has fewer than the %d entries its head claims", head.Size)
+		}
+		for _, e := range page.Entries {
+			leaf, err := identity.DecodeString(e.Leaf)
+			if err != nil || e.Index != len(leaves) {
+				return identity.ErrorInvalidLogEntry
+			}
+			leaves = append(leaves, leaf)
+		}
+	}
+
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = transparency.LeafHash(leaf)
+	}
+	if len(hashes) != head.Size || !bytes.Equal(transparency.RootHash(hashes), head.Root) {
+		return ErrorLogRoot
+	}
+
+	entries, latest, err := auditLog(leaves)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyHead(&head, entries); err != nil {
+		return err
+	}
+
+	if state != nil {
+		if head.Signer != state.Signer {
+			return fmt.Errorf("the log is signed by %s, not %s as before", head.Signer, state.Signer)
+		}
+		if head.Size < state.Size {
+			return fmt.Errorf("the log has shrunk from %d to %d entries", state.Size, head.Size)
+		}
+
+		root, err := identity.DecodeString(state.Root)
+		if err != nil {
+			return fmt.Errorf("%s is corrupt", statePath)
+		}
+
+		var response web.ConsistencyProofResponse
+		err = getJSON(ctx, client, fmt.Sprintf("%s/log/consistency?first=%d&second=%d",
+			server, state.Size, head.Size), &response)
+		if err != nil {
+			return err
+		}
+
+		proof, err := transparency.DecodeProof(response.Proof)
+		if err != nil {
+			return err
+		}
+		if err := transparency.VerifyConsistency(
+			state.Size, head.Size, root, head.Root, proof); err != nil {
+			return ErrorLogInconsistent
+		}
+	}
+
+	s.Printf("Verified %d entries of the log of %s, signed by %s at %s\n",
+		head.Size, server, head.Signer, head.Timestamp.Format(time.RFC3339))
+	if state != nil {
+		s.Printf("The log extends the %d entries verified before\n", state.Size)
+	}
+
+	for _, id := range args {
+		var keys web.KeysResponse
+		err := getJSON(ctx, client,
+			fmt.Sprintf("%s/identities/%s/keys", server, url.PathEscape(id)), &keys)
+		if err != nil {
+			return err
+		}
+
+		index, ok := latest[keys.ID]
+		if !ok || index != keys.LogIndex {
+			return ErrorKeysNotLogged{keys.ID}
+		}
+
+		logged := entries[index]
+		if logged.Type == identity.LogDelete || logged.KeyID != keys.KeyID ||
+			logged.ECDSAPublicKey != keys.ECDSAPublicKey ||
+			logged.Ed25519PublicKey != keys.Ed25519PublicKey ||
+			logged.SealPublicKey != keys.SealPublicKey {
+			return ErrorKeysNotLogged{keys.ID}
+		}
+
+		var response web.InclusionProofResponse
+		err = getJSON(ctx, client, fmt.Sprintf("%s/log/inclusion?index=%d&size=%d",
+			server, index, head.Size), &response)
+		if err != nil {
+			return err
+		}
+
+		proof, err := transparency.DecodeProof(response.Proof)
+		if err != nil {
+			return err
+		}
+		if err := transparency.VerifyInclusion(
+			hashes[index], index, head.Size, proof, head.Root); err != nil {
+			return ErrorKeysNotLogged{keys.ID}
+		}
+
+		s.Printf("The keys of %s (%s) match entry %d of the log\n", keys.ID, keys.KeyID, index)
+	}
+
+	return writeLogState(statePath, logState{
+		URL:    server,
+		Signer: head.Signer,
+		Size:   head.Size,
+		Root:   identity.EncodeToString(head.Root),
+	})
+}
+
+// auditLog parses the entries of the log, checking that each follows from
+// those before it, and returns them along with the index of the latest entry
+// of each identity.
+func auditLog(leaves [][]byte) ([]*identity.LogEntry, map[string]int, error) {
+	var entries []*identity.LogEntry
+	latest := map[string]int{}
+	for i, leaf := range leaves {
+		e, err := identity.ParseLogEntry(leaf)
+		if err != nil {
+			return nil, nil, ErrorLogEntry{i, "is not valid"}
+		}
+
+		previous, seen := latest[e.Identity]
+		switch {
+		case seen && entries[previous].Type == identity.LogDelete:
+			return nil, nil, ErrorLogEntry{i, "changes an identity after it was deleted"}
+		case seen && (e.Type == identity.LogCreate || e.Type == identity.LogExisting):
+			return nil, nil, ErrorLogEntry{i, "creates an identity that already exists"}
+		case !seen && e.Type != identity.LogCreate && e.Type != identity.LogImport &&
+			e.Type != identity.LogExisting:
+			return nil, nil, ErrorLogEntry{i, "changes an identity before it was created"}
+		}
+
+		entries = append(entries, e)
+		latest[e.Identity] = i
+	}
+	return entries, latest, nil
+}
+
+// verifyHead checks the signature of a head with the key it names, which must
+// have been logged for the identity that signed it.
+func verifyHead(head *transparency.TreeHead, entries []*identity.LogEntry) error {
+	for _, e := range entries {
+		if e.Identity != head.Signer || e.KeyID != head.KeyID {
+			continue
+		}
+		key, err := e.Ed25519Key()
+		if err != nil {
+			return err
+		}
+		return head.Verify(key)
+	}
+	return fmt.Errorf("the log is signed with key %s of %s, which isn't in the log",
+		head.KeyID, head.Signer)
+}
+
+func (c VerifyLogCommand) client(s cli.System) (*http.Client, error) {
+	certificatePath := *c.certificate
+	if certificatePath == "" {
+		if p, err := config.GetCertificatePath(s); err == nil {
+			if _, err := os.Stat(p); err == nil {
+				certificatePath = p
+			}
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if certificatePath != "" {
+		roots, err := certificate.Trust(certificatePath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, location string, v interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("%s: %s: %s", location, response.Status,
+			strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+func readLogState(statePath string) (*logState, error) {
+	marshaled, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state logState
+	if err := json.Unmarshal(marshaled, &state); err != nil {
+		return nil, fmt.Errorf("%s is corrupt", statePath)
+	}
+	return &state, nil
+}
+
+func writeLogState(statePath string, state logState) error {
+	marshaled, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(statePath), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statePath, append(marshaled, '\n'), 0600)
+}
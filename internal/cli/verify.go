@@ -0,0 +1,118 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/akb/go-cli"
+
+	"github.com/akb/identify/internal/config"
+	"github.com/akb/identify/internal/identity"
+)
+
+type VerifyCommand struct {
+	signature *string
+	signer    *string
+}
+
+func (c *VerifyCommand) Flags(f *flag.FlagSet) {
+	c.signature = f.String("signature", "", "file containing the signature")
+	c.signer = f.String("signer", "", "identity the signature must have been made by")
+}
+
+func (VerifyCommand) Help() {
+	fmt.Println("identify - authentication and authorization service")
+	fmt.Println("")
+	fmt.Println("Usage: identify verify -signature=<file> [-signer=<id>] [<file>]")
+	fmt.Println("")
+	fmt.Println("Check a detached signature made by 'identify sign' against a file, or")
+	fmt.Println("standard input if no file or '-' is given. The signing identity must be")
+	fmt.Println("in the identity database. If a signer is given, by id or alias, the")
+	fmt.Println("signature must have been made by it.")
+}
+
+func (c VerifyCommand) Command(ctx context.Context, args []string, s cli.System) error {
+	if *c.signature == "" || len(args) > 1 {
+		c.Help()
+		return &cli.ExitError{Status: 1, Message: "verify requires a signature file and at most one file"}
+	}
+
+	marshaled, err := ioutil.ReadFile(*c.signature)
+	if err != nil {
+		return err
+	}
+
+	signature, err := identity.ParseSignature(marshaled)
+	if err != nil {
+		return err
+	}
+
+	dbPath, err := config.GetDBPath(s)
+	if err != nil {
+		return err
+	}
+
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *c.signer != "" {
+		expected, err := store.GetIdentity(*c.signer)
+		if err != nil {
+			return err
+		}
+		if expected.String() != signature.Signer {
+			return identity.ErrorWrongSigner
+		}
+	}
+
+	signer, err := store.GetIdentity(signature.Signer)
+	if err != nil {
+		return err
+	}
+
+	data, err := openData(args)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	if err := signer.Verify(signature, data); err != nil {
+		return err
+	}
+
+	name := signer.String()
+	if aliases := signer.Aliases(); len(aliases) > 0 {
+		name = fmt.Sprintf("%s (%s)", name, strings.Join(aliases, ", "))
+	}
+
+	s.Printf("Good %s signature from %s made %s\n",
+		signature.Algorithm, name, signature.Created.Format("2006-01-02 15:04:05 MST"))
+	if signature.KeyID != signer.KeyID() {
+		s.Printf("The signature was made with key %s, which has since been rotated.\n",
+			signature.KeyID)
+	}
+	return nil
+}
@@ -19,8 +19,10 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/akb/go-cli"
 )
@@ -33,6 +35,24 @@ func GetHTTPAddress(s cli.System) string {
 	return address
 }
 
+// GetURL returns the URL of the identify server, which defaults to the HTTP
+// address it listens on.
+func GetURL(s cli.System) string {
+	url := s.Getenv("IDENTIFY_URL")
+	if len(url) > 0 {
+		return strings.TrimSuffix(url, "/")
+	}
+
+	host, port, err := net.SplitHostPort(GetHTTPAddress(s))
+	if err != nil {
+		return "https://localhost:8443"
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "https://" + net.JoinHostPort(host, port)
+}
+
 func GetRealm(s cli.System) string {
 	realm := s.Getenv("IDENTIFY_REALM")
 	if len(realm) == 0 {
@@ -76,6 +96,33 @@ func GetTokenDBPath(s cli.System) (string, error) {
 	return tokenDBPath, nil
 }
 
+// GetWebAuthnOrigin returns the origin WebAuthn credentials are registered to,
+// such as "https://identify.example.com". Passkeys are disabled without one.
+func GetWebAuthnOrigin(s cli.System) string {
+	return strings.TrimSuffix(s.Getenv("IDENTIFY_WEBAUTHN_ORIGIN"), "/")
+}
+
+// GetBackupPassphrase returns the passphrase backups are encrypted with, if
+// one is configured.
+func GetBackupPassphrase(s cli.System) string {
+	return s.Getenv("IDENTIFY_BACKUP_PASSPHRASE")
+}
+
+// GetLogStatePath returns the file in which 'identify verify-log' records the
+// last head of the key transparency log it verified.
+func GetLogStatePath(s cli.System) (string, error) {
+	statePath := s.Getenv("IDENTIFY_LOG_STATE_PATH")
+	if len(statePath) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("A path to a log state file must be provided by " +
+				"the environment variable IDENTIFY_LOG_STATE_PATH.")
+		}
+		return path.Join(home, ".identify", "log-state.json"), nil
+	}
+	return statePath, nil
+}
+
 func GetCertificatePath(s cli.System) (string, error) {
 	certificatePath := s.Getenv("IDENTIFY_CERTIFICATE_PATH")
 	if len(certificatePath) == 0 {
@@ -0,0 +1,85 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltDB holds an exclusive lock on its file, so it can't be opened by more
+// than one process at a time.
+type boltDB struct {
+	db *bolt.DB
+}
+
+func openBolt(path string) (*boltDB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltDB{db}, nil
+}
+
+func (d *boltDB) View(fn func(Tx) error) error {
+	return d.db.View(func(tx *bolt.Tx) error { return fn(boltTx{tx}) })
+}
+
+func (d *boltDB) Update(fn func(Tx) error) error {
+	return d.db.Update(func(tx *bolt.Tx) error { return fn(boltTx{tx}) })
+}
+
+func (d *boltDB) Close() error {
+	return d.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// Bucket returns an untyped nil for missing buckets, so that callers can
+// compare the result with nil.
+func (t boltTx) Bucket(name []byte) Bucket {
+	if b := t.tx.Bucket(name); b != nil {
+		return boltBucket{b}
+	}
+	return nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) ForEach(fn func([]byte, Bucket) error) error {
+	return t.tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return fn(name, boltBucket{b})
+	})
+}
+
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (b boltBucket) Cursor() Cursor {
+	return b.Bucket.Cursor()
+}
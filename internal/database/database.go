@@ -0,0 +1,153 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package database provides the transactional key-value storage the identity
+// and token stores are built on, backed by either a bolt file or a SQLite
+// database.
+//
+// Data is kept in named buckets of keys and values, ordered by key, as in
+// bolt. Values returned by Get and cursors are only valid for the life of the
+// transaction, and buckets must not be modified while they're being iterated.
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Drivers, which may prefix the path to a database as "<driver>:<path>".
+// Paths without a prefix are bolt files.
+const (
+	DriverBolt   = "bolt"
+	DriverSQLite = "sqlite"
+)
+
+var (
+	ErrorNotEmpty     = fmt.Errorf("destination database isn't empty")
+	ErrorSameDatabase = fmt.Errorf("source and destination are the same database")
+)
+
+// ErrorUnknownDriver is returned for locations prefixed with a driver that
+// isn't supported.
+type ErrorUnknownDriver struct {
+	Driver string
+}
+
+func (err ErrorUnknownDriver) Error() string {
+	return fmt.Sprintf("unknown database driver '%s'", err.Driver)
+}
+
+type DB interface {
+	// View runs a read-only transaction.
+	View(func(Tx) error) error
+
+	// Update runs a read-write transaction, which is committed if the function
+	// returns nil and rolled back otherwise.
+	Update(func(Tx) error) error
+
+	Close() error
+}
+
+type Tx interface {
+	// Bucket returns a bucket, or nil if it doesn't exist.
+	Bucket(name []byte) Bucket
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+
+	// ForEach calls a function for each bucket, in order of their names.
+	ForEach(func(name []byte, b Bucket) error) error
+}
+
+type Bucket interface {
+	// Get returns the value of a key, or nil if it isn't set.
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+
+	// ForEach calls a function for each key and value, in order of the keys.
+	ForEach(func(key, value []byte) error) error
+	Cursor() Cursor
+}
+
+// Cursor iterates over the keys of a bucket in order. Each method returns nil
+// keys once the end of the bucket is reached.
+type Cursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+	Next() (key, value []byte)
+
+	// Seek moves to the first key at or after the one given.
+	Seek(seek []byte) (key, value []byte)
+}
+
+// Open opens or creates the database at a location, which is a path to a file
+// optionally prefixed with the driver to open it with, such as
+// "sqlite:/var/lib/identify/identity.db".
+func Open(location string) (DB, error) {
+	driver, path := Parse(location)
+
+	if _, err := os.Stat(filepath.Dir(path)); os.IsNotExist(err) {
+		os.Mkdir(filepath.Dir(path), 0755)
+	}
+
+	switch driver {
+	case DriverBolt:
+		return openBolt(path)
+	case DriverSQLite:
+		return openSQLite(path)
+	}
+	return nil, ErrorUnknownDriver{driver}
+}
+
+// Parse splits a location into its driver and path.
+func Parse(location string) (driver, path string) {
+	n := strings.Index(location, ":")
+	// a single letter is a Windows drive rather than a driver
+	if n < 2 {
+		return DriverBolt, location
+	}
+	return location[:n], location[n+1:]
+}
+
+// Copy copies every bucket of one database into another, which must be empty,
+// in a single transaction.
+func Copy(destination, source DB) error {
+	return source.View(func(stx Tx) error {
+		return destination.Update(func(dtx Tx) error {
+			empty := true
+			err := dtx.ForEach(func([]byte, Bucket) error {
+				empty = false
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !empty {
+				return ErrorNotEmpty
+			}
+
+			return stx.ForEach(func(name []byte, sb Bucket) error {
+				db, err := dtx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				return sb.ForEach(db.Put)
+			})
+		})
+	})
+}
@@ -0,0 +1,130 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"fmt"
+	"strconv"
+)
+
+var (
+	metaBucketKey    = []byte("meta")
+	schemaVersionKey = []byte("schema-version")
+)
+
+// Migration upgrades a database by one schema version. The migrations of a
+// database are numbered from one in the order they're listed, and databases
+// without a schema version, including new ones, are at version zero.
+type Migration struct {
+	Description string
+	Migrate     func(Tx) error
+}
+
+// ErrorSchemaTooNew is returned for databases written by a newer version of
+// identify, which this one can't safely read or write.
+type ErrorSchemaTooNew struct {
+	Version   int
+	Supported int
+}
+
+func (err ErrorSchemaTooNew) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the latest this version "+
+		"of identify supports, %d", err.Version, err.Supported)
+}
+
+// SchemaVersion returns the number of migrations that have been applied to a
+// database.
+func SchemaVersion(tx Tx) (int, error) {
+	mb := tx.Bucket(metaBucketKey)
+	if mb == nil {
+		return 0, nil
+	}
+	stored := mb.Get(schemaVersionKey)
+	if stored == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(string(stored))
+	if err != nil {
+		return 0, fmt.Errorf("database schema version '%s' isn't a number", stored)
+	}
+	return version, nil
+}
+
+// Pending returns the current schema version of a database and the
+// migrations that haven't been applied to it.
+func Pending(db DB, migrations []Migration) (int, []Migration, error) {
+	var version int
+	err := db.View(func(tx Tx) error {
+		var err error
+		version, err = SchemaVersion(tx)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if version > len(migrations) {
+		return version, nil, ErrorSchemaTooNew{version, len(migrations)}
+	}
+	return version, migrations[version:], nil
+}
+
+// Migrate applies the pending migrations of a database in a single
+// transaction, so that either all of them or none are applied.
+func Migrate(db DB, migrations []Migration) error {
+	_, pending, err := Pending(db, migrations)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	return db.Update(func(tx Tx) error {
+		// another process may have migrated the database in the meantime
+		version, err := SchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version > len(migrations) {
+			return ErrorSchemaTooNew{version, len(migrations)}
+		}
+
+		for n, m := range migrations[version:] {
+			if err := m.Migrate(tx); err != nil {
+				return fmt.Errorf("migration %d, %s, failed: %s", version+n+1, m.Description, err)
+			}
+		}
+
+		mb, err := tx.CreateBucketIfNotExists(metaBucketKey)
+		if err != nil {
+			return err
+		}
+		return mb.Put(schemaVersionKey, []byte(strconv.Itoa(len(migrations))))
+	})
+}
+
+// CreateBuckets returns a migration function that creates buckets.
+func CreateBuckets(names ...[]byte) func(Tx) error {
+	return func(tx Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,288 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The buckets of a SQLite database are listed in their own table, so that
+// empty buckets exist as they do in bolt. Keys and values are blobs, which
+// SQLite compares byte by byte, as bolt does.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS buckets (
+	name BLOB PRIMARY KEY
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS entries (
+	bucket BLOB NOT NULL,
+	key    BLOB NOT NULL,
+	value  BLOB NOT NULL,
+	PRIMARY KEY (bucket, key)
+) WITHOUT ROWID;
+`
+
+// sqliteDB may be opened by several processes at once. It's kept in WAL mode,
+// so that reads don't block writes, and writers wait for each other rather
+// than failing. Read-write transactions take the write lock when they begin,
+// so that two of them can't both read and then fail to write.
+type sqliteDB struct {
+	reader *sql.DB
+	writer *sql.DB
+}
+
+func openSQLite(path string) (*sqliteDB, error) {
+	open := func(lock string) (*sql.DB, error) {
+		return sql.Open("sqlite3", fmt.Sprintf(
+			"file:%s?_journal_mode=WAL&_busy_timeout=10000&_txlock=%s",
+			(&url.URL{Path: path}).EscapedPath(), lock,
+		))
+	}
+
+	writer, err := open("immediate")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Exec(sqliteSchema); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	reader, err := open("deferred")
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	return &sqliteDB{reader, writer}, nil
+}
+
+func (d *sqliteDB) View(fn func(Tx) error) error {
+	tx, err := d.reader.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t := &sqliteTx{tx: tx}
+	if err := fn(t); err != nil {
+		return err
+	}
+	return t.err
+}
+
+func (d *sqliteDB) Update(fn func(Tx) error) error {
+	tx, err := d.writer.Begin()
+	if err != nil {
+		return err
+	}
+
+	t := &sqliteTx{tx: tx}
+	if err := fn(t); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if t.err != nil {
+		tx.Rollback()
+		return t.err
+	}
+	return tx.Commit()
+}
+
+func (d *sqliteDB) Close() error {
+	d.reader.Close()
+	return d.writer.Close()
+}
+
+// sqliteTx remembers the first error of the methods that can't return one,
+// such as Get, and fails the transaction with it.
+type sqliteTx struct {
+	tx  *sql.Tx
+	err error
+}
+
+func (t *sqliteTx) fail(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *sqliteTx) Bucket(name []byte) Bucket {
+	var exists int
+	err := t.tx.QueryRow("SELECT 1 FROM buckets WHERE name = ?", name).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		t.fail(err)
+		return nil
+	}
+	return &sqliteBucket{t, name}
+}
+
+func (t *sqliteTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if _, err := t.tx.Exec("INSERT OR IGNORE INTO buckets (name) VALUES (?)", name); err != nil {
+		return nil, err
+	}
+	return &sqliteBucket{t, name}, nil
+}
+
+func (t *sqliteTx) ForEach(fn func([]byte, Bucket) error) error {
+	names, err := t.query("SELECT name FROM buckets ORDER BY name")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := fn(name[0], &sqliteBucket{t, name[0]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query reads every row of a query before returning, so that callers can run
+// other statements in the transaction while going through them.
+func (t *sqliteTx) query(query string, args ...interface{}) ([][2][]byte, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var results [][2][]byte
+	for rows.Next() {
+		var row [2][]byte
+		if len(columns) == 1 {
+			err = rows.Scan(&row[0])
+		} else {
+			err = rows.Scan(&row[0], &row[1])
+			if row[1] == nil {
+				row[1] = []byte{}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, row)
+	}
+	return results, rows.Err()
+}
+
+type sqliteBucket struct {
+	tx   *sqliteTx
+	name []byte
+}
+
+func (b *sqliteBucket) Get(key []byte) []byte {
+	var value []byte
+	err := b.tx.tx.QueryRow("SELECT value FROM entries WHERE bucket = ? AND key = ?",
+		b.name, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		b.tx.fail(err)
+		return nil
+	}
+	// empty values are still set
+	if value == nil {
+		value = []byte{}
+	}
+	return value
+}
+
+func (b *sqliteBucket) Put(key, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	_, err := b.tx.tx.Exec("INSERT OR REPLACE INTO entries (bucket, key, value) VALUES (?, ?, ?)",
+		b.name, key, value)
+	return err
+}
+
+func (b *sqliteBucket) Delete(key []byte) error {
+	_, err := b.tx.tx.Exec("DELETE FROM entries WHERE bucket = ? AND key = ?", b.name, key)
+	return err
+}
+
+func (b *sqliteBucket) ForEach(fn func([]byte, []byte) error) error {
+	entries, err := b.tx.query("SELECT key, value FROM entries WHERE bucket = ? ORDER BY key", b.name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := fn(entry[0], entry[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *sqliteBucket) Cursor() Cursor {
+	return &sqliteCursor{bucket: b}
+}
+
+// sqliteCursor looks up each key after the last one it returned, rather than
+// holding a query open.
+type sqliteCursor struct {
+	bucket *sqliteBucket
+	last   []byte
+}
+
+func (c *sqliteCursor) First() ([]byte, []byte) {
+	return c.find("SELECT key, value FROM entries WHERE bucket = ? ORDER BY key LIMIT 1",
+		c.bucket.name)
+}
+
+func (c *sqliteCursor) Last() ([]byte, []byte) {
+	return c.find("SELECT key, value FROM entries WHERE bucket = ? ORDER BY key DESC LIMIT 1",
+		c.bucket.name)
+}
+
+func (c *sqliteCursor) Next() ([]byte, []byte) {
+	if c.last == nil {
+		return nil, nil
+	}
+	return c.find("SELECT key, value FROM entries WHERE bucket = ? AND key > ? ORDER BY key LIMIT 1",
+		c.bucket.name, c.last)
+}
+
+func (c *sqliteCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.find("SELECT key, value FROM entries WHERE bucket = ? AND key >= ? ORDER BY key LIMIT 1",
+		c.bucket.name, seek)
+}
+
+func (c *sqliteCursor) find(query string, args ...interface{}) ([]byte, []byte) {
+	entries, err := c.bucket.tx.query(query, args...)
+	if err != nil {
+		c.bucket.tx.fail(err)
+		entries = nil
+	}
+	if len(entries) == 0 {
+		c.last = nil
+		return nil, nil
+	}
+	c.last = entries[0][0]
+	return entries[0][0], entries[0][1]
+}
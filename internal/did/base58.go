@@ -0,0 +1,89 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package did
+
+import (
+	"fmt"
+	"strings"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var ErrorInvalidBase58 = fmt.Errorf("invalid base58 encoding")
+
+// encodeBase58 encodes bytes with the Bitcoin base58 alphabet, which multibase
+// calls base58btc. Leading zero bytes are encoded as leading '1's.
+func encodeBase58(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+
+	// digits holds the base58 digits of the number, least significant first
+	var digits []byte
+	for _, c := range b[zeros:] {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	var encoded strings.Builder
+	encoded.WriteString(strings.Repeat("1", zeros))
+	for i := len(digits) - 1; i >= 0; i-- {
+		encoded.WriteByte(base58Alphabet[digits[i]])
+	}
+	return encoded.String()
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+
+	// decoded holds the bytes of the number, least significant first
+	var decoded []byte
+	for _, r := range s[zeros:] {
+		carry := strings.IndexRune(base58Alphabet, r)
+		if carry < 0 {
+			return nil, ErrorInvalidBase58
+		}
+		for i := range decoded {
+			carry += int(decoded[i]) * 58
+			decoded[i] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			decoded = append(decoded, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	b := make([]byte, zeros, zeros+len(decoded))
+	for i := len(decoded) - 1; i >= 0; i-- {
+		b = append(b, decoded[i])
+	}
+	return b, nil
+}
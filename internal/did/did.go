@@ -0,0 +1,117 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package did derives did:key decentralized identifiers from public keys, as
+// described by https://w3c-ccg.github.io/did-method-key, and describes them
+// with DID documents.
+package did
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+)
+
+const prefix = "did:key:"
+
+// Key types of did:key identifiers, named after the multicodec of each.
+const (
+	KeyEd25519 = "ed25519-pub"
+	KeyX25519  = "x25519-pub"
+)
+
+// multicodecs maps key types to their multicodec prefixes, which are the
+// unsigned varint encodings of 0xed and 0xec.
+var multicodecs = map[string][]byte{
+	KeyEd25519: {0xed, 0x01},
+	KeyX25519:  {0xec, 0x01},
+}
+
+var (
+	ErrorInvalidDID = fmt.Errorf("not a valid did:key identifier")
+)
+
+// ErrorUnsupportedKey is returned when parsing a did:key identifier of a key
+// type other than KeyEd25519 or KeyX25519.
+type ErrorUnsupportedKey struct {
+	DID string
+}
+
+func (err ErrorUnsupportedKey) Error() string {
+	return fmt.Sprintf("%s is not an Ed25519 or X25519 key", err.DID)
+}
+
+// Key is the public key a did:key identifier is derived from.
+type Key struct {
+	Type      string
+	PublicKey []byte
+}
+
+// Ed25519 returns the did:key identifier of an Ed25519 public key.
+func Ed25519(key ed25519.PublicKey) string {
+	return prefix + multibase(KeyEd25519, key)
+}
+
+// X25519 returns the did:key identifier of an X25519 public key.
+func X25519(key [32]byte) string {
+	return prefix + multibase(KeyX25519, key[:])
+}
+
+// encodedLength is the length of the multibase encoding of an Ed25519 or X25519
+// key, which is the same for both.
+var encodedLength = len(multibase(KeyEd25519, make([]byte, ed25519.PublicKeySize)))
+
+// multibase encodes a public key prefixed by the multicodec of its type as
+// base58btc, which multibase denotes with a leading 'z'.
+func multibase(keyType string, key []byte) string {
+	return "z" + encodeBase58(append(append([]byte{}, multicodecs[keyType]...), key...))
+}
+
+// Parse returns the public key of a did:key identifier. A DID URL fragment,
+// such as the one naming a verification method, is ignored.
+func Parse(did string) (*Key, error) {
+	if !strings.HasPrefix(did, prefix) {
+		return nil, ErrorInvalidDID
+	}
+
+	encoded := strings.TrimPrefix(did, prefix)
+	if i := strings.IndexByte(encoded, '#'); i >= 0 {
+		encoded = encoded[:i]
+	}
+	if !strings.HasPrefix(encoded, "z") {
+		return nil, ErrorInvalidDID
+	}
+
+	// decoding base58 takes time quadratic in its length, so identifiers that
+	// can't be of a supported key are refused before decoding
+	if len(encoded) != encodedLength {
+		return nil, ErrorInvalidDID
+	}
+
+	decoded, err := decodeBase58(encoded[1:])
+	if err != nil {
+		return nil, ErrorInvalidDID
+	}
+
+	for keyType, codec := range multicodecs {
+		if len(decoded) != len(codec)+32 || string(decoded[:len(codec)]) != string(codec) {
+			continue
+		}
+		return &Key{keyType, decoded[len(codec):]}, nil
+	}
+	return nil, ErrorUnsupportedKey{prefix + encoded}
+}
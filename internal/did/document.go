@@ -0,0 +1,90 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package did
+
+// MediaType is the media type of DID documents represented as JSON-LD.
+const MediaType = "application/did+ld+json"
+
+// Document is a DID document, which describes how the subject of a DID
+// authenticates and agrees on keys.
+type Document struct {
+	Context              []string             `json:"@context"`
+	ID                   string               `json:"id"`
+	VerificationMethod   []VerificationMethod `json:"verificationMethod"`
+	Authentication       []string             `json:"authentication,omitempty"`
+	AssertionMethod      []string             `json:"assertionMethod,omitempty"`
+	CapabilityInvocation []string             `json:"capabilityInvocation,omitempty"`
+	CapabilityDelegation []string             `json:"capabilityDelegation,omitempty"`
+	KeyAgreement         []string             `json:"keyAgreement,omitempty"`
+}
+
+// VerificationMethod is a public key of the subject of a DID document.
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+// NewDocument derives the DID document of a did:key identifier from the
+// identifier alone. The key of an Ed25519 identifier may be used for any
+// purpose but key agreement; no X25519 key is derived from it, as with the
+// method's enableEncryptionKeyDerivation option turned off. The key of an
+// X25519 identifier may only be used for key agreement.
+func NewDocument(identifier string) (*Document, error) {
+	key, err := Parse(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := multibase(key.Type, key.PublicKey)
+	id := prefix + encoded
+	method := id + "#" + encoded
+
+	document := &Document{
+		Context: []string{"https://www.w3.org/ns/did/v1"},
+		ID:      id,
+	}
+
+	switch key.Type {
+	case KeyEd25519:
+		document.Context = append(document.Context,
+			"https://w3id.org/security/suites/ed25519-2020/v1")
+		document.VerificationMethod = []VerificationMethod{{
+			ID:                 method,
+			Type:               "Ed25519VerificationKey2020",
+			Controller:         id,
+			PublicKeyMultibase: encoded,
+		}}
+		document.Authentication = []string{method}
+		document.AssertionMethod = []string{method}
+		document.CapabilityInvocation = []string{method}
+		document.CapabilityDelegation = []string{method}
+	case KeyX25519:
+		document.Context = append(document.Context,
+			"https://w3id.org/security/suites/x25519-2020/v1")
+		document.VerificationMethod = []VerificationMethod{{
+			ID:                 method,
+			Type:               "X25519KeyAgreementKey2020",
+			Controller:         id,
+			PublicKeyMultibase: encoded,
+		}}
+		document.KeyAgreement = []string{method}
+	}
+	return document, nil
+}
@@ -0,0 +1,169 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/akb/identify/internal/database"
+)
+
+var aliasPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+var (
+	ErrorInvalidAlias = fmt.Errorf("aliases must be 1 to 64 letters, digits, " +
+		"'.', '_' or '-', starting with a letter or digit")
+	ErrorUnknownAlias = fmt.Errorf("unknown alias")
+)
+
+// ErrorAliasTaken is returned when an alias is already held by another
+// identity.
+type ErrorAliasTaken struct {
+	Alias string
+}
+
+func (err ErrorAliasTaken) Error() string {
+	return fmt.Sprintf("alias '%s' is already taken", err.Alias)
+}
+
+// ValidateAlias checks that an alias is well-formed. Aliases may not look like
+// UUIDs, since either may be used to look up an identity.
+func ValidateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return ErrorInvalidAlias
+	}
+	if _, err := uuid.Parse(alias); err == nil {
+		return ErrorInvalidAlias
+	}
+	return nil
+}
+
+// SplitAliases parses a comma-separated list of aliases, ignoring blank
+// entries.
+func SplitAliases(aliases string) []string {
+	var split []string
+	for _, a := range strings.Split(aliases, ",") {
+		if a = strings.TrimSpace(a); len(a) > 0 {
+			split = append(split, a)
+		}
+	}
+	return split
+}
+
+// putAlias claims an alias for an identity in the alias index.
+func putAlias(tx database.Tx, id, alias string) error {
+	if err := ValidateAlias(alias); err != nil {
+		return err
+	}
+
+	ab, err := tx.CreateBucketIfNotExists(aliasBucketKey)
+	if err != nil {
+		return err
+	}
+
+	if owner := ab.Get([]byte(alias)); owner != nil {
+		if string(owner) == id {
+			return nil
+		}
+		return ErrorAliasTaken{alias}
+	}
+
+	return ab.Put([]byte(alias), []byte(id))
+}
+
+func (s *localStore) AddAlias(id, alias string) error {
+	return s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := putAlias(tx, id, alias); err != nil {
+			return err
+		}
+
+		added := true
+		err = updateIdentity(tx, id, func(stored *publicIdentity) error {
+			for _, a := range stored.aliases {
+				if a == alias {
+					added = false
+					return nil
+				}
+			}
+			stored.aliases = append(stored.aliases, alias)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if !added {
+			return nil
+		}
+		if err := logIdentity(tx, LogAliases, id); err != nil {
+			return err
+		}
+
+		log.Printf("added alias %s to identity %s\n", alias, id)
+		return nil
+	})
+}
+
+func (s *localStore) RemoveAlias(id, alias string) error {
+	return s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		ab := tx.Bucket(aliasBucketKey)
+		if ab == nil || string(ab.Get([]byte(alias))) != id {
+			return ErrorUnknownAlias
+		}
+
+		if err := ab.Delete([]byte(alias)); err != nil {
+			return err
+		}
+
+		err = updateIdentity(tx, id, func(stored *publicIdentity) error {
+			var aliases []string
+			for _, a := range stored.aliases {
+				if a != alias {
+					aliases = append(aliases, a)
+				}
+			}
+			stored.aliases = aliases
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := logIdentity(tx, LogAliases, id); err != nil {
+			return err
+		}
+
+		log.Printf("removed alias %s from identity %s\n", alias, id)
+		return nil
+	})
+}
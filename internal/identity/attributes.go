@@ -0,0 +1,225 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"regexp"
+	"sort"
+
+	"github.com/akb/identify/internal/database"
+)
+
+// Well-known profile attributes.
+const (
+	AttributeName  = "name"
+	AttributeEmail = "email"
+)
+
+var (
+	attributeNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]{0,63}$`)
+
+	// reservedClaims are set by the token store and can't be overridden by
+	// profile attributes.
+	reservedClaims = map[string]bool{
+		"aud": true, "exp": true, "iat": true, "iss": true, "jti": true,
+		"nbf": true, "sub": true, "identity": true, "amr": true,
+		"groups": true, "roles": true,
+	}
+
+	ErrorInvalidAttribute = fmt.Errorf("attribute names must start with a letter " +
+		"and contain only letters, digits, '.', '_' and '-'")
+	ErrorUnknownAttribute = fmt.Errorf("identity has no such attribute")
+	ErrorReservedClaim    = fmt.Errorf("attribute name is reserved for token claims")
+	ErrorSealedClaim      = fmt.Errorf("sealed attributes can't be used as token claims")
+	ErrorInvalidEmail     = fmt.Errorf("email attribute must be an email address")
+)
+
+// Attribute is a piece of profile information about an identity.
+type Attribute struct {
+	Name  string
+	Value string
+
+	// Sealed attributes are encrypted to the seal key of the identity. Their
+	// Value is empty until they are opened with the private identity.
+	Sealed bool
+
+	// Claim attributes are included in access tokens issued to the identity.
+	Claim bool
+
+	sealed []byte
+}
+
+type jsonAttribute struct {
+	Value  string `json:"value,omitempty"`
+	Sealed string `json:"sealed,omitempty"`
+	Claim  bool   `json:"claim,omitempty"`
+}
+
+// ValidateAttribute checks the name and value of an attribute before it is set.
+func ValidateAttribute(a Attribute) error {
+	if !attributeNamePattern.MatchString(a.Name) {
+		return ErrorInvalidAttribute
+	}
+	if a.Claim {
+		if a.Sealed {
+			return ErrorSealedClaim
+		}
+		if reservedClaims[a.Name] {
+			return ErrorReservedClaim
+		}
+	}
+	if a.Name == AttributeEmail && !a.Sealed {
+		if _, err := mail.ParseAddress(a.Value); err != nil {
+			return ErrorInvalidEmail
+		}
+	}
+	return nil
+}
+
+// Open returns the value of an attribute, decrypting it if it is sealed.
+func (a Attribute) Open(i PrivateIdentity) (string, error) {
+	if !a.Sealed {
+		return a.Value, nil
+	}
+	return i.OpenAnonymous(a.sealed)
+}
+
+func (a Attribute) toJSON() jsonAttribute {
+	if a.Sealed {
+		return jsonAttribute{Sealed: EncodeToString(a.sealed)}
+	}
+	return jsonAttribute{Value: a.Value, Claim: a.Claim}
+}
+
+func (j jsonAttribute) toAttribute(name string) (Attribute, error) {
+	a := Attribute{Name: name, Value: j.Value, Claim: j.Claim}
+	if j.Sealed != "" {
+		sealed, err := DecodeString(j.Sealed)
+		if err != nil {
+			return Attribute{}, err
+		}
+		a.Sealed = true
+		a.sealed = sealed
+	}
+	return a, nil
+}
+
+func (i publicIdentity) Attributes() []Attribute {
+	var attributes []Attribute
+	for _, a := range i.attributes {
+		attributes = append(attributes, a)
+	}
+	sort.Slice(attributes, func(x, y int) bool {
+		return attributes[x].Name < attributes[y].Name
+	})
+	return attributes
+}
+
+func (i publicIdentity) Claims() map[string]interface{} {
+	claims := map[string]interface{}{}
+	for name, a := range i.attributes {
+		if a.Claim && !a.Sealed {
+			claims[name] = a.Value
+		}
+	}
+	return claims
+}
+
+func (s *localStore) SetAttribute(id string, a Attribute) error {
+	if err := ValidateAttribute(a); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		err = updateIdentity(tx, id, func(stored *publicIdentity) error {
+			if a.Sealed {
+				sealed, err := stored.SealAnonymous(a.Value)
+				if err != nil {
+					return err
+				}
+				a.Value = ""
+				a.sealed = sealed
+			}
+
+			if stored.attributes == nil {
+				stored.attributes = map[string]Attribute{}
+			}
+			stored.attributes[a.Name] = a
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("set attribute %s of identity %s\n", a.Name, id)
+		return nil
+	})
+}
+
+func (s *localStore) RemoveAttribute(id, name string) error {
+	return s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		err = updateIdentity(tx, id, func(stored *publicIdentity) error {
+			if _, ok := stored.attributes[name]; !ok {
+				return ErrorUnknownAttribute
+			}
+			delete(stored.attributes, name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("removed attribute %s of identity %s\n", name, id)
+		return nil
+	})
+}
+
+// resealAttributes re-encrypts the sealed attributes of an identity to the
+// seal key of the rotated identity.
+func resealAttributes(
+	attributes map[string]Attribute, private, rotated *privateIdentity,
+) (map[string]Attribute, error) {
+	resealed := map[string]Attribute{}
+	for name, a := range attributes {
+		if a.Sealed {
+			value, err := private.OpenAnonymous(a.sealed)
+			if err != nil {
+				return nil, err
+			}
+			a.sealed, err = rotated.SealAnonymous(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		resealed[name] = a
+	}
+	return resealed, nil
+}
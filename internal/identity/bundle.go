@@ -0,0 +1,322 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/akb/identify/internal/database"
+)
+
+const (
+	bundleFormat  = "identify-identity-bundle"
+	bundleVersion = 1
+)
+
+var (
+	ErrorInvalidBundle    = fmt.Errorf("file is not an identity bundle or is corrupt")
+	ErrorBundlePassphrase = fmt.Errorf("unable to decrypt identity bundle with this passphrase")
+	ErrorSecretExists     = fmt.Errorf("a secret with the same key already exists")
+)
+
+// ImportOptions control how conflicts with existing records are resolved when
+// importing an identity.
+type ImportOptions struct {
+	// Replace overwrites an existing identity with the same id, along with
+	// any existing secrets with the same keys that the imported identity or
+	// the replacer can read.
+	Replace bool
+
+	// Replacer is the authenticated identity replacing an existing identity.
+	// It must be that identity or hold the identities:manage permission.
+	Replacer PrivateIdentity
+
+	// SkipTakenAliases drops aliases that belong to other identities instead
+	// of failing the import.
+	SkipTakenAliases bool
+}
+
+// jsonBundle is a portable export of an identity. The payload is sealed with a
+// random key, which is wrapped by the bundle passphrase in the same kind of
+// envelope that protects a private identity.
+type jsonBundle struct {
+	Format  string        `json:"format"`
+	Version int           `json:"version"`
+	ID      string        `json:"id"`
+	KDF     *jsonEnvelope `json:"kdf"`
+	Payload string        `json:"payload"`
+}
+
+type jsonBundlePayload struct {
+	Identity json.RawMessage `json:"identity"`
+
+	// Secrets remain sealed to the identity.
+	Secrets map[string]string `json:"secrets,omitempty"`
+
+	Exported time.Time `json:"exported"`
+}
+
+// ExportIdentity returns a bundle of the stored record of an identity, which
+// includes its sealed private keys and aliases, encrypted with the given
+// passphrase. Secrets that belong to the identity are included if requested.
+func (s *localStore) ExportIdentity(i PrivateIdentity, passphrase string, secrets bool) ([]byte, error) {
+	payload := jsonBundlePayload{Exported: time.Now().UTC()}
+
+	err := s.db.View(func(tx database.Tx) error {
+		b := tx.Bucket(identityBucketKey)
+		if b == nil {
+			return fmt.Errorf("identity bucket doesn't exist")
+		}
+		record := b.Get([]byte(i.String()))
+		if record == nil {
+			return fmt.Errorf("could not find identity for id %s", i)
+		}
+		payload.Identity = append(json.RawMessage{}, record...)
+
+		sb := tx.Bucket(secretBucketKey)
+		if !secrets || sb == nil {
+			return nil
+		}
+
+		// secrets aren't indexed by recipient; include the ones the identity
+		// can read
+		payload.Secrets = map[string]string{}
+		return sb.ForEach(func(key, stored []byte) error {
+			if isSecretRecipient(i, stored) {
+				payload.Secrets[string(key)] = EncodeToString(stored)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	marshaled, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := newEnvelope(passphrase, key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(marshaled, key)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("exported identity %s with %d secrets\n", i, len(payload.Secrets))
+	return json.MarshalIndent(jsonBundle{
+		Format:  bundleFormat,
+		Version: bundleVersion,
+		ID:      i.String(),
+		KDF:     e.toJSON(),
+		Payload: EncodeToString(sealed),
+	}, "", "  ")
+}
+
+// ImportIdentity decrypts a bundle made by ExportIdentity and adds the identity
+// and its secrets to the store.
+func (s *localStore) ImportIdentity(bundle []byte, passphrase string, options ImportOptions) (PublicIdentity, error) {
+	var unmarshaled jsonBundle
+	if err := json.Unmarshal(bundle, &unmarshaled); err != nil {
+		return nil, ErrorInvalidBundle
+	}
+
+	if unmarshaled.Format != bundleFormat || unmarshaled.KDF == nil {
+		return nil, ErrorInvalidBundle
+	}
+	if unmarshaled.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported identity bundle version %d", unmarshaled.Version)
+	}
+
+	e, err := unmarshaled.KDF.toEnvelope()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := e.open(passphrase)
+	if err != nil {
+		return nil, ErrorBundlePassphrase
+	}
+
+	sealed, err := DecodeString(unmarshaled.Payload)
+	if err != nil {
+		return nil, ErrorInvalidBundle
+	}
+
+	// secretbox authenticates the payload, so a bundle that opens hasn't been
+	// tampered with
+	opened, err := open(sealed, key)
+	if err != nil {
+		return nil, ErrorInvalidBundle
+	}
+
+	var payload jsonBundlePayload
+	if err := json.Unmarshal(opened, &payload); err != nil {
+		return nil, ErrorInvalidBundle
+	}
+
+	var imported publicIdentity
+	if err := json.Unmarshal(payload.Identity, &imported); err != nil {
+		return nil, ErrorInvalidBundle
+	}
+	if imported.String() != unmarshaled.ID {
+		return nil, ErrorInvalidBundle
+	}
+
+	secrets := map[string][]byte{}
+	for key, value := range payload.Secrets {
+		secrets[key], err = DecodeString(value)
+		if err != nil {
+			return nil, ErrorInvalidBundle
+		}
+	}
+
+	id := imported.String()
+	err = s.db.Update(func(tx database.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(identityBucketKey)
+		if err != nil {
+			return err
+		}
+
+		if isTombstoned(tx, id) {
+			return ErrorIdentityDeleted
+		}
+
+		// a bundle's public record is only as trustworthy as whoever made it,
+		// so it can't take over the DIDs and log entries of another identity
+		if err := checkDIDs(tx, &imported); err != nil {
+			return err
+		}
+
+		// roles and ssh principals are granted by this store, never by a bundle
+		groups, roles, principals := imported.groups, []string(nil), []string(nil)
+		var replaced *publicIdentity
+		if existing := b.Get([]byte(id)); existing != nil {
+			if !options.Replace {
+				return ErrorIdentityExists
+			}
+			if options.Replacer == nil {
+				return ErrorForbidden
+			}
+			if options.Replacer.String() != id {
+				if err := authorize(tx, options.Replacer, PermissionManageIdentities); err != nil {
+					return err
+				}
+			}
+			if err := freeAliases(tx, id); err != nil {
+				return err
+			}
+
+			replaced = &publicIdentity{}
+			if err := json.Unmarshal(existing, replaced); err != nil {
+				return err
+			}
+			groups = append(groups, replaced.groups...)
+
+			// roles stay with the keys they were granted to
+			if replaced.ed25519PublicKey.Equal(*imported.ed25519PublicKey) {
+				roles, principals = replaced.roles, replaced.sshPrincipals
+			} else if len(replaced.roles) > 0 || len(replaced.sshPrincipals) > 0 {
+				log.Printf("dropped the roles and ssh principals of identity %s, whose keys were replaced\n", id)
+			}
+		}
+		imported.roles = roles
+		imported.sshPrincipals = principals
+
+		// group membership is kept by the groups of this store, not the bundle
+		imported.groups = memberGroups(tx, id, groups)
+
+		var aliases []string
+		for _, a := range imported.aliases {
+			err := putAlias(tx, id, a)
+			if _, ok := err.(ErrorAliasTaken); ok && options.SkipTakenAliases {
+				log.Printf("skipped alias %s of imported identity %s\n", a, id)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			aliases = append(aliases, a)
+		}
+		imported.aliases = aliases
+
+		marshaled, err := json.Marshal(imported)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(id), marshaled); err != nil {
+			return err
+		}
+
+		if err := indexDIDs(tx, replaced, id); err != nil {
+			return err
+		}
+
+		if err := logIdentity(tx, LogImport, id); err != nil {
+			return err
+		}
+
+		sb, err := tx.CreateBucketIfNotExists(secretBucketKey)
+		if err != nil {
+			return err
+		}
+		for key, sealed := range secrets {
+			stored := sb.Get([]byte(key))
+			if stored != nil && (!options.Replace || !mayReplaceSecret(id, options.Replacer, stored)) {
+				return ErrorSecretExists
+			}
+			if err := sb.Put([]byte(key), sealed); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("imported identity %s with %d secrets\n", id, len(secrets))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	imported.store = s
+	return &imported, nil
+}
+
+// mayReplaceSecret reports whether an import may overwrite a stored secret,
+// which it may only if the secret was already shared with the imported
+// identity or the replacer can read it. Otherwise a bundle could overwrite the
+// secrets of any identity by naming their keys.
+func mayReplaceSecret(id string, replacer PrivateIdentity, stored []byte) bool {
+	if e, err := parseSecret(stored); err == nil && e != nil {
+		if _, ok := e.recipients[id]; ok {
+			return true
+		}
+	}
+	return replacer != nil && isSecretRecipient(replacer, stored)
+}
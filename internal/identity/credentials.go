@@ -0,0 +1,92 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/akb/identify/internal/webauthn"
+)
+
+// Authentication method for the amr claim from RFC 8176, used for WebAuthn
+// credentials since their private keys never leave the authenticator.
+const MethodHardwareKey = "hwk"
+
+var (
+	ErrorCredentialExists  = fmt.Errorf("credential is already registered")
+	ErrorUnknownCredential = fmt.Errorf("unknown credential")
+)
+
+// Credentials returns the WebAuthn credentials registered to the identity.
+func (i publicIdentity) Credentials() []webauthn.Credential {
+	return i.credentials
+}
+
+// AddCredential registers a WebAuthn credential, such as a passkey, that may be
+// used to log in to the web interface as the identity.
+func (s *localStore) AddCredential(id string, c webauthn.Credential) error {
+	err := s.updateIdentity(id, func(stored *publicIdentity) error {
+		for _, existing := range stored.credentials {
+			if bytes.Equal(existing.ID, c.ID) {
+				return ErrorCredentialExists
+			}
+		}
+		stored.credentials = append(stored.credentials, c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("registered webauthn credential for identity: %s\n", id)
+	return nil
+}
+
+func (s *localStore) RemoveCredential(id string, credentialID []byte) error {
+	err := s.updateIdentity(id, func(stored *publicIdentity) error {
+		for n, existing := range stored.credentials {
+			if bytes.Equal(existing.ID, credentialID) {
+				stored.credentials = append(stored.credentials[:n], stored.credentials[n+1:]...)
+				return nil
+			}
+		}
+		return ErrorUnknownCredential
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("removed webauthn credential for identity: %s\n", id)
+	return nil
+}
+
+// UpdateSignCount records the signature counter of a credential after a
+// successful assertion, so that cloned authenticators can be detected.
+func (s *localStore) UpdateSignCount(id string, credentialID []byte, count uint32) error {
+	return s.updateIdentity(id, func(stored *publicIdentity) error {
+		for n, existing := range stored.credentials {
+			if bytes.Equal(existing.ID, credentialID) {
+				stored.credentials[n].SignCount = count
+				return nil
+			}
+		}
+		return ErrorUnknownCredential
+	})
+}
@@ -0,0 +1,191 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/did"
+)
+
+var (
+	ErrorUnknownDID = fmt.Errorf("no identity has the key of this DID")
+	ErrorKeysTaken  = fmt.Errorf("the keys belong to another identity")
+)
+
+// DID returns the did:key identifier of the current Ed25519 key of an
+// identity. It changes when the identity's keys are rotated.
+func DID(i PublicIdentity) string {
+	return did.Ed25519(i.Ed25519PublicKey())
+}
+
+// SealDID returns the did:key identifier of the current seal key of an
+// identity, which is the key to agree on keys with it. It changes when the
+// identity's keys are rotated.
+func SealDID(i PublicIdentity) string {
+	return did.X25519(i.SealPublicKey())
+}
+
+// DIDDocument returns the DID document of an identity that was looked up by
+// name. If the name is the did:key of one of its keys the document describes
+// that key, otherwise it describes the DID of the identity's Ed25519 key.
+func DIDDocument(i PublicIdentity, name string) (*did.Document, error) {
+	if isDID(name) {
+		return did.NewDocument(name)
+	}
+	return did.NewDocument(DID(i))
+}
+
+func isDID(id string) bool {
+	return strings.HasPrefix(id, "did:")
+}
+
+// didBucketKey indexes identities by the did:key identifiers of their current
+// Ed25519 and seal keys.
+var didBucketKey = []byte("did")
+
+// resolveDID returns the UUID of the identity whose current Ed25519 or seal
+// key a did:key identifier was derived from.
+func resolveDID(tx database.Tx, identifier string) (string, error) {
+	key, err := did.Parse(identifier)
+	if err != nil {
+		return "", err
+	}
+
+	// the index is keyed by identifiers without a fragment
+	var canonical string
+	switch key.Type {
+	case did.KeyEd25519:
+		canonical = did.Ed25519(key.PublicKey)
+	case did.KeyX25519:
+		var sealKey [32]byte
+		copy(sealKey[:], key.PublicKey)
+		canonical = did.X25519(sealKey)
+	}
+
+	b := tx.Bucket(didBucketKey)
+	if b == nil {
+		return "", ErrorUnknownDID
+	}
+	id := b.Get([]byte(canonical))
+	if id == nil {
+		return "", ErrorUnknownDID
+	}
+	return string(id), nil
+}
+
+// dids returns the did:key identifiers of the current keys of an identity.
+func dids(i *publicIdentity) []string {
+	return []string{did.Ed25519(*i.ed25519PublicKey), did.X25519(*i.sealPublicKey)}
+}
+
+// indexDIDs points the DIDs of the current keys of an identity at it, after
+// removing those of the keys it held before, if any.
+func indexDIDs(tx database.Tx, previous *publicIdentity, id string) error {
+	b, err := tx.CreateBucketIfNotExists(didBucketKey)
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		if err := unindexDIDs(tx, previous); err != nil {
+			return err
+		}
+	}
+
+	current, err := getIdentity(tx, id)
+	if err != nil {
+		return err
+	}
+	for _, identifier := range dids(current) {
+		if err := b.Put([]byte(identifier), []byte(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDIDs returns ErrorKeysTaken if the current keys of an identity are
+// indexed under another identity, which an identity written from outside the
+// store, such as an imported one, could otherwise claim.
+func checkDIDs(tx database.Tx, i *publicIdentity) error {
+	b := tx.Bucket(didBucketKey)
+	if b == nil {
+		return nil
+	}
+	for _, identifier := range dids(i) {
+		if id := b.Get([]byte(identifier)); id != nil && string(id) != i.String() {
+			return ErrorKeysTaken
+		}
+	}
+	return nil
+}
+
+// unindexDIDs removes the DIDs of the current keys of an identity from the
+// index.
+func unindexDIDs(tx database.Tx, i *publicIdentity) error {
+	b := tx.Bucket(didBucketKey)
+	if b == nil {
+		return nil
+	}
+	for _, identifier := range dids(i) {
+		if string(b.Get([]byte(identifier))) != i.String() {
+			continue
+		}
+		if err := b.Delete([]byte(identifier)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexExistingDIDs is the migration that indexes the identities written
+// before DIDs were indexed.
+func indexExistingDIDs(tx database.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(didBucketKey); err != nil {
+		return err
+	}
+
+	b := tx.Bucket(identityBucketKey)
+	if b == nil {
+		return nil
+	}
+
+	var ids []string
+	err := b.ForEach(func(id, _ []byte) error {
+		ids = append(ids, string(id))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := getIdentity(tx, id); err != nil {
+			log.Printf("left unreadable identity %s out of the DID index: %s\n", id, err.Error())
+			continue
+		}
+		if err := indexDIDs(tx, nil, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/akb/identify/internal/stream"
+)
+
+const (
+	encryptionFormat  = "identify-encryption"
+	encryptionVersion = 1
+
+	// maxEncryptionHeader bounds the length of the header line, which grows
+	// with the number of recipients.
+	maxEncryptionHeader = 1024 * 1024
+)
+
+var (
+	ErrorEncryptionFormat = fmt.Errorf("file is not encrypted by identify or its header is corrupt")
+	ErrorNoRecipients     = fmt.Errorf("at least one recipient is required")
+	ErrorNotRecipient     = fmt.Errorf("file was not encrypted to this identity")
+	ErrorRetiredKey       = fmt.Errorf("file was encrypted to a retired key this identity no longer holds")
+	ErrorWrongSender      = fmt.Errorf("file was encrypted by another identity")
+	ErrorSenderSignature  = fmt.Errorf("file was not signed by its sender")
+)
+
+// An encrypted file is a header line followed by a stream of encrypted chunks
+// as described by package stream. The header is a JSON object:
+//
+//	{
+//	  "format": "identify-encryption",
+//	  "version": 1,
+//	  "sender": "<uuid of the sender>",
+//	  "sender-key-id": "<key id of the sender>",
+//	  "chunk-size": 65536,
+//	  "nonce": "<16 byte nonce prefix of the stream>",
+//	  "recipients": [
+//	    {"id": "<uuid>", "key-id": "<key id>", "key": "<wrapped file key>"}
+//	  ]
+//	}
+//
+// followed by a newline; binary values are unpadded base64. A random 32 byte
+// file key is wrapped for each recipient with a NaCl box from the sender's
+// seal key to the recipient's, prefixed by its 24 byte nonce. The chunks are
+// encrypted with HKDF-SHA256 of the file key, using the header line (without
+// its newline) as the info, so that any change to the header is detected.
+//
+// Every recipient learns the file key, so the box alone doesn't keep one
+// recipient from encrypting other content under the sender's header for
+// another. The plaintext is therefore followed, inside the encrypted stream,
+// by the sender's Ed25519 signature of the format name, the header line and
+// the SHA-512 digest of the plaintext, each followed by a newline.
+type jsonEncryptionHeader struct {
+	Format      string             `json:"format"`
+	Version     int                `json:"version"`
+	Sender      string             `json:"sender"`
+	SenderKeyID string             `json:"sender-key-id"`
+	ChunkSize   int                `json:"chunk-size"`
+	Nonce       string             `json:"nonce"`
+	Recipients  []jsonEncryptedKey `json:"recipients"`
+}
+
+type jsonEncryptedKey struct {
+	ID    string `json:"id"`
+	KeyID string `json:"key-id"`
+	Key   string `json:"key"`
+}
+
+// EncryptionHeader is the parsed header of an encrypted file.
+type EncryptionHeader struct {
+	Sender     string
+	Recipients []string
+
+	header jsonEncryptionHeader
+	raw    []byte
+}
+
+// Encrypt writes the header of an encrypted file to w and returns a writer
+// that encrypts to it. The file can be decrypted by each of the recipients.
+// Close must be called to complete the file.
+func (i privateIdentity) Encrypt(w io.Writer, recipients []PublicIdentity) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, ErrorNoRecipients
+	}
+
+	var fileKey [32]byte
+	if _, err := io.ReadFull(rand.Reader, fileKey[:]); err != nil {
+		return nil, err
+	}
+
+	var prefix [stream.PrefixSize]byte
+	if _, err := io.ReadFull(rand.Reader, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	header := jsonEncryptionHeader{
+		Format:      encryptionFormat,
+		Version:     encryptionVersion,
+		Sender:      i.String(),
+		SenderKeyID: i.KeyID(),
+		ChunkSize:   stream.DefaultChunkSize,
+		Nonce:       EncodeToString(prefix[:]),
+	}
+
+	seen := map[string]bool{}
+	for _, r := range recipients {
+		if seen[r.String()] {
+			continue
+		}
+		seen[r.String()] = true
+
+		var nonce [24]byte
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return nil, err
+		}
+		key := r.SealPublicKey()
+		wrapped := box.Seal(nonce[:], fileKey[:], &nonce, &key, i.sealPrivateKey)
+
+		header.Recipients = append(header.Recipients, jsonEncryptedKey{
+			ID:    r.String(),
+			KeyID: r.KeyID(),
+			Key:   EncodeToString(wrapped),
+		})
+	}
+
+	raw, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(append(raw, '\n')); err != nil {
+		return nil, err
+	}
+
+	payloadKey, err := derivePayloadKey(&fileKey, raw)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := stream.NewWriter(w, payloadKey, &prefix, header.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &signedWriter{w: encrypted, key: i.Ed25519PrivateKey(), header: raw, digest: sha512.New()}, nil
+}
+
+// ReadEncryptionHeader reads the header of an encrypted file, leaving r at the
+// start of its encrypted chunks.
+func ReadEncryptionHeader(r *bufio.Reader) (*EncryptionHeader, error) {
+	var line []byte
+	for {
+		fragment, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, ErrorEncryptionFormat
+		}
+		line = append(line, fragment...)
+		if len(line) > maxEncryptionHeader {
+			return nil, ErrorEncryptionFormat
+		}
+		if !isPrefix {
+			break
+		}
+	}
+
+	var header jsonEncryptionHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, ErrorEncryptionFormat
+	}
+	if header.Format != encryptionFormat || header.Version != encryptionVersion {
+		return nil, ErrorEncryptionFormat
+	}
+
+	parsed := EncryptionHeader{Sender: header.Sender, header: header, raw: line}
+	for _, r := range header.Recipients {
+		parsed.Recipients = append(parsed.Recipients, r.ID)
+	}
+	return &parsed, nil
+}
+
+// Decrypt returns a reader of the plaintext of an encrypted file, given its
+// header, the identity that sent it and the remainder of the file. Either may
+// have rotated its keys since; retired seal keys are kept for this, except
+// those retired before they were.
+//
+// The reader returns an error instead of any chunk that was modified, and at
+// the end of a file that was truncated, so the plaintext must not be trusted
+// until it has been read to io.EOF.
+func (i privateIdentity) Decrypt(h *EncryptionHeader, sender PublicIdentity, r io.Reader) (io.Reader, error) {
+	if sender.String() != h.header.Sender {
+		return nil, ErrorWrongSender
+	}
+
+	senderKey, err := sender.SealPublicKeyForID(h.header.SenderKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipient *jsonEncryptedKey
+	for n := range h.header.Recipients {
+		if h.header.Recipients[n].ID == i.String() {
+			recipient = &h.header.Recipients[n]
+			break
+		}
+	}
+	if recipient == nil {
+		return nil, ErrorNotRecipient
+	}
+	privateKey, ok := i.sealPrivateKeyForID(recipient.KeyID)
+	if !ok {
+		return nil, ErrorRetiredKey
+	}
+
+	wrapped, err := DecodeString(recipient.Key)
+	if err != nil || len(wrapped) < 24 {
+		return nil, ErrorEncryptionFormat
+	}
+	var nonce [24]byte
+	copy(nonce[:], wrapped[:24])
+
+	opened, ok := box.Open(nil, wrapped[24:], &nonce, &senderKey, privateKey)
+	if !ok || len(opened) != 32 {
+		return nil, ErrorEncryptionFormat
+	}
+	var fileKey [32]byte
+	copy(fileKey[:], opened)
+
+	decoded, err := DecodeString(h.header.Nonce)
+	if err != nil || len(decoded) != stream.PrefixSize {
+		return nil, ErrorEncryptionFormat
+	}
+	var prefix [stream.PrefixSize]byte
+	copy(prefix[:], decoded)
+
+	signingKey, _, err := sender.Ed25519PublicKeyForID(h.header.SenderKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadKey, err := derivePayloadKey(&fileKey, h.raw)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := stream.NewReader(r, payloadKey, &prefix, h.header.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedReader{r: decrypted, key: signingKey, header: h.raw, digest: sha512.New()}, nil
+}
+
+func derivePayloadKey(fileKey *[32]byte, header []byte) (*[32]byte, error) {
+	info := bytes.Join([][]byte{[]byte(encryptionFormat), header}, []byte("\n"))
+
+	var key [32]byte
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey[:], nil, info), key[:]); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// signedMessage is what the sender of a file signs, given the digest of its
+// plaintext.
+func signedMessage(header, digest []byte) []byte {
+	return bytes.Join([][]byte{[]byte(encryptionFormat), header, digest, nil}, []byte("\n"))
+}
+
+// signedWriter digests the plaintext written to an encrypted stream, and
+// appends the sender's signature when closed.
+type signedWriter struct {
+	w      io.WriteCloser
+	key    ed25519.PrivateKey
+	header []byte
+	digest hash.Hash
+}
+
+func (w *signedWriter) Write(p []byte) (int, error) {
+	w.digest.Write(p)
+	return w.w.Write(p)
+}
+
+func (w *signedWriter) Close() error {
+	signature := ed25519.Sign(w.key, signedMessage(w.header, w.digest.Sum(nil)))
+	if _, err := w.w.Write(signature); err != nil {
+		return err
+	}
+	return w.w.Close()
+}
+
+// verifiedReader holds back the signature at the end of a decrypted stream,
+// and returns io.EOF only if it is the sender's signature of the plaintext.
+type verifiedReader struct {
+	r      io.Reader
+	key    ed25519.PublicKey
+	header []byte
+	digest hash.Hash
+
+	chunk  []byte
+	buffer []byte
+	eof    bool
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	for {
+		if available := len(r.buffer) - ed25519.SignatureSize; available > 0 {
+			if available > len(p) {
+				available = len(p)
+			}
+			n := copy(p, r.buffer[:available])
+			r.digest.Write(p[:n])
+			r.buffer = r.buffer[n:]
+			return n, nil
+		}
+
+		if r.eof {
+			if len(r.buffer) != ed25519.SignatureSize ||
+				!ed25519.Verify(r.key, signedMessage(r.header, r.digest.Sum(nil)), r.buffer) {
+				return 0, ErrorSenderSignature
+			}
+			return 0, io.EOF
+		}
+
+		if r.chunk == nil {
+			r.chunk = make([]byte, stream.DefaultChunkSize)
+		}
+		n, err := r.r.Read(r.chunk)
+		r.buffer = append(r.buffer, r.chunk[:n]...)
+		if err == io.EOF {
+			r.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
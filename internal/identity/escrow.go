@@ -0,0 +1,304 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/shamir"
+)
+
+var escrowBucketKey = []byte("escrow")
+
+var (
+	ErrorNoEscrow        = fmt.Errorf("identity has not been escrowed")
+	ErrorStaleEscrow     = fmt.Errorf("identity keys have been rotated since it was escrowed")
+	ErrorNotTrustee      = fmt.Errorf("identity is not a trustee of this escrow")
+	ErrorEscrowThreshold = fmt.Errorf("not enough trustees to recover escrowed identity")
+	ErrorEscrowTrustees  = fmt.Errorf("trustees must be distinct identities other than the one escrowed")
+	ErrorTrusteeKey      = fmt.Errorf("share was sealed to a key the trustee no longer holds")
+)
+
+// escrow records the shares of the private key material of an identity, each
+// sealed by the identity to one of its trustees.
+type escrow struct {
+	Threshold int           `json:"threshold"`
+	KeyID     string        `json:"key-id"`
+	Created   time.Time     `json:"created"`
+	Shares    []escrowShare `json:"shares"`
+}
+
+type escrowShare struct {
+	Trustee string `json:"trustee"`
+	Sealed  string `json:"sealed"`
+
+	// KeyID is the seal key of the trustee the share was sealed to, which the
+	// trustee may have rotated since.
+	KeyID string `json:"key-id,omitempty"`
+}
+
+// EscrowIdentity splits the private keys of an identity into shares sealed to
+// each trustee, any threshold of whom can later recover the identity. Escrowing
+// again replaces the previous shares.
+func (s *localStore) EscrowIdentity(i PrivateIdentity, threshold int, trustees []PublicIdentity) error {
+	private, ok := i.(*privateIdentity)
+	if !ok {
+		return fmt.Errorf("identity %s was not loaded from a store", i)
+	}
+
+	seen := map[string]bool{i.String(): true}
+	for _, t := range trustees {
+		if seen[t.String()] {
+			return ErrorEscrowTrustees
+		}
+		seen[t.String()] = true
+	}
+
+	record, err := newEscrow(private, threshold, trustees)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx database.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(escrowBucketKey)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put([]byte(i.String()), marshaled); err != nil {
+			return err
+		}
+
+		log.Printf("escrowed identity %s with %d of %d trustees\n", i, threshold, len(trustees))
+		return nil
+	})
+}
+
+// newEscrow splits the private keys of an identity into shares sealed to each
+// trustee.
+func newEscrow(i *privateIdentity, threshold int, trustees []PublicIdentity) (*escrow, error) {
+	marshaled, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := shamir.Split(marshaled, len(trustees), threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	record := escrow{
+		Threshold: threshold,
+		KeyID:     i.KeyID(),
+		Created:   time.Now().UTC(),
+	}
+	for n, t := range trustees {
+		sealed, err := i.SealMessage(t, EncodeToString(shares[n]))
+		if err != nil {
+			return nil, err
+		}
+		record.Shares = append(record.Shares, escrowShare{
+			Trustee: t.String(),
+			Sealed:  EncodeToString(sealed),
+			KeyID:   t.KeyID(),
+		})
+	}
+	return &record, nil
+}
+
+// reescrow replaces the escrow of an identity whose keys were rotated with
+// shares of its new keys, sealed to the same trustees. Deleted trustees are
+// dropped, and if too few remain the escrow is removed.
+func reescrow(tx database.Tx, rotated *privateIdentity) error {
+	b := tx.Bucket(escrowBucketKey)
+	if b == nil {
+		return nil
+	}
+	marshaled := b.Get([]byte(rotated.String()))
+	if marshaled == nil {
+		return nil
+	}
+
+	var record escrow
+	if err := json.Unmarshal(marshaled, &record); err != nil {
+		return err
+	}
+
+	var trustees []PublicIdentity
+	for _, share := range record.Shares {
+		trustee, err := getIdentity(tx, share.Trustee)
+		if err == ErrorIdentityDeleted {
+			continue
+		} else if err != nil {
+			return err
+		}
+		trustees = append(trustees, trustee)
+	}
+
+	if len(trustees) < record.Threshold {
+		log.Printf("WARNING: removed escrow of %s, only %d of %d trustees remain\n",
+			rotated, len(trustees), record.Threshold)
+		return b.Delete([]byte(rotated.String()))
+	}
+
+	replaced, err := newEscrow(rotated, record.Threshold, trustees)
+	if err != nil {
+		return err
+	}
+	marshaled, err = json.Marshal(replaced)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("escrowed rotated keys of %s with %d of %d trustees\n",
+		rotated, record.Threshold, len(trustees))
+	return b.Put([]byte(rotated.String()), marshaled)
+}
+
+// RecoverEscrow combines the shares of the given trustees to reconstitute an
+// escrowed identity, which is then sealed with a new passphrase. Recovery codes
+// are invalidated since they protect the previous master key.
+func (s *localStore) RecoverEscrow(id string, trustees []PrivateIdentity, passphrase string) (PrivateIdentity, error) {
+	public, err := s.GetIdentity(id)
+	if err != nil {
+		return nil, err
+	}
+	stored := public.(*publicIdentity)
+
+	if stored.disabled {
+		return nil, ErrorIdentityDisabled
+	}
+
+	var record escrow
+	err = s.db.View(func(tx database.Tx) error {
+		b := tx.Bucket(escrowBucketKey)
+		if b == nil {
+			return ErrorNoEscrow
+		}
+		marshaled := b.Get([]byte(stored.String()))
+		if marshaled == nil {
+			return ErrorNoEscrow
+		}
+		return json.Unmarshal(marshaled, &record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if record.KeyID != stored.KeyID() {
+		return nil, ErrorStaleEscrow
+	}
+
+	var shares [][]byte
+	for _, t := range trustees {
+		share, err := record.open(stored, t)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+
+	if len(shares) < record.Threshold {
+		return nil, ErrorEscrowThreshold
+	}
+
+	combined, err := shamir.Combine(shares)
+	if err != nil {
+		return nil, err
+	}
+
+	private := privateIdentity{public: stored}
+	if err := json.Unmarshal(combined, &private); err != nil {
+		return nil, ErrorEscrowThreshold
+	}
+
+	recovered := private.Ed25519PrivateKey().Public().(ed25519.PublicKey)
+	if !bytes.Equal(recovered, stored.Ed25519PublicKey()) {
+		return nil, ErrorEscrowThreshold
+	}
+
+	private.masterKey, err = newMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := private.reseal(); err != nil {
+		return nil, err
+	}
+	stored.envelope, err = newEnvelope(passphrase, private.masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.updateIdentity(stored.String(), func(record *publicIdentity) error {
+		record.private = stored.private
+		record.envelope = stored.envelope
+		record.recovery = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stored.recovery = nil
+
+	log.Printf("recovered escrowed identity %s with %d trustees\n", stored, len(trustees))
+	return &private, nil
+}
+
+// open returns the share sealed to a trustee.
+func (e escrow) open(owner PublicIdentity, trustee PrivateIdentity) ([]byte, error) {
+	for _, share := range e.Shares {
+		if share.Trustee != trustee.String() {
+			continue
+		}
+
+		sealed, err := DecodeString(share.Sealed)
+		if err != nil {
+			return nil, err
+		}
+
+		private, ok := trustee.(*privateIdentity)
+		if !ok {
+			return nil, fmt.Errorf("identity %s was not loaded from a store", trustee)
+		}
+		key, ok := private.sealPrivateKeyForID(share.KeyID)
+		if share.KeyID == "" {
+			key, ok = private.sealPrivateKey, true
+		}
+		if !ok {
+			return nil, ErrorTrusteeKey
+		}
+
+		opened, err := private.openMessage(owner, key, sealed)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeString(opened)
+	}
+	return nil, ErrorNotTrustee
+}
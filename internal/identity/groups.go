@@ -0,0 +1,627 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/akb/identify/internal/database"
+)
+
+var (
+	groupBucketKey     = []byte("group")
+	groupNameBucketKey = []byte("group-name")
+)
+
+var (
+	ErrorUnknownGroup       = fmt.Errorf("unknown group")
+	ErrorNotGroupOwner      = fmt.Errorf("only the owner of a group can change its members")
+	ErrorNotGroupMember     = fmt.Errorf("identity is not a member of the group")
+	ErrorAlreadyGroupMember = fmt.Errorf("identity is already a member of the group")
+	ErrorRemoveGroupOwner   = fmt.Errorf("the owner of a group can't be removed from it")
+)
+
+// ErrorGroupNameTaken is returned when a group name is already held by another
+// group.
+type ErrorGroupNameTaken struct {
+	Name string
+}
+
+func (err ErrorGroupNameTaken) Error() string {
+	return fmt.Sprintf("group name '%s' is already taken", err.Name)
+}
+
+// ErrorGroupRekey is returned when an identity is deleted by an identity that
+// isn't a member of one of its groups, and so can't re-encrypt the secrets
+// shared with that group.
+type ErrorGroupRekey struct {
+	Name string
+}
+
+func (err ErrorGroupRekey) Error() string {
+	return fmt.Sprintf("only members of group '%s' can re-encrypt the secrets shared with it; "+
+		"remove the identity from the group first", err.Name)
+}
+
+// Recipient is anything a secret may be shared with: an identity or a group.
+type Recipient interface {
+	String() string
+	KeyID() string
+	SealAnonymous(value string) ([]byte, error)
+}
+
+// Group is a named set of identities. Each group has its own seal key pair,
+// whose private key is sealed to every member, so that secrets shared with the
+// group can be read by its current members. Only the owner, the identity that
+// created the group, may add or remove other members.
+type Group struct {
+	ID      string
+	Name    string
+	Owner   string
+	Created time.Time
+
+	sealPublicKey *[32]byte
+
+	// keys holds the private key of the group sealed to each member
+	keys map[string]wrappedKey
+}
+
+type jsonGroup struct {
+	ID            string                    `json:"id"`
+	Name          string                    `json:"name"`
+	Owner         string                    `json:"owner"`
+	Created       time.Time                 `json:"created"`
+	SealPublicKey string                    `json:"seal-public-key"`
+	Members       map[string]jsonWrappedKey `json:"members"`
+}
+
+func (g Group) String() string {
+	return g.ID
+}
+
+// Members returns the ids of the identities in the group.
+func (g Group) Members() []string {
+	var members []string
+	for id := range g.keys {
+		members = append(members, id)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// IsMember reports whether an identity belongs to the group.
+func (g Group) IsMember(id string) bool {
+	_, ok := g.keys[id]
+	return ok
+}
+
+// KeyID identifies the current seal key of the group, which changes whenever a
+// member is removed.
+func (g Group) KeyID() string {
+	sum := sha256.Sum256(g.sealPublicKey[:])
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func (g Group) SealAnonymous(value string) ([]byte, error) {
+	return box.SealAnonymous(nil, []byte(value), g.sealPublicKey, rand.Reader)
+}
+
+// openAnonymous opens a value sealed to the group as one of its members.
+func (g Group) openAnonymous(i PrivateIdentity, sealed []byte) ([]byte, error) {
+	privateKey, err := g.privateKey(i)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := box.OpenAnonymous(nil, sealed, g.sealPublicKey, privateKey)
+	if !ok {
+		return nil, errorCantDecrypt
+	}
+	return value, nil
+}
+
+// rekey replaces the key pair of the group and seals the new private key to
+// each of the members.
+func (g *Group) rekey(members []PublicIdentity) error {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	g.sealPublicKey = publicKey
+	g.keys = map[string]wrappedKey{}
+	for _, m := range members {
+		if err := g.wrap(privateKey, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Group) wrap(privateKey *[32]byte, member PublicIdentity) error {
+	sealed, err := member.SealAnonymous(string(privateKey[:]))
+	if err != nil {
+		return err
+	}
+	g.keys[member.String()] = wrappedKey{keyID: member.KeyID(), sealed: sealed}
+	return nil
+}
+
+// privateKey opens the private key of the group as one of its members.
+func (g Group) privateKey(i PrivateIdentity) (*[32]byte, error) {
+	wrapped, ok := g.keys[i.String()]
+	if !ok {
+		return nil, ErrorNotGroupMember
+	}
+
+	opened, err := i.OpenAnonymous(wrapped.sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(opened) != 32 {
+		return nil, errorCantDecrypt
+	}
+
+	var privateKey [32]byte
+	copy(privateKey[:], opened)
+	return &privateKey, nil
+}
+
+func (g Group) MarshalJSON() ([]byte, error) {
+	members := map[string]jsonWrappedKey{}
+	for id, k := range g.keys {
+		members[id] = jsonWrappedKey{KeyID: k.keyID, Key: EncodeToString(k.sealed)}
+	}
+
+	return json.Marshal(jsonGroup{
+		ID:            g.ID,
+		Name:          g.Name,
+		Owner:         g.Owner,
+		Created:       g.Created,
+		SealPublicKey: EncodeToString(g.sealPublicKey[:]),
+		Members:       members,
+	})
+}
+
+func (g *Group) UnmarshalJSON(marshaled []byte) error {
+	var unmarshaled jsonGroup
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		return err
+	}
+
+	sealPublicKey, err := DecodeString(unmarshaled.SealPublicKey)
+	if err != nil {
+		return err
+	}
+	if len(sealPublicKey) != 32 {
+		return fmt.Errorf("invalid seal public key for group %s", unmarshaled.ID)
+	}
+
+	g.ID = unmarshaled.ID
+	g.Name = unmarshaled.Name
+	g.Owner = unmarshaled.Owner
+	g.Created = unmarshaled.Created
+	g.sealPublicKey = &[32]byte{}
+	copy(g.sealPublicKey[:], sealPublicKey)
+
+	g.keys = map[string]wrappedKey{}
+	for id, k := range unmarshaled.Members {
+		sealed, err := DecodeString(k.Key)
+		if err != nil {
+			return err
+		}
+		g.keys[id] = wrappedKey{keyID: k.KeyID, sealed: sealed}
+	}
+	return nil
+}
+
+// Groups returns the ids of the groups the identity belongs to.
+func (i publicIdentity) Groups() []string {
+	return i.groups
+}
+
+// NewGroup creates a group owned by an identity, which is its first member.
+// Group names follow the same rules as aliases.
+func (s *localStore) NewGroup(owner PrivateIdentity, name string) (*Group, error) {
+	if err := ValidateAlias(name); err != nil {
+		return nil, err
+	}
+
+	g := Group{
+		ID:      uuid.New().String(),
+		Name:    name,
+		Owner:   owner.String(),
+		Created: time.Now().UTC(),
+	}
+	if err := g.rekey([]PublicIdentity{owner}); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Update(func(tx database.Tx) error {
+		nb, err := tx.CreateBucketIfNotExists(groupNameBucketKey)
+		if err != nil {
+			return err
+		}
+		if nb.Get([]byte(name)) != nil {
+			return ErrorGroupNameTaken{name}
+		}
+		if err := nb.Put([]byte(name), []byte(g.ID)); err != nil {
+			return err
+		}
+
+		if err := putGroup(tx, &g); err != nil {
+			return err
+		}
+		return joinGroup(tx, owner.String(), g.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("identity %s created group %s (%s)\n", owner, name, g.ID)
+	return &g, nil
+}
+
+// GetGroup looks up a group by UUID or name.
+func (s *localStore) GetGroup(id string) (*Group, error) {
+	var g *Group
+	err := s.db.View(func(tx database.Tx) error {
+		var err error
+		g, err = getGroup(tx, id)
+		return err
+	})
+	return g, err
+}
+
+// AddGroupMember seals the private key of a group to another identity, which
+// may then read the secrets shared with the group.
+func (s *localStore) AddGroupMember(owner PrivateIdentity, group string, member PublicIdentity) error {
+	return s.db.Update(func(tx database.Tx) error {
+		g, err := getGroup(tx, group)
+		if err != nil {
+			return err
+		}
+		if g.Owner != owner.String() {
+			return ErrorNotGroupOwner
+		}
+		if g.IsMember(member.String()) {
+			return ErrorAlreadyGroupMember
+		}
+
+		privateKey, err := g.privateKey(owner)
+		if err != nil {
+			return err
+		}
+		if err := g.wrap(privateKey, member); err != nil {
+			return err
+		}
+
+		if err := putGroup(tx, g); err != nil {
+			return err
+		}
+		if err := joinGroup(tx, member.String(), g.ID); err != nil {
+			return err
+		}
+
+		log.Printf("%s added %s to group %s\n", owner, member, g.ID)
+		return nil
+	})
+}
+
+// RemoveGroupMember removes an identity from a group. The owner may remove any
+// other member, and a member may remove itself. The group is given a new key
+// pair and the secrets shared with it are encrypted with new data keys, so
+// that the removed member can no longer read them.
+func (s *localStore) RemoveGroupMember(i PrivateIdentity, group, member string) error {
+	return s.db.Update(func(tx database.Tx) error {
+		g, err := getGroup(tx, group)
+		if err != nil {
+			return err
+		}
+
+		member, err := resolveID(tx, member)
+		if err != nil {
+			return err
+		}
+		if member == g.Owner {
+			return ErrorRemoveGroupOwner
+		}
+		if g.Owner != i.String() && member != i.String() {
+			return ErrorNotGroupOwner
+		}
+		if !g.IsMember(member) {
+			return ErrorNotGroupMember
+		}
+
+		reencrypted, err := removeMember(tx, i, g, member)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("%s removed %s from group %s, re-encrypted %d secrets\n",
+			i, member, g.ID, reencrypted)
+		return nil
+	})
+}
+
+// removeMember takes a member out of a group, replacing the key of the group
+// and re-encrypting the secrets shared with it as i, so that the member can't
+// read them with a key it kept. It returns the number of secrets re-encrypted.
+func removeMember(tx database.Tx, i PrivateIdentity, g *Group, member string) (int, error) {
+	// open the secrets shared with the group before its key is replaced
+	values, envelopes, err := groupSecrets(tx, i, g)
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining []PublicIdentity
+	for _, id := range g.Members() {
+		if id == member {
+			continue
+		}
+		m, err := getIdentity(tx, id)
+		if err == ErrorIdentityDeleted {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		remaining = append(remaining, m)
+	}
+	if err := g.rekey(remaining); err != nil {
+		return 0, err
+	}
+	if err := putGroup(tx, g); err != nil {
+		return 0, err
+	}
+
+	if err := putSecrets(tx, values, envelopes); err != nil {
+		return 0, err
+	}
+
+	if err := leaveGroup(tx, member, g.ID); err != nil {
+		return 0, err
+	}
+	return len(envelopes), nil
+}
+
+// DeleteGroup removes a group, its members' claims to it and its access to any
+// secrets shared with it.
+func (s *localStore) DeleteGroup(owner PrivateIdentity, group string) error {
+	return s.db.Update(func(tx database.Tx) error {
+		g, err := getGroup(tx, group)
+		if err != nil {
+			return err
+		}
+		if g.Owner != owner.String() {
+			return ErrorNotGroupOwner
+		}
+
+		if err := deleteGroup(tx, g); err != nil {
+			return err
+		}
+
+		log.Printf("%s deleted group %s (%s)\n", owner, g.Name, g.ID)
+		return nil
+	})
+}
+
+func getGroup(tx database.Tx, id string) (*Group, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		nb := tx.Bucket(groupNameBucketKey)
+		if nb == nil {
+			return nil, ErrorUnknownGroup
+		}
+		resolved := nb.Get([]byte(id))
+		if resolved == nil {
+			return nil, ErrorUnknownGroup
+		}
+		id = string(resolved)
+	}
+
+	gb := tx.Bucket(groupBucketKey)
+	if gb == nil {
+		return nil, ErrorUnknownGroup
+	}
+
+	stored := gb.Get([]byte(id))
+	if stored == nil {
+		return nil, ErrorUnknownGroup
+	}
+
+	var g Group
+	if err := json.Unmarshal(stored, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func putGroup(tx database.Tx, g *Group) error {
+	gb, err := tx.CreateBucketIfNotExists(groupBucketKey)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return gb.Put([]byte(g.ID), marshaled)
+}
+
+// deleteGroup removes a group from the store, from the records of its members
+// and from the recipients of any secrets shared with it.
+func deleteGroup(tx database.Tx, g *Group) error {
+	for _, id := range g.Members() {
+		if err := leaveGroup(tx, id, g.ID); err != nil {
+			return err
+		}
+	}
+
+	if nb := tx.Bucket(groupNameBucketKey); nb != nil {
+		if err := nb.Delete([]byte(g.Name)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(groupBucketKey).Delete([]byte(g.ID)); err != nil {
+		return err
+	}
+
+	b := tx.Bucket(secretBucketKey)
+	if b == nil {
+		return nil
+	}
+
+	unshared := map[string][]byte{}
+	err := b.ForEach(func(key, stored []byte) error {
+		e, err := parseSecret(stored)
+		if err != nil || e == nil {
+			return err
+		}
+		if _, ok := e.recipients[g.ID]; !ok {
+			return nil
+		}
+
+		delete(e.recipients, g.ID)
+		marshaled, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		unshared[string(key)] = marshaled
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for key, marshaled := range unshared {
+		if err := b.Put([]byte(key), marshaled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinGroup records a group on the record of one of its members, so that it
+// can be included in the member's tokens.
+func joinGroup(tx database.Tx, id, group string) error {
+	return updateIdentity(tx, id, func(stored *publicIdentity) error {
+		for _, g := range stored.groups {
+			if g == group {
+				return nil
+			}
+		}
+		stored.groups = append(stored.groups, group)
+		return nil
+	})
+}
+
+func leaveGroup(tx database.Tx, id, group string) error {
+	return updateIdentity(tx, id, func(stored *publicIdentity) error {
+		var groups []string
+		for _, g := range stored.groups {
+			if g != group {
+				groups = append(groups, g)
+			}
+		}
+		stored.groups = groups
+		return nil
+	})
+}
+
+// resealGroupKeys re-encrypts the private keys of the groups an identity
+// belongs to with the seal key of the rotated identity.
+func resealGroupKeys(tx database.Tx, private, rotated *privateIdentity) error {
+	for _, id := range private.public.groups {
+		g, err := getGroup(tx, id)
+		if err == ErrorUnknownGroup {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		privateKey, err := g.privateKey(private)
+		if err != nil {
+			return err
+		}
+		if err := g.wrap(privateKey, rotated); err != nil {
+			return err
+		}
+		if err := putGroup(tx, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFromGroups takes a deleted identity out of the groups it belongs to,
+// re-keying each as i, and deletes the groups it owns.
+func removeFromGroups(tx database.Tx, i PrivateIdentity, id string, groups []string) error {
+	for _, group := range groups {
+		g, err := getGroup(tx, group)
+		if err == ErrorUnknownGroup {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if g.Owner == id {
+			if err := deleteGroup(tx, g); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reencrypted, err := removeMember(tx, i, g, id)
+		if err == ErrorNotGroupMember {
+			return ErrorGroupRekey{g.Name}
+		} else if err != nil {
+			return err
+		}
+		log.Printf("removed deleted identity %s from group %s, re-encrypted %d secrets\n",
+			id, g.ID, reencrypted)
+	}
+	return nil
+}
+
+// memberGroups filters group ids to the groups in the store that an identity
+// is a member of.
+func memberGroups(tx database.Tx, id string, groups []string) []string {
+	var member []string
+	seen := map[string]bool{}
+	for _, group := range groups {
+		if seen[group] {
+			continue
+		}
+		seen[group] = true
+
+		if g, err := getGroup(tx, group); err == nil && g.IsMember(id) {
+			member = append(member, group)
+		}
+	}
+	return member
+}
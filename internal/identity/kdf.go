@@ -0,0 +1,228 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Envelope versions describe how the sealed private blob of an identity is
+// protected. Version 0 is the original scheme where the blob is sealed directly
+// with the SHA-256 hash of the passphrase; it has no envelope record and is
+// only ever read, never written.
+const (
+	envelopeVersionLegacy = 0
+	envelopeVersionArgon2 = 1
+)
+
+const kdfAlgorithmArgon2id = "argon2id"
+
+// KDFParameters are the tunable costs used to derive a key from a passphrase.
+// Identities sealed with weaker parameters than these are transparently
+// upgraded the next time they are authenticated.
+type KDFParameters struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var DefaultKDFParameters = KDFParameters{
+	Time:    1,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// Bounds on the parameters of envelopes read from records and bundles, so that
+// a crafted envelope can't make authenticating exhaust memory or CPU. Argon2
+// panics if the time or threads are zero.
+const (
+	maxKDFTime   = 16
+	maxKDFMemory = 1024 * 1024
+)
+
+// Valid reports whether the parameters are within the bounds accepted from
+// envelopes and other untrusted sources, such as backup archives.
+func (p KDFParameters) Valid() bool {
+	return p.Time > 0 && p.Time <= maxKDFTime && p.Threads > 0 &&
+		p.Memory > 0 && p.Memory <= maxKDFMemory
+}
+
+var (
+	errorUnknownEnvelope = fmt.Errorf("unknown private identity envelope")
+	errorKDFParameters   = fmt.Errorf("private identity envelope has invalid kdf parameters")
+	errorCantDecrypt     = fmt.Errorf("unable to decrypt identity")
+)
+
+// envelope records how the random master key that seals a private identity is
+// itself wrapped by a key derived from the passphrase.
+type envelope struct {
+	version int
+	salt    []byte
+	params  KDFParameters
+	key     []byte
+}
+
+type jsonEnvelope struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"`
+	Salt      string `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+	Key       string `json:"key"`
+}
+
+func newEnvelope(passphrase string, masterKey *[32]byte) (*envelope, error) {
+	e := envelope{
+		version: envelopeVersionArgon2,
+		salt:    make([]byte, 16),
+		params:  DefaultKDFParameters,
+	}
+	if _, err := io.ReadFull(rand.Reader, e.salt); err != nil {
+		return nil, err
+	}
+
+	key := e.derive(passphrase)
+	sealed, err := seal(masterKey[:], &key)
+	if err != nil {
+		return nil, err
+	}
+	e.key = sealed
+
+	return &e, nil
+}
+
+func (e *envelope) derive(passphrase string) [32]byte {
+	var key [32]byte
+	switch e.version {
+	case envelopeVersionArgon2:
+		copy(key[:], argon2.IDKey([]byte(passphrase), e.salt,
+			e.params.Time, e.params.Memory, e.params.Threads, 32))
+	default:
+		key = sha256.Sum256([]byte(passphrase))
+	}
+	return key
+}
+
+// open derives the passphrase key and unwraps the master key.
+func (e *envelope) open(passphrase string) (*[32]byte, error) {
+	if e.version != envelopeVersionArgon2 {
+		return nil, errorUnknownEnvelope
+	}
+
+	key := e.derive(passphrase)
+	unsealed, err := open(e.key, &key)
+	if err != nil {
+		return nil, err
+	}
+
+	var masterKey [32]byte
+	copy(masterKey[:], unsealed)
+	return &masterKey, nil
+}
+
+// current reports whether the envelope was made with the current defaults.
+func (e *envelope) current() bool {
+	return e != nil &&
+		e.version == envelopeVersionArgon2 &&
+		e.params.Time >= DefaultKDFParameters.Time &&
+		e.params.Memory >= DefaultKDFParameters.Memory &&
+		e.params.Threads >= DefaultKDFParameters.Threads
+}
+
+func (e *envelope) toJSON() *jsonEnvelope {
+	if e == nil {
+		return nil
+	}
+	return &jsonEnvelope{
+		Version:   e.version,
+		Algorithm: kdfAlgorithmArgon2id,
+		Salt:      EncodeToString(e.salt),
+		Time:      e.params.Time,
+		Memory:    e.params.Memory,
+		Threads:   e.params.Threads,
+		Key:       EncodeToString(e.key),
+	}
+}
+
+func (j jsonEnvelope) toEnvelope() (*envelope, error) {
+	if j.Version != envelopeVersionArgon2 || j.Algorithm != kdfAlgorithmArgon2id {
+		return nil, errorUnknownEnvelope
+	}
+
+	salt, err := DecodeString(j.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DecodeString(j.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	params := KDFParameters{j.Time, j.Memory, j.Threads}
+	if len(salt) == 0 || !params.Valid() {
+		return nil, errorKDFParameters
+	}
+
+	return &envelope{
+		version: j.Version,
+		salt:    salt,
+		params:  params,
+		key:     key,
+	}, nil
+}
+
+func newMasterKey() (*[32]byte, error) {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// seal encrypts a message with secretbox, prefixing the result with a random
+// nonce.
+func seal(message []byte, key *[32]byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], message, &nonce, key), nil
+}
+
+// open reverses seal.
+func open(sealed []byte, key *[32]byte) ([]byte, error) {
+	if len(sealed) < 24+secretbox.Overhead {
+		return nil, errorCantDecrypt
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+	unsealed, ok := secretbox.Open(nil, sealed[24:], &nonce, key)
+	if !ok {
+		return nil, errorCantDecrypt
+	}
+	return unsealed, nil
+}
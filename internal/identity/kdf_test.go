@@ -0,0 +1,63 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import "testing"
+
+func TestEnvelopeKDFParameters(t *testing.T) {
+	valid := jsonEnvelope{
+		Version:   envelopeVersionArgon2,
+		Algorithm: kdfAlgorithmArgon2id,
+		Salt:      EncodeToString(make([]byte, 16)),
+		Time:      1,
+		Memory:    64 * 1024,
+		Threads:   4,
+		Key:       EncodeToString(make([]byte, 72)),
+	}
+
+	// argon2 panics with no passes or threads, and larger costs would let a
+	// crafted record or bundle exhaust the CPU or memory of the server
+	for _, tc := range []struct {
+		name   string
+		modify func(*jsonEnvelope)
+		valid  bool
+	}{
+		{"defaults", func(*jsonEnvelope) {}, true},
+		{"no passes", func(j *jsonEnvelope) { j.Time = 0 }, false},
+		{"most passes", func(j *jsonEnvelope) { j.Time = maxKDFTime }, true},
+		{"too many passes", func(j *jsonEnvelope) { j.Time = maxKDFTime + 1 }, false},
+		{"no memory", func(j *jsonEnvelope) { j.Memory = 0 }, false},
+		{"most memory", func(j *jsonEnvelope) { j.Memory = maxKDFMemory }, true},
+		{"too much memory", func(j *jsonEnvelope) { j.Memory = maxKDFMemory + 1 }, false},
+		{"no threads", func(j *jsonEnvelope) { j.Threads = 0 }, false},
+		{"most threads", func(j *jsonEnvelope) { j.Threads = 255 }, true},
+		{"no salt", func(j *jsonEnvelope) { j.Salt = "" }, false},
+	} {
+		j := valid
+		tc.modify(&j)
+
+		e, err := j.toEnvelope()
+		if tc.valid && err != nil {
+			t.Errorf("%s: expected the envelope to be accepted: %s", tc.name, err)
+		} else if !tc.valid && err != errorKDFParameters {
+			t.Errorf("%s: expected the envelope to be refused, got %v", tc.name, err)
+		} else if tc.valid && e.params != (KDFParameters{j.Time, j.Memory, j.Threads}) {
+			t.Errorf("%s: expected the parameters to be kept, got %+v", tc.name, e.params)
+		}
+	}
+}
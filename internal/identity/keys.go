@@ -0,0 +1,113 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrorUnknownKey = fmt.Errorf("unknown key id")
+)
+
+// KeyID derives a short, stable identifier for a signing key. It is used as
+// the "kid" of tokens and signatures so that verifiers can select a retired
+// key from an identity's key history.
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// retiredKeys are the public keys an identity used before a key rotation.
+type retiredKeys struct {
+	ecdsaPublicKey   *ecdsa.PublicKey
+	ed25519PublicKey ed25519.PublicKey
+	sealPublicKey    *[32]byte
+	retired          time.Time
+}
+
+type jsonRetiredKeys struct {
+	KeyID            string    `json:"key-id"`
+	ECDSAPublicKey   string    `json:"ecdsa-public-key"`
+	Ed25519PublicKey string    `json:"ed25519-public-key"`
+	SealPublicKey    string    `json:"seal-public-key"`
+	Retired          time.Time `json:"retired"`
+}
+
+func (k retiredKeys) toJSON() (*jsonRetiredKeys, error) {
+	marshaledECDSAPublicKey, err := x509.MarshalPKIXPublicKey(k.ecdsaPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonRetiredKeys{
+		KeyID:            KeyID(k.ed25519PublicKey),
+		ECDSAPublicKey:   EncodeToString(marshaledECDSAPublicKey),
+		Ed25519PublicKey: EncodeToString(k.ed25519PublicKey),
+		SealPublicKey:    EncodeToString(k.sealPublicKey[:]),
+		Retired:          k.retired,
+	}, nil
+}
+
+func (j jsonRetiredKeys) toRetiredKeys() (*retiredKeys, error) {
+	ecdsaPublicKey, err := parseECDSAPublicKey(j.ECDSAPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ed25519PublicKey, err := DecodeString(j.Ed25519PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealPublicKey, err := DecodeString(j.SealPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	k := retiredKeys{
+		ecdsaPublicKey:   ecdsaPublicKey,
+		ed25519PublicKey: ed25519.PublicKey(ed25519PublicKey),
+		sealPublicKey:    &[32]byte{},
+		retired:          j.Retired,
+	}
+	copy(k.sealPublicKey[:], sealPublicKey)
+	return &k, nil
+}
+
+func parseECDSAPublicKey(encoded string) (*ecdsa.PublicKey, error) {
+	decoded, err := DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	untyped, err := x509.ParsePKIXPublicKey(decoded)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := untyped.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key is not a valid ecdsa public key")
+	}
+	return key, nil
+}
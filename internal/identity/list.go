@@ -0,0 +1,123 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/akb/identify/internal/database"
+)
+
+// DefaultListLimit is the page size used when a listing doesn't specify one.
+const DefaultListLimit = 50
+
+// ListOptions filter and paginate a listing of identities. Identities are
+// listed in order of their ids; Cursor is the id of the last identity of the
+// previous page.
+type ListOptions struct {
+	// Prefix matches identities whose id or any alias starts with it.
+	Prefix string
+
+	// Alias matches the identity with exactly this alias.
+	Alias string
+
+	Cursor string
+	Limit  int
+}
+
+// ListIdentities returns a page of identities along with the cursor of the
+// next page, which is empty when there are no more identities.
+func (s *localStore) ListIdentities(options ListOptions) ([]PublicIdentity, string, error) {
+	limit := options.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	var identities []PublicIdentity
+	var next string
+	err := s.db.View(func(tx database.Tx) error {
+		b := tx.Bucket(identityBucketKey)
+		if b == nil {
+			return nil
+		}
+
+		var aliasID []byte
+		if options.Alias != "" {
+			if ab := tx.Bucket(aliasBucketKey); ab != nil {
+				aliasID = ab.Get([]byte(options.Alias))
+			}
+			if aliasID == nil {
+				return nil
+			}
+		}
+
+		c := b.Cursor()
+		k, v := c.First()
+		if options.Cursor != "" {
+			k, v = c.Seek([]byte(options.Cursor))
+			if k != nil && string(k) == options.Cursor {
+				k, v = c.Next()
+			}
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			if aliasID != nil && !bytes.Equal(k, aliasID) {
+				continue
+			}
+
+			var identity publicIdentity
+			if err := json.Unmarshal(v, &identity); err != nil {
+				return err
+			}
+
+			if !identity.hasPrefix(options.Prefix) {
+				continue
+			}
+
+			if len(identities) == limit {
+				next = identities[limit-1].String()
+				return nil
+			}
+
+			identity.store = s
+			identities = append(identities, &identity)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return identities, next, nil
+}
+
+// hasPrefix reports whether the id or any alias of an identity starts with the
+// given prefix.
+func (i publicIdentity) hasPrefix(prefix string) bool {
+	if strings.HasPrefix(i.String(), prefix) {
+		return true
+	}
+	for _, a := range i.aliases {
+		if strings.HasPrefix(a, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -21,19 +21,76 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/policy"
+	"github.com/akb/identify/internal/transparency"
+	"github.com/akb/identify/internal/webauthn"
 )
 
 type Store interface {
 	NewIdentity(string, []string) (PublicIdentity, PrivateIdentity, error)
 	GetIdentity(string) (PublicIdentity, error)
-	PutSecret(PublicIdentity, string, string) error
+	ListIdentities(ListOptions) ([]PublicIdentity, string, error)
+	ChangePassphrase(PrivateIdentity, string) error
+	GenerateRecoveryCodes(PrivateIdentity) ([]string, error)
+	Recover(string, string, string) (PrivateIdentity, error)
+	EscrowIdentity(PrivateIdentity, int, []PublicIdentity) error
+	RecoverEscrow(string, []PrivateIdentity, string) (PrivateIdentity, error)
+	ExportIdentity(PrivateIdentity, string, bool) ([]byte, error)
+	EnrollTOTP(PrivateIdentity, []byte, string) error
+	RemoveTOTP(PrivateIdentity) error
+	VerifyTOTP(PrivateIdentity, string) error
+	AddCredential(string, webauthn.Credential) error
+	RemoveCredential(string, []byte) error
+	UpdateSignCount(string, []byte, uint32) error
+	ImportIdentity([]byte, string, ImportOptions) (PublicIdentity, error)
+	RotateKeys(PrivateIdentity) (PrivateIdentity, error)
+	DisableIdentity(PrivateIdentity, string) error
+	EnableIdentity(PrivateIdentity, string) error
+	DeleteIdentity(PrivateIdentity, string) error
+	AddAlias(string, string) error
+	RemoveAlias(string, string) error
+	SetAttribute(string, Attribute) error
+	RemoveAttribute(string, string) error
+	PutSecret(PublicIdentity, string, string, ...Recipient) error
 	GetSecret(PrivateIdentity, string) (string, error)
+	SecretRecipients(PrivateIdentity, string) ([]string, error)
+	AddSecretRecipients(PrivateIdentity, string, []Recipient) error
+	RemoveSecretRecipients(PrivateIdentity, string, []string) error
+	NewGroup(PrivateIdentity, string) (*Group, error)
+	GetGroup(string) (*Group, error)
+	AddGroupMember(PrivateIdentity, string, PublicIdentity) error
+	RemoveGroupMember(PrivateIdentity, string, string) error
+	DeleteGroup(PrivateIdentity, string) error
+	NewRole(PrivateIdentity, string, []string) (*Role, error)
+	GetRole(string) (*Role, error)
+	ListRoles() ([]Role, error)
+	DeleteRole(PrivateIdentity, string) error
+	AssignRole(PrivateIdentity, string, string) error
+	BootstrapAdministrator(PrivateIdentity) error
+	RecordServerIdentity(PrivateIdentity) error
+	IsServerIdentity(string) (bool, error)
+	RevokeRole(PrivateIdentity, string, string) error
+	Permits([]string, string) (bool, error)
+	PutPolicy(PrivateIdentity, *policy.Policy) (int, error)
+	GetPolicy(string, int) (*PolicyVersion, error)
+	PolicyHistory(string) ([]PolicyVersion, error)
+	ListPolicies() ([]PolicyVersion, error)
+	DeletePolicy(PrivateIdentity, string) error
+	Authorize(policy.Request) (*policy.Decision, error)
+	LogHead() (*transparency.TreeHead, error)
+	LogEntries(int, int) ([][]byte, error)
+	LogInclusionProof(int, int) ([][]byte, error)
+	LogConsistencyProof(int, int) ([][]byte, error)
+	LogIndex(string) (int, error)
+	IssueSSHCertificate(PrivateIdentity, string, SSHCertificateRequest) (*ssh.Certificate, error)
+	SetSSHPrincipals(PrivateIdentity, string, []string) error
 	Close()
 }
 
@@ -41,19 +98,49 @@ var (
 	aliasBucketKey    = []byte("alias")
 	identityBucketKey = []byte("identity")
 	secretBucketKey   = []byte("secret")
+
+	// tombstoneBucketKey records the ids of deleted identities so that they are
+	// never reused
+	tombstoneBucketKey = []byte("tombstone")
 )
 
+// Migrations upgrade identity databases written by earlier versions of
+// identify. Databases record how many have been applied, so migrations are
+// only ever appended.
+var Migrations = []database.Migration{
+	{
+		Description: "create the buckets of the initial schema",
+		Migrate: database.CreateBuckets(
+			aliasBucketKey, identityBucketKey, secretBucketKey, tombstoneBucketKey,
+			escrowBucketKey, groupBucketKey, groupNameBucketKey, roleBucketKey,
+			policyBucketKey,
+		),
+	},
+	{
+		Description: "record existing identities in the key transparency log",
+		Migrate:     logExistingIdentities,
+	},
+	{
+		Description: "index identities by the DIDs of their keys",
+		Migrate:     indexExistingDIDs,
+	},
+}
+
 type localStore struct {
-	db *bolt.DB
+	db database.DB
 }
 
+// NewLocalStore opens the identity database at a location, which is a path
+// to a bolt file or, prefixed with "sqlite:", a SQLite database, and applies
+// any pending migrations to it.
 func NewLocalStore(dbPath string) (*localStore, error) {
-	if _, err := os.Stat(filepath.Dir(dbPath)); os.IsNotExist(err) {
-		os.Mkdir(filepath.Dir(dbPath), 0755)
+	db, err := database.Open(dbPath)
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
-	if err != nil {
+	if err := database.Migrate(db, Migrations); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -64,6 +151,11 @@ func (s *localStore) Close() {
 	s.db.Close()
 }
 
+// Database returns the database the store is kept in, for backups.
+func (s *localStore) Database() database.DB {
+	return s.db
+}
+
 func (s *localStore) NewIdentity(passphrase string, aliases []string) (PublicIdentity, PrivateIdentity, error) {
 	public, private, err := NewIdentity(passphrase, aliases)
 	if err != nil {
@@ -75,35 +167,45 @@ func (s *localStore) NewIdentity(passphrase string, aliases []string) (PublicIde
 		return nil, nil, err
 	}
 
-	err = s.db.Update(func(tx *bolt.Tx) error {
+	err = s.db.Update(func(tx database.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(identityBucketKey)
 		if err != nil {
 			return err
 		}
 
+		if isTombstoned(tx, public.String()) {
+			return ErrorIdentityDeleted
+		}
+
+		if b.Get([]byte(public.String())) != nil {
+			return ErrorIdentityExists
+		}
+
 		err = b.Put([]byte(public.String()), marshaled)
 		if err != nil {
 			return err
 		}
 
-		ab, err := tx.CreateBucketIfNotExists(aliasBucketKey)
-		if err != nil {
+		for _, a := range public.aliases {
+			if err := putAlias(tx, public.String(), a); err != nil {
+				return err
+			}
+		}
+
+		if err := indexDIDs(tx, nil, public.String()); err != nil {
+			return err
+		}
+
+		if err := logIdentity(tx, LogCreate, public.String()); err != nil {
 			return err
 		}
 
 		var msg string
-		if len(aliases) == 0 || len(aliases[0]) == 0 {
+		if len(public.aliases) == 0 {
 			msg = fmt.Sprintf("created new identity: %s\n", public.String())
 		} else {
-			msg = fmt.Sprintf("created new identity: %s, a.k.a. ", public.String())
-			for i, a := range aliases {
-				if i+1 == len(aliases) {
-					msg = fmt.Sprintf("%s%s", msg, a)
-				} else {
-					msg = fmt.Sprintf("%s%s, ", msg, a)
-				}
-				err = ab.Put([]byte(a), []byte(public.String()))
-			}
+			msg = fmt.Sprintf("created new identity: %s, a.k.a. %s\n",
+				public.String(), strings.Join(public.aliases, ", "))
 		}
 
 		log.Print(msg)
@@ -117,75 +219,380 @@ func (s *localStore) NewIdentity(passphrase string, aliases []string) (PublicIde
 }
 
 func (s *localStore) GetIdentity(id string) (PublicIdentity, error) {
+	var identity *publicIdentity
+	err := s.db.View(func(tx database.Tx) error {
+		var err error
+		identity, err = getIdentity(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	identity.store = s
+	return identity, nil
+}
+
+// getIdentity reads an identity by UUID or alias within a transaction.
+func getIdentity(tx database.Tx, id string) (*publicIdentity, error) {
+	id, err := resolveID(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	b := tx.Bucket(identityBucketKey)
+	if b == nil {
+		return nil, fmt.Errorf("identity bucket doesn't exist")
+	}
+
+	unparsed := b.Get([]byte(id))
+	if unparsed == nil {
+		if isTombstoned(tx, id) {
+			return nil, ErrorIdentityDeleted
+		}
+		return nil, fmt.Errorf("could not find identity for id %s", id)
+	}
+
 	var identity publicIdentity
-	err := s.db.View(func(tx *bolt.Tx) error {
-		_, err := uuid.Parse(id)
+	if err := json.Unmarshal(unparsed, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// resolveID returns the UUID of an identity given its UUID, an alias or a
+// did:key identifier derived from one of its current keys.
+func resolveID(tx database.Tx, id string) (string, error) {
+	if _, err := uuid.Parse(id); err == nil {
+		return id, nil
+	}
+
+	if isDID(id) {
+		return resolveDID(tx, id)
+	}
+
+	ab := tx.Bucket(aliasBucketKey)
+	if ab == nil {
+		return "", ErrorUnknownAlias
+	}
+	aliasID := ab.Get([]byte(id))
+	if aliasID == nil {
+		return "", ErrorUnknownAlias
+	}
+	return string(aliasID), nil
+}
+
+func isTombstoned(tx database.Tx, id string) bool {
+	tb := tx.Bucket(tombstoneBucketKey)
+	return tb != nil && tb.Get([]byte(id)) != nil
+}
+
+// DisableIdentity prevents an identity from authenticating or being issued
+// tokens. Its aliases remain reserved. Neither the server identity nor the last
+// enabled administrator may be disabled.
+func (s *localStore) DisableIdentity(by PrivateIdentity, id string) error {
+	return s.setDisabled(by, id, true)
+}
+
+func (s *localStore) EnableIdentity(by PrivateIdentity, id string) error {
+	return s.setDisabled(by, id, false)
+}
+
+func (s *localStore) setDisabled(by PrivateIdentity, id string, disabled bool) error {
+	return s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
 		if err != nil {
-			ab := tx.Bucket(aliasBucketKey)
-			if ab == nil {
-				return fmt.Errorf("alias bucket doesn't exist")
+			return err
+		}
+
+		target, err := getIdentity(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := authorizeIdentity(tx, by, target); err != nil {
+			return err
+		}
+
+		if disabled {
+			if err := checkRemovable(tx, target); err != nil {
+				return err
 			}
-			aliasID := ab.Get([]byte(id))
-			if aliasID == nil {
-				return fmt.Errorf("unknown alias")
+		}
+
+		err = updateIdentity(tx, id, func(stored *publicIdentity) error {
+			stored.disabled = disabled
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if disabled {
+			log.Printf("disabled identity: %s\n", id)
+		} else {
+			log.Printf("enabled identity: %s\n", id)
+		}
+		return nil
+	})
+}
+
+// DeleteIdentity removes an identity and frees its aliases, leaving a tombstone
+// in its place so that its UUID is never reused. The identity leaves its
+// groups, which are re-keyed by i, and the groups it owns are deleted. Neither
+// the server identity nor the last enabled administrator may be deleted.
+func (s *localStore) DeleteIdentity(i PrivateIdentity, id string) error {
+	return s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := getIdentity(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := authorizeIdentity(tx, i, deleted); err != nil {
+			return err
+		}
+
+		if err := checkRemovable(tx, deleted); err != nil {
+			return err
+		}
+
+		if err := removeFromGroups(tx, i, id, deleted.groups); err != nil {
+			return err
+		}
+
+		if err := freeAliases(tx, id); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(identityBucketKey).Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		if eb := tx.Bucket(escrowBucketKey); eb != nil {
+			if err := eb.Delete([]byte(id)); err != nil {
+				return err
 			}
-			id = string(aliasID)
 		}
 
-		b := tx.Bucket(identityBucketKey)
-		if b == nil {
-			return fmt.Errorf("identity bucket doesn't exist")
+		tb, err := tx.CreateBucketIfNotExists(tombstoneBucketKey)
+		if err != nil {
+			return err
+		}
+
+		tombstone, err := json.Marshal(map[string]time.Time{"deleted": time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+
+		if err := tb.Put([]byte(id), tombstone); err != nil {
+			return err
+		}
+
+		if err := unindexDIDs(tx, deleted); err != nil {
+			return err
+		}
+
+		if err := logIdentity(tx, LogDelete, id); err != nil {
+			return err
+		}
+
+		log.Printf("deleted identity: %s\n", id)
+		return nil
+	})
+}
+
+// freeAliases removes every alias of an identity from the alias index.
+func freeAliases(tx database.Tx, id string) error {
+	ab := tx.Bucket(aliasBucketKey)
+	if ab == nil {
+		return nil
+	}
+
+	var aliases [][]byte
+	err := ab.ForEach(func(alias, aliasID []byte) error {
+		if string(aliasID) == id {
+			aliases = append(aliases, alias)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		unparsed := b.Get([]byte(id))
-		if unparsed == nil {
-			return fmt.Errorf("could not find identity for id %s", id)
+	for _, alias := range aliases {
+		if err := ab.Delete(alias); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// ChangePassphrase seals the private keys of an identity under a new master key
+// wrapped by the passphrase. The identity's recovery codes no longer work and
+// must be generated again.
+func (s *localStore) ChangePassphrase(i PrivateIdentity, passphrase string) error {
+	private, ok := i.(*privateIdentity)
+	if !ok {
+		return fmt.Errorf("identity %s was not loaded from a store", i)
+	}
+
+	if err := private.rekey(passphrase); err != nil {
+		return err
+	}
 
-		return json.Unmarshal(unparsed, &identity)
+	err := s.updateIdentity(i.String(), func(stored *publicIdentity) error {
+		stored.private = private.public.private
+		stored.envelope = private.public.envelope
+		stored.recovery = nil
+		return nil
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &identity, nil
+	log.Printf("changed passphrase for identity: %s\n", i)
+	return nil
 }
 
-func (s *localStore) PutSecret(i PublicIdentity, key, value string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(secretBucketKey)
+func (s *localStore) RotateKeys(i PrivateIdentity) (PrivateIdentity, error) {
+	private, ok := i.(*privateIdentity)
+	if !ok {
+		return nil, fmt.Errorf("identity %s was not loaded from a store", i)
+	}
+
+	rotated, err := private.rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Update(func(tx database.Tx) error {
+		previous, err := getIdentity(tx, i.String())
 		if err != nil {
 			return err
 		}
-		sealed, err := i.SealAnonymous(value)
+
+		err = updateIdentity(tx, i.String(), func(stored *publicIdentity) error {
+			attributes, err := resealAttributes(stored.attributes, private, rotated)
+			if err != nil {
+				return err
+			}
+			stored.attributes = attributes
+			rotated.public.attributes = attributes
+
+			stored.totp, err = resealTOTP(stored.totp, private, rotated)
+			if err != nil {
+				return err
+			}
+			rotated.public.totp = stored.totp
+
+			stored.ecdsaPublicKey = rotated.public.ecdsaPublicKey
+			stored.ed25519PublicKey = rotated.public.ed25519PublicKey
+			stored.sealPublicKey = rotated.public.sealPublicKey
+			stored.history = rotated.public.history
+			stored.private = rotated.public.private
+			return nil
+		})
 		if err != nil {
 			return err
 		}
-		return b.Put([]byte(key), sealed)
-	})
-}
 
-func (s *localStore) GetSecret(i PrivateIdentity, key string) (string, error) {
-	var value string
-	if err := s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(secretBucketKey)
-		if b == nil {
-			return fmt.Errorf("secret bucket doesn't exist")
+		if err := resealGroupKeys(tx, private, rotated); err != nil {
+			return err
 		}
 
-		sealed := b.Get([]byte(key))
-		if sealed == nil {
-			return fmt.Errorf("secret for key doesn't exist")
+		if err := reescrow(tx, rotated); err != nil {
+			return err
 		}
 
-		var err error
-		value, err = i.OpenAnonymous(sealed)
+		if err := indexDIDs(tx, previous, i.String()); err != nil {
+			return err
+		}
+
+		if err := logIdentity(tx, LogRotate, i.String()); err != nil {
+			return err
+		}
+
+		b := tx.Bucket(secretBucketKey)
+		if b == nil {
+			return nil
+		}
+
+		// secrets aren't indexed by recipient; the ones that open with the old
+		// key are readable by this identity
+		resealed := map[string][]byte{}
+		err = b.ForEach(func(key, stored []byte) error {
+			sealed, err := resealSecret(stored, private, rotated)
+			if sealed != nil {
+				resealed[string(key)] = sealed
+			}
+			return err
+		})
 		if err != nil {
 			return err
 		}
+
+		for key, sealed := range resealed {
+			if err := b.Put([]byte(key), sealed); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("rotated keys for identity %s, resealed %d secrets\n", i, len(resealed))
 		return nil
-	}); err != nil {
-		return "", err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rotated.public.store = s
+	return rotated, nil
+}
+
+// updateIdentity applies a change to the stored record of an identity within a
+// single transaction.
+func (s *localStore) updateIdentity(id string, update func(*publicIdentity) error) error {
+	return s.db.Update(func(tx database.Tx) error {
+		return updateIdentity(tx, id, update)
+	})
+}
+
+func updateIdentity(tx database.Tx, id string, update func(*publicIdentity) error) error {
+	b := tx.Bucket(identityBucketKey)
+	if b == nil {
+		return fmt.Errorf("identity bucket doesn't exist")
+	}
+
+	unparsed := b.Get([]byte(id))
+	if unparsed == nil {
+		return fmt.Errorf("could not find identity for id %s", id)
 	}
-	return value, nil
+
+	var identity publicIdentity
+	if err := json.Unmarshal(unparsed, &identity); err != nil {
+		return err
+	}
+
+	if err := update(&identity); err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(id), marshaled)
+}
+
+// putSealed stores the sealed private blob and envelope of an identity.
+func (s *localStore) putSealed(i *publicIdentity) error {
+	return s.updateIdentity(i.String(), func(stored *publicIdentity) error {
+		stored.private = i.private
+		stored.envelope = i.envelope
+		return nil
+	})
 }
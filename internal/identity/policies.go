@@ -0,0 +1,271 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/policy"
+)
+
+var policyBucketKey = []byte("policy")
+
+// selfAttributePrefix namespaces the profile attributes of a subject in
+// requests. Identities set their attributes themselves, so policies must not
+// mistake them for facts an administrator vouched for.
+const selfAttributePrefix = "self."
+
+var (
+	ErrorUnknownPolicy        = fmt.Errorf("unknown policy")
+	ErrorUnknownPolicyVersion = fmt.Errorf("policy has no such version")
+)
+
+// PolicyVersion is a stored revision of a policy. Versions are numbered from
+// one, and every change to a policy stores a new one.
+type PolicyVersion struct {
+	Policy  policy.Policy `json:"policy"`
+	Author  string        `json:"author"`
+	Created time.Time     `json:"created"`
+}
+
+// PutPolicy stores a new version of a policy, returning its number.
+func (s *localStore) PutPolicy(by PrivateIdentity, p *policy.Policy) (int, error) {
+	if err := p.Validate(); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := s.db.Update(func(tx database.Tx) error {
+		if err := authorize(tx, by, PermissionManagePolicies); err != nil {
+			return err
+		}
+
+		pb, err := tx.CreateBucketIfNotExists(policyBucketKey)
+		if err != nil {
+			return err
+		}
+
+		history, err := policyHistory(tx, p.Name)
+		if err != nil && err != ErrorUnknownPolicy {
+			return err
+		}
+
+		stored := *p
+		stored.Version = len(history) + 1
+		history = append(history, PolicyVersion{
+			Policy:  stored,
+			Author:  by.String(),
+			Created: time.Now().UTC(),
+		})
+
+		marshaled, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		version = stored.Version
+		return pb.Put([]byte(p.Name), marshaled)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("%s stored version %d of policy %s\n", by, version, p.Name)
+	return version, nil
+}
+
+// GetPolicy returns a version of a policy, or its latest version if version is
+// zero.
+func (s *localStore) GetPolicy(name string, version int) (*PolicyVersion, error) {
+	history, err := s.PolicyHistory(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		version = len(history)
+	}
+	if version < 1 || version > len(history) {
+		return nil, ErrorUnknownPolicyVersion
+	}
+	return &history[version-1], nil
+}
+
+// PolicyHistory returns every version of a policy, oldest first.
+func (s *localStore) PolicyHistory(name string) ([]PolicyVersion, error) {
+	var history []PolicyVersion
+	err := s.db.View(func(tx database.Tx) error {
+		var err error
+		history, err = policyHistory(tx, name)
+		return err
+	})
+	return history, err
+}
+
+// ListPolicies returns the latest version of every policy, sorted by name.
+func (s *localStore) ListPolicies() ([]PolicyVersion, error) {
+	var policies []PolicyVersion
+	err := s.db.View(func(tx database.Tx) error {
+		var err error
+		policies, err = latestPolicies(tx)
+		return err
+	})
+	return policies, err
+}
+
+// DeletePolicy removes a policy and all of its versions.
+func (s *localStore) DeletePolicy(by PrivateIdentity, name string) error {
+	err := s.db.Update(func(tx database.Tx) error {
+		if err := authorize(tx, by, PermissionManagePolicies); err != nil {
+			return err
+		}
+
+		pb := tx.Bucket(policyBucketKey)
+		if pb == nil || pb.Get([]byte(name)) == nil {
+			return ErrorUnknownPolicy
+		}
+		return pb.Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s deleted policy %s\n", by, name)
+	return nil
+}
+
+// Authorize decides a request against the latest version of every policy.
+// The subject, if any, must be an identity or one of its aliases. Its unsealed
+// attributes are added to the request as "self.<name>", and if the request has
+// no claims, it's given those an access token for the identity would carry.
+// Otherwise, the roles and groups claims are replaced with the stored ones, so
+// that those revoked since the token was issued no longer count.
+func (s *localStore) Authorize(r policy.Request) (*policy.Decision, error) {
+	var policies []*policy.Policy
+	err := s.db.View(func(tx database.Tx) error {
+		latest, err := latestPolicies(tx)
+		if err != nil {
+			return err
+		}
+		for n := range latest {
+			policies = append(policies, &latest[n].Policy)
+		}
+
+		if r.Subject == "" {
+			return nil
+		}
+		subject, err := getIdentity(tx, r.Subject)
+		if err != nil {
+			return err
+		}
+
+		r.Subject = subject.String()
+		r.Attributes = map[string]string{}
+		for _, a := range subject.Attributes() {
+			if !a.Sealed {
+				r.Attributes[selfAttributePrefix+a.Name] = a.Value
+			}
+		}
+
+		stored := identityClaims(subject)
+		if r.Claims == nil {
+			r.Claims = stored
+			return nil
+		}
+
+		claims := map[string]interface{}{}
+		for name, value := range r.Claims {
+			claims[name] = value
+		}
+		delete(claims, "groups")
+		for _, name := range []string{"roles", "groups"} {
+			if value, ok := stored[name]; ok {
+				claims[name] = value
+			}
+		}
+		r.Claims = claims
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.Evaluate(policies, r), nil
+}
+
+// identityClaims returns the claims of an access token for an identity that
+// policies may test.
+func identityClaims(i PublicIdentity) map[string]interface{} {
+	claims := map[string]interface{}{}
+	for name, value := range i.Claims() {
+		claims[name] = value
+	}
+	claims["identity"] = i.String()
+	if groups := i.Groups(); len(groups) > 0 {
+		claims["groups"] = groups
+	}
+	claims["roles"] = i.Roles()
+	return claims
+}
+
+func policyHistory(tx database.Tx, name string) ([]PolicyVersion, error) {
+	pb := tx.Bucket(policyBucketKey)
+	if pb == nil {
+		return nil, ErrorUnknownPolicy
+	}
+
+	stored := pb.Get([]byte(name))
+	if stored == nil {
+		return nil, ErrorUnknownPolicy
+	}
+
+	var history []PolicyVersion
+	if err := json.Unmarshal(stored, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func latestPolicies(tx database.Tx) ([]PolicyVersion, error) {
+	pb := tx.Bucket(policyBucketKey)
+	if pb == nil {
+		return nil, nil
+	}
+
+	var policies []PolicyVersion
+	err := pb.ForEach(func(name, stored []byte) error {
+		var history []PolicyVersion
+		if err := json.Unmarshal(stored, &history); err != nil {
+			return err
+		}
+		policies = append(policies, history[len(history)-1])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Policy.Name < policies[j].Policy.Name
+	})
+	return policies, nil
+}
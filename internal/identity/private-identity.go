@@ -26,8 +26,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	"golang.org/x/crypto/nacl/box"
+
+	"github.com/akb/identify/internal/webauthn"
 )
 
 var (
@@ -50,20 +53,37 @@ type PrivateIdentity interface {
 	SealMessage(PublicIdentity, string) ([]byte, error)
 
 	OpenAnonymous([]byte) (string, error)
+
+	// Sign creates a detached signature of data with one of the identity's
+	// signing keys.
+	Sign(algorithm string, data io.Reader) (*Signature, error)
+
+	// Encrypt and Decrypt stream files encrypted between identities.
+	Encrypt(w io.Writer, recipients []PublicIdentity) (io.WriteCloser, error)
+	Decrypt(h *EncryptionHeader, sender PublicIdentity, r io.Reader) (io.Reader, error)
 }
 
 type jsonPrivateIdentity struct {
 	ECDSAPrivateKey   string `json:"ecdsa-private-key"`
 	Ed25519PrivateKey string `json:"ed25519-private-key"`
 	SealPrivateKey    string `json:"seal-private-key"`
+
+	RetiredSealPrivateKeys map[string]string `json:"retired-seal-private-keys,omitempty"`
 }
 
 type privateIdentity struct {
 	public *publicIdentity
 
+	// masterKey seals the private blob of the public identity
+	masterKey *[32]byte
+
 	ecdsaPrivateKey   *ecdsa.PrivateKey
 	ed25519PrivateKey *ed25519.PrivateKey
 	sealPrivateKey    *[32]byte
+
+	// retiredSealKeys holds the seal private keys of previous key IDs, so that
+	// files and messages sealed to them can still be opened
+	retiredSealKeys map[string]*[32]byte
 }
 
 func (i privateIdentity) ECDSAPublicKey() *ecdsa.PublicKey {
@@ -78,6 +98,58 @@ func (i privateIdentity) SealPublicKey() [32]byte {
 	return i.public.SealPublicKey()
 }
 
+func (i privateIdentity) KeyID() string {
+	return i.public.KeyID()
+}
+
+func (i privateIdentity) Ed25519PublicKeyForID(kid string) (ed25519.PublicKey, time.Time, error) {
+	return i.public.Ed25519PublicKeyForID(kid)
+}
+
+func (i privateIdentity) SealPublicKeyForID(kid string) ([32]byte, error) {
+	return i.public.SealPublicKeyForID(kid)
+}
+
+func (i privateIdentity) Aliases() []string {
+	return i.public.Aliases()
+}
+
+func (i privateIdentity) Attributes() []Attribute {
+	return i.public.Attributes()
+}
+
+func (i privateIdentity) Claims() map[string]interface{} {
+	return i.public.Claims()
+}
+
+func (i privateIdentity) RecoveryCodes() int {
+	return i.public.RecoveryCodes()
+}
+
+func (i privateIdentity) TOTPEnrolled() bool {
+	return i.public.TOTPEnrolled()
+}
+
+func (i privateIdentity) Credentials() []webauthn.Credential {
+	return i.public.Credentials()
+}
+
+func (i privateIdentity) Groups() []string {
+	return i.public.Groups()
+}
+
+func (i privateIdentity) Roles() []string {
+	return i.public.Roles()
+}
+
+func (i privateIdentity) SSHPrincipals() []string {
+	return i.public.SSHPrincipals()
+}
+
+func (i privateIdentity) Disabled() bool {
+	return i.public.Disabled()
+}
+
 func (i privateIdentity) String() string {
 	return i.public.String()
 }
@@ -102,16 +174,37 @@ func (i privateIdentity) OpenMessage(
 	sender PublicIdentity,
 	encrypted []byte,
 ) (string, error) {
+	return i.openMessage(sender, i.sealPrivateKey, encrypted)
+}
+
+func (i privateIdentity) openMessage(
+	sender PublicIdentity,
+	privateKey *[32]byte,
+	encrypted []byte,
+) (string, error) {
+	if len(encrypted) < 24 {
+		return "", fmt.Errorf("unauthorized")
+	}
 	var nonce [24]byte
 	copy(nonce[:], encrypted[:24])
 	key := sender.SealPublicKey()
-	decrypted, ok := box.Open(nil, encrypted[24:], &nonce, &key, i.sealPrivateKey)
+	decrypted, ok := box.Open(nil, encrypted[24:], &nonce, &key, privateKey)
 	if !ok {
 		return "", fmt.Errorf("unauthorized")
 	}
 	return string(decrypted), nil
 }
 
+// sealPrivateKeyForID returns the current or a retired seal private key by key
+// ID. Keys retired before they were kept can't be returned.
+func (i privateIdentity) sealPrivateKeyForID(kid string) (*[32]byte, bool) {
+	if kid == i.KeyID() {
+		return i.sealPrivateKey, true
+	}
+	key, ok := i.retiredSealKeys[kid]
+	return key, ok
+}
+
 func (i privateIdentity) SealMessage(
 	recipient PublicIdentity,
 	message string,
@@ -136,16 +229,133 @@ func (i privateIdentity) SealAnonymous(value string) ([]byte, error) {
 	return i.public.SealAnonymous(value)
 }
 
+// reseal encrypts the private keys with the master key and stores the result
+// on the public identity.
+func (i *privateIdentity) reseal() error {
+	marshaled, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := seal(marshaled, i.masterKey)
+	if err != nil {
+		return err
+	}
+
+	i.public.private = sealed
+	return nil
+}
+
+// rotate generates new keys for the identity. The current public keys are
+// retired into the key history of the rotated identity, which is sealed with
+// the same master key so that its passphrase is unchanged.
+func (i *privateIdentity) rotate() (*privateIdentity, error) {
+	public := *i.public
+	public.history = append(append([]retiredKeys{}, i.public.history...),
+		retiredKeys{
+			ecdsaPublicKey:   i.public.ecdsaPublicKey,
+			ed25519PublicKey: *i.public.ed25519PublicKey,
+			sealPublicKey:    i.public.sealPublicKey,
+			retired:          time.Now().UTC(),
+		})
+
+	rotated := privateIdentity{
+		public:          &public,
+		masterKey:       i.masterKey,
+		retiredSealKeys: map[string]*[32]byte{i.KeyID(): i.sealPrivateKey},
+	}
+	for kid, key := range i.retiredSealKeys {
+		rotated.retiredSealKeys[kid] = key
+	}
+	if err := generateKeys(&rotated); err != nil {
+		return nil, err
+	}
+
+	if err := rotated.reseal(); err != nil {
+		return nil, err
+	}
+
+	return &rotated, nil
+}
+
+// rewrap wraps the master key with a new passphrase envelope. Legacy
+// identities, which have no master key, are resealed with a new one.
+func (i *privateIdentity) rewrap(passphrase string) error {
+	if i.public.envelope == nil {
+		masterKey, err := newMasterKey()
+		if err != nil {
+			return err
+		}
+		i.masterKey = masterKey
+		if err := i.reseal(); err != nil {
+			return err
+		}
+	}
+
+	e, err := newEnvelope(passphrase, i.masterKey)
+	if err != nil {
+		return err
+	}
+	i.public.envelope = e
+	return nil
+}
+
+// rekey seals the private keys with a new master key, wrapped by a new
+// passphrase envelope, so that the old passphrase can't open the current keys
+// from an older copy of the record, such as a backup or bundle. Recovery codes
+// wrap the old master key, so they are dropped.
+func (i *privateIdentity) rekey(passphrase string) error {
+	masterKey, err := newMasterKey()
+	if err != nil {
+		return err
+	}
+	i.masterKey = masterKey
+	if err := i.reseal(); err != nil {
+		return err
+	}
+
+	e, err := newEnvelope(passphrase, masterKey)
+	if err != nil {
+		return err
+	}
+	i.public.envelope = e
+	i.public.recovery = nil
+	return nil
+}
+
+// upgrade re-wraps the private identity with an envelope using the current
+// key derivation parameters and persists the result if the identity came from
+// a store.
+func (i *privateIdentity) upgrade(passphrase string) error {
+	if err := i.rewrap(passphrase); err != nil {
+		return err
+	}
+
+	if i.public.store != nil {
+		return i.public.store.putSealed(i.public)
+	}
+	return nil
+}
+
 func (i privateIdentity) MarshalJSON() ([]byte, error) {
 	marshaledECDSAPrivateKey, err := x509.MarshalPKCS8PrivateKey(i.ecdsaPrivateKey)
 	if err != nil {
 		return nil, err
 	}
 
+	var retired map[string]string
+	for kid, key := range i.retiredSealKeys {
+		if retired == nil {
+			retired = map[string]string{}
+		}
+		retired[kid] = EncodeToString(key[:])
+	}
+
 	return json.Marshal(jsonPrivateIdentity{
-		ECDSAPrivateKey:   EncodeToString(marshaledECDSAPrivateKey),
-		Ed25519PrivateKey: EncodeToString([]byte(*i.ed25519PrivateKey)),
-		SealPrivateKey:    EncodeToString(i.sealPrivateKey[:]),
+		ECDSAPrivateKey:        EncodeToString(marshaledECDSAPrivateKey),
+		Ed25519PrivateKey:      EncodeToString([]byte(*i.ed25519PrivateKey)),
+		SealPrivateKey:         EncodeToString(i.sealPrivateKey[:]),
+		RetiredSealPrivateKeys: retired,
 	})
 }
 
@@ -183,5 +393,21 @@ func (i *privateIdentity) UnmarshalJSON(marshaled []byte) error {
 	i.sealPrivateKey = &[32]byte{}
 	copy(i.sealPrivateKey[:], sealPrivateKey[:32])
 
+	for kid, encoded := range unmarshaled.RetiredSealPrivateKeys {
+		decoded, err := DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		if len(decoded) != 32 {
+			return fmt.Errorf("retired seal private key %s is not 32 bytes", kid)
+		}
+		if i.retiredSealKeys == nil {
+			i.retiredSealKeys = map[string]*[32]byte{}
+		}
+		key := [32]byte{}
+		copy(key[:], decoded)
+		i.retiredSealKeys[kid] = &key
+	}
+
 	return nil
 }
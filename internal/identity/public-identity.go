@@ -22,16 +22,18 @@ import (
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"time"
 
 	"golang.org/x/crypto/nacl/box"
-	"golang.org/x/crypto/nacl/secretbox"
 
 	"github.com/google/uuid"
+
+	"github.com/akb/identify/internal/webauthn"
 )
 
 type PublicIdentity interface {
@@ -39,12 +41,65 @@ type PublicIdentity interface {
 	Ed25519PublicKey() ed25519.PublicKey
 	SealPublicKey() [32]byte
 
+	// KeyID identifies the current set of keys of the identity.
+	KeyID() string
+
+	// Ed25519PublicKeyForID returns the current or a retired Ed25519 public key
+	// by key ID, along with the time it was retired. The retirement time of the
+	// current key is zero.
+	Ed25519PublicKeyForID(string) (ed25519.PublicKey, time.Time, error)
+
+	// SealPublicKeyForID returns the current or a retired seal public key by
+	// key ID.
+	SealPublicKeyForID(string) ([32]byte, error)
+
 	String() string
+	Aliases() []string
+
+	// Attributes returns the profile attributes of the identity by name.
+	Attributes() []Attribute
+
+	// Claims returns the attributes that are included in access tokens.
+	Claims() map[string]interface{}
+
+	// RecoveryCodes returns the number of unused recovery codes.
+	RecoveryCodes() int
+
+	// TOTPEnrolled identities must provide a one-time password in addition to
+	// their passphrase.
+	TOTPEnrolled() bool
+
+	// Credentials are the WebAuthn credentials, such as passkeys, that may be
+	// used to log in as the identity.
+	Credentials() []webauthn.Credential
+
+	// Groups returns the ids of the groups the identity is a member of.
+	Groups() []string
+
+	// Roles returns the names of the roles assigned to the identity, which
+	// grant it permissions.
+	Roles() []string
+
+	// SSHPrincipals returns the principals, besides its UUID, that the
+	// identity may be issued SSH user certificates for.
+	SSHPrincipals() []string
+
+	// Disabled identities may not authenticate or be issued tokens.
+	Disabled() bool
 
 	Authenticate(passphrase string) (PrivateIdentity, error)
 	SealAnonymous(value string) ([]byte, error)
+
+	// Verify checks a detached signature made by the identity.
+	Verify(*Signature, io.Reader) error
 }
 
+var (
+	ErrorIdentityDisabled = fmt.Errorf("identity is disabled")
+	ErrorIdentityDeleted  = fmt.Errorf("identity has been deleted")
+	ErrorIdentityExists   = fmt.Errorf("identity already exists")
+)
+
 type publicIdentity struct {
 	id               uuid.UUID
 	aliases          []string
@@ -52,15 +107,47 @@ type publicIdentity struct {
 	ed25519PublicKey *ed25519.PublicKey
 	sealPublicKey    *[32]byte
 	private          []byte
+	envelope         *envelope
+	history          []retiredKeys
+	disabled         bool
+	attributes       map[string]Attribute
+	recovery         []recoveryCode
+	totp             []byte
+	totpStep         int64
+	credentials      []webauthn.Credential
+	groups           []string
+	roles            []string
+	sshPrincipals    []string
+
+	// store is set when the identity was loaded from a store, so that changes
+	// made while authenticating (such as envelope upgrades) can be persisted.
+	store *localStore
 }
 
 type jsonPublicIdentity struct {
-	ID               string   `json:"id"`
-	Aliases          []string `json:"aliases"`
-	ECDSAPublicKey   string   `json:"ecdsa-public-key"`
-	Ed25519PublicKey string   `json:"ed25519-public-key"`
-	SealPublicKey    string   `json:"seal-public-key"`
-	Private          string   `json:"private"`
+	ID               string        `json:"id"`
+	Aliases          []string      `json:"aliases"`
+	ECDSAPublicKey   string        `json:"ecdsa-public-key"`
+	Ed25519PublicKey string        `json:"ed25519-public-key"`
+	SealPublicKey    string        `json:"seal-public-key"`
+	Private          string        `json:"private"`
+	KDF              *jsonEnvelope `json:"kdf,omitempty"`
+
+	KeyHistory []jsonRetiredKeys        `json:"key-history,omitempty"`
+	Disabled   bool                     `json:"disabled,omitempty"`
+	Attributes map[string]jsonAttribute `json:"attributes,omitempty"`
+
+	RecoveryCodes []jsonRecoveryCode `json:"recovery-codes,omitempty"`
+
+	TOTP     string `json:"totp,omitempty"`
+	TOTPStep int64  `json:"totp-step,omitempty"`
+
+	Credentials []webauthn.Credential `json:"webauthn-credentials,omitempty"`
+
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+
+	SSHPrincipals []string `json:"ssh-principals,omitempty"`
 }
 
 func NewIdentity(passphrase string, aliases []string) (*publicIdentity, *privateIdentity, error) {
@@ -69,62 +156,84 @@ func NewIdentity(passphrase string, aliases []string) (*publicIdentity, *private
 		return nil, nil, err
 	}
 
-	ecdsaPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, nil, err
+	var unique []string
+	seen := map[string]bool{}
+	for _, a := range aliases {
+		if !seen[a] {
+			unique = append(unique, a)
+			seen[a] = true
+		}
 	}
-	ecdsaPublicKey, ok := ecdsaPrivateKey.Public().(*ecdsa.PublicKey)
-	if !ok {
-		panic("ecdsa private key did not produce a valid public key")
+
+	public := publicIdentity{
+		id:      id,
+		aliases: unique,
+		private: nil,
 	}
 
-	ed25519PublicKey, ed25519PrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	private := privateIdentity{public: &public}
+	if err := generateKeys(&private); err != nil {
+		return nil, nil, err
+	}
+
+	masterKey, err := newMasterKey()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	sealPublicKey, sealPrivateKey, err := box.GenerateKey(rand.Reader)
+	public.envelope, err = newEnvelope(passphrase, masterKey)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	public := publicIdentity{
-		id:               id,
-		aliases:          aliases,
-		ecdsaPublicKey:   ecdsaPublicKey,
-		ed25519PublicKey: &ed25519PublicKey,
-		sealPublicKey:    sealPublicKey,
-		private:          nil,
+	private.masterKey = masterKey
+	if err := private.reseal(); err != nil {
+		return nil, nil, err
 	}
 
-	private := privateIdentity{
-		public:            &public,
-		ecdsaPrivateKey:   ecdsaPrivateKey,
-		ed25519PrivateKey: &ed25519PrivateKey,
-		sealPrivateKey:    sealPrivateKey,
+	return &public, &private, nil
+}
+
+// generateKeys creates new signing and sealing keys for an identity.
+func generateKeys(private *privateIdentity) error {
+	ecdsaPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	ecdsaPublicKey, ok := ecdsaPrivateKey.Public().(*ecdsa.PublicKey)
+	if !ok {
+		panic("ecdsa private key did not produce a valid public key")
 	}
 
-	marshaled, err := json.Marshal(private)
+	ed25519PublicKey, ed25519PrivateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	var nonce [24]byte
-	if _, err = io.ReadFull(rand.Reader, nonce[:]); err != nil {
-		return nil, nil, err
+	sealPublicKey, sealPrivateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
 	}
 
-	key := sha256.Sum256([]byte(passphrase))
+	private.public.ecdsaPublicKey = ecdsaPublicKey
+	private.public.ed25519PublicKey = &ed25519PublicKey
+	private.public.sealPublicKey = sealPublicKey
 
-	public.private = secretbox.Seal(nonce[:], marshaled, &nonce, &key)
+	private.ecdsaPrivateKey = ecdsaPrivateKey
+	private.ed25519PrivateKey = &ed25519PrivateKey
+	private.sealPrivateKey = sealPrivateKey
 
-	return &public, &private, nil
+	return nil
 }
 
 func (i publicIdentity) String() string {
 	return i.id.String()
 }
 
+func (i publicIdentity) Aliases() []string {
+	return i.aliases
+}
+
 func (i publicIdentity) SealPublicKey() [32]byte {
 	return *i.sealPublicKey
 }
@@ -137,20 +246,85 @@ func (i publicIdentity) ECDSAPublicKey() *ecdsa.PublicKey {
 	return i.ecdsaPublicKey
 }
 
+func (i publicIdentity) KeyID() string {
+	return KeyID(*i.ed25519PublicKey)
+}
+
+func (i publicIdentity) Ed25519PublicKeyForID(kid string) (ed25519.PublicKey, time.Time, error) {
+	if kid == i.KeyID() {
+		return *i.ed25519PublicKey, time.Time{}, nil
+	}
+	for _, k := range i.history {
+		if kid == KeyID(k.ed25519PublicKey) {
+			return k.ed25519PublicKey, k.retired, nil
+		}
+	}
+	return nil, time.Time{}, ErrorUnknownKey
+}
+
+func (i publicIdentity) SealPublicKeyForID(kid string) ([32]byte, error) {
+	if kid == i.KeyID() {
+		return *i.sealPublicKey, nil
+	}
+	for _, k := range i.history {
+		if kid == KeyID(k.ed25519PublicKey) {
+			return *k.sealPublicKey, nil
+		}
+	}
+	return [32]byte{}, ErrorUnknownKey
+}
+
+func (i publicIdentity) Disabled() bool {
+	return i.disabled
+}
+
 func (i *publicIdentity) Authenticate(passphrase string) (PrivateIdentity, error) {
-	key := sha256.Sum256([]byte(passphrase))
+	if i.disabled {
+		return nil, ErrorIdentityDisabled
+	}
 
-	var nonce [24]byte
-	copy(nonce[:], i.private[:24])
-	unparsed, ok := secretbox.Open(nil, i.private[24:], &nonce, &key)
-	if !ok {
-		return nil, fmt.Errorf("unable to decrypt identity")
+	var masterKey *[32]byte
+	var err error
+	if i.envelope == nil {
+		// legacy identities seal the private blob directly with the passphrase
+		legacy := envelope{version: envelopeVersionLegacy}
+		key := legacy.derive(passphrase)
+		masterKey = &key
+	} else {
+		masterKey, err = i.envelope.open(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identity, err := i.unseal(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !i.envelope.current() {
+		if err := identity.upgrade(passphrase); err != nil {
+			log.Printf("unable to upgrade identity %s: %s\n", i, err)
+		}
+	}
+
+	return identity, nil
+}
+
+// unseal decrypts the private blob with the given master key.
+func (i *publicIdentity) unseal(masterKey *[32]byte) (*privateIdentity, error) {
+	unparsed, err := open(i.private, masterKey)
+	if err != nil {
+		return nil, err
 	}
 
 	var identity privateIdentity
-	err := json.Unmarshal(unparsed, &identity)
+	if err := json.Unmarshal(unparsed, &identity); err != nil {
+		return nil, err
+	}
 	identity.public = i
-	return &identity, err
+	identity.masterKey = masterKey
+	return &identity, nil
 }
 
 func (i *publicIdentity) SealAnonymous(value string) ([]byte, error) {
@@ -163,14 +337,54 @@ func (i publicIdentity) MarshalJSON() ([]byte, error) {
 		return nil, err
 	}
 
+	var history []jsonRetiredKeys
+	for _, k := range i.history {
+		marshaled, err := k.toJSON()
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, *marshaled)
+	}
+
+	var attributes map[string]jsonAttribute
+	if len(i.attributes) > 0 {
+		attributes = map[string]jsonAttribute{}
+		for name, a := range i.attributes {
+			attributes[name] = a.toJSON()
+		}
+	}
+
+	var recovery []jsonRecoveryCode
+	for _, rc := range i.recovery {
+		recovery = append(recovery, rc.toJSON())
+	}
+
 	return json.Marshal(jsonPublicIdentity{
-		ID: i.id.String(),
+		ID:      i.id.String(),
+		Aliases: i.aliases,
 
 		ECDSAPublicKey:   EncodeToString(marshaledECDSAPublicKey),
 		Ed25519PublicKey: EncodeToString([]byte(*i.ed25519PublicKey)),
 		SealPublicKey:    EncodeToString(i.sealPublicKey[:]),
 
 		Private: EncodeToString(i.private),
+		KDF:     i.envelope.toJSON(),
+
+		KeyHistory: history,
+		Disabled:   i.disabled,
+		Attributes: attributes,
+
+		RecoveryCodes: recovery,
+
+		TOTP:     EncodeToString(i.totp),
+		TOTPStep: i.totpStep,
+
+		Credentials: i.credentials,
+
+		Groups: i.groups,
+		Roles:  i.roles,
+
+		SSHPrincipals: i.sshPrincipals,
 	})
 }
 
@@ -186,20 +400,12 @@ func (i *publicIdentity) UnmarshalJSON(marshaled []byte) error {
 	}
 
 	i.id = id
+	i.aliases = unmarshaled.Aliases
 
-	decodedECDSAPublicKey, err := DecodeString(unmarshaled.ECDSAPublicKey)
-	if err != nil {
-		return err
-	}
-	untypedECDSAPublicKey, err := x509.ParsePKIXPublicKey(decodedECDSAPublicKey)
+	i.ecdsaPublicKey, err = parseECDSAPublicKey(unmarshaled.ECDSAPublicKey)
 	if err != nil {
 		return err
 	}
-	ecdsaPublicKey, ok := untypedECDSAPublicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return fmt.Errorf("key is not a valid ecdsa public key")
-	}
-	i.ecdsaPublicKey = ecdsaPublicKey
 
 	decodedEd25519PublicKey, err := DecodeString(unmarshaled.Ed25519PublicKey)
 	if err != nil {
@@ -221,5 +427,50 @@ func (i *publicIdentity) UnmarshalJSON(marshaled []byte) error {
 	}
 	i.private = private
 
+	i.disabled = unmarshaled.Disabled
+
+	for _, k := range unmarshaled.KeyHistory {
+		retired, err := k.toRetiredKeys()
+		if err != nil {
+			return err
+		}
+		i.history = append(i.history, *retired)
+	}
+
+	if len(unmarshaled.Attributes) > 0 {
+		i.attributes = map[string]Attribute{}
+		for name, a := range unmarshaled.Attributes {
+			i.attributes[name], err = a.toAttribute(name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	i.totp, err = DecodeString(unmarshaled.TOTP)
+	if err != nil {
+		return err
+	}
+	i.totpStep = unmarshaled.TOTPStep
+	i.credentials = unmarshaled.Credentials
+	i.groups = unmarshaled.Groups
+	i.roles = unmarshaled.Roles
+	i.sshPrincipals = unmarshaled.SSHPrincipals
+
+	for _, rc := range unmarshaled.RecoveryCodes {
+		recovery, err := rc.toRecoveryCode()
+		if err != nil {
+			return err
+		}
+		i.recovery = append(i.recovery, *recovery)
+	}
+
+	if unmarshaled.KDF != nil {
+		i.envelope, err = unmarshaled.KDF.toEnvelope()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,225 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/akb/identify/internal/database"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated at a time.
+const RecoveryCodeCount = 10
+
+var (
+	ErrorInvalidRecoveryCode = fmt.Errorf("recovery code is invalid or has already been used")
+
+	errorLegacyIdentity = fmt.Errorf("identity must be authenticated with its passphrase " +
+		"before recovery codes can be generated")
+
+	recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// recoveryCode wraps the master key of an identity, like the passphrase
+// envelope does, with a key derived from a one-time recovery code. Recovery
+// codes carry 100 random bits, so unlike passphrases they aren't stretched.
+type recoveryCode struct {
+	salt []byte
+	key  []byte
+}
+
+type jsonRecoveryCode struct {
+	Salt string `json:"salt"`
+	Key  string `json:"key"`
+}
+
+// newRecoveryCode generates a code formatted as four groups of five base32
+// characters, and wraps the master key with it.
+func newRecoveryCode(masterKey *[32]byte) (string, *recoveryCode, error) {
+	random := make([]byte, 13)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return "", nil, err
+	}
+	encoded := recoveryCodeEncoding.EncodeToString(random)[:20]
+
+	var groups []string
+	for i := 0; i < len(encoded); i += 5 {
+		groups = append(groups, encoded[i:i+5])
+	}
+	code := strings.Join(groups, "-")
+
+	rc := recoveryCode{salt: make([]byte, 16)}
+	if _, err := io.ReadFull(rand.Reader, rc.salt); err != nil {
+		return "", nil, err
+	}
+
+	key := rc.derive(code)
+	sealed, err := seal(masterKey[:], &key)
+	if err != nil {
+		return "", nil, err
+	}
+	rc.key = sealed
+
+	return code, &rc, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes so that codes can be
+// typed however they were written down.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(code))
+}
+
+func (rc recoveryCode) derive(code string) [32]byte {
+	return sha256.Sum256(append(append([]byte{}, rc.salt...),
+		[]byte(normalizeRecoveryCode(code))...))
+}
+
+func (rc recoveryCode) open(code string) (*[32]byte, error) {
+	key := rc.derive(code)
+	unsealed, err := open(rc.key, &key)
+	if err != nil {
+		return nil, err
+	}
+
+	var masterKey [32]byte
+	copy(masterKey[:], unsealed)
+	return &masterKey, nil
+}
+
+func (rc recoveryCode) toJSON() jsonRecoveryCode {
+	return jsonRecoveryCode{
+		Salt: EncodeToString(rc.salt),
+		Key:  EncodeToString(rc.key),
+	}
+}
+
+func (j jsonRecoveryCode) toRecoveryCode() (*recoveryCode, error) {
+	salt, err := DecodeString(j.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DecodeString(j.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recoveryCode{salt: salt, key: key}, nil
+}
+
+func (i publicIdentity) RecoveryCodes() int {
+	return len(i.recovery)
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of an identity with a new
+// set. The codes are returned once and can't be retrieved again.
+func (s *localStore) GenerateRecoveryCodes(i PrivateIdentity) ([]string, error) {
+	private, ok := i.(*privateIdentity)
+	if !ok {
+		return nil, fmt.Errorf("identity %s was not loaded from a store", i)
+	}
+
+	if private.public.envelope == nil {
+		return nil, errorLegacyIdentity
+	}
+
+	var codes []string
+	var recovery []recoveryCode
+	for n := 0; n < RecoveryCodeCount; n++ {
+		code, rc, err := newRecoveryCode(private.masterKey)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		recovery = append(recovery, *rc)
+	}
+
+	err := s.updateIdentity(i.String(), func(stored *publicIdentity) error {
+		stored.recovery = recovery
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	private.public.recovery = recovery
+	log.Printf("generated recovery codes for identity: %s\n", i)
+	return codes, nil
+}
+
+// Recover uses a recovery code in place of the passphrase of an identity to
+// set a new passphrase. Each code can only be used once.
+func (s *localStore) Recover(id, code, passphrase string) (PrivateIdentity, error) {
+	var private *privateIdentity
+	var stored *publicIdentity
+	err := s.db.Update(func(tx database.Tx) error {
+		var err error
+		stored, err = getIdentity(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if stored.disabled {
+			return ErrorIdentityDisabled
+		}
+
+		for n, rc := range stored.recovery {
+			masterKey, err := rc.open(code)
+			if err != nil {
+				continue
+			}
+
+			private, err = stored.unseal(masterKey)
+			if err != nil {
+				return err
+			}
+
+			if err := private.rewrap(passphrase); err != nil {
+				return err
+			}
+
+			// the code is consumed in the same transaction that found it, so it
+			// can't be used twice by concurrent recoveries
+			remaining := append(append([]recoveryCode{}, stored.recovery[:n]...),
+				stored.recovery[n+1:]...)
+			stored.recovery = remaining
+
+			return updateIdentity(tx, stored.String(), func(record *publicIdentity) error {
+				record.private = stored.private
+				record.envelope = stored.envelope
+				record.recovery = remaining
+				return nil
+			})
+		}
+
+		return ErrorInvalidRecoveryCode
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("recovered identity %s, %d recovery codes remain\n",
+		stored, len(stored.recovery))
+	return private, nil
+}
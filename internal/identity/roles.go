@@ -0,0 +1,585 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/akb/identify/internal/database"
+)
+
+// Permissions granted by roles. Identities may always act on themselves
+// through the user role; the others allow acting on other identities.
+const (
+	// PermissionSelf allows an identity to manage its own aliases, attributes
+	// and credentials.
+	PermissionSelf = "self:manage"
+
+	PermissionCreateIdentities = "identities:create"
+	PermissionListIdentities   = "identities:list"
+
+	// PermissionManageIdentities allows disabling, enabling and deleting
+	// other identities.
+	PermissionManageIdentities = "identities:manage"
+
+	// PermissionManageRoles allows defining roles and assigning them.
+	PermissionManageRoles = "roles:manage"
+
+	// PermissionManagePolicies allows storing and deleting policies, and
+	// PermissionEvaluatePolicies allows asking for authorization decisions.
+	PermissionManagePolicies   = "policies:manage"
+	PermissionEvaluatePolicies = "policies:evaluate"
+
+	// PermissionIssueHostCertificates allows requesting SSH host certificates,
+	// which may name any host.
+	PermissionIssueHostCertificates = "host-certificates:issue"
+
+	// PermissionManageSSHPrincipals allows setting the principals identities
+	// may be issued SSH user certificates for.
+	PermissionManageSSHPrincipals = "ssh-principals:manage"
+
+	// PermissionAll is granted only to administrators.
+	PermissionAll = "*"
+)
+
+// Permissions lists the permissions that custom roles may grant.
+var Permissions = []string{
+	PermissionSelf,
+	PermissionCreateIdentities,
+	PermissionListIdentities,
+	PermissionManageIdentities,
+	PermissionManageRoles,
+	PermissionManagePolicies,
+	PermissionEvaluatePolicies,
+	PermissionIssueHostCertificates,
+	PermissionManageSSHPrincipals,
+}
+
+// Built-in roles. Every identity holds the user role.
+const (
+	RoleAdministrator = "admin"
+	RoleOperator      = "operator"
+	RoleUser          = "user"
+)
+
+var builtinRoles = map[string]Role{
+	RoleAdministrator: {Name: RoleAdministrator, Permissions: []string{PermissionAll}, Builtin: true},
+	RoleOperator: {Name: RoleOperator, Builtin: true, Permissions: []string{
+		PermissionSelf,
+		PermissionCreateIdentities,
+		PermissionListIdentities,
+		PermissionManageIdentities,
+	}},
+	RoleUser: {Name: RoleUser, Permissions: []string{PermissionSelf}, Builtin: true},
+}
+
+var roleBucketKey = []byte("role")
+
+var (
+	ErrorUnknownRole       = fmt.Errorf("unknown role")
+	ErrorUnknownPermission = fmt.Errorf("unknown permission")
+	ErrorBuiltinRole       = fmt.Errorf("built-in roles can't be changed")
+	ErrorRoleNotAssigned   = fmt.Errorf("role isn't assigned to the identity")
+	ErrorForbidden         = fmt.Errorf("identity doesn't have permission")
+	ErrorLastAdministrator = fmt.Errorf("the last administrator can't be removed")
+)
+
+// ErrorRoleExists is returned when a custom role has the name of an existing
+// role.
+type ErrorRoleExists struct {
+	Name string
+}
+
+func (err ErrorRoleExists) Error() string {
+	return fmt.Sprintf("role '%s' already exists", err.Name)
+}
+
+// Role is a named set of permissions that may be assigned to identities.
+type Role struct {
+	Name        string    `json:"name"`
+	Permissions []string  `json:"permissions"`
+	Builtin     bool      `json:"-"`
+	Created     time.Time `json:"created,omitempty"`
+}
+
+// Permits reports whether the role grants a permission.
+func (r Role) Permits(permission string) bool {
+	for _, p := range r.Permissions {
+		if p == permission || p == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles returns the names of the roles assigned to the identity, including
+// the user role every identity holds.
+func (i publicIdentity) Roles() []string {
+	return append([]string{RoleUser}, i.roles...)
+}
+
+// NewRole defines a custom role granting some of the known permissions.
+func (s *localStore) NewRole(by PrivateIdentity, name string, permissions []string) (*Role, error) {
+	if err := ValidateAlias(name); err != nil {
+		return nil, err
+	}
+	if len(permissions) == 0 {
+		return nil, ErrorUnknownPermission
+	}
+	for _, p := range permissions {
+		if !isPermission(p) {
+			return nil, ErrorUnknownPermission
+		}
+	}
+
+	role := Role{Name: name, Permissions: permissions, Created: time.Now().UTC()}
+	err := s.db.Update(func(tx database.Tx) error {
+		if err := authorize(tx, by, PermissionManageRoles); err != nil {
+			return err
+		}
+
+		if _, ok := builtinRoles[name]; ok {
+			return ErrorRoleExists{name}
+		}
+
+		rb, err := tx.CreateBucketIfNotExists(roleBucketKey)
+		if err != nil {
+			return err
+		}
+		if rb.Get([]byte(name)) != nil {
+			return ErrorRoleExists{name}
+		}
+
+		marshaled, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return rb.Put([]byte(name), marshaled)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("%s defined role %s\n", by, name)
+	return &role, nil
+}
+
+func (s *localStore) GetRole(name string) (*Role, error) {
+	var role *Role
+	err := s.db.View(func(tx database.Tx) error {
+		var err error
+		role, err = getRole(tx, name)
+		return err
+	})
+	return role, err
+}
+
+// ListRoles returns the built-in and custom roles, sorted by name.
+func (s *localStore) ListRoles() ([]Role, error) {
+	var roles []Role
+	for _, role := range builtinRoles {
+		roles = append(roles, role)
+	}
+
+	err := s.db.View(func(tx database.Tx) error {
+		rb := tx.Bucket(roleBucketKey)
+		if rb == nil {
+			return nil
+		}
+		return rb.ForEach(func(name, stored []byte) error {
+			var role Role
+			if err := json.Unmarshal(stored, &role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// DeleteRole removes a custom role and unassigns it from every identity.
+func (s *localStore) DeleteRole(by PrivateIdentity, name string) error {
+	err := s.db.Update(func(tx database.Tx) error {
+		if err := authorize(tx, by, PermissionManageRoles); err != nil {
+			return err
+		}
+
+		if _, ok := builtinRoles[name]; ok {
+			return ErrorBuiltinRole
+		}
+
+		rb := tx.Bucket(roleBucketKey)
+		if rb == nil || rb.Get([]byte(name)) == nil {
+			return ErrorUnknownRole
+		}
+		if err := rb.Delete([]byte(name)); err != nil {
+			return err
+		}
+
+		holders, err := roleHolders(tx, name)
+		if err != nil {
+			return err
+		}
+		for _, id := range holders {
+			if err := updateIdentity(tx, id, unassign(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s deleted role %s\n", by, name)
+	return nil
+}
+
+// AssignRole gives a role to an identity. Only identities permitted to manage
+// roles may assign them, and only roles granting permissions they hold
+// themselves.
+func (s *localStore) AssignRole(by PrivateIdentity, id, role string) error {
+	err := s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := authorizeRole(tx, by, role); err != nil {
+			return err
+		}
+
+		if role == RoleUser {
+			return nil
+		}
+		return assign(tx, id, role)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s assigned role %s to identity %s\n", by, role, id)
+	return nil
+}
+
+// BootstrapAdministrator makes an identity an administrator while there is no
+// other. It is how `identify listen` gives the server identity its role, and
+// does nothing once any identity is an administrator.
+func (s *localStore) BootstrapAdministrator(i PrivateIdentity) error {
+	assigned := false
+	err := s.db.Update(func(tx database.Tx) error {
+		administrators, err := roleHolders(tx, RoleAdministrator)
+		if err != nil {
+			return err
+		}
+		if len(administrators) > 0 {
+			return nil
+		}
+
+		assigned = true
+		return assign(tx, i.String(), RoleAdministrator)
+	})
+	if err != nil {
+		return err
+	}
+
+	if assigned {
+		log.Printf("made identity %s the first administrator\n", i)
+	}
+	return nil
+}
+
+// authorizeRole checks that an identity may manage roles, and holds every
+// permission a role grants, so that it can't give out more than it has.
+func authorizeRole(tx database.Tx, by PublicIdentity, name string) error {
+	if err := authorize(tx, by, PermissionManageRoles); err != nil {
+		return err
+	}
+
+	role, err := getRole(tx, name)
+	if err != nil {
+		return err
+	}
+
+	stored, err := getIdentity(tx, by.String())
+	if err != nil {
+		return err
+	}
+	for _, p := range role.Permissions {
+		permitted, err := permits(tx, stored.Roles(), p)
+		if err != nil {
+			return err
+		}
+		if !permitted {
+			return ErrorForbidden
+		}
+	}
+	return nil
+}
+
+// authorizeIdentity checks that an identity may act on another, which takes the
+// identities:manage permission and every permission the other's roles grant,
+// so that operators can't disable or delete administrators. Identities may
+// always act on themselves.
+func authorizeIdentity(tx database.Tx, by PublicIdentity, target *publicIdentity) error {
+	if by.String() == target.String() {
+		return nil
+	}
+
+	if err := authorize(tx, by, PermissionManageIdentities); err != nil {
+		return err
+	}
+
+	stored, err := getIdentity(tx, by.String())
+	if err != nil {
+		return err
+	}
+	for _, name := range target.Roles() {
+		role, err := getRole(tx, name)
+		if err == ErrorUnknownRole {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, p := range role.Permissions {
+			permitted, err := permits(tx, stored.Roles(), p)
+			if err != nil {
+				return err
+			}
+			if !permitted {
+				return ErrorForbidden
+			}
+		}
+	}
+	return nil
+}
+
+// checkRemovable refuses to disable or delete the server identity, or the last
+// administrator that can still authenticate.
+func checkRemovable(tx database.Tx, target *publicIdentity) error {
+	if isServerIdentity(tx, target.String()) {
+		return ErrorServerIdentityRemoval
+	}
+
+	administrator := false
+	for _, r := range target.Roles() {
+		administrator = administrator || r == RoleAdministrator
+	}
+	if !administrator {
+		return nil
+	}
+
+	holders, err := roleHolders(tx, RoleAdministrator)
+	if err != nil {
+		return err
+	}
+	for _, id := range holders {
+		if id == target.String() {
+			continue
+		}
+		other, err := getIdentity(tx, id)
+		if err != nil {
+			return err
+		}
+		if !other.disabled {
+			return nil
+		}
+	}
+	return ErrorLastAdministrator
+}
+
+func assign(tx database.Tx, id, role string) error {
+	return updateIdentity(tx, id, func(stored *publicIdentity) error {
+		for _, r := range stored.roles {
+			if r == role {
+				return nil
+			}
+		}
+		stored.roles = append(stored.roles, role)
+		return nil
+	})
+}
+
+// RevokeRole takes a role away from an identity. The user role can't be
+// revoked, and the last administrator can't be removed. Like assigning, only
+// roles granting permissions the revoker holds may be revoked.
+func (s *localStore) RevokeRole(by PrivateIdentity, id, role string) error {
+	err := s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if role == RoleUser {
+			return ErrorBuiltinRole
+		}
+
+		if err := authorizeRole(tx, by, role); err != nil {
+			return err
+		}
+
+		holders, err := roleHolders(tx, role)
+		if err != nil {
+			return err
+		}
+
+		held := false
+		for _, h := range holders {
+			held = held || h == id
+		}
+		if !held {
+			return ErrorRoleNotAssigned
+		}
+		if role == RoleAdministrator && len(holders) == 1 {
+			return ErrorLastAdministrator
+		}
+
+		return updateIdentity(tx, id, unassign(role))
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s revoked role %s from identity %s\n", by, role, id)
+	return nil
+}
+
+// Permits reports whether any of the named roles grants a permission. Roles
+// that no longer exist grant nothing.
+func (s *localStore) Permits(roles []string, permission string) (bool, error) {
+	permitted := false
+	err := s.db.View(func(tx database.Tx) error {
+		var err error
+		permitted, err = permits(tx, roles, permission)
+		return err
+	})
+	return permitted, err
+}
+
+func permits(tx database.Tx, roles []string, permission string) (bool, error) {
+	for _, name := range roles {
+		role, err := getRole(tx, name)
+		if err == ErrorUnknownRole {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if role.Permits(permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorize checks the stored roles of an identity, rather than those it was
+// loaded with, so that revoked roles take effect immediately.
+func authorize(tx database.Tx, i PublicIdentity, permission string) error {
+	stored, err := getIdentity(tx, i.String())
+	if err != nil {
+		return err
+	}
+
+	permitted, err := permits(tx, stored.Roles(), permission)
+	if err != nil {
+		return err
+	}
+	if !permitted {
+		return ErrorForbidden
+	}
+	return nil
+}
+
+func getRole(tx database.Tx, name string) (*Role, error) {
+	if role, ok := builtinRoles[name]; ok {
+		return &role, nil
+	}
+
+	rb := tx.Bucket(roleBucketKey)
+	if rb == nil {
+		return nil, ErrorUnknownRole
+	}
+
+	stored := rb.Get([]byte(name))
+	if stored == nil {
+		return nil, ErrorUnknownRole
+	}
+
+	var role Role
+	if err := json.Unmarshal(stored, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// roleHolders returns the ids of the identities a role is assigned to.
+// Assignments aren't indexed, so every identity is checked.
+func roleHolders(tx database.Tx, role string) ([]string, error) {
+	b := tx.Bucket(identityBucketKey)
+	if b == nil {
+		return nil, nil
+	}
+
+	var holders []string
+	err := b.ForEach(func(id, stored []byte) error {
+		var record struct {
+			Roles []string `json:"roles"`
+		}
+		if err := json.Unmarshal(stored, &record); err != nil {
+			return err
+		}
+		for _, r := range record.Roles {
+			if r == role {
+				holders = append(holders, string(id))
+			}
+		}
+		return nil
+	})
+	return holders, err
+}
+
+func unassign(role string) func(*publicIdentity) error {
+	return func(stored *publicIdentity) error {
+		var roles []string
+		for _, r := range stored.roles {
+			if r != role {
+				roles = append(roles, r)
+			}
+		}
+		stored.roles = roles
+		return nil
+	}
+}
+
+func isPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,581 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/akb/identify/internal/database"
+)
+
+const (
+	secretFormat  = "identify-secret"
+	secretVersion = 1
+)
+
+var (
+	ErrorUnknownSecret       = fmt.Errorf("secret for key doesn't exist")
+	ErrorNotSecretRecipient  = fmt.Errorf("identity is not a recipient of the secret")
+	ErrorNotSecretOwner      = fmt.Errorf("only the owner of a secret can change it or remove other recipients")
+	ErrorRemoveSecretOwner   = fmt.Errorf("the owner of a secret can't be removed from its recipients")
+	ErrorDuplicateRecipients = fmt.Errorf("identity is already a recipient of the secret")
+)
+
+// secretEnvelope is a secret that may be read by several identities. The
+// value is encrypted with a random data key, which is sealed to each of the
+// recipients. Only the owner, the identity that stored the secret, may change
+// it or remove other recipients; any recipient may share it further.
+//
+// Secrets may also be shared with groups, in which case the data key is sealed
+// to the group and read by its members through the group's private key.
+//
+// Secrets stored before envelopes were introduced are sealed directly to a
+// single identity. They are read as before, and become envelopes owned by
+// that identity once their recipients are changed.
+type secretEnvelope struct {
+	owner      string
+	value      []byte
+	recipients map[string]wrappedKey
+}
+
+// wrappedKey is the data key of a secret sealed to a recipient.
+type wrappedKey struct {
+	keyID  string
+	sealed []byte
+	group  bool
+}
+
+type jsonSecretEnvelope struct {
+	Format     string                    `json:"format"`
+	Version    int                       `json:"version"`
+	Owner      string                    `json:"owner"`
+	Value      string                    `json:"value"`
+	Recipients map[string]jsonWrappedKey `json:"recipients"`
+}
+
+type jsonWrappedKey struct {
+	KeyID string `json:"key-id"`
+	Key   string `json:"key"`
+	Group bool   `json:"group,omitempty"`
+}
+
+func (e secretEnvelope) MarshalJSON() ([]byte, error) {
+	recipients := map[string]jsonWrappedKey{}
+	for id, k := range e.recipients {
+		recipients[id] = jsonWrappedKey{KeyID: k.keyID, Key: EncodeToString(k.sealed), Group: k.group}
+	}
+
+	return json.Marshal(jsonSecretEnvelope{
+		Format:     secretFormat,
+		Version:    secretVersion,
+		Owner:      e.owner,
+		Value:      EncodeToString(e.value),
+		Recipients: recipients,
+	})
+}
+
+// parseSecret returns the envelope of a stored secret, or nil if it is a
+// legacy secret sealed directly to one identity.
+func parseSecret(stored []byte) (*secretEnvelope, error) {
+	var unmarshaled jsonSecretEnvelope
+	if err := json.Unmarshal(stored, &unmarshaled); err != nil || unmarshaled.Format != secretFormat {
+		return nil, nil
+	}
+	if unmarshaled.Version != secretVersion {
+		return nil, fmt.Errorf("unsupported secret version %d", unmarshaled.Version)
+	}
+
+	value, err := DecodeString(unmarshaled.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	e := secretEnvelope{
+		owner:      unmarshaled.Owner,
+		value:      value,
+		recipients: map[string]wrappedKey{},
+	}
+	for id, k := range unmarshaled.Recipients {
+		sealed, err := DecodeString(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		e.recipients[id] = wrappedKey{keyID: k.KeyID, sealed: sealed, group: k.Group}
+	}
+	return &e, nil
+}
+
+// newSecretEnvelope encrypts a value with a new data key sealed to each of the
+// recipients.
+func newSecretEnvelope(owner, value string, recipients []Recipient) (*secretEnvelope, error) {
+	e := secretEnvelope{owner: owner}
+	if err := e.encrypt(value, recipients); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// encrypt replaces the value of the envelope, encrypting it with a new data
+// key sealed to each of the recipients.
+func (e *secretEnvelope) encrypt(value string, recipients []Recipient) error {
+	// data keys are random, like master keys
+	dataKey, err := newMasterKey()
+	if err != nil {
+		return err
+	}
+
+	e.value, err = seal([]byte(value), dataKey)
+	if err != nil {
+		return err
+	}
+
+	e.recipients = map[string]wrappedKey{}
+	for _, r := range recipients {
+		if err := e.wrap(dataKey, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *secretEnvelope) wrap(dataKey *[32]byte, recipient Recipient) error {
+	sealed, err := recipient.SealAnonymous(string(dataKey[:]))
+	if err != nil {
+		return err
+	}
+
+	_, group := recipient.(*Group)
+	e.recipients[recipient.String()] = wrappedKey{
+		keyID:  recipient.KeyID(),
+		sealed: sealed,
+		group:  group,
+	}
+	return nil
+}
+
+// lookupRecipients looks up the identities and groups the envelope is sealed to.
+// Deleted identities and groups are left out, so the secret is no longer
+// sealed to them once it is re-encrypted.
+func (e *secretEnvelope) lookupRecipients(tx database.Tx) ([]Recipient, error) {
+	var recipients []Recipient
+	for id, k := range e.recipients {
+		var (
+			r   Recipient
+			err error
+		)
+		if k.group {
+			r, err = getGroup(tx, id)
+		} else {
+			r, err = getIdentity(tx, id)
+		}
+		if err == ErrorIdentityDeleted || err == ErrorUnknownGroup {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// dataKey opens the data key of the envelope as one of its recipients, or as
+// a member of a group that is.
+func (e *secretEnvelope) dataKey(tx database.Tx, i PrivateIdentity) (*[32]byte, error) {
+	if _, ok := e.recipients[i.String()]; ok {
+		return e.recipientKey(i)
+	}
+
+	for id, wrapped := range e.recipients {
+		if !wrapped.group {
+			continue
+		}
+
+		g, err := getGroup(tx, id)
+		if err == ErrorUnknownGroup {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !g.IsMember(i.String()) {
+			continue
+		}
+
+		opened, err := g.openAnonymous(i, wrapped.sealed)
+		if err != nil {
+			return nil, err
+		}
+		return toDataKey(opened)
+	}
+
+	return nil, ErrorNotSecretRecipient
+}
+
+// recipientKey opens the data key sealed directly to an identity.
+func (e *secretEnvelope) recipientKey(i PrivateIdentity) (*[32]byte, error) {
+	wrapped, ok := e.recipients[i.String()]
+	if !ok {
+		return nil, ErrorNotSecretRecipient
+	}
+
+	opened, err := i.OpenAnonymous(wrapped.sealed)
+	if err != nil {
+		return nil, err
+	}
+	return toDataKey([]byte(opened))
+}
+
+func toDataKey(opened []byte) (*[32]byte, error) {
+	if len(opened) != 32 {
+		return nil, errorCantDecrypt
+	}
+
+	var key [32]byte
+	copy(key[:], opened)
+	return &key, nil
+}
+
+func (e *secretEnvelope) open(tx database.Tx, i PrivateIdentity) (string, error) {
+	dataKey, err := e.dataKey(tx, i)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := open(e.value, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// openSecret returns the value of a stored secret as one of its recipients,
+// along with its envelope. Legacy secrets are converted to an envelope owned by
+// the identity they were sealed to.
+func openSecret(tx database.Tx, i PrivateIdentity, stored []byte) (string, *secretEnvelope, error) {
+	e, err := parseSecret(stored)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if e != nil {
+		value, err := e.open(tx, i)
+		return value, e, err
+	}
+
+	value, err := i.OpenAnonymous(stored)
+	if err != nil {
+		return "", nil, ErrorNotSecretRecipient
+	}
+
+	e, err = newSecretEnvelope(i.String(), value, []Recipient{i})
+	if err != nil {
+		return "", nil, err
+	}
+	return value, e, nil
+}
+
+// PutSecret stores a secret readable by the identity, along with any other
+// identities or groups. An existing secret with the same key can only be
+// replaced by its owner.
+func (s *localStore) PutSecret(i PublicIdentity, key, value string, recipients ...Recipient) error {
+	return s.db.Update(func(tx database.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(secretBucketKey)
+		if err != nil {
+			return err
+		}
+
+		if stored := b.Get([]byte(key)); stored != nil {
+			existing, err := parseSecret(stored)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.owner != i.String() {
+				return ErrorNotSecretOwner
+			}
+		}
+
+		e, err := newSecretEnvelope(i.String(), value, append([]Recipient{i}, recipients...))
+		if err != nil {
+			return err
+		}
+
+		marshaled, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), marshaled)
+	})
+}
+
+func (s *localStore) GetSecret(i PrivateIdentity, key string) (string, error) {
+	var value string
+	if err := s.db.View(func(tx database.Tx) error {
+		b := tx.Bucket(secretBucketKey)
+		if b == nil {
+			return fmt.Errorf("secret bucket doesn't exist")
+		}
+
+		stored := b.Get([]byte(key))
+		if stored == nil {
+			return ErrorUnknownSecret
+		}
+
+		var err error
+		value, _, err = openSecret(tx, i, stored)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// SecretRecipients returns the ids of the identities and groups that can read
+// a secret.
+func (s *localStore) SecretRecipients(i PrivateIdentity, key string) ([]string, error) {
+	var recipients []string
+	err := s.db.View(func(tx database.Tx) error {
+		b := tx.Bucket(secretBucketKey)
+		if b == nil {
+			return ErrorUnknownSecret
+		}
+
+		stored := b.Get([]byte(key))
+		if stored == nil {
+			return ErrorUnknownSecret
+		}
+
+		_, e, err := openSecret(tx, i, stored)
+		if err != nil {
+			return err
+		}
+
+		for id := range e.recipients {
+			recipients = append(recipients, id)
+		}
+		sort.Strings(recipients)
+		return nil
+	})
+	return recipients, err
+}
+
+// AddSecretRecipients seals the data key of a secret to more identities or
+// groups. Any recipient of the secret may add others.
+func (s *localStore) AddSecretRecipients(i PrivateIdentity, key string, recipients []Recipient) error {
+	return s.updateSecret(i, key, func(tx database.Tx, e *secretEnvelope) error {
+		dataKey, err := e.dataKey(tx, i)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range recipients {
+			if _, ok := e.recipients[r.String()]; ok {
+				return ErrorDuplicateRecipients
+			}
+			if err := e.wrap(dataKey, r); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("%s shared secret %s with %d recipients\n", i, key, len(recipients))
+		return nil
+	})
+}
+
+// RemoveSecretRecipients stops identities or groups from reading a secret. The
+// owner may remove any other recipient, and a recipient may remove itself. The
+// value is encrypted with a new data key, so that removed recipients can no
+// longer read it even if they kept the old one.
+func (s *localStore) RemoveSecretRecipients(i PrivateIdentity, key string, recipients []string) error {
+	return s.updateSecret(i, key, func(tx database.Tx, e *secretEnvelope) error {
+		for _, id := range recipients {
+			if id == e.owner {
+				return ErrorRemoveSecretOwner
+			}
+			if e.owner != i.String() && id != i.String() {
+				return ErrorNotSecretOwner
+			}
+			if _, ok := e.recipients[id]; !ok {
+				return ErrorNotSecretRecipient
+			}
+		}
+
+		value, err := e.open(tx, i)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range recipients {
+			delete(e.recipients, id)
+		}
+
+		remaining, err := e.lookupRecipients(tx)
+		if err != nil {
+			return err
+		}
+		if err := e.encrypt(value, remaining); err != nil {
+			return err
+		}
+
+		log.Printf("%s removed %d recipients of secret %s\n", i, len(recipients), key)
+		return nil
+	})
+}
+
+// updateSecret applies a change to the envelope of a secret as one of its
+// recipients.
+func (s *localStore) updateSecret(i PrivateIdentity, key string, update func(database.Tx, *secretEnvelope) error) error {
+	return s.db.Update(func(tx database.Tx) error {
+		b := tx.Bucket(secretBucketKey)
+		if b == nil {
+			return ErrorUnknownSecret
+		}
+
+		stored := b.Get([]byte(key))
+		if stored == nil {
+			return ErrorUnknownSecret
+		}
+
+		_, e, err := openSecret(tx, i, stored)
+		if err != nil {
+			return err
+		}
+
+		if err := update(tx, e); err != nil {
+			return err
+		}
+
+		marshaled, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), marshaled)
+	})
+}
+
+// groupSecrets opens the secrets shared with a group as one of its members,
+// returning their values and envelopes by key.
+func groupSecrets(tx database.Tx, i PrivateIdentity, g *Group) (map[string]string, map[string]*secretEnvelope, error) {
+	values := map[string]string{}
+	envelopes := map[string]*secretEnvelope{}
+
+	b := tx.Bucket(secretBucketKey)
+	if b == nil {
+		return values, envelopes, nil
+	}
+
+	err := b.ForEach(func(key, stored []byte) error {
+		e, err := parseSecret(stored)
+		if err != nil || e == nil {
+			return err
+		}
+
+		wrapped, ok := e.recipients[g.ID]
+		if !ok {
+			return nil
+		}
+
+		opened, err := g.openAnonymous(i, wrapped.sealed)
+		if err != nil {
+			return err
+		}
+		dataKey, err := toDataKey(opened)
+		if err != nil {
+			return err
+		}
+
+		value, err := open(e.value, dataKey)
+		if err != nil {
+			return err
+		}
+
+		values[string(key)] = string(value)
+		envelopes[string(key)] = e
+		return nil
+	})
+	return values, envelopes, err
+}
+
+// putSecrets encrypts secrets with new data keys sealed to their current
+// recipients and stores them.
+func putSecrets(tx database.Tx, values map[string]string, envelopes map[string]*secretEnvelope) error {
+	b := tx.Bucket(secretBucketKey)
+	for key, e := range envelopes {
+		recipients, err := e.lookupRecipients(tx)
+		if err != nil {
+			return err
+		}
+		if err := e.encrypt(values[key], recipients); err != nil {
+			return err
+		}
+
+		marshaled, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(key), marshaled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resealSecret re-encrypts the data key of a stored secret to the seal key of
+// the rotated identity. It returns nil if the identity isn't a recipient.
+func resealSecret(stored []byte, private, rotated *privateIdentity) ([]byte, error) {
+	e, err := parseSecret(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if e == nil {
+		value, err := private.OpenAnonymous(stored)
+		if err != nil {
+			return nil, nil
+		}
+		return rotated.SealAnonymous(value)
+	}
+
+	// secrets shared through groups are resealed with the group keys
+	dataKey, err := e.recipientKey(private)
+	if err == ErrorNotSecretRecipient {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := e.wrap(dataKey, rotated); err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// isSecretRecipient reports whether an identity can read a stored secret.
+func isSecretRecipient(i PrivateIdentity, stored []byte) bool {
+	e, err := parseSecret(stored)
+	if err != nil {
+		return false
+	}
+	if e == nil {
+		_, err := i.OpenAnonymous(stored)
+		return err == nil
+	}
+	_, ok := e.recipients[i.String()]
+	return ok
+}
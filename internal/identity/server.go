@@ -0,0 +1,77 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/akb/identify/internal/database"
+)
+
+// serverBucketKey records the identities `identify listen` has served as, with
+// when they first did, as their keys sign the log and SSH certificates.
+var serverBucketKey = []byte("server")
+
+var (
+	ErrorServerIdentity        = fmt.Errorf("the server identity's key must not leave the server")
+	ErrorServerIdentityRemoval = fmt.Errorf("the server identity can't be disabled or deleted")
+)
+
+// RecordServerIdentity records that an identity serves as the server. Once
+// recorded, it stays the server identity, as hosts may still trust the SSH
+// certificates it issued.
+func (s *localStore) RecordServerIdentity(i PrivateIdentity) error {
+	recorded := false
+	err := s.db.Update(func(tx database.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(serverBucketKey)
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(i.String())) != nil {
+			return nil
+		}
+
+		recorded = true
+		return b.Put([]byte(i.String()), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return err
+	}
+
+	if recorded {
+		log.Printf("recorded identity %s as a server identity\n", i)
+	}
+	return nil
+}
+
+// IsServerIdentity reports whether an identity has served as the server.
+func (s *localStore) IsServerIdentity(id string) (bool, error) {
+	served := false
+	err := s.db.View(func(tx database.Tx) error {
+		served = isServerIdentity(tx, id)
+		return nil
+	})
+	return served, err
+}
+
+func isServerIdentity(tx database.Tx, id string) bool {
+	b := tx.Bucket(serverBucketKey)
+	return b != nil && b.Get([]byte(id)) != nil
+}
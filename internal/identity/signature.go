@@ -0,0 +1,245 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+)
+
+const (
+	signatureFormat  = "identify-signature"
+	signatureVersion = 1
+)
+
+// Signature algorithms.
+const (
+	SignatureEd25519 = "ed25519"
+	SignatureECDSA   = "ecdsa-p256-sha256"
+)
+
+var (
+	ErrorInvalidSignature = fmt.Errorf("signature does not match")
+	ErrorSignatureFormat  = fmt.Errorf("file is not a signature or is corrupt")
+	ErrorWrongSigner      = fmt.Errorf("signature was made by another identity")
+)
+
+// ErrorUnknownSignatureAlgorithm is returned when signing or verifying with an
+// algorithm other than SignatureEd25519 or SignatureECDSA.
+type ErrorUnknownSignatureAlgorithm struct {
+	Algorithm string
+}
+
+func (err ErrorUnknownSignatureAlgorithm) Error() string {
+	return fmt.Sprintf("unknown signature algorithm '%s'", err.Algorithm)
+}
+
+// Signature is a detached signature of arbitrary data by an identity. The key
+// ID selects the current or a retired key of the signer, so that signatures
+// can still be verified after keys are rotated.
+//
+// The signed message is the following, with a newline after each field:
+//
+//	identify-signature v1
+//	<signer id>
+//	<algorithm>
+//	<key id>
+//	<time signed, RFC 3339>
+//
+// followed by the SHA-512 digest of the data. Ed25519 signs the message
+// itself; ECDSA signs its SHA-256 digest and the signature is DER encoded.
+type Signature struct {
+	Signer    string
+	Algorithm string
+	KeyID     string
+	Created   time.Time
+	Value     []byte
+}
+
+type jsonSignature struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Signer    string    `json:"signer"`
+	Algorithm string    `json:"algorithm"`
+	KeyID     string    `json:"key-id"`
+	Created   time.Time `json:"created"`
+	Signature string    `json:"signature"`
+}
+
+func (s Signature) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSignature{
+		Format:    signatureFormat,
+		Version:   signatureVersion,
+		Signer:    s.Signer,
+		Algorithm: s.Algorithm,
+		KeyID:     s.KeyID,
+		Created:   s.Created,
+		Signature: EncodeToString(s.Value),
+	})
+}
+
+// ParseSignature reads a signature written by MarshalJSON.
+func ParseSignature(marshaled []byte) (*Signature, error) {
+	var unmarshaled jsonSignature
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		return nil, ErrorSignatureFormat
+	}
+	if unmarshaled.Format != signatureFormat || unmarshaled.Version != signatureVersion {
+		return nil, ErrorSignatureFormat
+	}
+
+	value, err := DecodeString(unmarshaled.Signature)
+	if err != nil {
+		return nil, ErrorSignatureFormat
+	}
+
+	return &Signature{
+		Signer:    unmarshaled.Signer,
+		Algorithm: unmarshaled.Algorithm,
+		KeyID:     unmarshaled.KeyID,
+		Created:   unmarshaled.Created,
+		Value:     value,
+	}, nil
+}
+
+// message returns the bytes that are signed for the given data.
+func (s Signature) message(data io.Reader) ([]byte, error) {
+	digest := sha512.New()
+	if _, err := io.Copy(digest, data); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "%s v%d\n%s\n%s\n%s\n%s\n", signatureFormat, signatureVersion,
+		s.Signer, s.Algorithm, s.KeyID, s.Created.UTC().Format(time.RFC3339))
+	message.Write(digest.Sum(nil))
+	return message.Bytes(), nil
+}
+
+// Sign creates a detached signature of data with the current key of the
+// identity for the given algorithm.
+func (i privateIdentity) Sign(algorithm string, data io.Reader) (*Signature, error) {
+	signature := Signature{
+		Signer:    i.String(),
+		Algorithm: algorithm,
+		KeyID:     i.KeyID(),
+		Created:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	message, err := signature.message(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case SignatureEd25519:
+		signature.Value = ed25519.Sign(*i.ed25519PrivateKey, message)
+	case SignatureECDSA:
+		digest := sha256.Sum256(message)
+		signature.Value, err = ecdsaSign(i.ecdsaPrivateKey, digest[:])
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrorUnknownSignatureAlgorithm{algorithm}
+	}
+
+	return &signature, nil
+}
+
+func (i privateIdentity) Verify(s *Signature, data io.Reader) error {
+	return i.public.Verify(s, data)
+}
+
+// Verify checks a detached signature of data made by the identity with its
+// current or a retired key.
+func (i publicIdentity) Verify(s *Signature, data io.Reader) error {
+	if s.Signer != i.String() {
+		return ErrorWrongSigner
+	}
+
+	ecdsaPublicKey, ed25519PublicKey, err := i.publicKeysForID(s.KeyID)
+	if err != nil {
+		return err
+	}
+
+	message, err := s.message(data)
+	if err != nil {
+		return err
+	}
+
+	switch s.Algorithm {
+	case SignatureEd25519:
+		if !ed25519.Verify(ed25519PublicKey, message, s.Value) {
+			return ErrorInvalidSignature
+		}
+	case SignatureECDSA:
+		digest := sha256.Sum256(message)
+		if !ecdsaVerify(ecdsaPublicKey, digest[:], s.Value) {
+			return ErrorInvalidSignature
+		}
+	default:
+		return ErrorUnknownSignatureAlgorithm{s.Algorithm}
+	}
+	return nil
+}
+
+// publicKeysForID returns the current or retired signing keys of an identity
+// by key ID.
+func (i publicIdentity) publicKeysForID(kid string) (*ecdsa.PublicKey, ed25519.PublicKey, error) {
+	if kid == i.KeyID() {
+		return i.ecdsaPublicKey, *i.ed25519PublicKey, nil
+	}
+	for _, k := range i.history {
+		if kid == KeyID(k.ed25519PublicKey) {
+			return k.ecdsaPublicKey, k.ed25519PublicKey, nil
+		}
+	}
+	return nil, nil, ErrorUnknownKey
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func ecdsaSign(key *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ecdsaSignature{r, s})
+}
+
+func ecdsaVerify(key *ecdsa.PublicKey, digest, signature []byte) bool {
+	var parsed ecdsaSignature
+	rest, err := asn1.Unmarshal(signature, &parsed)
+	if err != nil || len(rest) > 0 {
+		return false
+	}
+	return ecdsa.Verify(key, digest, parsed.R, parsed.S)
+}
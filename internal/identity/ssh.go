@@ -0,0 +1,312 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/akb/identify/internal/database"
+)
+
+// Types of SSH certificate.
+const (
+	SSHUserCertificate = "user"
+	SSHHostCertificate = "host"
+)
+
+// Validity periods of SSH certificates. Certificates are meant to be short-lived
+// and requested again as needed, so that they needn't be revoked.
+const (
+	DefaultSSHUserCertificateValidity = time.Hour
+	MaxSSHUserCertificateValidity     = 24 * time.Hour
+	DefaultSSHHostCertificateValidity = 24 * time.Hour
+	MaxSSHHostCertificateValidity     = 30 * 24 * time.Hour
+
+	// certificates are valid from a little before they're issued, in case the
+	// clocks of the hosts checking them are behind
+	sshClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrorSSHCertificateType  = fmt.Errorf("certificate type must be %s or %s", SSHUserCertificate, SSHHostCertificate)
+	ErrorNoHostPrincipals    = fmt.Errorf("host certificates must name at least one host")
+	ErrorHostCriticalOptions = fmt.Errorf("host certificates can't have critical options")
+)
+
+// ErrorPrincipalNotAllowed is returned when a user certificate is requested for
+// a principal that isn't one of the requester's.
+type ErrorPrincipalNotAllowed struct {
+	Principal string
+}
+
+func (err ErrorPrincipalNotAllowed) Error() string {
+	return fmt.Sprintf("principal '%s' is not one of the identity's", err.Principal)
+}
+
+// ErrorSSHPrincipal is returned when setting a principal that is empty or
+// contains a separator, which sshd couldn't match.
+type ErrorSSHPrincipal struct {
+	Principal string
+}
+
+func (err ErrorSSHPrincipal) Error() string {
+	return fmt.Sprintf("'%s' is not a valid ssh principal", err.Principal)
+}
+
+// ErrorSSHValidity is returned when a certificate is requested for longer than
+// its type allows.
+type ErrorSSHValidity struct {
+	Max time.Duration
+}
+
+func (err ErrorSSHValidity) Error() string {
+	return fmt.Sprintf("certificate validity must be positive and at most %s", err.Max)
+}
+
+// ErrorSourceAddress is returned for a source address that is neither an IP
+// address nor a CIDR block.
+type ErrorSourceAddress struct {
+	Address string
+}
+
+func (err ErrorSourceAddress) Error() string {
+	return fmt.Sprintf("source address '%s' is not an IP address or CIDR block", err.Address)
+}
+
+// SSHCertificateRequest asks for an SSH certificate of a public key.
+type SSHCertificateRequest struct {
+	// Type is SSHUserCertificate or SSHHostCertificate.
+	Type      string
+	PublicKey ssh.PublicKey
+
+	// Principals are the users or hosts the certificate is valid for. User
+	// certificates default to every principal of the requester.
+	Principals []string
+
+	// Validity defaults to the default validity of the type of certificate.
+	Validity time.Duration
+
+	// ForceCommand and SourceAddresses are the critical options of user
+	// certificates, restricting the command run and where it may connect from.
+	ForceCommand    string
+	SourceAddresses []string
+}
+
+// SSHPublicKey returns the Ed25519 key of an identity as an SSH public key.
+func SSHPublicKey(i PublicIdentity) (ssh.PublicKey, error) {
+	return ssh.NewPublicKey(i.Ed25519PublicKey())
+}
+
+// AuthorizedKey formats the SSH public key of an identity as a line of an
+// authorized_keys file, commented with the identity's UUID.
+func AuthorizedKey(i PublicIdentity) (string, error) {
+	key, err := SSHPublicKey(i)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + i.String(), nil
+}
+
+// SSHSigner signs with the Ed25519 key of an identity for SSH.
+func SSHSigner(i PrivateIdentity) (ssh.Signer, error) {
+	return ssh.NewSignerFromSigner(i.Ed25519PrivateKey())
+}
+
+// sshUserExtensions are the extensions ssh-keygen grants user certificates by
+// default.
+var sshUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// IssueSSHCertificate signs an SSH certificate with the Ed25519 key of a
+// certificate authority on behalf of a requester, whose UUID becomes the key
+// ID. User certificates may only name the requester's principals: its UUID
+// and those set by an administrator. Host certificates may name any host, so
+// the requester must be permitted to issue them.
+func (s *localStore) IssueSSHCertificate(
+	ca PrivateIdentity, requester string, r SSHCertificateRequest,
+) (*ssh.Certificate, error) {
+	certificate := ssh.Certificate{
+		Key:             r.PublicKey,
+		ValidPrincipals: r.Principals,
+	}
+
+	validity, max := r.Validity, time.Duration(0)
+	switch r.Type {
+	case SSHUserCertificate:
+		certificate.CertType = ssh.UserCert
+		if validity == 0 {
+			validity = DefaultSSHUserCertificateValidity
+		}
+		max = MaxSSHUserCertificateValidity
+
+		options, err := sshCriticalOptions(r)
+		if err != nil {
+			return nil, err
+		}
+		certificate.Permissions = ssh.Permissions{
+			CriticalOptions: options,
+			Extensions:      sshUserExtensions,
+		}
+	case SSHHostCertificate:
+		certificate.CertType = ssh.HostCert
+		if validity == 0 {
+			validity = DefaultSSHHostCertificateValidity
+		}
+		max = MaxSSHHostCertificateValidity
+
+		if len(r.Principals) == 0 {
+			return nil, ErrorNoHostPrincipals
+		}
+		if r.ForceCommand != "" || len(r.SourceAddresses) > 0 {
+			return nil, ErrorHostCriticalOptions
+		}
+	default:
+		return nil, ErrorSSHCertificateType
+	}
+	if validity < 0 || validity > max {
+		return nil, ErrorSSHValidity{max}
+	}
+
+	err := s.db.View(func(tx database.Tx) error {
+		stored, err := getIdentity(tx, requester)
+		if err != nil {
+			return err
+		}
+		if stored.Disabled() {
+			return ErrorIdentityDisabled
+		}
+		certificate.KeyId = stored.String()
+
+		if certificate.CertType == ssh.HostCert {
+			return authorize(tx, stored, PermissionIssueHostCertificates)
+		}
+
+		principals := sshPrincipals(stored)
+		if len(certificate.ValidPrincipals) == 0 {
+			certificate.ValidPrincipals = principals
+			return nil
+		}
+		allowed := map[string]bool{}
+		for _, p := range principals {
+			allowed[p] = true
+		}
+		for _, p := range certificate.ValidPrincipals {
+			if !allowed[p] {
+				return ErrorPrincipalNotAllowed{p}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	certificate.Serial = binary.BigEndian.Uint64(serial[:])
+
+	now := time.Now()
+	certificate.ValidAfter = uint64(now.Add(-sshClockSkew).Unix())
+	certificate.ValidBefore = uint64(now.Add(validity).Unix())
+
+	signer, err := SSHSigner(ca)
+	if err != nil {
+		return nil, err
+	}
+	if err := certificate.SignCert(rand.Reader, signer); err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+// sshPrincipals lists the principals an identity may be issued user
+// certificates for: its UUID and those an administrator set for it. Aliases
+// and group names are chosen by the identities themselves, so they aren't.
+func sshPrincipals(i *publicIdentity) []string {
+	return append([]string{i.String()}, i.sshPrincipals...)
+}
+
+// SSHPrincipals returns the principals set for the identity with
+// SetSSHPrincipals.
+func (i publicIdentity) SSHPrincipals() []string {
+	return i.sshPrincipals
+}
+
+// SetSSHPrincipals replaces the principals, besides its UUID, that an identity
+// may be issued SSH user certificates for, such as the users it may log in to
+// hosts as. Only identities permitted to manage SSH principals may set them.
+func (s *localStore) SetSSHPrincipals(by PrivateIdentity, id string, principals []string) error {
+	for _, p := range principals {
+		if p == "" || strings.ContainsAny(p, ", \t\n") {
+			return ErrorSSHPrincipal{p}
+		}
+	}
+
+	err := s.db.Update(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := authorize(tx, by, PermissionManageSSHPrincipals); err != nil {
+			return err
+		}
+
+		return updateIdentity(tx, id, func(stored *publicIdentity) error {
+			stored.sshPrincipals = principals
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s set the ssh principals of identity %s to %s\n", by, id, strings.Join(principals, ","))
+	return nil
+}
+
+func sshCriticalOptions(r SSHCertificateRequest) (map[string]string, error) {
+	options := map[string]string{}
+	if r.ForceCommand != "" {
+		options["force-command"] = r.ForceCommand
+	}
+	if len(r.SourceAddresses) > 0 {
+		for _, a := range r.SourceAddresses {
+			if _, _, err := net.ParseCIDR(a); err != nil && net.ParseIP(a) == nil {
+				return nil, ErrorSourceAddress{a}
+			}
+		}
+		options["source-address"] = strings.Join(r.SourceAddresses, ",")
+	}
+	return options, nil
+}
@@ -0,0 +1,140 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/totp"
+)
+
+// Authentication methods, as registered for the amr claim by RFC 8176.
+const (
+	MethodPassphrase = "pwd"
+	MethodOTP        = "otp"
+	MethodMultiple   = "mfa"
+)
+
+var (
+	ErrorTOTPRequired    = fmt.Errorf("an authentication code is required")
+	ErrorInvalidTOTP     = fmt.Errorf("authentication code is invalid or has already been used")
+	ErrorTOTPNotEnrolled = fmt.Errorf("identity has not enrolled a second factor")
+)
+
+func (i publicIdentity) TOTPEnrolled() bool {
+	return len(i.totp) > 0
+}
+
+// EnrollTOTP seals a TOTP secret to an identity, after which it must provide a
+// one-time password whenever it authenticates. The code proves that the secret
+// was added to an authenticator.
+func (s *localStore) EnrollTOTP(i PrivateIdentity, secret []byte, code string) error {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return ErrorInvalidTOTP
+	}
+
+	sealed, err := i.SealAnonymous(totp.Encoding.EncodeToString(secret))
+	if err != nil {
+		return err
+	}
+
+	err = s.updateIdentity(i.String(), func(stored *publicIdentity) error {
+		stored.totp = sealed
+		stored.totpStep = step
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if private, ok := i.(*privateIdentity); ok {
+		private.public.totp = sealed
+		private.public.totpStep = step
+	}
+
+	log.Printf("enrolled totp for identity: %s\n", i)
+	return nil
+}
+
+func (s *localStore) RemoveTOTP(i PrivateIdentity) error {
+	err := s.updateIdentity(i.String(), func(stored *publicIdentity) error {
+		if len(stored.totp) == 0 {
+			return ErrorTOTPNotEnrolled
+		}
+		stored.totp = nil
+		stored.totpStep = 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("removed totp for identity: %s\n", i)
+	return nil
+}
+
+// VerifyTOTP checks a one-time password of an authenticated identity. Each
+// code is only accepted once.
+func (s *localStore) VerifyTOTP(i PrivateIdentity, code string) error {
+	if !i.TOTPEnrolled() {
+		return ErrorTOTPNotEnrolled
+	}
+	if code == "" {
+		return ErrorTOTPRequired
+	}
+
+	return s.db.Update(func(tx database.Tx) error {
+		return updateIdentity(tx, i.String(), func(stored *publicIdentity) error {
+			encoded, err := i.OpenAnonymous(stored.totp)
+			if err != nil {
+				return err
+			}
+
+			secret, err := totp.Encoding.DecodeString(encoded)
+			if err != nil {
+				return err
+			}
+
+			step, ok := totp.Validate(secret, code, time.Now())
+			if !ok || step <= stored.totpStep {
+				return ErrorInvalidTOTP
+			}
+
+			stored.totpStep = step
+			return nil
+		})
+	})
+}
+
+// resealTOTP re-encrypts the TOTP secret of an identity to the seal key of the
+// rotated identity.
+func resealTOTP(sealed []byte, private, rotated *privateIdentity) ([]byte, error) {
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+
+	secret, err := private.OpenAnonymous(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return rotated.SealAnonymous(secret)
+}
@@ -0,0 +1,321 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/transparency"
+)
+
+// logBucketKey holds the key transparency log, keyed by the big-endian index
+// of each entry. Entries are only ever appended.
+var logBucketKey = []byte("log")
+
+// Types of key transparency log entries.
+const (
+	LogCreate  = "create"
+	LogImport  = "import"
+	LogRotate  = "rotate"
+	LogAliases = "aliases"
+	LogDelete  = "delete"
+
+	// LogExisting entries record identities that were created before the log
+	// was kept.
+	LogExisting = "existing"
+)
+
+var (
+	ErrorInvalidLogEntry = fmt.Errorf("log entry is not valid")
+	ErrorNotInLog        = fmt.Errorf("identity is not in the log")
+)
+
+// LogEntry records the public keys and aliases of an identity after it was
+// created, imported, had its keys rotated or had its aliases changed, or
+// records that it was deleted. Each entry is a leaf of the Merkle tree of the
+// key transparency log, so the keys an identity has ever had can be audited.
+type LogEntry struct {
+	Type             string    `json:"type"`
+	Identity         string    `json:"identity"`
+	Aliases          []string  `json:"aliases,omitempty"`
+	KeyID            string    `json:"key-id,omitempty"`
+	ECDSAPublicKey   string    `json:"ecdsa-public-key,omitempty"`
+	Ed25519PublicKey string    `json:"ed25519-public-key,omitempty"`
+	SealPublicKey    string    `json:"seal-public-key,omitempty"`
+	Time             time.Time `json:"time"`
+}
+
+// ParseLogEntry reads a leaf of the log, checking that the key ID of the
+// entry is that of its Ed25519 key.
+func ParseLogEntry(leaf []byte) (*LogEntry, error) {
+	var e LogEntry
+	if err := json.Unmarshal(leaf, &e); err != nil {
+		return nil, ErrorInvalidLogEntry
+	}
+	if _, err := uuid.Parse(e.Identity); err != nil {
+		return nil, ErrorInvalidLogEntry
+	}
+
+	switch e.Type {
+	case LogDelete:
+		if e.KeyID != "" || len(e.Aliases) > 0 {
+			return nil, ErrorInvalidLogEntry
+		}
+		return &e, nil
+	case LogCreate, LogImport, LogRotate, LogAliases, LogExisting:
+	default:
+		return nil, ErrorInvalidLogEntry
+	}
+
+	key, err := e.Ed25519Key()
+	if err != nil || KeyID(key) != e.KeyID {
+		return nil, ErrorInvalidLogEntry
+	}
+	if marshaled, err := DecodeString(e.ECDSAPublicKey); err != nil {
+		return nil, ErrorInvalidLogEntry
+	} else if _, err := x509.ParsePKIXPublicKey(marshaled); err != nil {
+		return nil, ErrorInvalidLogEntry
+	}
+	if seal, err := DecodeString(e.SealPublicKey); err != nil || len(seal) != 32 {
+		return nil, ErrorInvalidLogEntry
+	}
+	return &e, nil
+}
+
+// Ed25519Key returns the Ed25519 public key recorded by the entry.
+func (e LogEntry) Ed25519Key() (ed25519.PublicKey, error) {
+	key, err := DecodeString(e.Ed25519PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrorInvalidLogEntry
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// logIdentity appends an entry recording the stored state of an identity to
+// the log, in the transaction that changed it.
+func logIdentity(tx database.Tx, kind, id string) error {
+	entry := LogEntry{Type: kind, Identity: id, Time: time.Now().UTC()}
+
+	if kind != LogDelete {
+		i, err := getIdentity(tx, id)
+		if err != nil {
+			return err
+		}
+
+		marshaledECDSAPublicKey, err := x509.MarshalPKIXPublicKey(i.ecdsaPublicKey)
+		if err != nil {
+			return err
+		}
+
+		entry.Aliases = i.aliases
+		entry.KeyID = i.KeyID()
+		entry.ECDSAPublicKey = EncodeToString(marshaledECDSAPublicKey)
+		entry.Ed25519PublicKey = EncodeToString([]byte(*i.ed25519PublicKey))
+		entry.SealPublicKey = EncodeToString(i.sealPublicKey[:])
+	}
+
+	leaf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	b, err := tx.CreateBucketIfNotExists(logBucketKey)
+	if err != nil {
+		return err
+	}
+
+	var index uint64
+	if last, _ := b.Cursor().Last(); last != nil {
+		index = binary.BigEndian.Uint64(last) + 1
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, index)
+	return b.Put(key, leaf)
+}
+
+// logExistingIdentities records the identities of a database written before
+// the log was kept.
+func logExistingIdentities(tx database.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(logBucketKey); err != nil {
+		return err
+	}
+
+	b := tx.Bucket(identityBucketKey)
+	if b == nil {
+		return nil
+	}
+
+	var ids []string
+	err := b.ForEach(func(id, _ []byte) error {
+		ids = append(ids, string(id))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// records that can't be read can't be served either, and are left out
+		// rather than holding back the rest of the upgrade
+		if _, err := getIdentity(tx, id); err != nil {
+			log.Printf("left unreadable identity %s out of the key transparency log: %s\n",
+				id, err.Error())
+			continue
+		}
+		if err := logIdentity(tx, LogExisting, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logLeaves reads the leaves of the log in order.
+func logLeaves(tx database.Tx) [][]byte {
+	var leaves [][]byte
+	if b := tx.Bucket(logBucketKey); b != nil {
+		b.ForEach(func(_, leaf []byte) error {
+			leaves = append(leaves, append([]byte{}, leaf...))
+			return nil
+		})
+	}
+	return leaves
+}
+
+// logHashes returns the leaf hashes of the first entries of the log, making
+// up a tree of the given size.
+func logHashes(tx database.Tx, size int) ([][]byte, error) {
+	leaves := logLeaves(tx)
+	if size < 0 || size > len(leaves) {
+		return nil, transparency.ErrorOutOfRange{Index: size, Size: len(leaves)}
+	}
+	return hashLeaves(leaves[:size]), nil
+}
+
+func hashLeaves(leaves [][]byte) [][]byte {
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = transparency.LeafHash(leaf)
+	}
+	return hashes
+}
+
+// LogHead returns the current size and root of the log, unsigned.
+func (s *localStore) LogHead() (*transparency.TreeHead, error) {
+	var head transparency.TreeHead
+	err := s.db.View(func(tx database.Tx) error {
+		hashes := hashLeaves(logLeaves(tx))
+		head.Size = len(hashes)
+		head.Root = transparency.RootHash(hashes)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// LogEntries returns the leaves of the log from the start index up to, but not
+// including, the end index.
+func (s *localStore) LogEntries(start, end int) ([][]byte, error) {
+	var entries [][]byte
+	err := s.db.View(func(tx database.Tx) error {
+		leaves := logLeaves(tx)
+		if start < 0 || start > end || end > len(leaves) {
+			return transparency.ErrorOutOfRange{Index: end, Size: len(leaves)}
+		}
+		entries = leaves[start:end]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LogInclusionProof proves that the entry at an index is included in the log
+// as of the given size.
+func (s *localStore) LogInclusionProof(index, size int) ([][]byte, error) {
+	var proof [][]byte
+	err := s.db.View(func(tx database.Tx) error {
+		hashes, err := logHashes(tx, size)
+		if err != nil {
+			return err
+		}
+		proof, err = transparency.InclusionProof(hashes, index)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// LogConsistencyProof proves that the log as of the second size extends the
+// log as of the first.
+func (s *localStore) LogConsistencyProof(first, second int) ([][]byte, error) {
+	var proof [][]byte
+	err := s.db.View(func(tx database.Tx) error {
+		hashes, err := logHashes(tx, second)
+		if err != nil {
+			return err
+		}
+		proof, err = transparency.ConsistencyProof(hashes, first)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// LogIndex returns the index of the latest entry of an identity in the log,
+// which records its current keys and aliases.
+func (s *localStore) LogIndex(id string) (int, error) {
+	index := -1
+	err := s.db.View(func(tx database.Tx) error {
+		id, err := resolveID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		for i, leaf := range logLeaves(tx) {
+			var e LogEntry
+			if err := json.Unmarshal(leaf, &e); err == nil && e.Identity == id {
+				index = i
+			}
+		}
+		if index < 0 {
+			return ErrorNotInLog
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return index, nil
+}
@@ -0,0 +1,300 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Request asks whether a subject may perform an action on a resource. The
+// claims are those of the subject's access token, and the attributes its
+// unsealed profile attributes. Context holds anything else the caller knows
+// about the request, such as the address it came from.
+type Request struct {
+	Subject    string                 `json:"subject"`
+	Action     string                 `json:"action"`
+	Resource   string                 `json:"resource"`
+	Claims     map[string]interface{} `json:"claims,omitempty"`
+	Attributes map[string]string      `json:"attributes,omitempty"`
+	Context    map[string]interface{} `json:"context,omitempty"`
+}
+
+// Decision is the outcome of evaluating a request, naming the rule that
+// decided it, if any. Trace explains how every rule was evaluated.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Policy  string `json:"policy,omitempty"`
+	Version int    `json:"version,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason"`
+	Trace   []Step `json:"trace,omitempty"`
+}
+
+// Step records whether a rule matched a request, and why not if it didn't.
+type Step struct {
+	Policy  string `json:"policy"`
+	Version int    `json:"version,omitempty"`
+	Rule    string `json:"rule"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// Evaluate decides a request against policies. Any matching rule that denies
+// the request takes precedence over those that allow it, and requests that no
+// rule allows are denied.
+func Evaluate(policies []*Policy, r Request) *Decision {
+	var trace []Step
+	allowed, denied := -1, -1
+
+	for _, p := range policies {
+		for _, rule := range p.Rules {
+			step := Step{Policy: p.Name, Version: p.Version, Rule: rule.ID, Effect: rule.Effect}
+			step.Matched, step.Reason = rule.match(r)
+			trace = append(trace, step)
+
+			if !step.Matched {
+				continue
+			}
+			if rule.Effect == Deny && denied < 0 {
+				denied = len(trace) - 1
+			} else if rule.Effect == Allow && allowed < 0 {
+				allowed = len(trace) - 1
+			}
+		}
+	}
+
+	switch {
+	case denied >= 0:
+		return decide(false, trace[denied], trace)
+	case allowed >= 0:
+		return decide(true, trace[allowed], trace)
+	}
+
+	return &Decision{
+		Reason: fmt.Sprintf("no rule allows %s on %s", r.Action, r.Resource),
+		Trace:  trace,
+	}
+}
+
+func decide(allowed bool, step Step, trace []Step) *Decision {
+	verb := "allowed"
+	if !allowed {
+		verb = "denied"
+	}
+	return &Decision{
+		Allowed: allowed,
+		Policy:  step.Policy,
+		Version: step.Version,
+		Rule:    step.Rule,
+		Reason:  fmt.Sprintf("%s by rule %s of policy %s", verb, step.Rule, step.Policy),
+		Trace:   trace,
+	}
+}
+
+// match reports whether a rule applies to a request, or the reason it doesn't.
+func (rule Rule) match(r Request) (bool, string) {
+	if !matchAny(rule.Actions, r.Action, r, false) {
+		return false, fmt.Sprintf("action %s doesn't match %s", r.Action, strings.Join(rule.Actions, ", "))
+	}
+	if !matchAny(rule.Resources, r.Resource, r, true) {
+		return false, fmt.Sprintf("resource %s doesn't match %s", r.Resource, strings.Join(rule.Resources, ", "))
+	}
+
+	for n, c := range rule.Conditions {
+		if ok, reason := c.holds(r); !ok {
+			return false, fmt.Sprintf("condition %d failed: %s", n+1, reason)
+		}
+	}
+	return true, "matched"
+}
+
+func matchAny(patterns []string, value string, r Request, references bool) bool {
+	for _, pattern := range patterns {
+		// wildcards are split out before references are expanded, so that a
+		// '*' in the value of a field is matched literally
+		parts := strings.Split(pattern, "*")
+		if references {
+			complete := true
+			for n, part := range parts {
+				expanded, ok := r.expand(part)
+				parts[n], complete = expanded, complete && ok
+			}
+			if !complete {
+				continue
+			}
+		}
+		if match(parts, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// holds reports whether a condition is true of a request, or why it isn't.
+func (c Condition) holds(r Request) (bool, string) {
+	actual, present := r.field(c.Field)
+	switch c.Op {
+	case OpPresent:
+		return present, fmt.Sprintf("%s is absent", c.Field)
+	case OpAbsent:
+		return !present, fmt.Sprintf("%s is present", c.Field)
+	}
+	if !present {
+		return false, fmt.Sprintf("%s is absent", c.Field)
+	}
+
+	expected, ok := r.value(c.Value)
+	if !ok {
+		return false, fmt.Sprintf("%v refers to an absent field", c.Value)
+	}
+
+	var holds bool
+	switch c.Op {
+	case OpEquals:
+		holds = equal(actual, expected)
+	case OpNotEquals:
+		holds = !equal(actual, expected)
+	case OpIn:
+		holds = false
+		for _, a := range elements(actual) {
+			holds = holds || contains(expected, a)
+		}
+	case OpContains:
+		if s, ok := actual.(string); ok {
+			holds = strings.Contains(s, fmt.Sprint(expected))
+		} else {
+			holds = contains(actual, expected)
+		}
+	case OpPrefix:
+		holds = strings.HasPrefix(fmt.Sprint(actual), fmt.Sprint(expected))
+	}
+
+	return holds, fmt.Sprintf("%s is %v, not %s %v", c.Field, format(actual), c.Op, format(expected))
+}
+
+// field returns the value of a field of the request.
+func (r Request) field(field string) (interface{}, bool) {
+	switch {
+	case field == FieldSubject:
+		return r.Subject, r.Subject != ""
+	case field == FieldAction:
+		return r.Action, true
+	case field == FieldResource:
+		return r.Resource, true
+	case strings.HasPrefix(field, FieldClaims):
+		v, ok := r.Claims[strings.TrimPrefix(field, FieldClaims)]
+		return v, ok
+	case strings.HasPrefix(field, FieldAttributes):
+		v, ok := r.Attributes[strings.TrimPrefix(field, FieldAttributes)]
+		return v, ok
+	case strings.HasPrefix(field, FieldContext):
+		v, ok := r.Context[strings.TrimPrefix(field, FieldContext)]
+		return v, ok
+	}
+	return nil, false
+}
+
+// value resolves references to fields in the value of a condition. A value
+// that is only a reference takes the type of the field it refers to.
+func (r Request) value(v interface{}) (interface{}, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return v, true
+	}
+
+	if match := referencePattern.FindStringSubmatch(s); match != nil && match[0] == s {
+		return r.field(match[1])
+	}
+	return r.expand(s)
+}
+
+// expand replaces references to fields in a string with their values.
+func (r Request) expand(s string) (string, bool) {
+	complete := true
+	expanded := referencePattern.ReplaceAllStringFunc(s, func(reference string) string {
+		v, ok := r.field(reference[2 : len(reference)-1])
+		if !ok {
+			complete = false
+			return ""
+		}
+		return fmt.Sprint(v)
+	})
+	return expanded, complete
+}
+
+// match reports whether a value matches a pattern split at each '*', which
+// matches any sequence of characters.
+func match(parts []string, value string) bool {
+	if len(parts) == 1 {
+		return parts[0] == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		n := strings.Index(value, part)
+		if n < 0 {
+			return false
+		}
+		value = value[n+len(part):]
+	}
+	return strings.HasSuffix(value, last)
+}
+
+// elements returns the elements of a list, or a scalar as a list of one.
+func elements(v interface{}) []interface{} {
+	switch list := v.(type) {
+	case []interface{}:
+		return list
+	case []string:
+		var elements []interface{}
+		for _, s := range list {
+			elements = append(elements, s)
+		}
+		return elements
+	}
+	return []interface{}{v}
+}
+
+func contains(list, v interface{}) bool {
+	for _, e := range elements(list) {
+		if equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares scalars by their text, since claims decoded from tokens and
+// values decoded from policies may have different types for the same value.
+func equal(a, b interface{}) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func format(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
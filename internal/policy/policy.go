@@ -0,0 +1,254 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package policy implements a small declarative language for deciding whether
+// a subject may perform an action on a resource.
+//
+// A policy is a JSON document holding rules. Each rule allows or denies a set
+// of actions on a set of resources, optionally only when all of its
+// conditions hold:
+//
+//	{
+//	  "name": "documents",
+//	  "rules": [{
+//	    "id": "editors-write",
+//	    "effect": "allow",
+//	    "actions": ["documents:read", "documents:write"],
+//	    "resources": ["documents/*"],
+//	    "conditions": [
+//	      {"field": "claims.roles", "op": "contains", "value": "editor"}
+//	    ]
+//	  }, {
+//	    "id": "owners",
+//	    "effect": "allow",
+//	    "actions": ["*"],
+//	    "resources": ["documents/${subject}/*"]
+//	  }]
+//	}
+//
+// Actions and resources are matched with patterns in which '*' matches any
+// sequence of characters. Resource patterns and condition values may refer to
+// fields of the request as ${field}. A request is denied if any matching rule
+// denies it, allowed if any matching rule allows it, and denied otherwise.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Effects of a rule.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Condition operators.
+const (
+	OpEquals    = "equals"
+	OpNotEquals = "not-equals"
+	OpIn        = "in"
+	OpContains  = "contains"
+	OpPrefix    = "prefix"
+	OpPresent   = "present"
+	OpAbsent    = "absent"
+)
+
+var operators = map[string]bool{
+	OpEquals: true, OpNotEquals: true, OpIn: true, OpContains: true,
+	OpPrefix: true, OpPresent: true, OpAbsent: true,
+}
+
+// Fields of a request that conditions may test. Claims, attributes and context
+// values are named with a prefix, such as "claims.roles".
+const (
+	FieldSubject    = "subject"
+	FieldAction     = "action"
+	FieldResource   = "resource"
+	FieldClaims     = "claims."
+	FieldAttributes = "attributes."
+	FieldContext    = "context."
+)
+
+var (
+	namePattern      = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+	referencePattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+)
+
+var (
+	ErrorInvalidName = fmt.Errorf("policy and rule names must be 1 to 64 letters, " +
+		"digits, '.', '_' or '-', starting with a letter or digit")
+	ErrorNoRules = fmt.Errorf("policy has no rules")
+)
+
+// ErrorInvalidRule describes what is wrong with a rule of a policy.
+type ErrorInvalidRule struct {
+	Rule   string
+	Reason string
+}
+
+func (err ErrorInvalidRule) Error() string {
+	return fmt.Sprintf("rule '%s' is invalid: %s", err.Rule, err.Reason)
+}
+
+// Policy is a named set of rules for deciding requests.
+type Policy struct {
+	Name string `json:"name"`
+
+	// Version is assigned when the policy is stored, and is ignored in
+	// documents that are being stored.
+	Version int `json:"version,omitempty"`
+
+	Description string `json:"description,omitempty"`
+	Rules       []Rule `json:"rules"`
+}
+
+// Rule allows or denies actions on resources that match its patterns, when all
+// of its conditions hold.
+type Rule struct {
+	ID          string      `json:"id"`
+	Description string      `json:"description,omitempty"`
+	Effect      string      `json:"effect"`
+	Actions     []string    `json:"actions"`
+	Resources   []string    `json:"resources"`
+	Conditions  []Condition `json:"conditions,omitempty"`
+}
+
+// Condition compares a field of the request with a value. The value of the in
+// operator is a list, and present and absent take no value.
+type Condition struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Parse reads and validates a policy document.
+func Parse(document []byte) (*Policy, error) {
+	var p Policy
+	decoder := json.NewDecoder(strings.NewReader(string(document)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("policy is not valid JSON: %s", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks that a policy can be evaluated.
+func (p Policy) Validate() error {
+	if !namePattern.MatchString(p.Name) {
+		return ErrorInvalidName
+	}
+	if len(p.Rules) == 0 {
+		return ErrorNoRules
+	}
+
+	ids := map[string]bool{}
+	for _, r := range p.Rules {
+		if !namePattern.MatchString(r.ID) {
+			return ErrorInvalidRule{r.ID, ErrorInvalidName.Error()}
+		}
+		if ids[r.ID] {
+			return ErrorInvalidRule{r.ID, "another rule has the same id"}
+		}
+		ids[r.ID] = true
+
+		if err := r.validate(); err != nil {
+			return ErrorInvalidRule{r.ID, err.Error()}
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if r.Effect != Allow && r.Effect != Deny {
+		return fmt.Errorf("effect must be '%s' or '%s'", Allow, Deny)
+	}
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("at least one action is required")
+	}
+	if len(r.Resources) == 0 {
+		return fmt.Errorf("at least one resource is required")
+	}
+	for _, resource := range r.Resources {
+		if err := validateReferences(resource); err != nil {
+			return err
+		}
+	}
+
+	for n, c := range r.Conditions {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("condition %d: %s", n+1, err)
+		}
+	}
+	return nil
+}
+
+func (c Condition) validate() error {
+	if !isField(c.Field) {
+		return fmt.Errorf("unknown field '%s'", c.Field)
+	}
+	if !operators[c.Op] {
+		return fmt.Errorf("unknown operator '%s'", c.Op)
+	}
+
+	switch c.Op {
+	case OpPresent, OpAbsent:
+		if c.Value != nil {
+			return fmt.Errorf("%s takes no value", c.Op)
+		}
+	case OpIn:
+		if _, ok := c.Value.([]interface{}); !ok {
+			return fmt.Errorf("%s requires a list of values", c.Op)
+		}
+	default:
+		if c.Value == nil {
+			return fmt.Errorf("%s requires a value", c.Op)
+		}
+	}
+
+	if s, ok := c.Value.(string); ok {
+		return validateReferences(s)
+	}
+	return nil
+}
+
+func validateReferences(s string) error {
+	for _, match := range referencePattern.FindAllStringSubmatch(s, -1) {
+		if !isField(match[1]) {
+			return fmt.Errorf("unknown field '%s' in '%s'", match[1], s)
+		}
+	}
+	return nil
+}
+
+func isField(field string) bool {
+	switch field {
+	case FieldSubject, FieldAction, FieldResource:
+		return true
+	}
+	for _, prefix := range []string{FieldClaims, FieldAttributes, FieldContext} {
+		if strings.HasPrefix(field, prefix) && len(field) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package shamir implements Shamir's secret sharing over GF(2^8). A secret is
+// split into shares so that any threshold of them can reconstruct it, while
+// fewer reveal nothing about it.
+package shamir
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrorInvalidThreshold = fmt.Errorf("threshold must be at least 2 and at most the number of shares, which may not exceed 255")
+	ErrorEmptySecret      = fmt.Errorf("secret must not be empty")
+	ErrorInvalidShares    = fmt.Errorf("shares must be non-empty, of equal length and distinct")
+)
+
+// exp and log are lookup tables for multiplication in GF(2^8) using the AES
+// polynomial x^8 + x^4 + x^3 + x + 1 and the generator 3.
+var exp, log [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		log[x] = byte(i)
+		// multiply by the generator: x*3 = x*2 ^ x
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x = x2 ^ x
+	}
+	exp[255] = exp[0]
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return exp[(int(log[a])+int(log[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return exp[(int(log[a])-int(log[b])+255)%255]
+}
+
+// Split divides a secret into n shares, any threshold of which can be combined
+// to recover it. Each share is one byte longer than the secret; the first byte
+// is the x coordinate of the share.
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, ErrorEmptySecret
+	}
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, ErrorInvalidThreshold
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for j, b := range secret {
+		coefficients[0] = b
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for _, share := range shares {
+			// evaluate the polynomial at x with Horner's method
+			x, y := share[0], byte(0)
+			for k := threshold - 1; k >= 0; k-- {
+				y = mul(y, x) ^ coefficients[k]
+			}
+			share[j+1] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine reconstructs a secret from shares. Given fewer shares than the
+// threshold the secret was split with, the result is meaningless.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrorInvalidShares
+	}
+
+	length := len(shares[0])
+	seen := map[byte]bool{}
+	for _, share := range shares {
+		if len(share) != length || length < 2 || share[0] == 0 || seen[share[0]] {
+			return nil, ErrorInvalidShares
+		}
+		seen[share[0]] = true
+	}
+
+	secret := make([]byte, length-1)
+	for i, share := range shares {
+		// the Lagrange basis polynomial of this share evaluated at zero
+		basis := byte(1)
+		for k, other := range shares {
+			if k != i {
+				basis = mul(basis, div(other[0], other[0]^share[0]))
+			}
+		}
+
+		for j := range secret {
+			secret[j] ^= mul(share[j+1], basis)
+		}
+	}
+
+	return secret, nil
+}
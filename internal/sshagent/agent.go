@@ -0,0 +1,251 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package sshagent serves the ssh-agent protocol for a single identity, so
+// that ssh and git can sign with its key while it stays in memory. The agent
+// only signs requests to authenticate to ssh servers and SSHSIG signatures,
+// so that it can't be made to sign anything else the key is trusted for.
+package sshagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	ErrorReadOnly   = fmt.Errorf("the agent only holds the key of its identity")
+	ErrorLocked     = fmt.Errorf("the agent is locked")
+	ErrorNotLocked  = fmt.Errorf("the agent is not locked")
+	ErrorUnknownKey = fmt.Errorf("the agent doesn't hold that key")
+	ErrorPassphrase = fmt.Errorf("incorrect passphrase")
+	ErrorPayload    = fmt.Errorf("the agent only signs ssh authentication requests and SSHSIG signatures")
+)
+
+// identityAgent is an agent holding a single key, which clients can't add to
+// or remove. Locking it with a passphrase refuses requests until it's unlocked
+// with the same one.
+type identityAgent struct {
+	signer  ssh.Signer
+	comment string
+
+	mutex      sync.Mutex
+	passphrase []byte
+}
+
+// New returns an agent that signs with a key, listed with a comment.
+func New(signer ssh.Signer, comment string) agent.Agent {
+	return &identityAgent{signer: signer, comment: comment}
+}
+
+func (a *identityAgent) locked() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.passphrase != nil
+}
+
+func (a *identityAgent) List() ([]*agent.Key, error) {
+	if a.locked() {
+		return []*agent.Key{}, nil
+	}
+
+	key := a.signer.PublicKey()
+	return []*agent.Key{{
+		Format:  key.Type(),
+		Blob:    key.Marshal(),
+		Comment: a.comment,
+	}}, nil
+}
+
+func (a *identityAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if a.locked() {
+		return nil, ErrorLocked
+	}
+	if !bytes.Equal(key.Marshal(), a.signer.PublicKey().Marshal()) {
+		return nil, ErrorUnknownKey
+	}
+	if !a.isUserAuthRequest(data) && !isSSHSIG(data) {
+		return nil, ErrorPayload
+	}
+	return a.signer.Sign(nil, data)
+}
+
+// userAuthRequest is the data signed to authenticate with a public key, as
+// described by RFC 4252 section 7.
+type userAuthRequest struct {
+	Session   []byte
+	Type      byte
+	User      string
+	Service   string
+	Method    string
+	Signed    bool
+	Algorithm string
+	Key       []byte
+	Rest      []byte `ssh:"rest"`
+}
+
+// msgUserAuthRequest is SSH_MSG_USERAUTH_REQUEST.
+const msgUserAuthRequest = 50
+
+// isUserAuthRequest reports whether data is a request to authenticate to an
+// ssh server with the agent's key, or a certificate of it.
+func (a *identityAgent) isUserAuthRequest(data []byte) bool {
+	var r userAuthRequest
+	if err := ssh.Unmarshal(data, &r); err != nil {
+		return false
+	}
+	if len(r.Session) == 0 || r.Type != msgUserAuthRequest || r.Service != "ssh-connection" || !r.Signed {
+		return false
+	}
+
+	switch r.Method {
+	case "publickey":
+		if len(r.Rest) != 0 {
+			return false
+		}
+	case "publickey-hostbound-v00@openssh.com":
+		// the request is bound to the host key of the server, which follows
+		var hostKey struct {
+			Key []byte
+		}
+		if err := ssh.Unmarshal(r.Rest, &hostKey); err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+
+	key, err := ssh.ParsePublicKey(r.Key)
+	if err != nil {
+		return false
+	}
+	if certificate, ok := key.(*ssh.Certificate); ok {
+		key = certificate.Key
+	}
+	return bytes.Equal(key.Marshal(), a.signer.PublicKey().Marshal())
+}
+
+// sshsigMagic begins the data signed by `ssh-keygen -Y sign`, as described by
+// OpenSSH's PROTOCOL.sshsig.
+const sshsigMagic = "SSHSIG"
+
+// isSSHSIG reports whether data is the hash of a message signed for a
+// namespace, as git and ssh-keygen sign.
+func isSSHSIG(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte(sshsigMagic)) {
+		return false
+	}
+
+	var signed struct {
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Hash          []byte
+	}
+	if err := ssh.Unmarshal(data[len(sshsigMagic):], &signed); err != nil {
+		return false
+	}
+	if signed.Namespace == "" {
+		return false
+	}
+
+	switch signed.HashAlgorithm {
+	case "sha256":
+		return len(signed.Hash) == sha256.Size
+	case "sha512":
+		return len(signed.Hash) == sha512.Size
+	}
+	return false
+}
+
+func (a *identityAgent) Add(agent.AddedKey) error {
+	return ErrorReadOnly
+}
+
+func (a *identityAgent) Remove(ssh.PublicKey) error {
+	return ErrorReadOnly
+}
+
+func (a *identityAgent) RemoveAll() error {
+	return ErrorReadOnly
+}
+
+func (a *identityAgent) Lock(passphrase []byte) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.passphrase != nil {
+		return ErrorLocked
+	}
+	a.passphrase = append([]byte{}, passphrase...)
+	return nil
+}
+
+func (a *identityAgent) Unlock(passphrase []byte) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.passphrase == nil {
+		return ErrorNotLocked
+	}
+	if subtle.ConstantTimeCompare(a.passphrase, passphrase) != 1 {
+		return ErrorPassphrase
+	}
+	a.passphrase = nil
+	return nil
+}
+
+func (a *identityAgent) Signers() ([]ssh.Signer, error) {
+	if a.locked() {
+		return nil, ErrorLocked
+	}
+	return []ssh.Signer{a.signer}, nil
+}
+
+// Serve answers agent requests on each connection accepted by a listener
+// until the context is done, when the listener is closed.
+func Serve(ctx context.Context, listener net.Listener, a agent.Agent) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := agent.ServeAgent(a, conn); err != nil && err != io.EOF {
+				log.Printf("ssh agent connection closed: %s\n", err.Error())
+			}
+		}()
+	}
+}
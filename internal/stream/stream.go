@@ -0,0 +1,237 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package stream encrypts data of any length as a sequence of authenticated
+// chunks, so that it never has to be held in memory at once.
+//
+// Each chunk of plaintext is sealed with NaCl secretbox under the same key.
+// The 24 byte nonce of a chunk is a random 16 byte prefix shared by the whole
+// stream, the index of the chunk as a 7 byte big-endian integer, and a byte
+// that is 1 for the last chunk and 0 otherwise. Every chunk but the last holds
+// exactly the chunk size of plaintext; the last may be shorter or even empty.
+// Chunks that are reordered, dropped or duplicated fail to open, and a stream
+// cut short is detected because its last chunk isn't marked final.
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// DefaultChunkSize is the amount of plaintext sealed in each chunk.
+	DefaultChunkSize = 64 * 1024
+
+	// MaxChunkSize bounds the memory used to read a stream.
+	MaxChunkSize = 16 * 1024 * 1024
+
+	// PrefixSize is the length of the nonce prefix of a stream.
+	PrefixSize = 16
+
+	// Overhead is the number of bytes added to each chunk.
+	Overhead = secretbox.Overhead
+
+	maxChunks = 1<<56 - 1
+)
+
+var (
+	ErrorInvalidChunkSize = fmt.Errorf("chunk size must be between 1 and %d bytes", MaxChunkSize)
+	ErrorCorrupt          = fmt.Errorf("stream is corrupt or was encrypted with another key")
+	ErrorTruncated        = fmt.Errorf("stream is truncated")
+	ErrorTrailingData     = fmt.Errorf("stream has data after its final chunk")
+	ErrorTooLong          = fmt.Errorf("stream has too many chunks")
+	ErrorClosed           = fmt.Errorf("stream is closed")
+)
+
+func nonce(prefix *[PrefixSize]byte, counter uint64, final bool) *[24]byte {
+	var index [8]byte
+	binary.BigEndian.PutUint64(index[:], counter)
+
+	var n [24]byte
+	copy(n[:PrefixSize], prefix[:])
+	copy(n[PrefixSize:23], index[1:])
+	if final {
+		n[23] = 1
+	}
+	return &n
+}
+
+type writer struct {
+	w         io.Writer
+	key       *[32]byte
+	prefix    *[PrefixSize]byte
+	chunkSize int
+	counter   uint64
+	buffer    []byte
+	sealed    []byte
+	closed    bool
+}
+
+// NewWriter returns a writer that encrypts to w. Close must be called to write
+// the final chunk.
+func NewWriter(w io.Writer, key *[32]byte, prefix *[PrefixSize]byte, chunkSize int) (io.WriteCloser, error) {
+	if chunkSize < 1 || chunkSize > MaxChunkSize {
+		return nil, ErrorInvalidChunkSize
+	}
+	return &writer{
+		w:         w,
+		key:       key,
+		prefix:    prefix,
+		chunkSize: chunkSize,
+		buffer:    make([]byte, 0, chunkSize),
+		sealed:    make([]byte, 0, chunkSize+Overhead),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrorClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, since the last
+		// chunk must be marked final
+		if len(w.buffer) == w.chunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buffer[len(w.buffer):w.chunkSize], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *writer) flush(final bool) error {
+	if w.counter > maxChunks {
+		return ErrorTooLong
+	}
+
+	w.sealed = secretbox.Seal(w.sealed[:0], w.buffer, nonce(w.prefix, w.counter, final), w.key)
+	if _, err := w.w.Write(w.sealed); err != nil {
+		return err
+	}
+
+	w.counter++
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+type reader struct {
+	r         *bufio.Reader
+	key       *[32]byte
+	prefix    *[PrefixSize]byte
+	chunkSize int
+	counter   uint64
+	sealed    []byte
+	opened    []byte
+	plaintext []byte
+	done      bool
+}
+
+// NewReader returns a reader that decrypts a stream written by NewWriter with
+// the same key, prefix and chunk size. Reads fail if the stream was modified
+// or truncated; no plaintext is returned from a chunk that fails to open.
+func NewReader(r io.Reader, key *[32]byte, prefix *[PrefixSize]byte, chunkSize int) (io.Reader, error) {
+	if chunkSize < 1 || chunkSize > MaxChunkSize {
+		return nil, ErrorInvalidChunkSize
+	}
+	return &reader{
+		r:         bufio.NewReader(r),
+		key:       key,
+		prefix:    prefix,
+		chunkSize: chunkSize,
+		sealed:    make([]byte, chunkSize+Overhead),
+		opened:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	if r.counter > maxChunks {
+		return ErrorTooLong
+	}
+
+	n, err := io.ReadFull(r.r, r.sealed)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		if n < Overhead {
+			return ErrorTruncated
+		}
+	default:
+		return err
+	}
+
+	// a full chunk is the last one only if nothing follows it
+	final := n < len(r.sealed)
+	if !final {
+		if _, err := r.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	opened, ok := secretbox.Open(r.opened[:0], r.sealed[:n], nonce(r.prefix, r.counter, final), r.key)
+	if !ok {
+		// a chunk that opens with the opposite marking was either followed by
+		// data it shouldn't have been, or by nothing when more was expected
+		if _, ok := secretbox.Open(nil, r.sealed[:n], nonce(r.prefix, r.counter, !final), r.key); ok {
+			if final {
+				return ErrorTruncated
+			}
+			return ErrorTrailingData
+		}
+		return ErrorCorrupt
+	}
+
+	r.plaintext = opened
+	r.counter++
+	r.done = final
+	return nil
+}
@@ -23,16 +23,16 @@ import (
 	"log"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 
+	"github.com/akb/identify/internal/database"
 	"github.com/akb/identify/internal/identity"
 	"github.com/akb/jwt-go-ed25519"
 )
 
 type Store interface {
-	New(identity.PrivateIdentity) (string, error)
+	New(identity.PrivateIdentity, identity.PublicIdentity, map[string]interface{}) (string, error)
 	Delete(string, string) error
 	Close()
 }
@@ -43,17 +43,35 @@ var (
 	AccessMaxAge    = time.Minute * 5
 )
 
+// Migrations upgrade token databases written by earlier versions of identify.
+// Databases record how many have been applied, so migrations are only ever
+// appended.
+var Migrations = []database.Migration{
+	{
+		Description: "create the buckets of the initial schema",
+		Migrate:     database.CreateBuckets(tokenBucket, accessTTLBucket),
+	},
+}
+
 type localStore struct {
-	db   *bolt.DB
+	db   database.DB
 	done chan struct{}
 }
 
+// NewLocalStore opens the token database at a location, which is a path to a
+// bolt file or, prefixed with "sqlite:", a SQLite database, and applies any
+// pending migrations to it.
 func NewLocalStore(dbPath string) (*localStore, error) {
-	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	db, err := database.Open(dbPath)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := database.Migrate(db, Migrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	store := localStore{db, make(chan struct{})}
 
 	go func() {
@@ -81,8 +99,23 @@ func (s *localStore) Close() {
 	s.db.Close()
 }
 
-func (s *localStore) New(identity identity.PrivateIdentity) (string, error) {
-	id := identity.String()
+// Database returns the database the store is kept in, for backups.
+func (s *localStore) Database() database.DB {
+	return s.db
+}
+
+// New issues an access token for the subject, signed by the issuer, with any
+// additional claims given, such as how the subject authenticated. The ids of
+// the groups the subject belongs to are included as the groups claim, and the
+// names of its roles as the roles claim.
+func (s *localStore) New(
+	issuer identity.PrivateIdentity, subject identity.PublicIdentity, extra map[string]interface{},
+) (string, error) {
+	if subject.Disabled() {
+		return "", identity.ErrorIdentityDisabled
+	}
+
+	id := subject.String()
 
 	accessUUID, err := uuid.NewRandom()
 	if err != nil {
@@ -91,14 +124,29 @@ func (s *localStore) New(identity identity.PrivateIdentity) (string, error) {
 
 	accessID := accessUUID.String()
 
-	atExpiry := time.Now().Add(AccessMaxAge).Unix()
-	at := jwt.NewWithClaims(ed25519.SigningMethod, jwt.MapClaims{
-		"exp":      atExpiry,
-		"jti":      accessID,
-		"identity": id,
-	})
+	// profile attributes marked as claims are projected into the token; the
+	// standard claims are set last so that they always take precedence
+	claims := jwt.MapClaims{}
+	for name, value := range subject.Claims() {
+		claims[name] = value
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+
+	claims["exp"] = time.Now().Add(AccessMaxAge).Unix()
+	claims["jti"] = accessID
+	claims["iss"] = issuer.String()
+	claims["identity"] = id
+	if groups := subject.Groups(); len(groups) > 0 {
+		claims["groups"] = groups
+	}
+	claims["roles"] = subject.Roles()
+
+	at := jwt.NewWithClaims(ed25519.SigningMethod, claims)
+	at.Header["kid"] = issuer.KeyID()
 
-	err = s.db.Update(func(tx *bolt.Tx) error {
+	err = s.db.Update(func(tx database.Tx) error {
 		ts := time.Now().UTC().Format(time.RFC3339Nano)
 
 		for _, g := range []struct {
@@ -120,11 +168,11 @@ func (s *localStore) New(identity identity.PrivateIdentity) (string, error) {
 		return "", err
 	}
 
-	return at.SignedString(identity.Ed25519PrivateKey())
+	return at.SignedString(issuer.Ed25519PrivateKey())
 }
 
 func (s *localStore) Delete(identity, id string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.db.Update(func(tx database.Tx) error {
 		b := tx.Bucket(tokenBucket)
 		if string(b.Get([]byte(id))) == identity {
 			return b.Delete([]byte(id))
@@ -148,10 +196,10 @@ func (s *localStore) sweep() error {
 	}
 
 	log.Println("deleting expired tokens...")
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.db.Update(func(tx database.Tx) error {
 		var count int
 		for _, b := range []struct {
-			*bolt.Bucket
+			database.Bucket
 			keys [][]byte
 		}{
 			{tx.Bucket(tokenBucket), atk},
@@ -174,7 +222,7 @@ func (s *localStore) getExpiredTokens(
 	keys := [][]byte{}
 	ttlKeys := [][]byte{}
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.db.View(func(tx database.Tx) error {
 		b := tx.Bucket(bucket)
 		if b == nil {
 			return nil
@@ -20,9 +20,11 @@ package token
 import (
 	"crypto/ed25519"
 	"fmt"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 
+	"github.com/akb/identify/internal/identity"
 	jwt_ed25519 "github.com/akb/jwt-go-ed25519"
 )
 
@@ -34,11 +36,34 @@ func (err ErrorUnknownAlgorithm) Error() string {
 	return fmt.Sprintf("unexpected signature algorithm: %s", err.algorithm)
 }
 
-func Parse(key ed25519.PublicKey, unparsed string) (*jwt.Token, error) {
+var (
+	ErrorRetiredKey = fmt.Errorf("token was signed by a retired key")
+)
+
+// Parse verifies a token signed by the issuing identity. Tokens name the
+// signing key with a "kid" header, which allows tokens signed with a key that
+// has since been rotated out to remain valid until they would have expired.
+func Parse(issuer identity.PublicIdentity, unparsed string) (*jwt.Token, error) {
 	return jwt.Parse(unparsed, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(jwt_ed25519.SigningMethodEd25519); !ok {
+		if _, ok := token.Method.(*jwt_ed25519.SigningMethodEd25519); !ok {
 			return nil, &ErrorUnknownAlgorithm{token.Header["alg"]}
 		}
-		return key, nil
+
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			// tokens issued before key ids were introduced
+			return issuer.Ed25519PublicKey(), nil
+		}
+
+		key, retired, err := issuer.Ed25519PublicKeyForID(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if !retired.IsZero() && time.Since(retired) > AccessMaxAge {
+			return nil, ErrorRetiredKey
+		}
+
+		return ed25519.PublicKey(key), nil
 	})
 }
@@ -0,0 +1,110 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package totp implements time-based one-time passwords as described by
+// RFC 6238, using the defaults most authenticator apps expect: HMAC-SHA1, six
+// digits and a thirty second step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+const (
+	Digits = 6
+	Step   = 30 * time.Second
+
+	// Skew is the number of steps before and after the current one within
+	// which codes are accepted, to allow for clock drift.
+	Skew = 1
+
+	secretLength = 20
+)
+
+var Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random shared secret.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, secretLength)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Counter returns the time step that t falls within.
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Step/time.Second)
+}
+
+// Code returns the one-time password for a time step.
+func Code(secret []byte, counter int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulus)
+}
+
+// Validate checks a code against the steps around t and returns the step it
+// matched, so that callers can refuse to accept the same code twice.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	current := Counter(t)
+	for counter := current - Skew; counter <= current+Skew; counter++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns an otpauth URI for enrolling the secret in an authenticator app.
+func URI(secret []byte, issuer, account string) string {
+	query := url.Values{}
+	query.Set("secret", Encoding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Step/time.Second)))
+
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
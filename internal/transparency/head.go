@@ -0,0 +1,140 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	headFormat  = "identify-tree-head"
+	headVersion = 1
+)
+
+var (
+	ErrorInvalidHead          = fmt.Errorf("tree head is not valid")
+	ErrorInvalidHeadSignature = fmt.Errorf("tree head signature does not match")
+)
+
+// TreeHead is the size and root of the tree at a point in time, signed by the
+// identity of the server keeping it. Signed heads commit the server to the
+// contents of the tree: any two it signs must be consistent with each other.
+//
+// The signed message is the following, with a newline after each field:
+//
+//	identify-tree-head v1
+//	<signer id>
+//	<key id>
+//	<size>
+//	<root, base64>
+//	<time signed, RFC 3339>
+type TreeHead struct {
+	Size      int
+	Root      []byte
+	Timestamp time.Time
+	Signer    string
+	KeyID     string
+	Signature []byte
+}
+
+type jsonTreeHead struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Size      int       `json:"size"`
+	Root      string    `json:"root"`
+	Timestamp time.Time `json:"timestamp"`
+	Signer    string    `json:"signer"`
+	KeyID     string    `json:"key-id"`
+	Signature string    `json:"signature"`
+}
+
+var encoding = base64.RawStdEncoding
+
+func (h TreeHead) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonTreeHead{
+		Format:    headFormat,
+		Version:   headVersion,
+		Size:      h.Size,
+		Root:      encoding.EncodeToString(h.Root),
+		Timestamp: h.Timestamp,
+		Signer:    h.Signer,
+		KeyID:     h.KeyID,
+		Signature: encoding.EncodeToString(h.Signature),
+	})
+}
+
+func (h *TreeHead) UnmarshalJSON(marshaled []byte) error {
+	var unmarshaled jsonTreeHead
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		return ErrorInvalidHead
+	}
+	if unmarshaled.Format != headFormat || unmarshaled.Version != headVersion ||
+		unmarshaled.Size < 0 {
+		return ErrorInvalidHead
+	}
+
+	root, err := encoding.DecodeString(unmarshaled.Root)
+	if err != nil {
+		return ErrorInvalidHead
+	}
+	signature, err := encoding.DecodeString(unmarshaled.Signature)
+	if err != nil {
+		return ErrorInvalidHead
+	}
+
+	*h = TreeHead{
+		Size:      unmarshaled.Size,
+		Root:      root,
+		Timestamp: unmarshaled.Timestamp,
+		Signer:    unmarshaled.Signer,
+		KeyID:     unmarshaled.KeyID,
+		Signature: signature,
+	}
+	return nil
+}
+
+func (h TreeHead) message() []byte {
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "%s v%d\n%s\n%s\n%d\n%s\n%s\n", headFormat, headVersion,
+		h.Signer, h.KeyID, h.Size, encoding.EncodeToString(h.Root),
+		h.Timestamp.UTC().Format(time.RFC3339))
+	return message.Bytes()
+}
+
+// Sign signs the head as the given identity with the Ed25519 key of a key ID,
+// stamping it with the current time.
+func (h *TreeHead) Sign(signer, keyID string, key ed25519.PrivateKey) {
+	h.Signer = signer
+	h.KeyID = keyID
+	h.Timestamp = time.Now().UTC().Truncate(time.Second)
+	h.Signature = ed25519.Sign(key, h.message())
+}
+
+// Verify checks the signature of the head with the Ed25519 public key of its
+// key ID.
+func (h TreeHead) Verify(key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, h.message(), h.Signature) {
+		return ErrorInvalidHeadSignature
+	}
+	return nil
+}
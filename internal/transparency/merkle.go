@@ -0,0 +1,241 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package transparency implements the append-only Merkle tree of RFC 6962 and
+// the proofs that let clients check the tree without trusting whoever serves
+// it: that an entry is included in a tree of a given size, and that a larger
+// tree extends a smaller one without rewriting it.
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+var (
+	ErrorInvalidProof = fmt.Errorf("proof does not match the tree")
+)
+
+// ErrorOutOfRange is returned when an index or tree size is outside the tree.
+type ErrorOutOfRange struct {
+	Index int
+	Size  int
+}
+
+func (err ErrorOutOfRange) Error() string {
+	return fmt.Sprintf("%d is out of range for a tree of size %d", err.Index, err.Size)
+}
+
+// LeafHash returns the hash of an entry as a leaf of the tree. Leaves and
+// interior nodes are hashed with different prefixes so that neither can be
+// passed off as the other.
+func LeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// RootHash returns the root of the tree of the given leaf hashes. The root of
+// an empty tree is the hash of nothing.
+func RootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return nodeHash(RootHash(leaves[:k]), RootHash(leaves[k:]))
+}
+
+// split returns the largest power of two smaller than n, where the tree of n
+// leaves divides into its left and right subtrees.
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// InclusionProof returns the hashes needed to rebuild the root of the tree of
+// the given leaf hashes from the leaf at an index.
+func InclusionProof(leaves [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, ErrorOutOfRange{index, len(leaves)}
+	}
+	return path(leaves, index), nil
+}
+
+func path(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if index < k {
+		return append(path(leaves[:k], index), RootHash(leaves[k:]))
+	}
+	return append(path(leaves[k:], index-k), RootHash(leaves[:k]))
+}
+
+// ConsistencyProof returns the hashes needed to show that the tree of the
+// given leaf hashes extends the tree of its first leaves.
+func ConsistencyProof(leaves [][]byte, first int) ([][]byte, error) {
+	if first < 0 || first > len(leaves) {
+		return nil, ErrorOutOfRange{first, len(leaves)}
+	}
+	if first == 0 || first == len(leaves) {
+		return nil, nil
+	}
+	return subproof(leaves, first, true), nil
+}
+
+func subproof(leaves [][]byte, first int, complete bool) [][]byte {
+	if first == len(leaves) {
+		if complete {
+			return nil
+		}
+		return [][]byte{RootHash(leaves)}
+	}
+	k := split(len(leaves))
+	if first <= k {
+		return append(subproof(leaves[:k], first, complete), RootHash(leaves[k:]))
+	}
+	return append(subproof(leaves[k:], first-k, false), RootHash(leaves[:k]))
+}
+
+// VerifyInclusion checks that a leaf hash is at an index of the tree of a size
+// with the given root.
+func VerifyInclusion(leaf []byte, index, size int, proof [][]byte, root []byte) error {
+	if index < 0 || index >= size {
+		return ErrorOutOfRange{index, size}
+	}
+
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrorInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrorInvalidProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of the second size and root extends
+// the tree of the first size and root.
+func VerifyConsistency(first, second int, firstRoot, secondRoot []byte, proof [][]byte) error {
+	if first < 0 || first > second {
+		return ErrorOutOfRange{first, second}
+	}
+
+	if first == 0 || first == second {
+		if len(proof) != 0 {
+			return ErrorInvalidProof
+		}
+		if first == second && !bytes.Equal(firstRoot, secondRoot) {
+			return ErrorInvalidProof
+		}
+		return nil
+	}
+
+	// the root of a first tree whose size is a power of two is a node of the
+	// second, and is left out of the proof
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return ErrorInvalidProof
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrorInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrorInvalidProof
+	}
+	return nil
+}
+
+// EncodeProof encodes the hashes of a proof for JSON.
+func EncodeProof(proof [][]byte) []string {
+	encoded := []string{}
+	for _, p := range proof {
+		encoded = append(encoded, encoding.EncodeToString(p))
+	}
+	return encoded
+}
+
+// DecodeProof decodes the hashes of a proof encoded by EncodeProof.
+func DecodeProof(encoded []string) ([][]byte, error) {
+	var proof [][]byte
+	for _, e := range encoded {
+		p, err := encoding.DecodeString(e)
+		if err != nil || len(p) != 32 {
+			return nil, ErrorInvalidProof
+		}
+		proof = append(proof, p)
+	}
+	return proof, nil
+}
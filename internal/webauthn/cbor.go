@@ -0,0 +1,149 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+var errorInvalidCBOR = fmt.Errorf("invalid cbor")
+
+// maxCBORDepth bounds the nesting of decoded values.
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first CBOR data item in data and returns it along with
+// the remaining bytes. Only the subset of CBOR used by WebAuthn is supported:
+// integers, byte and text strings, arrays, maps, booleans and null. Maps are
+// decoded as map[interface{}]interface{} with int64 or string keys.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if len(data) == 0 || depth > maxCBORDepth {
+		return nil, nil, errorInvalidCBOR
+	}
+
+	major := data[0] >> 5
+	argument, rest, err := decodeCBORArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, nil, errorInvalidCBOR
+		}
+		return int64(argument), rest, nil
+
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, errorInvalidCBOR
+		}
+		return -1 - int64(argument), rest, nil
+
+	case 2, 3:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errorInvalidCBOR
+		}
+		value := rest[:argument]
+		if major == 3 {
+			return string(value), rest[argument:], nil
+		}
+		return append([]byte{}, value...), rest[argument:], nil
+
+	case 4:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errorInvalidCBOR
+		}
+		array := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			array = append(array, item)
+		}
+		return array, rest, nil
+
+	case 5:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errorInvalidCBOR
+		}
+		m := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errorInvalidCBOR
+			}
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, rest, nil
+
+	case 6:
+		// tags carry no meaning for WebAuthn structures
+		return decodeCBORItem(rest, depth+1)
+
+	case 7:
+		switch argument {
+		case 20:
+			return false, rest, nil
+		case 21:
+			return true, rest, nil
+		case 22:
+			return nil, rest, nil
+		}
+	}
+
+	return nil, nil, errorInvalidCBOR
+}
+
+// decodeCBORArgument decodes the argument that follows the major type of a
+// data item. Indefinite lengths aren't supported.
+func decodeCBORArgument(data []byte) (uint64, []byte, error) {
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, errorInvalidCBOR
+}
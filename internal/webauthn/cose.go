@@ -0,0 +1,115 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+)
+
+// COSE algorithms and key parameters from RFC 8152.
+const (
+	AlgorithmES256 = -7
+	AlgorithmEdDSA = -8
+
+	coseKeyType      = 1
+	coseAlgorithm    = 3
+	coseCurve        = -1
+	coseX            = -2
+	coseY            = -3
+	coseKeyTypeOKP   = 1
+	coseKeyTypeEC2   = 2
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// Algorithms are the signature algorithms accepted for credentials, in order
+// of preference.
+var Algorithms = []int64{AlgorithmES256, AlgorithmEdDSA}
+
+// parsePublicKey decodes a COSE encoded public key.
+func parsePublicKey(encoded []byte) (crypto.PublicKey, int64, error) {
+	decoded, rest, err := decodeCBOR(encoded)
+	if err != nil || len(rest) > 0 {
+		return nil, 0, ErrorUnsupportedKey
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrorUnsupportedKey
+	}
+
+	keyType, _ := key[int64(coseKeyType)].(int64)
+	algorithm, _ := key[int64(coseAlgorithm)].(int64)
+	curve, _ := key[int64(coseCurve)].(int64)
+	x, _ := key[int64(coseX)].([]byte)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgorithmES256 && curve == coseCurveP256:
+		y, _ := key[int64(coseY)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrorUnsupportedKey
+		}
+		public := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, 0, ErrorUnsupportedKey
+		}
+		return public, algorithm, nil
+
+	case keyType == coseKeyTypeOKP && algorithm == AlgorithmEdDSA && curve == coseCurveEd25519:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrorUnsupportedKey
+		}
+		return ed25519.PublicKey(x), algorithm, nil
+	}
+
+	return nil, 0, ErrorUnsupportedKey
+}
+
+func verifySignature(encoded, signed, signature []byte) error {
+	public, _, err := parsePublicKey(encoded)
+	if err != nil {
+		return err
+	}
+
+	switch key := public.(type) {
+	case *ecdsa.PublicKey:
+		var parsed struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(signature, &parsed)
+		if err != nil || len(rest) > 0 {
+			return ErrorInvalidSignature
+		}
+		digest := sha256.Sum256(signed)
+		if ecdsa.Verify(key, digest[:], parsed.R, parsed.S) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, signed, signature) {
+			return nil
+		}
+	}
+	return ErrorInvalidSignature
+}
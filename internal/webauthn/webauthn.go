@@ -0,0 +1,270 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package webauthn verifies the registration and assertion ceremonies of Web
+// Authentication, which let people authenticate with a passkey or security
+// key. Only the "none" attestation format is accepted: authenticators are
+// trusted to hold a key, not to be a particular make or model.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ChallengeSize is the number of random bytes in a challenge.
+const ChallengeSize = 32
+
+// Flags of authenticator data.
+const (
+	FlagUserPresent   = 0x01
+	FlagUserVerified  = 0x04
+	FlagAttestedData  = 0x40
+	FlagExtensionData = 0x80
+)
+
+// authenticatorDataMin is the length of authenticator data without attested
+// credential data or extensions.
+const authenticatorDataMin = 37
+
+var (
+	ErrorInvalidClientData        = fmt.Errorf("client data is invalid")
+	ErrorInvalidAuthenticatorData = fmt.Errorf("authenticator data is invalid")
+	ErrorUnsupportedAttestation   = fmt.Errorf("attestation format is not supported")
+	ErrorUnsupportedKey           = fmt.Errorf("credential public key is not supported")
+	ErrorUserNotPresent           = fmt.Errorf("user presence was not asserted")
+	ErrorInvalidSignature         = fmt.Errorf("assertion signature is invalid")
+	ErrorCloned                   = fmt.Errorf("signature counter did not increase; the authenticator may be cloned")
+)
+
+// Encoding is used for challenges and credential ids exchanged with browsers.
+var Encoding = base64.RawURLEncoding
+
+// Credential is a public key registered by an authenticator.
+type Credential struct {
+	ID        []byte    `json:"id"`
+	PublicKey []byte    `json:"public-key"`
+	SignCount uint32    `json:"sign-count"`
+	Created   time.Time `json:"created"`
+}
+
+// RelyingParty is the site credentials are scoped to. ID is the domain of the
+// site and Origin the scheme, host and port pages are served from.
+type RelyingParty struct {
+	ID     string
+	Origin string
+}
+
+// NewChallenge returns random bytes for an authenticator to sign.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// AuthenticatorData is the parsed authenticator data of a ceremony.
+// CredentialID and PublicKey are only set during registration.
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func (d AuthenticatorData) UserVerified() bool {
+	return d.Flags&FlagUserVerified != 0
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create
+// and returns the credential it registered.
+func (rp RelyingParty) VerifyRegistration(
+	challenge, clientDataJSON, attestationObject []byte,
+) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) > 0 {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return nil, ErrorUnsupportedAttestation
+	}
+
+	raw, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+
+	data, err := rp.verifyAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if data.Flags&FlagAttestedData == 0 {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+
+	if _, _, err := parsePublicKey(data.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        data.CredentialID,
+		PublicKey: data.PublicKey,
+		SignCount: data.SignCount,
+		Created:   time.Now(),
+	}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get against a
+// registered credential. The sign count of the returned authenticator data
+// should be stored in place of the credential's.
+func (rp RelyingParty) VerifyAssertion(
+	c Credential, challenge, clientDataJSON, authenticatorData, signature []byte,
+) (*AuthenticatorData, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	data, err := rp.verifyAuthenticatorData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	if err := verifySignature(c.PublicKey, signed, signature); err != nil {
+		return nil, err
+	}
+
+	// authenticators that don't keep a counter always report zero
+	if (data.SignCount != 0 || c.SignCount != 0) && data.SignCount <= c.SignCount {
+		return nil, ErrorCloned
+	}
+
+	return data, nil
+}
+
+// Challenge returns the challenge in client data, so that the ceremony it
+// belongs to can be found before the response is verified.
+func Challenge(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, ErrorInvalidClientData
+	}
+
+	challenge, err := Encoding.DecodeString(data.Challenge)
+	if err != nil {
+		return nil, ErrorInvalidClientData
+	}
+	return challenge, nil
+}
+
+func (rp RelyingParty) verifyClientData(encoded []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return ErrorInvalidClientData
+	}
+
+	if data.Type != ceremony || data.Origin != rp.Origin {
+		return ErrorInvalidClientData
+	}
+
+	signed, err := Encoding.DecodeString(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(signed, challenge) != 1 {
+		return ErrorInvalidClientData
+	}
+	return nil
+}
+
+func (rp RelyingParty) verifyAuthenticatorData(raw []byte) (*AuthenticatorData, error) {
+	data, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.RPIDHash, rpIDHash[:]) {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+	if data.Flags&FlagUserPresent == 0 {
+		return nil, ErrorUserNotPresent
+	}
+	return data, nil
+}
+
+func parseAuthenticatorData(raw []byte) (*AuthenticatorData, error) {
+	if len(raw) < authenticatorDataMin {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+
+	data := AuthenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if data.Flags&FlagAttestedData == 0 {
+		return &data, nil
+	}
+
+	// attested credential data: a 16 byte aaguid, the length of the credential
+	// id, the credential id and a COSE encoded public key
+	rest := raw[authenticatorDataMin:]
+	if len(rest) < 18 {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+	length := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if length == 0 || len(rest) < length {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+	data.CredentialID = append([]byte{}, rest[:length]...)
+	rest = rest[length:]
+
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+	data.PublicKey = append([]byte{}, rest[:len(rest)-len(extensions)]...)
+
+	if data.Flags&FlagExtensionData == 0 && len(extensions) > 0 {
+		return nil, ErrorInvalidAuthenticatorData
+	}
+	return &data, nil
+}
@@ -0,0 +1,58 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package identify
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/akb/go-cli"
+)
+
+// ReadNewPassphrase prompts for a new passphrase twice and returns it if both
+// entries match.
+func ReadNewPassphrase(s cli.System) (string, error) {
+	return ReadConfirmedPassphrase(s, "New passphrase: ", "Confirm passphrase: ")
+}
+
+// ReadConfirmedPassphrase prompts for a passphrase and its confirmation and
+// returns it if both entries match.
+func ReadConfirmedPassphrase(s cli.System, prompt, confirm string) (string, error) {
+	s.Print(prompt)
+	passphrase, err := s.ReadPassword()
+	s.Println()
+	if err != nil {
+		return "", err
+	}
+
+	s.Print(confirm)
+	confirmation, err := s.ReadPassword()
+	s.Println()
+	if err != nil {
+		return "", err
+	}
+
+	if len(passphrase) == 0 {
+		return "", errors.Wrap(ErrorValidation, "passphrase must not be empty")
+	}
+
+	if passphrase != confirmation {
+		return "", errors.Wrap(ErrorValidation, "passphrases do not match")
+	}
+
+	return passphrase, nil
+}
@@ -61,32 +61,57 @@ func (c requiresCLIUserAuthCommand) Command(ctx context.Context, args []string,
 		return err
 	}
 
-	public, err := store.GetIdentity(*c.id)
+	private, err := Authenticate(store, *c.id, s)
 	store.Close()
 	if err != nil {
 		return err
 	}
 
+	ctx = context.WithValue(ctx, identityContextKey, private)
+
+	if b, ok := (interface{})(c.wrapped).(cli.Action); ok {
+		return b.Command(ctx, args, s)
+	} else {
+		c.Help()
+		return nil
+	}
+}
+
+// Authenticate prompts for a passphrase and, if the identity has enrolled a
+// second factor, a one-time password, and decrypts the identity. The store must
+// remain open while authenticating so that upgrades can be persisted.
+func Authenticate(store identity.Store, id string, s cli.System) (identity.PrivateIdentity, error) {
+	public, err := store.GetIdentity(id)
+	if err != nil {
+		return nil, err
+	}
+
 	s.Print("Passphrase: ")
 	passphrase, err := s.ReadPassword()
 	s.Println()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	private, err := public.Authenticate(passphrase)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	ctx = context.WithValue(ctx, identityContextKey, private)
+	if private.TOTPEnrolled() {
+		s.Print("Authentication code: ")
+		code, err := s.ReadPassword()
+		s.Println()
+		if err != nil {
+			return nil, err
+		}
 
-	if b, ok := (interface{})(c.wrapped).(cli.Action); ok {
-		return b.Command(ctx, args, s)
-	} else {
-		c.Help()
-		return nil
+		if err := store.VerifyTOTP(private, code); err != nil {
+			return nil, err
+		}
 	}
+
+	return private, nil
 }
 
 func (c requiresCLIUserAuthCommand) Subcommands() cli.CLI {
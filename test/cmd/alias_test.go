@@ -0,0 +1,95 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestAliases(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := gofakeit.Username()
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"alias", "add", alias}); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := ListAliases(t, ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(aliases, ",") != strings.Join([]string{ti.Alias, alias}, ",") {
+		t.Fatalf("unexpected aliases: %v", aliases)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"alias", "remove", ti.Alias}); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err = ListAliases(t, alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 || aliases[0] != alias {
+		t.Fatalf("unexpected aliases: %v", aliases)
+	}
+}
+
+func TestAliasConflict(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impostor, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := RunAuthenticatedCommand(t, impostor, []string{"alias", "add", ti.Alias})
+	if err == nil {
+		t.Fatal("expected an alias that is already taken to be rejected")
+	}
+	t.Log(output)
+
+	aliases, err := ListAliases(t, ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 || aliases[0] != ti.Alias {
+		t.Fatalf("unexpected aliases: %v", aliases)
+	}
+}
+
+func ListAliases(t *testing.T, id string) ([]string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	output, err := RunCommand(t, environment, []string{"alias", "list", id})
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
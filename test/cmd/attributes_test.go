@@ -0,0 +1,115 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestAttributes(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := gofakeit.Name()
+	email := gofakeit.Email()
+	phone := gofakeit.Phone()
+
+	for _, arguments := range [][]string{
+		{"set", "attribute", "name", name},
+		{"set", "attribute", "-claim", "email", email},
+		{"set", "attribute", "-sealed", "phone", phone},
+	} {
+		if _, err := RunAuthenticatedCommand(t, ti, arguments); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record, err := ReadIdentityRecord(ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marshaled, err := json.Marshal(record["attributes"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(marshaled), email) || strings.Contains(string(marshaled), phone) {
+		t.Fatalf("expected only unsealed attributes to be stored in plaintext: %s", marshaled)
+	}
+
+	output, err := RunAuthenticatedCommand(t, ti, []string{"get", "attributes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"name=" + name,
+		"email=" + email + " (claim)",
+		"phone=" + phone + " (sealed)",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected attributes to include '%s', got:\n%s", expected, output)
+		}
+	}
+
+	if err := RotateKeys(t, ti); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err = RunAuthenticatedCommand(t, ti, []string{"get", "attribute", "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(output, phone) {
+		t.Fatalf("expected sealed attribute to open after rotating keys, got: %s", output)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"delete", "attribute", "name"}); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err = RunAuthenticatedCommand(t, ti, []string{"get", "attributes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output, "name=") {
+		t.Fatalf("expected attribute to be deleted, got:\n%s", output)
+	}
+}
+
+func TestInvalidAttributes(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, arguments := range [][]string{
+		{"set", "attribute", "email", "not an email address"},
+		{"set", "attribute", "-claim", "exp", "0"},
+		{"set", "attribute", "-claim", "-sealed", "secret", "value"},
+	} {
+		if _, err := RunAuthenticatedCommand(t, ti, arguments); err == nil {
+			t.Fatalf("expected '%s' to be rejected", strings.Join(arguments, " "))
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/go-expect"
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	archive := filepath.Join(dir, "identify.backup")
+	environment := map[string]string{
+		"IDENTIFY_DB_PATH":           dbPath,
+		"IDENTIFY_TOKEN_DB_PATH":     tokenDBPath,
+		"IDENTIFY_BACKUP_PASSPHRASE": passphrase,
+	}
+
+	output, err := RunCommand(t, environment, []string{"backup", archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "Backed up the identity database") {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	contents, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contents, []byte(ti.Alias)) || bytes.Contains(contents, []byte(ti.ID)) {
+		t.Fatal("expected the backup to be encrypted")
+	}
+
+	if _, err := RunCommand(t, map[string]string{
+		"IDENTIFY_BACKUP_PASSPHRASE": passphrase + "x",
+	}, []string{"restore", "-verify", archive}); err == nil {
+		t.Fatal("expected verifying a backup with the wrong passphrase to fail")
+	}
+
+	output, err = RunCommand(t, environment, []string{"restore", "-verify", archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "Verified the identity database") {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	// a corrupted archive fails to verify
+	corrupted := filepath.Join(dir, "corrupted.backup")
+	tampered := append([]byte{}, contents...)
+	tampered[len(tampered)-10] ^= 1
+	if err := ioutil.WriteFile(corrupted, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunCommand(t, environment, []string{"restore", "-verify", corrupted}); err == nil {
+		t.Fatal("expected a corrupted backup to fail verification")
+	}
+
+	restored := map[string]string{
+		"IDENTIFY_DB_PATH":           "sqlite:" + filepath.Join(dir, "identity.db"),
+		"IDENTIFY_TOKEN_DB_PATH":     filepath.Join(dir, "token.db"),
+		"IDENTIFY_BACKUP_PASSPHRASE": passphrase,
+	}
+	if _, err := RunCommand(t, restored, []string{"restore", archive}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunCommand(t, restored, []string{"restore", archive}); err == nil {
+		t.Fatal("expected restoring over existing databases to fail")
+	}
+
+	output, err = RunCommand(t, restored, []string{"alias", "list", ti.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(output) != ti.Alias {
+		t.Fatalf("expected the identity to have been restored: %s", output)
+	}
+}
+
+func TestListenBackups(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateCertificate(t, ti); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(certPath)
+		os.Remove(certKeyPath)
+	})
+
+	port, err := GetFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	environment := map[string]string{
+		"IDENTIFY_DB_PATH":              dbPath,
+		"IDENTIFY_TOKEN_DB_PATH":        tokenDBPath,
+		"IDENTIFY_CERTIFICATE_PATH":     certPath,
+		"IDENTIFY_CERTIFICATE_KEY_PATH": certKeyPath,
+		"IDENTIFY_HTTP_ADDRESS":         fmt.Sprintf("localhost:%d", port),
+		"IDENTIFY_BACKUP_PASSPHRASE":    passphrase,
+	}
+
+	arguments := []string{
+		"listen", "-backup-interval=50ms", "-backup-keep=2",
+		fmt.Sprintf("-backup-dir=%s", dir), fmt.Sprintf("-id=%s", ti.ID),
+	}
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			if _, err = c.Expectf("Passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(ti.Passphrase); err != nil {
+				return
+			}
+
+			done := In(500*time.Millisecond, func() {
+				c.Tty().Close()
+				cancel()
+			})
+			_, err = c.ExpectEOF()
+			<-done
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != 0 {
+		t.Fatal(result.String())
+	}
+
+	archives, err := filepath.Glob(filepath.Join(dir, "identify-*.backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 2 {
+		t.Fatalf("expected the two most recent backups to be kept, got %v", archives)
+	}
+
+	output, err := RunCommand(t, environment, []string{"restore", "-verify", archives[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "Verified the identity database") {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
@@ -0,0 +1,224 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestExportImportIdentity(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GenerateSecret(t, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	bundlePath := filepath.Join(dir, "identity.bundle")
+	bundlePassphrase := gofakeit.Password(true, true, true, true, true, 33)
+
+	_, err = RunAuthenticatedCommand(t, ti,
+		[]string{"export", "identity", "-secrets", bundlePath},
+		Prompt{"Bundle passphrase: ", bundlePassphrase},
+		Prompt{"Confirm bundle passphrase: ", bundlePassphrase},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(contents), ti.Alias) {
+		t.Fatal("expected bundle contents to be encrypted")
+	}
+
+	otherDBPath := filepath.Join(dir, "identity.db")
+
+	if _, err := ImportIdentity(t, otherDBPath, bundlePath, "incorrect"); err == nil {
+		t.Fatal("expected import with an incorrect bundle passphrase to fail")
+	}
+
+	output, err := ImportIdentity(t, otherDBPath, bundlePath, bundlePassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, ti.ID) || !strings.Contains(output, ti.Alias) {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	environment := map[string]string{"IDENTIFY_DB_PATH": otherDBPath}
+	output, err = RunPromptedCommand(t, environment,
+		[]string{"get", "secret", ts.Key, fmt.Sprintf("-id=%s", ti.Alias)},
+		Prompt{"Passphrase: ", ti.Passphrase},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(output, ts.Value) {
+		t.Fatalf("expected imported secret '%s', got: %s", ts.Value, output)
+	}
+
+	if _, err := ImportIdentity(t, otherDBPath, bundlePath, bundlePassphrase); err == nil {
+		t.Fatal("expected importing an existing identity to fail")
+	}
+
+	other, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBundlePath := filepath.Join(dir, "other.bundle")
+	_, err = RunAuthenticatedCommand(t, other,
+		[]string{"export", "identity", otherBundlePath},
+		Prompt{"Bundle passphrase: ", bundlePassphrase},
+		Prompt{"Confirm bundle passphrase: ", bundlePassphrase},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportIdentity(t, otherDBPath, otherBundlePath, bundlePassphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ImportIdentity(t, otherDBPath, bundlePath, bundlePassphrase, "-replace"); err == nil {
+		t.Fatal("expected replacing an identity without authenticating to fail")
+	}
+
+	if _, err := ReplaceIdentity(t, otherDBPath, bundlePath, bundlePassphrase, other); err == nil {
+		t.Fatal("expected replacing another identity without identities:manage to fail")
+	}
+
+	if _, err := ReplaceIdentity(t, otherDBPath, bundlePath, bundlePassphrase, ti); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportSecretCollision(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := GenerateSecret(t, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacer, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	bundlePassphrase := gofakeit.Password(true, true, true, true, true, 33)
+	otherDBPath := filepath.Join(dir, "identity.db")
+	bundles := map[string]string{}
+	for _, exported := range []*TestIdentity{ti, third, replacer} {
+		bundles[exported.ID] = filepath.Join(dir, exported.ID+".bundle")
+		_, err = RunAuthenticatedCommand(t, exported,
+			[]string{"export", "identity", "-secrets", bundles[exported.ID]},
+			Prompt{"Bundle passphrase: ", bundlePassphrase},
+			Prompt{"Confirm bundle passphrase: ", bundlePassphrase},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, imported := range []*TestIdentity{third, replacer} {
+		if _, err := ImportIdentity(t, otherDBPath, bundles[imported.ID], bundlePassphrase); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// in the other database, a third identity holds a secret with the same key
+	environment := map[string]string{"IDENTIFY_DB_PATH": otherDBPath}
+	value := gofakeit.Word() + "-third"
+	_, err = RunPromptedCommand(t, environment,
+		[]string{"new", "secret", ts.Key, value, fmt.Sprintf("-id=%s", third.ID)},
+		Prompt{"Passphrase: ", third.Passphrase},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither importing nor replacing may overwrite a secret that isn't shared
+	// with the imported identity or the replacer
+	if _, err := ImportIdentity(t, otherDBPath, bundles[ti.ID], bundlePassphrase); err == nil {
+		t.Fatal("expected importing a colliding secret to fail")
+	}
+	if _, err := ReplaceIdentity(t, otherDBPath, bundles[ti.ID], bundlePassphrase, replacer); err == nil {
+		t.Fatal("expected replacing a secret of a third identity to fail")
+	}
+
+	output, err := RunPromptedCommand(t, environment,
+		[]string{"get", "secret", ts.Key, fmt.Sprintf("-id=%s", third.ID)},
+		Prompt{"Passphrase: ", third.Passphrase},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(output, value) {
+		t.Fatalf("expected the third identity's secret '%s' to be kept, got: %s", value, output)
+	}
+}
+
+func ImportIdentity(
+	t *testing.T, path, bundlePath, passphrase string, flags ...string,
+) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": path}
+	arguments := append([]string{"import", "identity", bundlePath}, flags...)
+	return RunPromptedCommand(t, environment, arguments,
+		Prompt{"Bundle passphrase: ", passphrase})
+}
+
+// ReplaceIdentity imports a bundle over an existing identity, authenticating as
+// ti in the database at path.
+func ReplaceIdentity(
+	t *testing.T, path, bundlePath, passphrase string, ti *TestIdentity,
+) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": path}
+	arguments := []string{"import", "identity", "-replace", "-id=" + ti.ID, bundlePath}
+	return RunPromptedCommand(t, environment, arguments,
+		Prompt{"Bundle passphrase: ", passphrase},
+		Prompt{"Passphrase: ", ti.Passphrase})
+}
@@ -0,0 +1,232 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+
+	"github.com/akb/identify/internal/database"
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/token"
+)
+
+func TestMigrateToSQLite(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	location := "sqlite:" + filepath.Join(dir, "identity.db")
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	if _, err := RunCommand(t, environment, []string{"migrate", location}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunCommand(t, environment, []string{"migrate", location}); err == nil {
+		t.Fatal("expected migrating into a database that isn't empty to fail")
+	}
+
+	// the rest of the test runs against the SQLite database
+	defer func(bolt string) { dbPath = bolt }(dbPath)
+	dbPath = location
+
+	// the store is held open, as `identify listen` would, which a bolt file
+	// doesn't allow
+	store, err := identity.NewLocalStore(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	roles, err := ListRoles(t, administrator.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(roles, "admin") {
+		t.Fatalf("expected the administrator to have been migrated: %s", roles)
+	}
+
+	aliases, err := ListAliases(t, ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0] != ti.Alias {
+		t.Fatalf("expected the aliases of %s to have been migrated: %v", ti.ID, aliases)
+	}
+
+	name := gofakeit.Lexify("????????")
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"set", "attribute", "name", name}); err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := store.GetIdentity(ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes := public.Attributes(); len(attributes) != 1 || attributes[0].Value != name {
+		t.Fatalf("expected changes made by another process to be visible, got %v", attributes)
+	}
+
+	other, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, err := ListIdentities(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(identities, "\n"), other.ID) {
+		t.Fatalf("expected %s to be listed: %v", other.ID, identities)
+	}
+
+	tokens, err := token.NewLocalStore("sqlite:" + filepath.Join(dir, "token.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, private, err := store.NewIdentity(ti.Passphrase, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.New(private, private, nil); err != nil {
+		t.Fatal(err)
+	}
+	tokens.Close()
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	location := filepath.Join(dir, "identity.db")
+	environment := map[string]string{
+		"IDENTIFY_DB_PATH":       location,
+		"IDENTIFY_TOKEN_DB_PATH": filepath.Join(dir, "token.db"),
+	}
+
+	// a database written before schemas were versioned
+	if err := withDatabase(location, func(tx database.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("identity"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("legacy"), []byte("{}"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := RunCommand(t, environment, []string{"migrate", "-dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "The identity database is at schema version 0") ||
+		!strings.Contains(output, "1 create the buckets of the initial schema") {
+		t.Fatalf("expected the pending migrations to be listed: %s", output)
+	}
+	if version := schemaVersion(t, location); version != 0 {
+		t.Fatalf("expected a dry run to leave the database at version 0, got %d", version)
+	}
+
+	output, err = RunCommand(t, environment, []string{"migrate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("Migrated the identity database to schema version %d", len(identity.Migrations))
+	if !strings.Contains(output, expected) {
+		t.Fatalf("expected the database to be migrated: %s", output)
+	}
+	if version := schemaVersion(t, location); version != len(identity.Migrations) {
+		t.Fatalf("expected the database to be at version %d, got %d", len(identity.Migrations), version)
+	}
+
+	backups, err := filepath.Glob(location + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || schemaVersion(t, backups[0]) != 0 {
+		t.Fatalf("expected a backup of the database before it was migrated: %v", backups)
+	}
+
+	output, err = RunCommand(t, environment, []string{"migrate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "The identity database is up to date") {
+		t.Fatalf("expected no migrations to be pending: %s", output)
+	}
+
+	// a database written by a newer version of identify
+	if err := withDatabase(location, func(tx database.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("meta"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("schema-version"), []byte("999"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := identity.NewLocalStore(location); err == nil {
+		t.Fatal("expected a database with a newer schema to be refused")
+	} else if _, ok := err.(database.ErrorSchemaTooNew); !ok {
+		t.Fatalf("expected a database with a newer schema to be refused, got %s", err)
+	}
+	if _, err := RunCommand(t, environment, []string{"list", "identities"}); err == nil {
+		t.Fatal("expected commands to refuse a database with a newer schema")
+	}
+}
+
+func withDatabase(location string, fn func(database.Tx) error) error {
+	db, err := database.Open(location)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+func schemaVersion(t *testing.T, location string) int {
+	db, err := database.Open(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var version int
+	if err := db.View(func(tx database.Tx) error {
+		version, err = database.SchemaVersion(tx)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
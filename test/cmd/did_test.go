@@ -0,0 +1,107 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/akb/identify/internal/did"
+)
+
+func TestDID(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+
+	identifier, err := RunCommand(t, environment, []string{"get", "did", ti.Alias})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(identifier, "did:key:z6Mk") {
+		t.Fatalf("expected an Ed25519 did:key identifier, got %s", identifier)
+	}
+
+	// identities can be looked up by their DIDs
+	aliases, err := ListAliases(t, identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0] != ti.Alias {
+		t.Fatalf("expected the DID to resolve to the identity, got aliases %v", aliases)
+	}
+
+	output, err := RunCommand(t, environment, []string{"get", "did", "-document", identifier})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document did.Document
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.ID != identifier || len(document.VerificationMethod) != 1 ||
+		len(document.Authentication) != 1 || len(document.KeyAgreement) != 0 {
+		t.Fatalf("unexpected DID document: %s", output)
+	}
+
+	// the seal key has a DID of its own, whose document only agrees on keys
+	agreement, err := RunCommand(t, environment, []string{"get", "did", "-seal", ti.Alias})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(agreement, "did:key:z6LS") {
+		t.Fatalf("expected an X25519 did:key identifier, got %s", agreement)
+	}
+	if _, err := ListAliases(t, agreement); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err = RunCommand(t, environment, []string{"get", "did", "-seal", "-document", ti.Alias})
+	if err != nil {
+		t.Fatal(err)
+	}
+	document = did.Document{}
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.ID != agreement || len(document.VerificationMethod) != 1 ||
+		len(document.Authentication) != 0 || len(document.KeyAgreement) != 1 {
+		t.Fatalf("unexpected DID document: %s", output)
+	}
+
+	if err := RotateKeys(t, ti); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ListAliases(t, identifier); err == nil {
+		t.Fatal("expected the DID of a rotated key not to resolve")
+	}
+
+	rotated, err := RunCommand(t, environment, []string{"get", "did", ti.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == identifier {
+		t.Fatal("expected the DID to change when the keys are rotated")
+	}
+}
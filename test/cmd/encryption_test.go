@@ -0,0 +1,313 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/stream"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	sender, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carol, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// several chunks, the last of them partial
+	plaintext := make([]byte, 200*1024+17)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintextPath := filepath.Join(dir, "plaintext")
+	if err := ioutil.WriteFile(plaintextPath, plaintext, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedPath := filepath.Join(dir, "encrypted")
+	_, err = RunAuthenticatedCommand(t, sender, []string{
+		"encrypt",
+		fmt.Sprintf("-recipients=%s,%s", bob.Alias, carol.ID),
+		fmt.Sprintf("-output=%s", encryptedPath),
+		plaintextPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := ioutil.ReadFile(encryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, plaintext[:64]) {
+		t.Fatal("expected file to be encrypted")
+	}
+
+	for _, recipient := range []*TestIdentity{bob, carol} {
+		decrypted, err := Decrypt(t, recipient, encryptedPath, filepath.Join(dir, recipient.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("decrypted file for %s does not match", recipient.ID)
+		}
+	}
+
+	if _, err := Decrypt(t, sender, encryptedPath, filepath.Join(dir, sender.ID)); err == nil {
+		t.Fatal("expected decryption by an identity that isn't a recipient to fail")
+	}
+
+	header := bytes.IndexByte(encrypted, '\n') + 1
+	tampered := map[string][]byte{
+		// cut off at a chunk boundary, after the first chunk
+		"truncated": encrypted[:header+64*1024+16],
+		"shortened": encrypted[:len(encrypted)-1],
+		"extended":  append(append([]byte{}, encrypted...), 0),
+		"reordered": append(append(append(append([]byte{}, encrypted[:header]...),
+			encrypted[header+64*1024+16:header+2*(64*1024+16)]...),
+			encrypted[header:header+64*1024+16]...),
+			encrypted[header+2*(64*1024+16):]...),
+		"header": bytes.Replace(encrypted, []byte(`"chunk-size":65536`),
+			[]byte(`"chunk-size":65536 `), 1),
+	}
+
+	for name, contents := range tampered {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		output := filepath.Join(dir, name+".decrypted")
+		if _, err := Decrypt(t, bob, path, output); err == nil {
+			t.Fatalf("expected decryption of %s file to fail", name)
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Fatalf("expected output of %s file to be removed", name)
+		}
+	}
+
+	// retired seal keys are kept, so files encrypted before a rotation can
+	// still be decrypted
+	for n := 0; n < 2; n++ {
+		if err := RotateKeys(t, bob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decrypted, err := Decrypt(t, bob, encryptedPath, filepath.Join(dir, "rotated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted file does not match after rotating keys")
+	}
+}
+
+func TestEncryptForgedByRecipient(t *testing.T) {
+	alice, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	plaintextPath := filepath.Join(dir, "plaintext")
+	if err := ioutil.WriteFile(plaintextPath, []byte("pay bob 10"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encryptedPath := filepath.Join(dir, "encrypted")
+	_, err = RunAuthenticatedCommand(t, alice, []string{
+		"encrypt",
+		fmt.Sprintf("-recipients=%s,%s", bob.ID, carol.ID),
+		fmt.Sprintf("-output=%s", encryptedPath),
+		plaintextPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := ioutil.ReadFile(encryptedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bob unwraps the file key with his seal key, as any recipient can, and
+	// encrypts other content under alice's header, ending with her signature
+	forged := forgeEncryptedFile(t, encrypted, alice, bob, []byte("pay bob 10000"))
+	forgedPath := filepath.Join(dir, "forged")
+	if err := ioutil.WriteFile(forgedPath, forged, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt(t, carol, forgedPath, filepath.Join(dir, "forged.decrypted")); err == nil {
+		t.Fatal("expected a file forged by another recipient to be refused")
+	}
+	if _, err := Decrypt(t, carol, encryptedPath, filepath.Join(dir, "decrypted")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// forgeEncryptedFile re-encrypts the content of a file from sender with the
+// file key a recipient unwraps, keeping the sender's header and signature.
+func forgeEncryptedFile(t *testing.T, encrypted []byte, sender, recipient *TestIdentity, content []byte) []byte {
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	senderPublic, err := store.GetIdentity(sender.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientPublic, err := store.GetIdentity(recipient.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientPrivate, err := recipientPublic.Authenticate(recipient.Passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	split := bytes.IndexByte(encrypted, '\n')
+	raw := encrypted[:split]
+	var header struct {
+		ChunkSize  int    `json:"chunk-size"`
+		Nonce      string `json:"nonce"`
+		Recipients []struct {
+			ID  string `json:"id"`
+			Key string `json:"key"`
+		} `json:"recipients"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		t.Fatal(err)
+	}
+
+	var wrapped []byte
+	for _, r := range header.Recipients {
+		if r.ID == recipient.ID {
+			if wrapped, err = identity.DecodeString(r.Key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var nonce [24]byte
+	copy(nonce[:], wrapped[:24])
+	senderKey, privateKey := senderPublic.SealPublicKey(), recipientPrivate.SealPrivateKey()
+	fileKey, ok := box.Open(nil, wrapped[24:], &nonce, &senderKey, &privateKey)
+	if !ok {
+		t.Fatal("expected the recipient to unwrap the file key")
+	}
+
+	var payloadKey [32]byte
+	info := bytes.Join([][]byte{[]byte("identify-encryption"), raw}, []byte("\n"))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nil, info), payloadKey[:]); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := identity.DecodeString(header.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefix [stream.PrefixSize]byte
+	copy(prefix[:], decoded)
+
+	reader, err := stream.NewReader(bytes.NewReader(encrypted[split+1:]), &payloadKey, &prefix, header.ChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signature []byte
+	if len(original) >= ed25519.SignatureSize {
+		signature = original[len(original)-ed25519.SignatureSize:]
+	}
+
+	var forged bytes.Buffer
+	forged.Write(raw)
+	forged.WriteByte('\n')
+	writer, err := stream.NewWriter(&forged, &payloadKey, &prefix, header.ChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(append(append([]byte{}, content...), signature...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return forged.Bytes()
+}
+
+// Decrypt decrypts a file as a recipient and returns its contents.
+func Decrypt(t *testing.T, ti *TestIdentity, encryptedPath, outputPath string) ([]byte, error) {
+	output, err := RunAuthenticatedCommand(t, ti, []string{
+		"decrypt", fmt.Sprintf("-output=%s", outputPath), encryptedPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(output, "Decrypted") {
+		return nil, fmt.Errorf("unexpected output: %s", output)
+	}
+	return ioutil.ReadFile(outputPath)
+}
@@ -0,0 +1,149 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestEscrow(t *testing.T) {
+	owner, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GenerateSecret(t, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trustees []*TestIdentity
+	for i := 0; i < 3; i++ {
+		trustee, err := GenerateNewIdentity(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trustees = append(trustees, trustee)
+	}
+
+	output, err := RunAuthenticatedCommand(t, owner, []string{"escrow", "split",
+		"-threshold=2", trustees[0].ID, trustees[1].Alias, trustees[2].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "any 2 of 3 trustees") {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	outsider, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 33)
+	if _, err := RecoverEscrow(t, owner, passphrase, trustees[0], outsider); err == nil {
+		t.Fatal("expected recovery with an identity that isn't a trustee to fail")
+	}
+
+	if _, err := RecoverEscrow(t, owner, passphrase, trustees[2], trustees[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	owner.Passphrase = passphrase
+	value, err := GetSecret(t, owner, ts.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != ts.Value {
+		t.Fatalf("returned value '%s' does not match expected value '%s'", value, ts.Value)
+	}
+}
+
+func TestEscrowRotation(t *testing.T) {
+	owner, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GenerateSecret(t, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trustees []*TestIdentity
+	for i := 0; i < 2; i++ {
+		trustee, err := GenerateNewIdentity(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trustees = append(trustees, trustee)
+	}
+
+	_, err = RunAuthenticatedCommand(t, owner, []string{"escrow", "split",
+		"-threshold=2", trustees[0].ID, trustees[1].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner's escrow is replaced with shares of its new keys, and a
+	// trustee can still open a share sealed to a key it has since retired
+	if err := RotateKeys(t, owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := RotateKeys(t, trustees[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 33)
+	if _, err := RecoverEscrow(t, owner, passphrase, trustees[0], trustees[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	owner.Passphrase = passphrase
+	value, err := GetSecret(t, owner, ts.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != ts.Value {
+		t.Fatalf("returned value '%s' does not match expected value '%s'", value, ts.Value)
+	}
+}
+
+func RecoverEscrow(
+	t *testing.T, owner *TestIdentity, passphrase string, trustees ...*TestIdentity,
+) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	arguments := []string{"escrow", "recover", fmt.Sprintf("-id=%s", owner.ID)}
+
+	var prompts []Prompt
+	for _, trustee := range trustees {
+		arguments = append(arguments, trustee.ID)
+		prompts = append(prompts, Prompt{"Passphrase for " + trustee.ID + ": ", trustee.Passphrase})
+	}
+	prompts = append(prompts,
+		Prompt{"New passphrase: ", passphrase},
+		Prompt{"Confirm passphrase: ", passphrase},
+	)
+
+	return RunPromptedCommand(t, environment, arguments, prompts...)
+}
@@ -0,0 +1,257 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+
+	"github.com/akb/identify/internal/identity"
+)
+
+func TestGroups(t *testing.T) {
+	owner, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carol, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := gofakeit.Lexify("team-????????")
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "new", name}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"group", "new", name}); err == nil {
+		t.Fatal("expected group names to be unique")
+	}
+
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "add", name, bob.Alias}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the owner can add members
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"group", "add", name, carol.ID}); err == nil {
+		t.Fatal("expected a member that isn't the owner to be unable to add others")
+	}
+
+	members, err := ListGroupMembers(t, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(members, owner.ID+" "+owner.Alias+" (owner)") || !strings.Contains(members, bob.ID) {
+		t.Fatalf("expected owner and bob to be members: %s", members)
+	}
+
+	groups, err := ListGroups(t, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(groups, name) {
+		t.Fatalf("expected bob to belong to %s: %s", name, groups)
+	}
+
+	key := gofakeit.Lexify("team-????????")
+	value := gofakeit.Sentence(6)
+
+	_, err = RunAuthenticatedCommand(t, owner, []string{
+		"new", "secret", fmt.Sprintf("-groups=%s", name), key, value,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSecret(t, bob, key, value)
+	expectNoSecret(t, carol, key, value)
+
+	// members added later can read secrets already shared with the group
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "add", name, carol.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectSecret(t, carol, key, value)
+
+	recipients, err := RunAuthenticatedCommand(t, carol, []string{"secret", "recipients", key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(recipients, name+" (group)") {
+		t.Fatalf("expected the secret to be shared with %s: %s", name, recipients)
+	}
+
+	// keys of group members are resealed when they rotate
+	if err := RotateKeys(t, bob); err != nil {
+		t.Fatal(err)
+	}
+	expectSecret(t, bob, key, value)
+
+	// removed members can no longer read secrets shared with the group
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"group", "remove", name, carol.ID}); err == nil {
+		t.Fatal("expected a member that isn't the owner to be unable to remove others")
+	}
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "remove", name, carol.Alias}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoSecret(t, carol, key, value)
+	expectSecret(t, bob, key, value)
+
+	// members can remove themselves, but the owner can't be removed
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"group", "remove", name, owner.ID}); err == nil {
+		t.Fatal("expected the owner to be unable to be removed")
+	}
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"group", "remove", name, bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoSecret(t, bob, key, value)
+
+	// secrets can be shared with and unshared from a group
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "add", name, carol.ID}); err != nil {
+		t.Fatal(err)
+	}
+	other := gofakeit.Lexify("team-????????")
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"new", "secret", other, value}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"secret", "share", fmt.Sprintf("-groups=%s", name), other}); err != nil {
+		t.Fatal(err)
+	}
+	expectSecret(t, carol, other, value)
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"secret", "unshare", fmt.Sprintf("-groups=%s", name), other}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoSecret(t, carol, other, value)
+
+	if _, err := RunAuthenticatedCommand(t, carol, []string{"group", "delete", name}); err == nil {
+		t.Fatal("expected a member that isn't the owner to be unable to delete the group")
+	}
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "delete", name}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoSecret(t, carol, key, value)
+	expectSecret(t, owner, key, value)
+
+	groups, err = ListGroups(t, carol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(groups, name) {
+		t.Fatalf("expected carol to no longer belong to %s: %s", name, groups)
+	}
+}
+
+func TestDeleteGroupMember(t *testing.T) {
+	owner, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var members []*TestIdentity
+	for n := 0; n < 2; n++ {
+		ti, err := GenerateNewIdentity(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, ti)
+	}
+
+	name := gofakeit.Lexify("team-????????")
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "new", name}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ti := range members {
+		if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "add", name, ti.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := gofakeit.Lexify("team-????????")
+	value := gofakeit.Sentence(6)
+	_, err = RunAuthenticatedCommand(t, owner, []string{
+		"new", "secret", fmt.Sprintf("-groups=%s", name), key, value,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyID, err := GroupKeyID(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the group is re-keyed as it would be if the member were removed
+	_, err = RunAuthenticatedCommand(t, members[0], []string{"delete", "identity"},
+		Prompt{"Permanently delete identity", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rekeyed, err := GroupKeyID(name); err != nil {
+		t.Fatal(err)
+	} else if rekeyed == keyID {
+		t.Fatal("expected the group to be re-keyed when a member is deleted")
+	}
+	expectSecret(t, owner, key, value)
+	expectSecret(t, members[1], key, value)
+
+	// an administrator outside the group can't re-encrypt its secrets
+	_, err = RunAuthenticatedCommand(t, administrator, []string{"delete", "identity", members[1].ID},
+		Prompt{"Permanently delete identity", "y"})
+	if err == nil {
+		t.Fatal("expected deleting a member of a group by a non-member to fail")
+	}
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"group", "remove", name, members[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = RunAuthenticatedCommand(t, administrator, []string{"delete", "identity", members[1].ID},
+		Prompt{"Permanently delete identity", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// GroupKeyID returns the id of the current seal key of a group.
+func GroupKeyID(name string) (string, error) {
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+
+	g, err := store.GetGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.KeyID(), nil
+}
+
+func ListGroupMembers(t *testing.T, group string) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	return RunCommand(t, environment, []string{"group", "members", group})
+}
+
+func ListGroups(t *testing.T, id string) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	return RunCommand(t, environment, []string{"group", "list", id})
+}
@@ -0,0 +1,161 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/brianvoe/gofakeit/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/akb/identify/internal/identity"
+)
+
+func TestLegacyIdentityUpgrade(t *testing.T) {
+	ti, err := GenerateLegacyIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GenerateSecret(t, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := ReadIdentityRecord(ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := record["kdf"]; !ok {
+		t.Fatal("expected legacy identity to be upgraded after authenticating")
+	}
+
+	value, err := GetSecret(t, ti, ts.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != ts.Value {
+		t.Fatalf("returned value '%s' does not match expected value '%s'", value, ts.Value)
+	}
+}
+
+// GenerateLegacyIdentity writes an identity directly to the database using the
+// original unsalted SHA-256 passphrase key.
+func GenerateLegacyIdentity(t *testing.T) (*TestIdentity, error) {
+	ti := TestIdentity{
+		ID:         uuid.New().String(),
+		Passphrase: gofakeit.Password(true, true, true, true, true, 33),
+	}
+
+	ecdsaPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	marshaledECDSAPrivateKey, err := x509.MarshalPKCS8PrivateKey(ecdsaPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	marshaledECDSAPublicKey, err := x509.MarshalPKIXPublicKey(&ecdsaPrivateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ed25519PublicKey, ed25519PrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	sealPublicKey, sealPrivateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := json.Marshal(map[string]string{
+		"ecdsa-private-key":   identity.EncodeToString(marshaledECDSAPrivateKey),
+		"ed25519-private-key": identity.EncodeToString(ed25519PrivateKey),
+		"seal-private-key":    identity.EncodeToString(sealPrivateKey[:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	if _, err = io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256([]byte(ti.Passphrase))
+	sealed := secretbox.Seal(nonce[:], private, &nonce, &key)
+
+	public, err := json.Marshal(map[string]interface{}{
+		"id":                 ti.ID,
+		"ecdsa-public-key":   identity.EncodeToString(marshaledECDSAPublicKey),
+		"ed25519-public-key": identity.EncodeToString(ed25519PublicKey),
+		"seal-public-key":    identity.EncodeToString(sealPublicKey[:]),
+		"private":            identity.EncodeToString(sealed),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("identity"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(ti.ID), public)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ti, nil
+}
+
+func ReadIdentityRecord(id string) (map[string]interface{}, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var record map[string]interface{}
+	err = db.View(func(tx *bolt.Tx) error {
+		return json.Unmarshal(tx.Bucket([]byte("identity")).Get([]byte(id)), &record)
+	})
+	return record, err
+}
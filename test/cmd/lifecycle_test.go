@@ -0,0 +1,145 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisableIdentity(t *testing.T) {
+	operator, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GenerateSecret(t, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only operators and administrators can disable other identities
+	if _, err := RunAuthenticatedCommand(t, operator,
+		[]string{"disable", "identity", ti.ID}); err == nil {
+		t.Fatal("expected an identity without the operator role to be unable to disable others")
+	}
+
+	if err := AssignRole(t, operator, "operator"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, operator,
+		[]string{"disable", "identity", ti.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := GetSecret(t, ti, ts.Key); err == nil || value == ts.Value {
+		t.Fatal("disabled identity was able to authenticate")
+	}
+
+	if _, err := RunAuthenticatedCommand(t, operator,
+		[]string{"enable", "identity", ti.Alias}); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := GetSecret(t, ti, ts.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != ts.Value {
+		t.Fatalf("returned value '%s' does not match expected value '%s'", value, ts.Value)
+	}
+}
+
+func TestDeleteIdentity(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := RunAuthenticatedCommand(t, ti, []string{"delete", "identity"},
+		Prompt{"Permanently delete identity", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output, "Deleted identity") {
+		t.Fatalf("unexpected output: '%s'", output)
+	}
+
+	if _, err := ReadIdentityRecord(ti.ID); err == nil {
+		t.Fatal("identity record still exists after being deleted")
+	}
+}
+
+func TestDisableAdministrator(t *testing.T) {
+	operator, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AssignRole(t, operator, "operator"); err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AssignRole(t, admin, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// other tests expect the administrator to be the only one
+	defer RunAuthenticatedCommand(t, administrator, []string{"role", "revoke", admin.ID, "admin"})
+
+	// operators hold identities:manage, but not every permission of an
+	// administrator
+	if _, err := RunAuthenticatedCommand(t, operator,
+		[]string{"disable", "identity", admin.ID}); err == nil {
+		t.Fatal("expected an operator to be unable to disable an administrator")
+	}
+	if _, err := RunAuthenticatedCommand(t, operator,
+		[]string{"delete", "identity", admin.ID},
+		Prompt{"Permanently delete identity", "y"}); err == nil {
+		t.Fatal("expected an operator to be unable to delete an administrator")
+	}
+
+	// not even administrators may disable or delete the server identity
+	if _, err := RunAuthenticatedCommand(t, admin,
+		[]string{"disable", "identity", administrator.ID}); err == nil {
+		t.Fatal("expected the server identity to be impossible to disable")
+	}
+	if _, err := RunAuthenticatedCommand(t, admin,
+		[]string{"delete", "identity", administrator.ID},
+		Prompt{"Permanently delete identity", "y"}); err == nil {
+		t.Fatal("expected the server identity to be impossible to delete")
+	}
+
+	if _, err := ReadIdentityRecord(administrator.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadIdentityRecord(admin.ID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestListIdentities(t *testing.T) {
+	prefix := gofakeit.Lexify("????????????")
+
+	var identities []*TestIdentity
+	for i := 0; i < 3; i++ {
+		ti, err := GenerateNewIdentity(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alias := fmt.Sprintf("%s-%d", prefix, i)
+		if _, err := RunAuthenticatedCommand(t, ti, []string{"alias", "add", alias}); err != nil {
+			t.Fatal(err)
+		}
+		identities = append(identities, ti)
+	}
+
+	lines, err := ListIdentities(t, "-prefix="+prefix, "-limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "next: -cursor=") {
+		t.Fatalf("expected two identities and a cursor, got: %v", lines)
+	}
+
+	rest, err := ListIdentities(t, "-prefix="+prefix, "-limit=2",
+		strings.TrimPrefix(lines[2], "next: "))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rest) != 1 {
+		t.Fatalf("expected one remaining identity, got: %v", rest)
+	}
+
+	listed := map[string]bool{}
+	for _, line := range append(lines[:2], rest...) {
+		listed[strings.Fields(line)[0]] = true
+	}
+	for _, ti := range identities {
+		if !listed[ti.ID] {
+			t.Fatalf("expected identity %s to be listed", ti.ID)
+		}
+	}
+
+	lines, err = ListIdentities(t, "-alias="+identities[1].Alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 1 || strings.Fields(lines[0])[0] != identities[1].ID {
+		t.Fatalf("expected only identity %s, got: %v", identities[1].ID, lines)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, identities[0], []string{"list", "identities"}); err == nil {
+		t.Fatal("expected an identity without the identities:list permission to be refused")
+	}
+}
+
+func ListIdentities(t *testing.T, flags ...string) ([]string, error) {
+	output, err := RunAuthenticatedCommand(t, administrator, append([]string{"list", "identities"}, flags...))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
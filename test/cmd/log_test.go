@@ -0,0 +1,185 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/go-expect"
+	"github.com/brianvoe/gofakeit/v5"
+
+	"github.com/akb/identify/internal/identity"
+)
+
+func TestVerifyLog(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a certificate left by another test would be confirmed before overwriting
+	os.Remove(certPath)
+	os.Remove(certKeyPath)
+	if err := GenerateCertificate(t, ti); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(certPath)
+		os.Remove(certKeyPath)
+	})
+
+	port, err := GetFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	statePath := filepath.Join(dir, "log-state.json")
+	environment := map[string]string{
+		"IDENTIFY_DB_PATH":              dbPath,
+		"IDENTIFY_TOKEN_DB_PATH":        tokenDBPath,
+		"IDENTIFY_CERTIFICATE_PATH":     certPath,
+		"IDENTIFY_CERTIFICATE_KEY_PATH": certKeyPath,
+		"IDENTIFY_HTTP_ADDRESS":         fmt.Sprintf("localhost:%d", port),
+		"IDENTIFY_LOG_STATE_PATH":       statePath,
+	}
+
+	var output string
+	var verified error
+	err = WhileListening(t, ti, environment, func() {
+		output, verified = RunCommand(t, environment, []string{"verify-log", ti.ID})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != nil {
+		t.Fatal(verified)
+	}
+	if !strings.HasPrefix(output, "Verified ") ||
+		!strings.Contains(output, fmt.Sprintf("signed by %s", ti.ID)) ||
+		!strings.Contains(output, fmt.Sprintf("The keys of %s", ti.ID)) {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	alias := gofakeit.Username()
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"alias", "add", alias}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = WhileListening(t, ti, environment, func() {
+		output, verified = RunCommand(t, environment, []string{"verify-log", alias})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != nil {
+		t.Fatal(verified)
+	}
+	if !strings.Contains(output, "The log extends the") {
+		t.Fatalf("expected the log to be checked against the last audit: %s", output)
+	}
+
+	// an audit that verified a different log can't be extended
+	marshaled, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state map[string]interface{}
+	if err := json.Unmarshal(marshaled, &state); err != nil {
+		t.Fatal(err)
+	}
+	state["root"] = identity.EncodeToString(make([]byte, 32))
+	if marshaled, err = json.Marshal(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(statePath, marshaled, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = WhileListening(t, ti, environment, func() {
+		_, verified = RunCommand(t, environment, []string{"verify-log"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified == nil {
+		t.Fatal("expected a log inconsistent with the last audit to fail verification")
+	}
+}
+
+// WhileListening runs the server as an identity, calling a function once it
+// accepts connections and stopping it when the function returns.
+func WhileListening(t *testing.T, ti *TestIdentity, environment map[string]string, fn func()) error {
+	arguments := []string{"listen", fmt.Sprintf("-id=%s", ti.ID)}
+
+	var err error
+	t.Logf("running '%s'", strings.Join(arguments, " "))
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			if _, err = c.Expectf("Passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(ti.Passphrase); err != nil {
+				return
+			}
+
+			address := environment["IDENTIFY_HTTP_ADDRESS"]
+			for i := 0; ; i++ {
+				conn, dialErr := net.Dial("tcp", address)
+				if dialErr == nil {
+					conn.Close()
+					break
+				}
+				if i == 50 {
+					err = fmt.Errorf("server has not started after 5 seconds")
+					break
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			if err == nil {
+				fn()
+			}
+
+			done := Async(func() {
+				c.Tty().Close()
+				cancel()
+			})
+			c.ExpectEOF()
+			<-done
+		},
+	)
+	if result.Status != 0 {
+		return ErrorNonZeroExit{result.Status}
+	}
+	return err
+}
@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,6 +34,7 @@ import (
 	gocli "github.com/akb/go-cli"
 
 	"github.com/akb/identify/internal/cli"
+	"github.com/akb/identify/internal/identity"
 )
 
 func init() {
@@ -42,6 +44,39 @@ func init() {
 
 var dbPath, tokenDBPath, certPath, certKeyPath string
 
+// administrator holds the admin role, for tests of commands that act on other
+// identities.
+var administrator *TestIdentity
+
+// newAdministrator creates an identity and makes it the first administrator,
+// before any test can.
+func newAdministrator() (*TestIdentity, error) {
+	store, err := identity.NewLocalStore(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	ti := TestIdentity{
+		Alias:      gofakeit.Lexify("admin-????????"),
+		Passphrase: gofakeit.Password(true, true, true, true, true, 33),
+	}
+
+	_, private, err := store.NewIdentity(ti.Passphrase, []string{ti.Alias})
+	if err != nil {
+		return nil, err
+	}
+	ti.ID = private.String()
+
+	if err := store.BootstrapAdministrator(private); err != nil {
+		return nil, err
+	}
+	if err := store.RecordServerIdentity(private); err != nil {
+		return nil, err
+	}
+	return &ti, nil
+}
+
 func TestMain(m *testing.M) {
 	dir, err := ioutil.TempDir("", "identify-testing")
 	if err != nil {
@@ -54,6 +89,16 @@ func TestMain(m *testing.M) {
 	certPath = filepath.Join(dir, "certificate.pem")
 	certKeyPath = filepath.Join(dir, "certificate-key.pem")
 
+	// the default key derivation costs are too slow for interactive tests
+	identity.DefaultKDFParameters = identity.KDFParameters{
+		Time: 1, Memory: 1024, Threads: 1,
+	}
+
+	administrator, err = newAdministrator()
+	if err != nil {
+		panic(err)
+	}
+
 	os.Exit(m.Run())
 }
 
@@ -102,6 +147,99 @@ func RunCommandTest(t *testing.T,
 	}
 }
 
+// RunCommand runs a command that doesn't prompt for input and returns its
+// output.
+func RunCommand(t *testing.T, environment map[string]string, arguments []string) (string, error) {
+	var output string
+	var err error
+	t.Logf("running '%s'", strings.Join(arguments, " "))
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			done := In(100*time.Millisecond, func() { c.Tty().Close() })
+
+			t.Log("waiting for eof...")
+			output, err = c.ExpectEOF()
+			t.Log("waiting for tty to close...")
+			<-done
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	output = strings.TrimSpace(output)
+
+	if result.Status != 0 {
+		return output, ErrorNonZeroExit{result.Status}
+	}
+
+	return output, nil
+}
+
+// Prompt is a line of input to send once the expected prompt is displayed.
+type Prompt struct {
+	Expect string
+	Send   string
+}
+
+// RunAuthenticatedCommand runs a command as the given identity, answering the
+// passphrase prompt followed by each of the given prompts, and returns what the
+// command printed afterwards.
+func RunAuthenticatedCommand(
+	t *testing.T, ti *TestIdentity, arguments []string, prompts ...Prompt,
+) (string, error) {
+	environment := map[string]string{
+		"IDENTIFY_DB_PATH":       dbPath,
+		"IDENTIFY_TOKEN_DB_PATH": tokenDBPath,
+	}
+
+	arguments = append(arguments, fmt.Sprintf("-id=%s", ti.ID))
+	prompts = append([]Prompt{{"Passphrase: ", ti.Passphrase}}, prompts...)
+
+	return RunPromptedCommand(t, environment, arguments, prompts...)
+}
+
+// RunPromptedCommand runs a command, answering each of the given prompts, and
+// returns what the command printed afterwards.
+func RunPromptedCommand(
+	t *testing.T, environment map[string]string, arguments []string, prompts ...Prompt,
+) (string, error) {
+	var output string
+	var err error
+	t.Logf("running '%s'", strings.Join(arguments, " "))
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			for _, p := range prompts {
+				t.Logf("waiting for prompt '%s'...", p.Expect)
+				if _, err = c.ExpectString(p.Expect); err != nil {
+					return
+				}
+				if _, err = c.SendLine(p.Send); err != nil {
+					return
+				}
+			}
+
+			done := In(100*time.Millisecond, func() { c.Tty().Close() })
+
+			t.Log("waiting for eof...")
+			output, err = c.ExpectEOF()
+			t.Log("waiting for tty to close...")
+			<-done
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	output = strings.TrimSpace(output)
+
+	if result.Status != 0 {
+		return output, ErrorNonZeroExit{result.Status}
+	}
+
+	return output, nil
+}
+
 func Async(fn func()) chan struct{} {
 	done := make(chan struct{})
 	go func() {
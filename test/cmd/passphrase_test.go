@@ -0,0 +1,187 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/go-expect"
+	"github.com/boltdb/bolt"
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestSetPassphrase(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GenerateSecret(t, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldPassphrase := ti.Passphrase
+	if err := SetPassphrase(t, ti, gofakeit.Password(true, true, true, true, true, 33)); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := GetSecret(t, ti, ts.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != ts.Value {
+		t.Fatalf("returned value '%s' does not match expected value '%s'", value, ts.Value)
+	}
+
+	impostor := *ti
+	impostor.Passphrase = oldPassphrase
+	value, err = GetSecret(t, &impostor, ts.Key)
+	if err != nil {
+		if _, ok := err.(ErrorNonZeroExit); !ok {
+			t.Fatal(err)
+		}
+	}
+
+	if value == ts.Value {
+		t.Fatal("old passphrase was accepted after being changed")
+	}
+}
+
+func TestSetPassphraseRekeys(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"new", "recovery-codes"}); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ReadIdentityRecord(ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldPassphrase := ti.Passphrase
+	if err := SetPassphrase(t, ti, gofakeit.Password(true, true, true, true, true, 33)); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := ReadIdentityRecord(ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := after["recovery-codes"]; ok {
+		t.Fatal("expected the recovery codes of the old master key to be dropped")
+	}
+
+	// the envelope of an older copy of the record, such as a backup, opens the
+	// old master key, which must not open the keys sealed since
+	after["kdf"] = before["kdf"]
+	if err := WriteIdentityRecord(ti.ID, after); err != nil {
+		t.Fatal(err)
+	}
+
+	impostor := *ti
+	impostor.Passphrase = oldPassphrase
+	if _, err := RunAuthenticatedCommand(t, &impostor, []string{"get", "attributes"}); err == nil {
+		t.Fatal("expected the old master key not to open the private keys")
+	}
+}
+
+func WriteIdentityRecord(id string, record map[string]interface{}) error {
+	marshaled, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("identity")).Put([]byte(id), marshaled)
+	})
+}
+
+func SetPassphrase(t *testing.T, ti *TestIdentity, passphrase string) error {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+
+	arguments := []string{"set", "passphrase", fmt.Sprintf("-id=%s", ti.ID)}
+
+	var err error
+	t.Logf("running '%s'", strings.Join(arguments, " "))
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			t.Log("waiting for passphrase prompt...")
+			if _, err = c.ExpectString("Passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(ti.Passphrase); err != nil {
+				return
+			}
+
+			t.Log("waiting for new passphrase prompt...")
+			if _, err = c.ExpectString("New passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(passphrase); err != nil {
+				return
+			}
+
+			t.Log("waiting for confirmation prompt...")
+			if _, err = c.ExpectString("Confirm passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(passphrase); err != nil {
+				return
+			}
+
+			t.Log("waiting for confirmation...")
+			if _, err = c.ExpectString("Passphrase changed."); err != nil {
+				return
+			}
+
+			done := In(10*time.Millisecond, func() { c.Tty().Close() })
+
+			t.Log("waiting for eof...")
+			_, err = c.ExpectEOF()
+			t.Log("waiting for tty to close...")
+			<-done
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.Status != 0 {
+		return ErrorNonZeroExit{result.Status}
+	}
+
+	ti.Passphrase = passphrase
+	return nil
+}
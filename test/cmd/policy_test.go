@@ -0,0 +1,215 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+const testPolicy = `{
+  "name": "%[1]s",
+  "rules": [{
+    "id": "engineers-read",
+    "effect": "allow",
+    "actions": ["read"],
+    "resources": ["%[1]s/*"],
+    "conditions": [{"field": "attributes.self.department", "op": "equals", "value": "engineering"}]
+  }, {
+    "id": "owners",
+    "effect": "allow",
+    "actions": ["*"],
+    "resources": ["%[1]s/${subject}/*"]
+  }, {
+    "id": "teams",
+    "effect": "allow",
+    "actions": ["read"],
+    "resources": ["%[1]s/teams/${attributes.self.team}/*"]
+  }, {
+    "id": "private-network",
+    "effect": "deny",
+    "actions": ["*"],
+    "resources": ["%[1]s/*"],
+    "conditions": [{"field": "context.network", "op": "equals", "value": "public"}]
+  }]
+}`
+
+func TestPolicies(t *testing.T) {
+	engineer, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, engineer, []string{
+		"set", "attribute", "department", "engineering",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	name := gofakeit.Lexify("documents-????????")
+	path := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(testPolicy, name)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, engineer, []string{"policy", "put", path}); err == nil {
+		t.Fatal("expected an identity without policies:manage to be unable to store policies")
+	}
+
+	output, err := RunAuthenticatedCommand(t, administrator, []string{"policy", "put", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, fmt.Sprintf("Stored version 1 of policy %s", name)) {
+		t.Fatalf("expected version 1 to be stored: %s", output)
+	}
+
+	output, err = CheckPolicy(t, engineer.Alias, "read", name+"/plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "allowed by rule engineers-read of policy "+name) {
+		t.Fatalf("expected the engineer to be allowed to read: %s", output)
+	}
+
+	if output, err := CheckPolicy(t, other.ID, "read", name+"/plans"); err == nil {
+		t.Fatalf("expected an identity without the attribute to be denied: %s", output)
+	}
+
+	if _, err := CheckPolicy(t, other.ID, "write", fmt.Sprintf("%s/%s/notes", name, other.ID)); err != nil {
+		t.Fatalf("expected an identity to be allowed to act on its own resources: %s", err)
+	}
+
+	// the values of references are matched literally, never as wildcards
+	if _, err := RunAuthenticatedCommand(t, other, []string{"set", "attribute", "team", "*"}); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := CheckPolicy(t, other.ID, "read", name+"/teams/red/roadmap"); err == nil {
+		t.Fatalf("expected a '*' in an attribute to match only itself: %s", output)
+	}
+	if _, err := CheckPolicy(t, other.ID, "read", name+"/teams/*/roadmap"); err != nil {
+		t.Fatalf("expected a '*' in an attribute to match itself: %s", err)
+	}
+
+	output, err = CheckPolicy(t, engineer.ID, "read", name+"/plans", "-explain", "-context=network=public")
+	if err == nil {
+		t.Fatalf("expected the deny rule to take precedence: %s", output)
+	}
+	for _, expected := range []string{
+		"denied by rule private-network of policy " + name,
+		fmt.Sprintf("%s@1 owners allow: resource %s/plans doesn't match", name, name),
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected the explanation to include '%s': %s", expected, err)
+		}
+	}
+
+	revised := strings.Replace(fmt.Sprintf(testPolicy, name), `"engineering"`, `"research"`, 1)
+	if err := ioutil.WriteFile(path, []byte(revised), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{"policy", "put", path}); err != nil {
+		t.Fatal(err)
+	}
+
+	if output, err := CheckPolicy(t, engineer.ID, "read", name+"/plans"); err == nil {
+		t.Fatalf("expected the latest version of the policy to be evaluated: %s", output)
+	}
+
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	output, err = RunCommand(t, environment, []string{"policy", "history", name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(output), "\n"); len(lines) != 2 ||
+		!strings.HasPrefix(lines[1], "2 ") || !strings.HasSuffix(lines[1], administrator.ID) {
+		t.Fatalf("expected two versions stored by the administrator: %s", output)
+	}
+
+	output, err = RunCommand(t, environment, []string{"policy", "get", "-version=1", name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, `"engineering"`) {
+		t.Fatalf("expected the first version of the policy: %s", output)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{"policy", "delete", name}); err != nil {
+		t.Fatal(err)
+	}
+	output, err = RunCommand(t, environment, []string{"policy", "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output, name) {
+		t.Fatalf("expected the policy to be deleted: %s", output)
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, document := range []string{
+		`{"name": "invalid", "rules": []}`,
+		`{"name": "invalid", "rules": [{"id": "r", "effect": "permit", "actions": ["*"], "resources": ["*"]}]}`,
+		`{"name": "invalid", "rules": [{"id": "r", "effect": "allow", "actions": ["*"], "resources": ["${owner}"]}]}`,
+		`{"name": "invalid", "rules": [{"id": "r", "effect": "allow", "actions": ["*"], "resources": ["*"],
+		  "conditions": [{"field": "claims.roles", "op": "in", "value": "admin"}]}]}`,
+	} {
+		path := filepath.Join(dir, "policy.json")
+		if err := ioutil.WriteFile(path, []byte(document), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := RunAuthenticatedCommand(t, administrator, []string{"policy", "put", path}); err == nil {
+			t.Fatalf("expected policy to be rejected: %s", document)
+		}
+	}
+}
+
+// CheckPolicy asks whether an identity may perform an action on a resource.
+// The output of a denied request is returned in the error.
+func CheckPolicy(t *testing.T, id, action, resource string, flags ...string) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	arguments := append([]string{"policy", "check"}, flags...)
+	output, err := RunCommand(t, environment, append(arguments, id, action, resource))
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, output)
+	}
+	return output, nil
+}
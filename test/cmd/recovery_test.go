@@ -0,0 +1,75 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+var RecoveryCodePattern = regexp.MustCompile(`[A-Z2-7]{5}(-[A-Z2-7]{5}){3}`)
+
+func TestRecover(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := RunAuthenticatedCommand(t, ti, []string{"new", "recovery-codes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes := RecoveryCodePattern.FindAllString(output, -1)
+	if len(codes) != 10 {
+		t.Fatalf("expected ten recovery codes, got:\n%s", output)
+	}
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 33)
+	output, err = Recover(t, ti, strings.ToLower(codes[3]), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output, "9 recovery codes remain") {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	ti.Passphrase = passphrase
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"get", "attributes"}); err != nil {
+		t.Fatalf("expected new passphrase to be accepted: %s", err)
+	}
+
+	if _, err := Recover(t, ti, codes[3], passphrase); err == nil {
+		t.Fatal("expected a recovery code to only be accepted once")
+	}
+}
+
+func Recover(t *testing.T, ti *TestIdentity, code, passphrase string) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	arguments := []string{"recover", fmt.Sprintf("-id=%s", ti.ID)}
+	return RunPromptedCommand(t, environment, arguments,
+		Prompt{"Recovery code: ", code},
+		Prompt{"New passphrase: ", passphrase},
+		Prompt{"Confirm passphrase: ", passphrase},
+	)
+}
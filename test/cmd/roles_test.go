@@ -0,0 +1,171 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestRoles(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := ListRoles(t, ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(roles) != "user" {
+		t.Fatalf("expected a new identity to hold only the user role, got '%s'", roles)
+	}
+
+	// there is already an administrator, so no one else can become one
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"role", "assign", ti.ID, "admin"}); err == nil {
+		t.Fatal("expected an identity to be unable to make itself an administrator")
+	}
+
+	name := gofakeit.Lexify("auditor-????????")
+	if _, err := RunAuthenticatedCommand(t, ti, []string{
+		"role", "new", "-permissions=identities:list", name,
+	}); err == nil {
+		t.Fatal("expected an identity without roles:manage to be unable to define roles")
+	}
+
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{
+		"role", "new", "-permissions=identities:bogus", name,
+	}); err == nil {
+		t.Fatal("expected roles to only grant known permissions")
+	}
+
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{
+		"role", "new", "-permissions=identities:manage", name,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := ListRoles(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"admin *", "user self:manage", name + " identities:manage"} {
+		if !strings.Contains(all, expected) {
+			t.Fatalf("expected roles to include '%s': %s", expected, all)
+		}
+	}
+
+	if err := AssignRole(t, ti, name); err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err = ListRoles(t, ti.Alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(roles, name) {
+		t.Fatalf("expected %s to hold %s: %s", ti.ID, name, roles)
+	}
+
+	// the custom role allows managing other identities
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"disable", "identity", other.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"enable", "identity", other.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{"role", "revoke", ti.ID, name}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"disable", "identity", other.ID}); err == nil {
+		t.Fatal("expected a revoked role to no longer grant its permissions")
+	}
+
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{"role", "revoke", ti.ID, "user"}); err == nil {
+		t.Fatal("expected the user role to be impossible to revoke")
+	}
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{
+		"role", "revoke", administrator.ID, "admin",
+	}); err == nil {
+		t.Fatal("expected the last administrator to be impossible to remove")
+	}
+
+	// managing roles doesn't allow handing out permissions the manager lacks
+	manager := gofakeit.Lexify("role-manager-????????")
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{
+		"role", "new", "-permissions=roles:manage", manager,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AssignRole(t, other, manager); err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []string{"admin", name} {
+		if _, err := RunAuthenticatedCommand(t, other, []string{"role", "assign", other.ID, role}); err == nil {
+			t.Fatalf("expected a role manager to be unable to assign %s", role)
+		}
+	}
+	if _, err := RunAuthenticatedCommand(t, other, []string{"role", "assign", ti.ID, manager}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, other, []string{"role", "revoke", administrator.ID, "admin"}); err == nil {
+		t.Fatal("expected a role manager to be unable to revoke admin")
+	}
+
+	if err := AssignRole(t, ti, name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{"role", "delete", name}); err != nil {
+		t.Fatal(err)
+	}
+	roles, err = ListRoles(t, ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(roles, name) {
+		t.Fatalf("expected a deleted role to be revoked: %s", roles)
+	}
+}
+
+// AssignRole gives a role to an identity as the administrator.
+func AssignRole(t *testing.T, ti *TestIdentity, role string) error {
+	_, err := RunAuthenticatedCommand(t, administrator, []string{"role", "assign", ti.ID, role})
+	return err
+}
+
+func ListRoles(t *testing.T, id string) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	arguments := []string{"role", "list"}
+	if id != "" {
+		arguments = append(arguments, id)
+	}
+	output, err := RunCommand(t, environment, arguments)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, output)
+	}
+	return output, nil
+}
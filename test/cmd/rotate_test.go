@@ -0,0 +1,104 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/go-expect"
+)
+
+func TestRotateKeys(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GenerateSecret(t, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RotateKeys(t, ti); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := ReadIdentityRecord(ti.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, ok := record["key-history"].([]interface{})
+	if !ok || len(history) != 1 {
+		t.Fatalf("expected one set of retired keys, found: %v", record["key-history"])
+	}
+
+	value, err := GetSecret(t, ti, ts.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != ts.Value {
+		t.Fatalf("returned value '%s' does not match expected value '%s'", value, ts.Value)
+	}
+}
+
+func RotateKeys(t *testing.T, ti *TestIdentity) error {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+
+	arguments := []string{"rotate", "keys", fmt.Sprintf("-id=%s", ti.ID)}
+
+	var err error
+	t.Logf("running '%s'", strings.Join(arguments, " "))
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			t.Log("waiting for passphrase prompt...")
+			if _, err = c.ExpectString("Passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(ti.Passphrase); err != nil {
+				return
+			}
+
+			t.Log("waiting for confirmation...")
+			if _, err = c.ExpectString("Rotated keys"); err != nil {
+				return
+			}
+
+			done := In(10*time.Millisecond, func() { c.Tty().Close() })
+
+			t.Log("waiting for eof...")
+			_, err = c.ExpectEOF()
+			t.Log("waiting for tty to close...")
+			<-done
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.Status != 0 {
+		return ErrorNonZeroExit{result.Status}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestSharedSecrets(t *testing.T) {
+	owner, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carol, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := gofakeit.Lexify("shared-????????")
+	value := gofakeit.Sentence(6)
+
+	_, err = RunAuthenticatedCommand(t, owner, []string{
+		"new", "secret", fmt.Sprintf("-recipients=%s", bob.Alias), key, value,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectSecret(t, owner, key, value)
+	expectSecret(t, bob, key, value)
+	expectNoSecret(t, carol, key, value)
+
+	// any recipient can share the secret further
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"secret", "share", key, carol.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectSecret(t, carol, key, value)
+
+	recipients, err := RunAuthenticatedCommand(t, owner, []string{"secret", "recipients", key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ti := range []*TestIdentity{owner, bob, carol} {
+		if !strings.Contains(recipients, ti.ID) {
+			t.Fatalf("expected %s to be a recipient: %s", ti.ID, recipients)
+		}
+	}
+
+	// only the owner can remove others
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"secret", "unshare", key, carol.ID}); err == nil {
+		t.Fatal("expected a recipient that isn't the owner to be unable to remove others")
+	}
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"secret", "unshare", key, carol.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoSecret(t, carol, key, value)
+	expectSecret(t, bob, key, value)
+
+	// recipients can remove themselves
+	if _, err := RunAuthenticatedCommand(t, bob, []string{"secret", "unshare", key, bob.Alias}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoSecret(t, bob, key, value)
+
+	if _, err := RunAuthenticatedCommand(t, carol, []string{"new", "secret", key, "replaced"}); err == nil {
+		t.Fatal("expected an identity that doesn't own a secret to be unable to replace it")
+	}
+
+	if err := RotateKeys(t, owner); err != nil {
+		t.Fatal(err)
+	}
+	expectSecret(t, owner, key, value)
+
+	// deleted recipients don't stop the secret from being re-encrypted
+	dave, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ti := range []*TestIdentity{carol, dave} {
+		if _, err := RunAuthenticatedCommand(t, owner, []string{"secret", "share", key, ti.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = RunAuthenticatedCommand(t, dave, []string{"delete", "identity"},
+		Prompt{"Permanently delete identity", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, owner, []string{"secret", "unshare", key, carol.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoSecret(t, carol, key, value)
+	expectSecret(t, owner, key, value)
+
+	recipients, err = RunAuthenticatedCommand(t, owner, []string{"secret", "recipients", key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(recipients, dave.ID) {
+		t.Fatalf("expected deleted identity %s to no longer be a recipient: %s", dave.ID, recipients)
+	}
+}
+
+func expectSecret(t *testing.T, ti *TestIdentity, key, value string) {
+	t.Helper()
+	got, err := GetSecret(t, ti, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != value {
+		t.Fatalf("expected %s to read '%s', got '%s'", ti.ID, value, got)
+	}
+}
+
+func expectNoSecret(t *testing.T, ti *TestIdentity, key, value string) {
+	t.Helper()
+	if got, _ := GetSecret(t, ti, key); got == value {
+		t.Fatalf("expected %s to be unable to read the secret", ti.ID)
+	}
+}
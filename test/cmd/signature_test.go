@@ -0,0 +1,114 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestSignVerify(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dataPath := filepath.Join(dir, "data.txt")
+	if err := ioutil.WriteFile(dataPath, []byte(gofakeit.Paragraph(2, 4, 12, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range []string{"ed25519", "ecdsa-p256-sha256"} {
+		signaturePath := filepath.Join(dir, algorithm+".sig")
+		if err := Sign(t, ti, dataPath, signaturePath, algorithm); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := Verify(t, dataPath, signaturePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "Good "+algorithm+" signature from "+ti.ID) {
+			t.Fatalf("unexpected output: %s", output)
+		}
+
+		if _, err := Verify(t, dataPath, signaturePath, "-signer="+ti.Alias); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Verify(t, dataPath, signaturePath, "-signer="+other.ID); err == nil {
+			t.Fatal("expected verification as another signer to fail")
+		}
+	}
+
+	signaturePath := filepath.Join(dir, "ed25519.sig")
+
+	if err := RotateKeys(t, ti); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := Verify(t, dataPath, signaturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "has since been rotated") {
+		t.Fatalf("expected signature by a retired key to be noted: %s", output)
+	}
+
+	tampered := filepath.Join(dir, "tampered.txt")
+	if err := ioutil.WriteFile(tampered, []byte(gofakeit.Sentence(8)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(t, tampered, signaturePath); err == nil {
+		t.Fatal("expected verification of different data to fail")
+	}
+}
+
+// Sign writes a detached signature of a file by an identity.
+func Sign(t *testing.T, ti *TestIdentity, dataPath, signaturePath, algorithm string) error {
+	_, err := RunAuthenticatedCommand(t, ti, []string{
+		"sign",
+		fmt.Sprintf("-algorithm=%s", algorithm),
+		fmt.Sprintf("-output=%s", signaturePath),
+		dataPath,
+	})
+	return err
+}
+
+// Verify checks a detached signature of a file.
+func Verify(t *testing.T, dataPath, signaturePath string, flags ...string) (string, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	arguments := append([]string{"verify", fmt.Sprintf("-signature=%s", signaturePath)}, flags...)
+	return RunCommand(t, environment, append(arguments, dataPath))
+}
@@ -0,0 +1,373 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/go-expect"
+	"github.com/brianvoe/gofakeit/v5"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSSHAgent(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environment := map[string]string{
+		"IDENTIFY_DB_PATH":       dbPath,
+		"IDENTIFY_TOKEN_DB_PATH": tokenDBPath,
+	}
+
+	line, err := RunCommand(t, environment, []string{"get", "ssh-key", ti.Alias})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.Type() != ssh.KeyAlgoED25519 || comment != ti.ID {
+		t.Fatalf("unexpected authorized key: %s", line)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	arguments := []string{"ssh-agent", fmt.Sprintf("-socket=%s", socket), fmt.Sprintf("-id=%s", ti.ID)}
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			defer func() {
+				done := Async(func() {
+					c.Tty().Close()
+					cancel()
+				})
+				c.ExpectEOF()
+				<-done
+			}()
+
+			if _, err = c.Expectf("Passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(ti.Passphrase); err != nil {
+				return
+			}
+			if _, err = c.Expectf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;", socket); err != nil {
+				return
+			}
+
+			var conn net.Conn
+			for i := 0; ; i++ {
+				if conn, err = net.Dial("unix", socket); err == nil {
+					break
+				}
+				if i == 50 {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			defer conn.Close()
+			client := agent.NewClient(conn)
+
+			var keys []*agent.Key
+			if keys, err = client.List(); err != nil {
+				return
+			}
+			if len(keys) != 1 || keys[0].Comment != ti.ID ||
+				string(keys[0].Marshal()) != string(public.Marshal()) {
+				err = fmt.Errorf("expected the agent to hold the identity's key, got %v", keys)
+				return
+			}
+
+			// only ssh authentication requests and SSHSIG signatures are signed
+			if _, refused := client.Sign(public, []byte("some data to sign")); refused == nil {
+				err = fmt.Errorf("expected the agent to refuse to sign arbitrary data")
+				return
+			}
+
+			for _, data := range [][]byte{userAuthRequest(public), sshsig("git")} {
+				var signature *ssh.Signature
+				if signature, err = client.Sign(public, data); err != nil {
+					return
+				}
+				if err = public.Verify(data, signature); err != nil {
+					return
+				}
+			}
+
+			if err = client.Lock([]byte("lock")); err != nil {
+				return
+			}
+			if client.Unlock([]byte("incorrect")) == nil {
+				err = fmt.Errorf("expected the agent to refuse an incorrect passphrase")
+				return
+			}
+			if err = client.Unlock([]byte("lock")); err != nil {
+				return
+			}
+
+			if client.RemoveAll() == nil {
+				err = fmt.Errorf("expected the agent to refuse to remove its key")
+			}
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != 0 {
+		t.Fatal(ErrorNonZeroExit{result.Status})
+	}
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatal("expected the agent's socket to be removed")
+	}
+}
+
+func TestSSHAgentServerIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identify-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	environment := map[string]string{
+		"IDENTIFY_DB_PATH":       dbPath,
+		"IDENTIFY_TOKEN_DB_PATH": tokenDBPath,
+	}
+
+	// the administrator is the server identity of the tests, as for `identify listen`
+	arguments := []string{"ssh-agent", fmt.Sprintf("-socket=%s", socket), fmt.Sprintf("-id=%s", administrator.ID)}
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			// an agent that serves the key anyway is stopped, and exits cleanly
+			defer func() {
+				done := In(2*time.Second, func() {
+					c.Tty().Close()
+					cancel()
+				})
+				c.ExpectEOF()
+				<-done
+			}()
+
+			if _, err = c.Expectf("Passphrase: "); err != nil {
+				return
+			}
+			_, err = c.SendLine(administrator.Passphrase)
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status == 0 {
+		t.Fatal("expected the agent to refuse to serve the server identity")
+	}
+}
+
+// userAuthRequest returns the data ssh signs to authenticate with a key.
+func userAuthRequest(key ssh.PublicKey) []byte {
+	return ssh.Marshal(struct {
+		Session   []byte
+		Type      byte
+		User      string
+		Service   string
+		Method    string
+		Signed    bool
+		Algorithm string
+		Key       []byte
+	}{[]byte("session"), 50, "git", "ssh-connection", "publickey", true, key.Type(), key.Marshal()})
+}
+
+// sshsig returns the data ssh-keygen signs for a message in a namespace.
+func sshsig(namespace string) []byte {
+	hash := sha512.Sum512([]byte("a message"))
+	return append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, nil, "sha512", hash[:]})...)
+}
+
+func TestSSHCertificate(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// aliases and group names are chosen by identities, so they aren't principals
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"group", "new", "root"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"alias", "add", "deploy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only identities permitted to manage principals may set them
+	principal := gofakeit.Lexify("user-????????")
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"set", "ssh-principals", ti.ID, "root"}); err == nil {
+		t.Fatal("expected an identity to be refused setting its own principals")
+	}
+	if _, err := RunAuthenticatedCommand(t, administrator, []string{"set", "ssh-principals", ti.ID, principal}); err != nil {
+		t.Fatal(err)
+	}
+
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	line, err := RunCommand(t, environment, []string{"get", "ssh-key", administrator.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "identify-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519.pub")
+	if err := ioutil.WriteFile(keyPath, ssh.MarshalAuthorizedKey(key), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the administrator acts as the certificate authority
+	_, err = RunAuthenticatedCommand(t, administrator, []string{
+		"new", "ssh-certificate", "-identity=" + ti.Alias, "-validity=10m",
+		"-source-address=10.0.0.0/8", keyPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate := readSSHCertificate(t, filepath.Join(dir, "id_ed25519-cert.pub"))
+	if !bytes.Equal(certificate.Key.Marshal(), key.Marshal()) || certificate.KeyId != ti.ID ||
+		certificate.CertType != ssh.UserCert {
+		t.Fatalf("unexpected certificate: %+v", certificate)
+	}
+	if strings.Join(certificate.ValidPrincipals, ",") != strings.Join([]string{ti.ID, principal}, ",") {
+		t.Fatalf("expected the identity's principals, got %v", certificate.ValidPrincipals)
+	}
+	if certificate.CriticalOptions["source-address"] != "10.0.0.0/8" {
+		t.Fatalf("unexpected critical options: %v", certificate.CriticalOptions)
+	}
+	if time.Until(time.Unix(int64(certificate.ValidBefore), 0)) > 10*time.Minute {
+		t.Fatal("expected the certificate to expire within its validity")
+	}
+
+	checker := ssh.CertChecker{IsUserAuthority: func(k ssh.PublicKey) bool {
+		return bytes.Equal(k.Marshal(), authority.Marshal())
+	}}
+	if err := checker.CheckCert(principal, certificate); err != nil {
+		t.Fatal(err)
+	}
+
+	// identities can't be certified for principals that aren't theirs, even
+	// those named after their groups or aliases
+	for _, p := range []string{"root", "deploy"} {
+		_, err = RunAuthenticatedCommand(t, ti, []string{"new", "ssh-certificate", "-principals=" + p, keyPath})
+		if err == nil {
+			t.Fatalf("expected a certificate for principal %s to be refused", p)
+		}
+	}
+
+	// without a key file, the identity's own key is certified and printed
+	output, err := RunAuthenticatedCommand(t, ti, []string{
+		"new", "ssh-certificate", "-principals=" + principal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, ok := parsed.(*ssh.Certificate)
+	if !ok || own.KeyId != ti.ID || len(own.ValidPrincipals) != 1 {
+		t.Fatalf("unexpected certificate: %s", output)
+	}
+
+	// host certificates require permission to issue them
+	hostArguments := []string{"new", "ssh-certificate", "-host", "-principals=host.example.com", keyPath}
+	if _, err := RunAuthenticatedCommand(t, ti, hostArguments); err == nil {
+		t.Fatal("expected a host certificate to require permission")
+	}
+	if _, err := RunAuthenticatedCommand(t, administrator, hostArguments); err != nil {
+		t.Fatal(err)
+	}
+	host := readSSHCertificate(t, filepath.Join(dir, "id_ed25519-cert.pub"))
+	if host.CertType != ssh.HostCert || host.ValidPrincipals[0] != "host.example.com" {
+		t.Fatalf("unexpected host certificate: %+v", host)
+	}
+
+	knownHosts, err := RunCommand(t, environment, []string{
+		"get", "ssh-key", "-cert-authority=*.example.com", administrator.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if knownHosts != "@cert-authority *.example.com "+line {
+		t.Fatalf("unexpected known_hosts line: %s", knownHosts)
+	}
+}
+
+func readSSHCertificate(t *testing.T, path string) *ssh.Certificate {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, ok := key.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("expected %s to hold a certificate", path)
+	}
+	return certificate
+}
@@ -0,0 +1,113 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/go-expect"
+
+	"github.com/akb/identify/internal/totp"
+)
+
+var TOTPSecretPattern = regexp.MustCompile(`Secret: ([A-Z2-7]+)`)
+
+func TestTOTP(t *testing.T) {
+	ti, err := GenerateNewIdentity(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := EnrollTOTP(t, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"get", "attributes"},
+		Prompt{"Authentication code: ", "000000"}); err == nil {
+		t.Fatal("expected an invalid authentication code to be rejected")
+	}
+
+	// the code of the current step was used to enroll, so use the next one
+	code := totp.Code(secret, totp.Counter(time.Now())+1)
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"get", "attributes"},
+		Prompt{"Authentication code: ", code}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunAuthenticatedCommand(t, ti, []string{"delete", "totp"},
+		Prompt{"Authentication code: ", code}); err == nil {
+		t.Fatal("expected an authentication code to only be accepted once")
+	}
+}
+
+// EnrollTOTP enrolls a second factor for an identity and returns its secret.
+func EnrollTOTP(t *testing.T, ti *TestIdentity) ([]byte, error) {
+	environment := map[string]string{"IDENTIFY_DB_PATH": dbPath}
+	arguments := []string{"new", "totp", fmt.Sprintf("-id=%s", ti.ID)}
+
+	var secret []byte
+	var err error
+	t.Logf("running '%s'", strings.Join(arguments, " "))
+	result := RunCommandTest(t, environment, arguments,
+		func(c *expect.Console, cancel context.CancelFunc) {
+			if _, err = c.ExpectString("Passphrase: "); err != nil {
+				return
+			}
+			if _, err = c.SendLine(ti.Passphrase); err != nil {
+				return
+			}
+
+			var output string
+			if output, err = c.ExpectString("Authentication code: "); err != nil {
+				return
+			}
+
+			match := TOTPSecretPattern.FindStringSubmatch(output)
+			if match == nil {
+				err = fmt.Errorf("expected a secret to be displayed, got:\n%s", output)
+				return
+			}
+			if secret, err = totp.Encoding.DecodeString(match[1]); err != nil {
+				return
+			}
+
+			if _, err = c.SendLine(totp.Code(secret, totp.Counter(time.Now()))); err != nil {
+				return
+			}
+
+			done := In(100*time.Millisecond, func() { c.Tty().Close() })
+			_, err = c.ExpectEOF()
+			<-done
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Status != 0 {
+		return nil, ErrorNonZeroExit{result.Status}
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,176 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/akb/identify/web"
+)
+
+func TestAttributeClaims(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email := gofakeit.Email()
+	for _, attribute := range []web.NewAttributeRequest{
+		{Name: "email", Value: email, Claim: true},
+		{Name: "phone", Value: gofakeit.Phone(), Sealed: true},
+	} {
+		if err := tc.SetAttribute(accessToken, id, attribute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	accessToken, err = tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.MapClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(accessToken, &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	if claims["email"] != email {
+		t.Fatalf("expected token to include the email claim, got %v", claims)
+	}
+	if _, ok := claims["phone"]; ok {
+		t.Fatalf("expected token to exclude attributes that aren't claims, got %v", claims)
+	}
+	if claims["identity"] != id {
+		t.Fatalf("expected token to be issued to %s, got %v", id, claims)
+	}
+}
+
+func TestAuthorizationHeaderOverridesCookie(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logging in sets the cookie, which a forged request would carry along
+	// with a header to skip the CSRF check
+	if _, err := tc.NewToken(id, passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	attribute := web.NewAttributeRequest{Name: "email", Value: gofakeit.Email()}
+	if err := tc.SetAttribute("forged", id, attribute); err == nil {
+		t.Fatal("expected a request with an invalid Authorization header to be refused")
+	}
+}
+
+func TestGroupClaims(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := tc.identities.GetIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := tc.identities.NewGroup(tc.PrivateIdentity, gofakeit.Lexify("team-????????"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.identities.AddGroupMember(tc.PrivateIdentity, group.ID, public); err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.MapClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(accessToken, &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, ok := claims["groups"].([]interface{})
+	if !ok || len(groups) != 1 || groups[0] != group.ID {
+		t.Fatalf("expected token to include the groups claim, got %v", claims)
+	}
+}
+
+func (tc *testClient) NewToken(id, passphrase string) (string, error) {
+	newTokenForm, err := tc.FetchNewTokenForm()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := newTokenForm.Submit(id, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return result.GetToken()
+}
+
+func (tc *testClient) SetAttribute(accessToken, id string, attribute web.NewAttributeRequest) error {
+	body, err := json.Marshal(attribute)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("https://localhost:8443/identities/%s/attributes", id),
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := tc.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		return fmt.Errorf("expected 201 status code, received %d", response.StatusCode)
+	}
+	return nil
+}
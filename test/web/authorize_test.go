@@ -0,0 +1,148 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/policy"
+	"github.com/akb/identify/web"
+)
+
+func TestAuthorize(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := policy.Parse([]byte(`{
+	  "name": "reports",
+	  "rules": [{
+	    "id": "operators-read",
+	    "effect": "allow",
+	    "actions": ["reports:read"],
+	    "resources": ["reports/*"],
+	    "conditions": [{"field": "claims.roles", "op": "contains", "value": "operator"}]
+	  }]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.identities.PutPolicy(tc.PrivateIdentity, p); err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.LogOut()
+
+	request := web.AuthorizeRequest{Action: "reports:read", Resource: "reports/q3"}
+	status, err := tc.PostJSONWithToken("/authorize", userToken, request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusForbidden {
+		t.Fatalf("expected identities without policies:evaluate to be forbidden, got %d", status)
+	}
+
+	serverToken, err := tc.NewToken("self", tc.passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Token = userToken
+	request.Explain = true
+	var decision policy.Decision
+	if _, err := tc.PostJSONWithToken("/authorize", serverToken, request, &decision); err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed || len(decision.Trace) != 1 ||
+		!strings.HasPrefix(decision.Trace[0].Reason, "condition 1 failed: claims.roles is [user]") {
+		t.Fatalf("expected the request to be denied with an explanation, got %+v", decision)
+	}
+
+	if err := tc.identities.AssignRole(tc.PrivateIdentity, id, identity.RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+
+	// roles are taken from the store, so the token issued before the role was
+	// assigned is enough
+	request.Explain = false
+	decision = policy.Decision{}
+	if _, err := tc.PostJSONWithToken("/authorize", serverToken, request, &decision); err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Allowed {
+		t.Fatalf("expected the stored roles of the subject to be used, got %+v", decision)
+	}
+	request.Token, err = tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.LogOut()
+
+	decision = policy.Decision{}
+	if _, err := tc.PostJSONWithToken("/authorize", serverToken, request, &decision); err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Allowed || decision.Policy != "reports" || decision.Version != 1 ||
+		decision.Rule != "operators-read" || decision.Trace != nil {
+		t.Fatalf("expected the request to be allowed by operators-read, got %+v", decision)
+	}
+
+	if err := tc.identities.DisableIdentity(tc.PrivateIdentity, id); err != nil {
+		t.Fatal(err)
+	}
+	status, err = tc.PostJSONWithToken("/authorize", serverToken, request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusForbidden {
+		t.Fatalf("expected the token of a disabled identity to be refused, got %d", status)
+	}
+
+	if err := tc.identities.DeleteIdentity(tc.PrivateIdentity, id); err != nil {
+		t.Fatal(err)
+	}
+	status, err = tc.PostJSONWithToken("/authorize", serverToken, request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected the token of a deleted identity to be rejected, got %d", status)
+	}
+
+	request.Token = "not a token"
+	status, err = tc.PostJSONWithToken("/authorize", serverToken, request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected an invalid token to be rejected, got %d", status)
+	}
+}
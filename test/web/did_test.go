@@ -0,0 +1,117 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v5"
+
+	"github.com/akb/identify/internal/did"
+	"github.com/akb/identify/internal/identity"
+)
+
+func TestDIDDocument(t *testing.T) {
+	tc := NewTestClient(t)
+
+	alias := gofakeit.Username()
+	id, err := tc.CreateNewIdentity(alias, gofakeit.Password(true, true, true, true, true, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := tc.identities.GetIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identifier := identity.DID(public)
+	sealIdentifier := identity.SealDID(public)
+
+	for _, name := range []string{id, alias, identifier} {
+		document := getDIDDocument(t, tc, name)
+		if document.ID != identifier || len(document.VerificationMethod) != 1 ||
+			len(document.Authentication) != 1 || len(document.KeyAgreement) != 0 {
+			t.Fatalf("unexpected DID document: %+v", document)
+		}
+	}
+
+	// the document of the seal key's DID lists it for key agreement only
+	document := getDIDDocument(t, tc, sealIdentifier)
+	if document.ID != sealIdentifier || len(document.VerificationMethod) != 1 ||
+		len(document.Authentication) != 0 || len(document.KeyAgreement) != 1 ||
+		document.VerificationMethod[0].ID != document.KeyAgreement[0] {
+		t.Fatalf("unexpected DID document: %+v", document)
+	}
+
+	key, err := did.Parse(document.KeyAgreement[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal := public.SealPublicKey()
+	if key.Type != did.KeyX25519 || string(key.PublicKey) != string(seal[:]) {
+		t.Fatal("expected the key agreement key to be the seal key of the identity")
+	}
+
+	response, err := tc.Get("https://localhost:8443/.well-known/did/did:key:z6MkUnknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected an unknown DID not to be found, got %d", response.StatusCode)
+	}
+
+	// identifiers longer than any key are refused without being decoded
+	started := time.Now()
+	response, err = tc.Get("https://localhost:8443/.well-known/did/did:key:z" + strings.Repeat("z", 64*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected an oversized DID not to be found, got %d", response.StatusCode)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected an oversized DID to be refused quickly, took %s", elapsed)
+	}
+}
+
+func getDIDDocument(t *testing.T, tc *testClient, name string) did.Document {
+	response, err := tc.Get("https://localhost:8443/.well-known/did/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 status code for %s, received %d", name, response.StatusCode)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != did.MediaType {
+		t.Fatalf("unexpected content type %s", contentType)
+	}
+
+	var document did.Document
+	if err := json.NewDecoder(response.Body).Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
@@ -0,0 +1,232 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/web"
+)
+
+func TestListIdentities(t *testing.T) {
+	tc := NewTestClient(t)
+
+	alias := gofakeit.Username()
+	id, err := tc.CreateNewIdentity(alias, gofakeit.Password(true, true, true, true, true, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.LogIn("self", tc.passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := tc.ListIdentities("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Identities) != 2 {
+		t.Fatalf("expected two identities, got %v", page.Identities)
+	}
+
+	page, err = tc.ListIdentities("?limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Identities) != 1 || page.Next == "" {
+		t.Fatalf("expected one identity and a cursor, got %v", page)
+	}
+
+	rest, err := tc.ListIdentities("?limit=1&cursor=" + page.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rest.Identities) != 1 || rest.Next != "" ||
+		rest.Identities[0].ID == page.Identities[0].ID {
+		t.Fatalf("expected the other identity and no cursor, got %v", rest)
+	}
+
+	page, err = tc.ListIdentities("?alias=" + alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Identities) != 1 || page.Identities[0].ID != id {
+		t.Fatalf("expected only identity %s, got %v", id, page.Identities)
+	}
+
+	document, err := tc.Fetch("https://localhost:8443/identities")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if document.Find("[data-testid=identity]").Length() != 2 {
+		t.Fatal("expected GET /identities to respond with a table of identities")
+	}
+}
+
+func TestListIdentitiesForbidden(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.LogIn(id, passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tc.ListIdentities(""); err == nil {
+		t.Fatal("expected identities other than the server's to be forbidden from listing")
+	}
+}
+
+func TestListIdentitiesOperator(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.identities.AssignRole(tc.PrivateIdentity, id, identity.RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.MapClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(accessToken, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(claims["roles"]) != "[user operator]" {
+		t.Fatalf("expected token to include the roles claim, got %v", claims)
+	}
+
+	page, err := tc.ListIdentities("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Identities) != 2 {
+		t.Fatalf("expected two identities, got %v", page.Identities)
+	}
+
+	// the token still claims the role, but it no longer grants anything
+	if err := tc.identities.RevokeRole(tc.PrivateIdentity, id, identity.RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.ListIdentities(""); err == nil {
+		t.Fatal("expected a revoked role to be refused before the token expires")
+	}
+}
+
+// LogIn requests an access token, which is kept as a cookie by the client.
+func TestTokensOfDisabledIdentities(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attribute := web.NewAttributeRequest{Name: "email", Value: gofakeit.Email()}
+	if err := tc.SetAttribute(accessToken, id, attribute); err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens stop working as soon as their identity is disabled or deleted,
+	// rather than when they expire
+	if err := tc.identities.DisableIdentity(tc.PrivateIdentity, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetAttribute(accessToken, id, attribute); err == nil {
+		t.Fatal("expected the token of a disabled identity to be refused")
+	}
+
+	if err := tc.identities.EnableIdentity(tc.PrivateIdentity, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetAttribute(accessToken, id, attribute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.identities.DeleteIdentity(tc.PrivateIdentity, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetAttribute(accessToken, id, attribute); err == nil {
+		t.Fatal("expected the token of a deleted identity to be refused")
+	}
+}
+
+func (tc *testClient) LogIn(id, passphrase string) error {
+	_, err := tc.NewToken(id, passphrase)
+	return err
+}
+
+// LogOut discards the access token kept by the client.
+func (tc *testClient) LogOut() {
+	u, _ := url.Parse("https://localhost:8443/")
+	tc.Jar.SetCookies(u, []*http.Cookie{{Name: "Authorization", Path: "/", MaxAge: -1}})
+}
+
+func (tc *testClient) ListIdentities(query string) (*web.ListIdentitiesResponse, error) {
+	request, err := http.NewRequest(http.MethodGet, "https://localhost:8443/identities"+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := tc.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected 200 status code, received %d", response.StatusCode)
+	}
+
+	var page web.ListIdentitiesResponse
+	if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
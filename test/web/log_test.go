@@ -0,0 +1,164 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/transparency"
+	"github.com/akb/identify/web"
+)
+
+func TestKeyTransparencyLog(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first transparency.TreeHead
+	if _, err := tc.GetJSON("/log", &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Signer != tc.PrivateIdentity.String() || first.Size != 2 {
+		t.Fatalf("unexpected head: %+v", first)
+	}
+	if err := first.Verify(tc.PrivateIdentity.Ed25519PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := tc.identities.GetIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := public.Authenticate(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := tc.identities.RotateKeys(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var second transparency.TreeHead
+	if _, err := tc.GetJSON("/log", &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Size != 3 {
+		t.Fatalf("expected the rotation to be logged, got a log of %d entries", second.Size)
+	}
+
+	var consistency web.ConsistencyProofResponse
+	location := fmt.Sprintf("/log/consistency?first=%d&second=%d", first.Size, second.Size)
+	if _, err := tc.GetJSON(location, &consistency); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := transparency.DecodeProof(consistency.Proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transparency.VerifyConsistency(first.Size, second.Size, first.Root, second.Root, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries web.LogEntriesResponse
+	if _, err := tc.GetJSON("/log/entries", &entries); err != nil {
+		t.Fatal(err)
+	}
+	var hashes [][]byte
+	var last *identity.LogEntry
+	for _, e := range entries.Entries {
+		leaf, err := identity.DecodeString(e.Leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last, err = identity.ParseLogEntry(leaf); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, transparency.LeafHash(leaf))
+	}
+	if string(transparency.RootHash(hashes)) != string(second.Root) {
+		t.Fatal("expected the entries to make up the signed root")
+	}
+	if last.Type != identity.LogRotate || last.Identity != id || last.KeyID != rotated.KeyID() {
+		t.Fatalf("unexpected log entry: %+v", last)
+	}
+
+	var keys web.KeysResponse
+	if _, err := tc.GetJSON("/identities/"+id+"/keys", &keys); err != nil {
+		t.Fatal(err)
+	}
+	if keys.KeyID != rotated.KeyID() || keys.LogIndex != 2 ||
+		keys.Ed25519PublicKey != last.Ed25519PublicKey {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+
+	var inclusion web.InclusionProofResponse
+	location = fmt.Sprintf("/log/inclusion?index=%d&size=%d", keys.LogIndex, second.Size)
+	if _, err := tc.GetJSON(location, &inclusion); err != nil {
+		t.Fatal(err)
+	}
+	if proof, err = transparency.DecodeProof(inclusion.Proof); err != nil {
+		t.Fatal(err)
+	}
+	err = transparency.VerifyInclusion(hashes[2], 2, second.Size, proof, second.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := tc.GetJSON("/log/inclusion?index=3&size=3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected an index outside the log to be rejected, got %d", status)
+	}
+}
+
+// GetJSON fetches a JSON resource into v, which must succeed unless v is nil.
+func (tc *testClient) GetJSON(location string, v interface{}) (int, error) {
+	request, err := http.NewRequest(http.MethodGet, "https://localhost:8443"+location, nil)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := tc.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if v == nil {
+		return response.StatusCode, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return response.StatusCode,
+			fmt.Errorf("expected 200 status code, received %d", response.StatusCode)
+	}
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(v)
+}
@@ -49,6 +49,11 @@ func init() {
 type testClient struct {
 	*http.Client
 	identity.PrivateIdentity
+
+	// passphrase of the identity the server runs as
+	passphrase string
+
+	identities identity.Store
 }
 
 func NewTestClient(t *testing.T) *testClient {
@@ -84,6 +89,16 @@ func NewTestClient(t *testing.T) *testClient {
 		log.Fatal(err.Error())
 	}
 
+	// the server identity administers the others, as `identify listen` makes it
+	if err := identityStore.BootstrapAdministrator(private); err != nil {
+		log.Printf("An error occurred while making the server identity an administrator:\n")
+		log.Fatal(err.Error())
+	}
+	if err := identityStore.RecordServerIdentity(private); err != nil {
+		log.Printf("An error occurred while recording the server identity:\n")
+		log.Fatal(err.Error())
+	}
+
 	certificatePath := filepath.Join(dir, "certificate.pem")
 	certificateKeyPath := filepath.Join(dir, "certificate.key")
 	if err := certificate.Generate(private, certificatePath, certificateKeyPath); err != nil {
@@ -101,6 +116,7 @@ func NewTestClient(t *testing.T) *testClient {
 		Identity:      private,
 		IdentityStore: identityStore,
 		TokenStore:    tokenStore,
+		Origin:        "https://localhost:8443",
 	})
 	if err != nil {
 		log.Fatal(err.Error())
@@ -157,6 +173,8 @@ func NewTestClient(t *testing.T) *testClient {
 			},
 		},
 		private,
+		passphrase,
+		identityStore,
 	}
 }
 
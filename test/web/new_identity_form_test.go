@@ -30,6 +30,10 @@ import (
 func TestNewIdentityForm(t *testing.T) {
 	tc := NewTestClient(t)
 
+	if err := tc.LogIn("self", tc.passphrase); err != nil {
+		t.Fatal(err)
+	}
+
 	newIdentityForm, err := tc.FetchNewIdentityForm()
 	if err != nil {
 		t.Fatal(err)
@@ -44,7 +48,36 @@ func TestNewIdentityForm(t *testing.T) {
 	}
 }
 
+func TestNewIdentityForbidden(t *testing.T) {
+	tc := NewTestClient(t)
+
+	if _, err := tc.FetchNewIdentityForm(); err == nil {
+		t.Fatal("expected the new identity form to require logging in")
+	}
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.LogIn(id, passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tc.FetchNewIdentityForm(); err == nil {
+		t.Fatal("expected identities without identities:create to be forbidden from creating others")
+	}
+}
+
+// CreateNewIdentity creates an identity as the server identity, which is an
+// administrator, and logs out again.
 func (tc *testClient) CreateNewIdentity(alias, passphrase string) (string, error) {
+	if err := tc.LogIn("self", tc.passphrase); err != nil {
+		return "", err
+	}
+	defer tc.LogOut()
+
 	form, err := tc.FetchNewIdentityForm()
 	if err != nil {
 		return "", err
@@ -150,3 +183,11 @@ type NewIdentityResult struct {
 func (r *NewIdentityResult) GetID() string {
 	return r.Find("[data-testid=id]").First().Text()
 }
+
+func (r *NewIdentityResult) GetRecoveryCodes() []string {
+	var codes []string
+	r.Find("[data-testid=recovery-code]").Each(func(_ int, s *goquery.Selection) {
+		codes = append(codes, s.Text())
+	})
+	return codes
+}
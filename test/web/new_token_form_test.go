@@ -159,6 +159,10 @@ func (f *NewTokenForm) GetCSRFToken() (string, error) {
 }
 
 func (f *NewTokenForm) Submit(id, passphrase string) (*NewTokenResult, error) {
+	return f.SubmitWithCode(id, passphrase, "")
+}
+
+func (f *NewTokenForm) SubmitWithCode(id, passphrase, code string) (*NewTokenResult, error) {
 	csrfToken, err := f.GetCSRFToken()
 	if err != nil {
 		return nil, err
@@ -168,6 +172,7 @@ func (f *NewTokenForm) Submit(id, passphrase string) (*NewTokenResult, error) {
 		"csrf_token": []string{csrfToken},
 		"id":         []string{id},
 		"passphrase": []string{passphrase},
+		"code":       []string{code},
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,85 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+)
+
+func TestRecover(t *testing.T) {
+	tc := NewTestClient(t)
+
+	if err := tc.LogIn("self", tc.passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := tc.FetchNewIdentityForm()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := form.Submit("", gofakeit.Password(true, true, true, true, true, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := result.GetID()
+	codes := result.GetRecoveryCodes()
+	if len(codes) == 0 {
+		t.Fatal("expected new identity page to include recovery codes")
+	}
+	tc.LogOut()
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	if err := tc.Recover(id, codes[0], passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.LogIn(id, passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.Recover(id, codes[0], passphrase); err == nil {
+		t.Fatal("expected a recovery code to only be accepted once")
+	}
+}
+
+func (tc *testClient) Recover(id, code, passphrase string) error {
+	document, err := tc.Fetch("https://localhost:8443/recover/new")
+	if err != nil {
+		return err
+	}
+
+	csrfToken, exists := document.Find("[name=csrf_token]").Attr("value")
+	if !exists {
+		return fmt.Errorf("could not find csrf token in recover form")
+	}
+
+	_, err = tc.Submit("https://localhost:8443/recover", url.Values{
+		"csrf_token":         []string{csrfToken},
+		"id":                 []string{id},
+		"recovery-code":      []string{code},
+		"new-passphrase":     []string{passphrase},
+		"confirm-passphrase": []string{passphrase},
+	})
+	return err
+}
@@ -0,0 +1,161 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v5"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/web"
+)
+
+func TestSSHCertificates(t *testing.T) {
+	tc := NewTestClient(t)
+
+	response, err := tc.Get("https://localhost:8443/ssh/ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	line, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority, _, _, _, err := ssh.ParseAuthorizedKey(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(authority.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey),
+		tc.PrivateIdentity.Ed25519PublicKey()) {
+		t.Fatal("expected the server identity to be the certificate authority")
+	}
+
+	alias := gofakeit.Username()
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity(alias, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.LogOut()
+
+	principal := gofakeit.Lexify("user-????????")
+	if err := tc.identities.SetSSHPrincipals(tc.PrivateIdentity, id, []string{principal}); err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := web.NewSSHCertificateRequest{
+		Type:         identity.SSHUserCertificate,
+		PublicKey:    string(ssh.MarshalAuthorizedKey(key)),
+		Validity:     300,
+		ForceCommand: "/usr/bin/true",
+	}
+
+	var issued web.SSHCertificateResponse
+	status, err := tc.PostJSONWithToken("/ssh/certificates", accessToken, request, &issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201 status code, received %d", status)
+	}
+	if issued.KeyID != id || len(issued.Principals) != 2 || issued.Principals[1] != principal ||
+		issued.ValidBefore.Sub(issued.ValidAfter) > 10*time.Minute {
+		t.Fatalf("unexpected certificate: %+v", issued)
+	}
+
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(issued.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := parsed.(*ssh.Certificate)
+	checker := ssh.CertChecker{
+		IsUserAuthority: func(k ssh.PublicKey) bool {
+			return bytes.Equal(k.Marshal(), authority.Marshal())
+		},
+		SupportedCriticalOptions: []string{"force-command"},
+	}
+	if err := checker.CheckCert(principal, certificate); err != nil {
+		t.Fatal(err)
+	}
+	if certificate.CriticalOptions["force-command"] != "/usr/bin/true" {
+		t.Fatalf("unexpected critical options: %v", certificate.CriticalOptions)
+	}
+
+	for _, refused := range []struct {
+		request web.NewSSHCertificateRequest
+		status  int
+	}{
+		{web.NewSSHCertificateRequest{Type: identity.SSHUserCertificate, Principals: []string{"root"}}, 400},
+		{web.NewSSHCertificateRequest{Type: identity.SSHUserCertificate, Principals: []string{alias}}, 400},
+		{web.NewSSHCertificateRequest{Type: identity.SSHUserCertificate, Validity: 7 * 24 * 3600}, 400},
+		{web.NewSSHCertificateRequest{Type: identity.SSHUserCertificate, SourceAddresses: []string{"nowhere"}}, 400},
+		{web.NewSSHCertificateRequest{Type: "client"}, 400},
+		{web.NewSSHCertificateRequest{Type: identity.SSHHostCertificate, Principals: []string{"host.example.com"}}, 403},
+	} {
+		refused.request.PublicKey = request.PublicKey
+		status, err := tc.PostJSONWithToken("/ssh/certificates", accessToken, refused.request, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != refused.status {
+			t.Fatalf("expected %d status code for %+v, received %d", refused.status, refused.request, status)
+		}
+	}
+	tc.LogOut()
+
+	// the server identity is an administrator, which may issue host certificates
+	accessToken, err = tc.NewToken(tc.PrivateIdentity.String(), tc.passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request = web.NewSSHCertificateRequest{
+		Type:       identity.SSHHostCertificate,
+		PublicKey:  request.PublicKey,
+		Principals: []string{"host.example.com"},
+	}
+	status, err = tc.PostJSONWithToken("/ssh/certificates", accessToken, request, &issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusCreated || issued.Principals[0] != "host.example.com" {
+		t.Fatalf("unexpected host certificate response %d: %+v", status, issued)
+	}
+}
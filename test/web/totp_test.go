@@ -0,0 +1,172 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v5"
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/akb/identify/internal/totp"
+)
+
+func TestTOTP(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amr := AuthenticationMethods(t, accessToken); amr != "[pwd]" {
+		t.Fatalf("expected passphrase authentication, got %s", amr)
+	}
+
+	public, err := tc.identities.GetIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := public.Authenticate(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := totp.Counter(time.Now())
+	if err := tc.identities.EnrollTOTP(private, secret, totp.Code(secret, counter)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tc.NewToken(id, passphrase); err == nil {
+		t.Fatal("expected a token to require an authentication code once enrolled")
+	}
+
+	newTokenForm, err := tc.FetchNewTokenForm()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := newTokenForm.SubmitWithCode(id, passphrase, totp.Code(secret, counter+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err = result.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amr := AuthenticationMethods(t, accessToken); amr != "[pwd otp mfa]" {
+		t.Fatalf("expected multi-factor authentication, got %s", amr)
+	}
+}
+
+func TestTOTPChangePassphrase(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := tc.identities.GetIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := public.Authenticate(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := totp.Counter(time.Now())
+	if err := tc.identities.EnrollTOTP(private, secret, totp.Code(secret, counter)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the passphrase alone can't change the passphrase once enrolled
+	newPassphrase := gofakeit.Password(true, true, true, true, true, 24)
+	status, err := tc.ChangePassphrase(id, passphrase, newPassphrase, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code without an authentication code, received %d", status)
+	}
+
+	status, err = tc.ChangePassphrase(id, passphrase, newPassphrase, totp.Code(secret, counter+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("expected 200 status code, received %d", status)
+	}
+}
+
+// ChangePassphrase posts the change passphrase form and returns the status code
+// of the response.
+func (tc *testClient) ChangePassphrase(id, passphrase, newPassphrase, code string) (int, error) {
+	document, err := tc.Fetch("https://localhost:8443/passphrase/edit")
+	if err != nil {
+		return 0, err
+	}
+
+	csrfToken, exists := document.Find("[name=csrf_token]").Attr("value")
+	if !exists {
+		return 0, fmt.Errorf("could not find csrf token in change passphrase form")
+	}
+
+	response, err := tc.PostForm("https://localhost:8443/passphrase", url.Values{
+		"csrf_token":         []string{csrfToken},
+		"id":                 []string{id},
+		"passphrase":         []string{passphrase},
+		"new-passphrase":     []string{newPassphrase},
+		"confirm-passphrase": []string{newPassphrase},
+		"code":               []string{code},
+	})
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.StatusCode, nil
+}
+
+// AuthenticationMethods returns the amr claim of a token.
+func AuthenticationMethods(t *testing.T, accessToken string) string {
+	var claims jwt.MapClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(accessToken, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(claims["amr"])
+}
@@ -0,0 +1,472 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v5"
+
+	"github.com/akb/identify/internal/webauthn"
+	"github.com/akb/identify/web"
+)
+
+const testOrigin = "https://localhost:8443"
+
+func TestWebAuthn(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := newVirtualAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.RegisterCredential(accessToken, authenticator, testOrigin); err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := tc.identities.GetIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(public.Credentials()) != 1 {
+		t.Fatalf("expected 1 credential, found %d", len(public.Credentials()))
+	}
+
+	if err := tc.RegisterCredential(accessToken, authenticator, testOrigin); err == nil {
+		t.Fatal("expected a credential to only be registered once")
+	}
+
+	csrfToken, err := tc.FetchWebAuthnLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := tc.Assert(csrfToken, id, authenticator, testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response web.NewTokenResponse
+	status, err := tc.PostJSON("/webauthn/login", csrfToken, request, &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201 status code, received %d", status)
+	}
+	if amr := AuthenticationMethods(t, response.Token); amr != "[hwk mfa]" {
+		t.Fatalf("expected hardware key authentication, got %s", amr)
+	}
+
+	// the login sets the same cookie as a passphrase
+	document, err := tc.Fetch("https://localhost:8443/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&Dashboard{document}).Test(t)
+
+	status, err = tc.PostJSON("/webauthn/login", csrfToken, request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected a replayed assertion to be rejected, received %d", status)
+	}
+
+	request, err = tc.Assert(csrfToken, id, authenticator, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = tc.PostJSON("/webauthn/login", csrfToken, request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected an assertion for another origin to be rejected, received %d", status)
+	}
+
+	// presence alone doesn't stand in for a passphrase
+	authenticator.unverified = true
+	request, err = tc.Assert(csrfToken, id, authenticator, testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = tc.PostJSON("/webauthn/login", csrfToken, request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected an assertion without user verification to be rejected, received %d", status)
+	}
+
+	location := fmt.Sprintf("https://localhost:8443/identities/%s/credentials/%s",
+		id, webauthn.Encoding.EncodeToString(authenticator.id))
+	remove, err := http.NewRequest(http.MethodDelete, location, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove.Header.Set("Authorization", "Bearer "+accessToken)
+	deleted, err := tc.Do(remove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted.Body.Close()
+	if deleted.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 status code, received %d", deleted.StatusCode)
+	}
+
+	if _, err := tc.Assert(csrfToken, id, authenticator, testOrigin); err == nil {
+		t.Fatal("expected login to fail once the credential was removed")
+	}
+}
+
+func TestWebAuthnRegistrationRequiresChallenge(t *testing.T) {
+	tc := NewTestClient(t)
+
+	passphrase := gofakeit.Password(true, true, true, true, true, 24)
+	id, err := tc.CreateNewIdentity("", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := tc.NewToken(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := newVirtualAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := authenticator.Create(web.CredentialCreationOptions{
+		Challenge:    webauthn.Encoding.EncodeToString(challenge),
+		RelyingParty: web.RelyingPartyEntity{ID: "localhost"},
+	}, testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := tc.PostJSONWithToken("/webauthn/register", accessToken, credential, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected an unissued challenge to be rejected, received %d", status)
+	}
+}
+
+// RegisterCredential runs the registration ceremony with an authenticator.
+func (tc *testClient) RegisterCredential(
+	accessToken string, a *virtualAuthenticator, origin string,
+) error {
+	var options web.CredentialCreationOptions
+	status, err := tc.PostJSONWithToken("/webauthn/register/options", accessToken,
+		struct{}{}, &options)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("expected 200 status code, received %d", status)
+	}
+
+	for _, c := range options.ExcludeCredentials {
+		if c.ID == webauthn.Encoding.EncodeToString(a.id) {
+			return fmt.Errorf("authenticator is already registered")
+		}
+	}
+
+	credential, err := a.Create(options, origin)
+	if err != nil {
+		return err
+	}
+
+	status, err = tc.PostJSONWithToken("/webauthn/register", accessToken, credential, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		return fmt.Errorf("expected 201 status code, received %d", status)
+	}
+	return nil
+}
+
+// FetchWebAuthnLogin fetches the passkey login page and returns its CSRF token.
+func (tc *testClient) FetchWebAuthnLogin() (string, error) {
+	document, err := tc.Fetch("https://localhost:8443/webauthn/login")
+	if err != nil {
+		return "", err
+	}
+
+	csrfToken, ok := document.Find("input[name=csrf_token]").Attr("value")
+	if !ok {
+		return "", fmt.Errorf("login page has no csrf token")
+	}
+	return csrfToken, nil
+}
+
+// Assert begins a login and returns the authenticator's response to it.
+func (tc *testClient) Assert(
+	csrfToken, id string, a *virtualAuthenticator, origin string,
+) (*web.LoginRequest, error) {
+	var options web.CredentialRequestOptions
+	status, err := tc.PostJSON("/webauthn/login/options", csrfToken,
+		web.LoginOptionsRequest{Identity: id}, &options)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("expected 200 status code, received %d", status)
+	}
+
+	credential, err := a.Get(options, origin)
+	if err != nil {
+		return nil, err
+	}
+	return &web.LoginRequest{Identity: id, Credential: *credential}, nil
+}
+
+func (tc *testClient) PostJSON(location, csrfToken string, body, v interface{}) (int, error) {
+	return tc.postJSON(location, map[string]string{"X-CSRF-Token": csrfToken}, body, v)
+}
+
+func (tc *testClient) PostJSONWithToken(location, accessToken string, body, v interface{}) (int, error) {
+	return tc.postJSON(location, map[string]string{"Authorization": "Bearer " + accessToken}, body, v)
+}
+
+func (tc *testClient) postJSON(location string, headers map[string]string, body, v interface{}) (int, error) {
+	marshaled, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, "https://localhost:8443"+location,
+		bytes.NewReader(marshaled))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/json")
+	for k, value := range headers {
+		request.Header.Set(k, value)
+	}
+
+	response, err := tc.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if v != nil && response.StatusCode < 300 {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			return 0, err
+		}
+	}
+	return response.StatusCode, nil
+}
+
+// virtualAuthenticator is a software authenticator holding a single ES256
+// credential, which verifies its user on every ceremony unless unverified.
+type virtualAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	count      uint32
+	unverified bool
+}
+
+func newVirtualAuthenticator() (*virtualAuthenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &virtualAuthenticator{key: key, id: id}, nil
+}
+
+func (a *virtualAuthenticator) Create(
+	options web.CredentialCreationOptions, origin string,
+) (*web.PublicKeyCredential, error) {
+	clientDataJSON, err := json.Marshal(map[string]string{
+		"type":      "webauthn.create",
+		"challenge": options.Challenge,
+		"origin":    origin,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := cborMap(
+		cborInt(1), cborInt(2),
+		cborInt(3), cborInt(webauthn.AlgorithmES256),
+		cborInt(-1), cborInt(1),
+		cborInt(-2), cborBytes(padCoordinate(a.key.X.Bytes())),
+		cborInt(-3), cborBytes(padCoordinate(a.key.Y.Bytes())),
+	)
+
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(a.id)))
+	attested = append(append(attested, a.id...), publicKey...)
+
+	authenticatorData := a.authenticatorData(options.RelyingParty.ID,
+		webauthn.FlagUserPresent|webauthn.FlagUserVerified|webauthn.FlagAttestedData)
+	authenticatorData = append(authenticatorData, attested...)
+
+	attestationObject := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(authenticatorData),
+	)
+
+	return &web.PublicKeyCredential{
+		ID:   webauthn.Encoding.EncodeToString(a.id),
+		Type: "public-key",
+		Response: web.AuthenticatorResponse{
+			ClientDataJSON:    webauthn.Encoding.EncodeToString(clientDataJSON),
+			AttestationObject: webauthn.Encoding.EncodeToString(attestationObject),
+		},
+	}, nil
+}
+
+func (a *virtualAuthenticator) Get(
+	options web.CredentialRequestOptions, origin string,
+) (*web.PublicKeyCredential, error) {
+	allowed := false
+	for _, c := range options.AllowCredentials {
+		allowed = allowed || c.ID == webauthn.Encoding.EncodeToString(a.id)
+	}
+	if !allowed {
+		return nil, fmt.Errorf("authenticator holds no allowed credential")
+	}
+
+	clientDataJSON, err := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": options.Challenge,
+		"origin":    origin,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	flags := byte(webauthn.FlagUserPresent | webauthn.FlagUserVerified)
+	if a.unverified {
+		flags = webauthn.FlagUserPresent
+	}
+	authenticatorData := a.authenticatorData(options.RelyingPartyID, flags)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+	r, sig, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, sig})
+	if err != nil {
+		return nil, err
+	}
+
+	return &web.PublicKeyCredential{
+		ID:   webauthn.Encoding.EncodeToString(a.id),
+		Type: "public-key",
+		Response: web.AuthenticatorResponse{
+			ClientDataJSON:    webauthn.Encoding.EncodeToString(clientDataJSON),
+			AuthenticatorData: webauthn.Encoding.EncodeToString(authenticatorData),
+			Signature:         webauthn.Encoding.EncodeToString(signature),
+		},
+	}, nil
+}
+
+func (a *virtualAuthenticator) authenticatorData(rpID string, flags byte) []byte {
+	a.count++
+
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.count)
+	return data
+}
+
+func padCoordinate(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		head := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(head[1:], uint16(n))
+		return head
+	}
+}
+
+func cborInt(n int64) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+func cborMap(pairs ...[]byte) []byte {
+	encoded := cborHead(5, uint64(len(pairs)/2))
+	for _, item := range pairs {
+		encoded = append(encoded, item...)
+	}
+	return encoded
+}
@@ -0,0 +1,170 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/akb/identify/internal/identity"
+)
+
+type AliasesResponse struct {
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases"`
+}
+
+type NewAliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+// identityResources routes requests for resources belonging to an identity.
+func (h *handler) identityResources(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/identities/"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case len(parts) == 2 && parts[1] == "aliases":
+		h.aliases(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "aliases":
+		h.alias(w, r, parts[0], parts[2])
+	case len(parts) == 2 && parts[1] == "attributes":
+		h.attributes(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "attributes":
+		h.attribute(w, r, parts[0], parts[2])
+	case len(parts) == 2 && parts[1] == "keys":
+		h.keys(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "credentials":
+		h.credentials(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "credentials":
+		h.credential(w, r, parts[0], parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) aliases(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		public, err := h.IdentityStore.GetIdentity(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, AliasesResponse{public.String(), public.Aliases()})
+
+	case http.MethodPost:
+		RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				public, ok := h.authorizeOwner(w, r, id)
+				if !ok {
+					return
+				}
+
+				var alias string
+				if hasContentType(r, "application/json") {
+					var request NewAliasRequest
+					if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+						http.Error(w, "unable to parse request body", http.StatusBadRequest)
+						return
+					}
+					alias = request.Alias
+				} else {
+					alias = r.PostFormValue("alias")
+				}
+
+				if err := h.IdentityStore.AddAlias(public.String(), alias); err != nil {
+					writeAliasError(w, err)
+					return
+				}
+
+				public, err := h.IdentityStore.GetIdentity(public.String())
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, http.StatusCreated, AliasesResponse{public.String(), public.Aliases()})
+			},
+		)).ServeHTTP(w, r)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) alias(w http.ResponseWriter, r *http.Request, id, alias string) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Only DELETE requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			public, ok := h.authorizeOwner(w, r, id)
+			if !ok {
+				return
+			}
+
+			if err := h.IdentityStore.RemoveAlias(public.String(), alias); err != nil {
+				writeAliasError(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)).ServeHTTP(w, r)
+}
+
+// authorizeOwner checks that the identity the request is about is the one the
+// request's access token was issued to.
+func (h *handler) authorizeOwner(
+	w http.ResponseWriter, r *http.Request, id string,
+) (identity.PublicIdentity, bool) {
+	public, err := h.IdentityStore.GetIdentity(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	if public.String() != SubjectFromContext(r.Context()) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	return public, true
+}
+
+func writeAliasError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case identity.ErrorAliasTaken:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		if err == identity.ErrorInvalidAlias || err == identity.ErrorUnknownAlias {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("error while updating aliases: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
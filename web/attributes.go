@@ -0,0 +1,156 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/akb/identify/internal/identity"
+)
+
+type AttributeResponse struct {
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
+	Sealed bool   `json:"sealed,omitempty"`
+	Claim  bool   `json:"claim,omitempty"`
+}
+
+type AttributesResponse struct {
+	ID         string              `json:"id"`
+	Attributes []AttributeResponse `json:"attributes"`
+}
+
+type NewAttributeRequest struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Sealed bool   `json:"sealed"`
+	Claim  bool   `json:"claim"`
+}
+
+// attributes serves the profile attributes of an identity. Sealed attributes
+// are returned without their values, which only the identity can open.
+func (h *handler) attributes(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			public, ok := h.authorizeOwner(w, r, id)
+			if !ok {
+				return
+			}
+
+			if r.Method == http.MethodGet {
+				writeJSON(w, http.StatusOK, newAttributesResponse(public))
+				return
+			}
+
+			var request NewAttributeRequest
+			if hasContentType(r, "application/json") {
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					http.Error(w, "unable to parse request body", http.StatusBadRequest)
+					return
+				}
+			} else {
+				request = NewAttributeRequest{
+					Name:   r.PostFormValue("name"),
+					Value:  r.PostFormValue("value"),
+					Sealed: r.PostFormValue("sealed") != "",
+					Claim:  r.PostFormValue("claim") != "",
+				}
+			}
+
+			err := h.IdentityStore.SetAttribute(public.String(), identity.Attribute{
+				Name:   request.Name,
+				Value:  request.Value,
+				Sealed: request.Sealed,
+				Claim:  request.Claim,
+			})
+			if err != nil {
+				writeAttributeError(w, err)
+				return
+			}
+
+			public, err = h.IdentityStore.GetIdentity(public.String())
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusCreated, newAttributesResponse(public))
+		},
+	)).ServeHTTP(w, r)
+}
+
+func (h *handler) attribute(w http.ResponseWriter, r *http.Request, id, name string) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Only DELETE requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			public, ok := h.authorizeOwner(w, r, id)
+			if !ok {
+				return
+			}
+
+			if err := h.IdentityStore.RemoveAttribute(public.String(), name); err != nil {
+				writeAttributeError(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)).ServeHTTP(w, r)
+}
+
+func newAttributesResponse(public identity.PublicIdentity) AttributesResponse {
+	response := AttributesResponse{ID: public.String(), Attributes: []AttributeResponse{}}
+	for _, a := range public.Attributes() {
+		response.Attributes = append(response.Attributes, AttributeResponse{
+			Name:   a.Name,
+			Value:  a.Value,
+			Sealed: a.Sealed,
+			Claim:  a.Claim,
+		})
+	}
+	return response
+}
+
+func writeAttributeError(w http.ResponseWriter, err error) {
+	switch err {
+	case identity.ErrorInvalidAttribute, identity.ErrorReservedClaim,
+		identity.ErrorSealedClaim, identity.ErrorInvalidEmail:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case identity.ErrorUnknownAttribute:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("error while updating attributes: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
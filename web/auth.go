@@ -44,18 +44,62 @@ func TokenFromContext(ctx context.Context) *jwt.Token {
 	return ctx.Value(tokenContextKey).(*jwt.Token)
 }
 
-func RequireTokenAuth(identity identity.PublicIdentity, h http.Handler) http.Handler {
+// SubjectFromContext returns the id of the identity the request's access token
+// was issued to.
+func SubjectFromContext(ctx context.Context) string {
+	t, ok := ctx.Value(tokenContextKey).(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	subject, _ := claims["identity"].(string)
+	return subject
+}
+
+// RolesFromContext returns the roles claimed by the request's access token.
+// Tokens issued before roles were claimed hold only the user role.
+func RolesFromContext(ctx context.Context) []string {
+	t, ok := ctx.Value(tokenContextKey).(*jwt.Token)
+	if !ok {
+		return nil
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+
+	claimed, ok := claims["roles"].([]interface{})
+	if !ok {
+		return []string{identity.RoleUser}
+	}
+
+	var roles []string
+	for _, r := range claimed {
+		if role, ok := r.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// RequireTokenAuth verifies the access token of a request, signed by the server
+// identity, before passing it on. Tokens of identities that have since been
+// disabled or deleted are rejected.
+func RequireTokenAuth(server identity.PublicIdentity, store identity.Store, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var authToken string
-		authCookie, err := r.Cookie("Authorization")
-		if err != nil {
-			authHeader := r.Header.Get("Authorization")
-			if len(authHeader) == 0 {
-				log.Println("No authorization provided")
-				log.Printf("Authorization header failed to parse: \"%s\"\n", authHeader)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			}
+		// the token has already been verified, such as by authorize
+		if _, ok := r.Context().Value(tokenContextKey).(*jwt.Token); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
 
+		// requests with an Authorization header are exempt from CSRF checks,
+		// so they must be authenticated by the header alone, never the cookie
+		var authToken string
+		if authHeader := r.Header.Get("Authorization"); len(authHeader) > 0 {
 			splitHeader := strings.Split(authHeader, " ")
 			if len(splitHeader) < 2 {
 				log.Printf("Authorization header failed to parse: \"%s\"\n", authHeader)
@@ -70,12 +114,16 @@ func RequireTokenAuth(identity identity.PublicIdentity, h http.Handler) http.Han
 			}
 			authToken = splitHeader[1]
 			log.Println("request authorization provided via header")
-		} else {
+		} else if authCookie, err := r.Cookie("Authorization"); err == nil {
 			authToken = authCookie.Value
 			log.Println("request authorization provided via cookie")
+		} else {
+			log.Println("No authorization provided")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
 
-		accessToken, err := token.Parse(identity.Ed25519PublicKey(), authToken)
+		accessToken, err := token.Parse(server, authToken)
 		if err != nil {
 			log.Printf("Token failed to parse: %s\nToken: %s\n", err.Error(), authToken)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -88,6 +136,22 @@ func RequireTokenAuth(identity identity.PublicIdentity, h http.Handler) http.Han
 			return
 		}
 
+		var subject string
+		if claims, ok := accessToken.Claims.(jwt.MapClaims); ok {
+			subject, _ = claims["identity"].(string)
+		}
+		public, err := store.GetIdentity(subject)
+		if err != nil {
+			log.Printf("Token subject %s could not be found: %s\n", subject, err.Error())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if public.Disabled() {
+			log.Printf("Token subject %s is disabled\n", subject)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		h.ServeHTTP(w, r.WithContext(
 			context.WithValue(r.Context(), tokenContextKey, accessToken),
 		))
@@ -0,0 +1,87 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// public marks methods of a route that may be requested without an access
+// token, such as logging in.
+const public = ""
+
+// policy maps the methods a route allows to the permission each requires.
+type policy map[string]string
+
+// authorize enforces a policy in front of a handler. Requests for methods the
+// policy doesn't allow are rejected, and requests for methods that require a
+// permission must carry an access token of an identity whose current roles
+// grant it. Handlers still check that identities only act on themselves where
+// that applies.
+func (h *handler) authorize(p policy, next http.Handler) http.Handler {
+	var methods []string
+	for method := range p {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	allow := strings.Join(methods, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permission, ok := p[r.Method]
+		if !ok {
+			w.Header().Set("Allow", allow)
+			http.Error(w, "Only "+allow+" requests are allowed for this endpoint.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+
+		if permission == public {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// the roles in the token are those it was issued with; the stored
+				// roles are checked so that revoked roles take effect immediately
+				subject := SubjectFromContext(r.Context())
+				stored, err := h.IdentityStore.GetIdentity(subject)
+				if err != nil || stored.Disabled() {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				permitted, err := h.IdentityStore.Permits(stored.Roles(), permission)
+				if err != nil {
+					log.Printf("error while checking permission %s: %s\n", permission, err.Error())
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if !permitted {
+					log.Printf("identity %s was denied %s\n", subject, permission)
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+			},
+		)).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,110 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+
+	// the package name is taken by the policies of routes
+	policies "github.com/akb/identify/internal/policy"
+	"github.com/akb/identify/internal/token"
+)
+
+// AuthorizeRequest asks whether the subject of an access token may perform an
+// action on a resource. Without a token, the question is asked of the
+// requester.
+type AuthorizeRequest struct {
+	Token    string                 `json:"token,omitempty"`
+	Action   string                 `json:"action"`
+	Resource string                 `json:"resource"`
+	Context  map[string]interface{} `json:"context,omitempty"`
+
+	// Explain includes how every rule was evaluated in the decision.
+	Explain bool `json:"explain,omitempty"`
+}
+
+// authorizeRequest decides a request against the stored policies. Asking
+// requires the policies:evaluate permission, which authorize checks. Denials
+// are decisions rather than errors, so both are returned with a 200 status.
+func (h *handler) authorizeRequest(w http.ResponseWriter, r *http.Request) {
+	if !hasContentType(r, "application/json") {
+		http.Error(w, "request body must be JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var request AuthorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "unable to parse request body", http.StatusBadRequest)
+		return
+	}
+	if request.Action == "" || request.Resource == "" {
+		http.Error(w, "action and resource are required", http.StatusBadRequest)
+		return
+	}
+
+	subject := TokenFromContext(r.Context())
+	if request.Token != "" {
+		var err error
+		subject, err = token.Parse(h.identity, request.Token)
+		if err != nil || !subject.Valid {
+			http.Error(w, "token is invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, ok := subject.Claims.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "token has no claims", http.StatusBadRequest)
+		return
+	}
+	id, _ := claims["identity"].(string)
+
+	// like tokens presented to RequireTokenAuth, those of deleted or disabled
+	// identities are refused as soon as they are
+	public, err := h.IdentityStore.GetIdentity(id)
+	if err != nil {
+		http.Error(w, "token subject is unknown", http.StatusBadRequest)
+		return
+	}
+	if public.Disabled() {
+		http.Error(w, "token subject is disabled", http.StatusForbidden)
+		return
+	}
+
+	decision, err := h.IdentityStore.Authorize(policies.Request{
+		Subject:  id,
+		Action:   request.Action,
+		Resource: request.Resource,
+		Claims:   claims,
+		Context:  request.Context,
+	})
+	if err != nil {
+		log.Printf("error while authorizing %s: %s\n", id, err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !request.Explain {
+		decision.Trace = nil
+	}
+	writeJSON(w, http.StatusOK, decision)
+}
@@ -0,0 +1,56 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/akb/identify/internal/did"
+	"github.com/akb/identify/internal/identity"
+)
+
+// didDocument serves the DID document of an identity given by UUID, alias or
+// did:key identifier.
+func (h *handler) didDocument(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/.well-known/did/")
+
+	public, err := h.IdentityStore.GetIdentity(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	document, err := identity.DIDDocument(public, id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	marshaled, err := json.Marshal(document)
+	if err != nil {
+		log.Printf("error while marshaling DID document: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", did.MediaType)
+	w.Write(marshaled)
+}
@@ -18,8 +18,10 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"log"
 	"mime"
 	"net/http"
 	"strings"
@@ -35,6 +37,11 @@ type Config struct {
 	Identity      identity.PrivateIdentity
 	IdentityStore identity.Store
 	TokenStore    token.Store
+
+	// Origin is the scheme, host and port WebAuthn credentials are registered
+	// to, such as "https://identify.example.com". WebAuthn is refused without
+	// one, since the host a request names can't be trusted.
+	Origin string
 }
 
 func NewHandler(c *Config) (http.Handler, error) {
@@ -49,15 +56,56 @@ func NewHandler(c *Config) (http.Handler, error) {
 		identity:      c.Identity,
 		IdentityStore: c.IdentityStore,
 		TokenStore:    c.TokenStore,
+		origin:        c.Origin,
+		ceremonies:    newCeremonies(),
 	}
 
-	h.Handle("/", RequireTokenAuth(c.Identity, http.HandlerFunc(h.dashboard)))
-	h.Handle("/tokens", http.HandlerFunc(h.tokens))
-	h.Handle("/tokens/new", http.HandlerFunc(h.tokensNew))
-	h.Handle("/identities", http.HandlerFunc(h.identities))
-	h.Handle("/identities/new", http.HandlerFunc(h.identitiesNew))
+	get, post, del := http.MethodGet, http.MethodPost, http.MethodDelete
+	self := identity.PermissionSelf
+
+	h.Handle("/", h.authorize(policy{get: self}, http.HandlerFunc(h.dashboard)))
+	h.Handle("/tokens", h.authorize(policy{post: public}, http.HandlerFunc(h.tokens)))
+	h.Handle("/tokens/new", h.authorize(policy{get: public}, http.HandlerFunc(h.tokensNew)))
+	h.Handle("/identities", h.authorize(policy{
+		get:  identity.PermissionListIdentities,
+		post: identity.PermissionCreateIdentities,
+	}, http.HandlerFunc(h.identities)))
+	h.Handle("/identities/new", h.authorize(policy{
+		get: identity.PermissionCreateIdentities,
+	}, http.HandlerFunc(h.identitiesNew)))
+	// aliases and keys of any identity may be read; the other resources of an identity
+	// are checked to belong to the requester
+	h.Handle("/identities/", h.authorize(policy{get: public, post: self, del: self},
+		http.HandlerFunc(h.identityResources)))
+	h.Handle("/authorize", h.authorize(policy{post: identity.PermissionEvaluatePolicies},
+		http.HandlerFunc(h.authorizeRequest)))
+	h.Handle("/.well-known/did/", h.authorize(policy{get: public}, http.HandlerFunc(h.didDocument)))
+	h.Handle("/log", h.authorize(policy{get: public}, http.HandlerFunc(h.logHead)))
+	h.Handle("/log/entries", h.authorize(policy{get: public}, http.HandlerFunc(h.logEntries)))
+	h.Handle("/log/inclusion", h.authorize(policy{get: public}, http.HandlerFunc(h.logInclusion)))
+	h.Handle("/log/consistency", h.authorize(policy{get: public},
+		http.HandlerFunc(h.logConsistency)))
+	h.Handle("/ssh/ca", h.authorize(policy{get: public}, http.HandlerFunc(h.sshCA)))
+	h.Handle("/ssh/certificates", h.authorize(policy{post: self}, http.HandlerFunc(h.sshCertificates)))
+	h.Handle("/passphrase", h.authorize(policy{post: public}, http.HandlerFunc(h.passphrase)))
+	h.Handle("/passphrase/edit", h.authorize(policy{get: public}, http.HandlerFunc(h.passphraseEdit)))
+	h.Handle("/recover", h.authorize(policy{post: public}, http.HandlerFunc(h.recover)))
+	h.Handle("/recover/new", h.authorize(policy{get: public}, http.HandlerFunc(h.recoverNew)))
+	h.Handle("/webauthn/register", h.requireOrigin(h.authorize(policy{get: self, post: self},
+		http.HandlerFunc(h.webauthnRegister))))
+	h.Handle("/webauthn/register/options", h.requireOrigin(h.authorize(policy{post: self},
+		http.HandlerFunc(h.webauthnRegisterOptions))))
+	h.Handle("/webauthn/login", h.requireOrigin(h.authorize(policy{get: public, post: public},
+		http.HandlerFunc(h.webauthnLogin))))
+	h.Handle("/webauthn/login/options", h.requireOrigin(h.authorize(policy{post: public},
+		http.HandlerFunc(h.webauthnLoginOptions))))
 
 	csrfHandler := nosurf.New(h)
+	// requests authorized by a header rather than a cookie can't be forged by
+	// another site; RequireTokenAuth ignores the cookie of such requests
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+	})
 	csrfHandler.SetFailureHandler(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			message := fmt.Sprintln("<h1>Bad Request</h1>") +
@@ -85,6 +133,10 @@ type handler struct {
 
 	identity identity.PrivateIdentity
 
+	// origin of WebAuthn credentials; WebAuthn is disabled without one
+	origin     string
+	ceremonies *ceremonies
+
 	IdentityStore identity.Store
 	TokenStore    token.Store
 }
@@ -106,3 +158,29 @@ func hasContentType(r *http.Request, mimetype string) bool {
 	}
 	return false
 }
+
+func acceptsJSON(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, _, err := mime.ParseMediaType(v)
+		if err != nil {
+			continue
+		}
+		if t == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	marshaled, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("error while marshaling json response: %s\n", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(marshaled)
+}
@@ -20,14 +20,16 @@ package web
 import (
 	"log"
 	"net/http"
-	"strings"
 
 	"github.com/justinas/nosurf"
+
+	"github.com/akb/identify/internal/identity"
 )
 
 type NewIdentityPage struct {
 	*Page
-	ID string
+	ID            string
+	RecoveryCodes []string
 }
 
 type NewIdentityRequest struct {
@@ -60,29 +62,49 @@ func (h *handler) identitiesNew(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) identities(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.Header().Set("Allow", "POST")
-		http.Error(w, "Only POST requests are allowed for this endpoint.",
+	switch r.Method {
+	case http.MethodGet:
+		h.listIdentities(w, r)
+	case http.MethodPost:
+		h.createIdentity(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST requests are allowed for this endpoint.",
 			http.StatusMethodNotAllowed)
 	}
+}
 
+func (h *handler) createIdentity(w http.ResponseWriter, r *http.Request) {
 	if !hasContentType(r, "application/x-www-form-urlencoded") &&
 		!hasContentType(r, "multipart/form-data") {
 		http.Error(w, "unable to parse request body", http.StatusBadRequest)
 		return
 	}
 
-	alias := r.PostFormValue("alias")
-	var aliases []string
-	for _, a := range strings.Split(alias, ",") {
-		aliases = append(aliases, strings.TrimSpace(a))
+	aliases := identity.SplitAliases(r.PostFormValue("alias"))
+	for _, a := range aliases {
+		if err := identity.ValidateAlias(a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
 	passphrase := r.PostFormValue("passphrase")
 
-	public, _, err := h.IdentityStore.NewIdentity(passphrase, aliases)
+	public, private, err := h.IdentityStore.NewIdentity(passphrase, aliases)
 	if err != nil {
 		log.Printf("error creating new identity: %s\n", err.Error())
+		if _, ok := err.(identity.ErrorAliasTaken); ok {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := h.IdentityStore.GenerateRecoveryCodes(private)
+	if err != nil {
+		log.Printf("error generating recovery codes: %s\n", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -94,7 +116,8 @@ func (h *handler) identities(w http.ResponseWriter, r *http.Request) {
 			Title:        "identify",
 			CSRFToken:    nosurf.Token(r),
 		},
-		ID: public.String(),
+		ID:            public.String(),
+		RecoveryCodes: codes,
 	}
 
 	if err := h.ExecuteTemplate(w, "new-identity", page); err != nil {
@@ -0,0 +1,109 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/justinas/nosurf"
+
+	"github.com/akb/identify/internal/identity"
+)
+
+type IdentitySummary struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+type ListIdentitiesResponse struct {
+	Identities []IdentitySummary `json:"identities"`
+	Next       string            `json:"next,omitempty"`
+}
+
+type IdentitiesPage struct {
+	*Page
+	Identities []IdentitySummary
+	NextURL    string
+}
+
+// listIdentities serves a page of identities, filtered by the prefix and alias
+// query parameters and continued from the cursor parameter. Listing requires
+// the identities:list permission, which authorize checks.
+func (h *handler) listIdentities(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := identity.ListOptions{
+		Prefix: query.Get("prefix"),
+		Alias:  query.Get("alias"),
+		Cursor: query.Get("cursor"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit < 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	identities, next, err := h.IdentityStore.ListIdentities(options)
+	if err != nil {
+		log.Printf("error while listing identities: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListIdentitiesResponse{
+		Identities: []IdentitySummary{},
+		Next:       next,
+	}
+	for _, i := range identities {
+		response.Identities = append(response.Identities, IdentitySummary{
+			ID:       i.String(),
+			Aliases:  i.Aliases(),
+			Disabled: i.Disabled(),
+		})
+	}
+
+	if acceptsJSON(r) {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	page := &IdentitiesPage{
+		Page: &Page{
+			Encoding:     "utf-8",
+			LanguageCode: "en",
+			Title:        "identify",
+			CSRFToken:    nosurf.Token(r),
+		},
+		Identities: response.Identities,
+	}
+	if next != "" {
+		query.Set("cursor", next)
+		page.NextURL = (&url.URL{Path: "/identities", RawQuery: query.Encode()}).String()
+	}
+
+	if err := h.ExecuteTemplate(w, "identities", page); err != nil {
+		log.Printf("error while rendering identity list: %s\n", err.Error())
+		http.Error(w, err.Error(), 500)
+	}
+}
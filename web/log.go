@@ -0,0 +1,227 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"crypto/x509"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/transparency"
+)
+
+// maxLogEntries is the most entries of the log returned by one request.
+const maxLogEntries = 1000
+
+type LogEntryResponse struct {
+	Index int    `json:"index"`
+	Leaf  string `json:"leaf"`
+}
+
+type LogEntriesResponse struct {
+	Entries []LogEntryResponse `json:"entries"`
+}
+
+type InclusionProofResponse struct {
+	Index int      `json:"index"`
+	Size  int      `json:"size"`
+	Proof []string `json:"proof"`
+}
+
+type ConsistencyProofResponse struct {
+	First  int      `json:"first"`
+	Second int      `json:"second"`
+	Proof  []string `json:"proof"`
+}
+
+type KeysResponse struct {
+	ID               string   `json:"id"`
+	DID              string   `json:"did"`
+	SealDID          string   `json:"seal-did"`
+	Aliases          []string `json:"aliases"`
+	KeyID            string   `json:"key-id"`
+	ECDSAPublicKey   string   `json:"ecdsa-public-key"`
+	Ed25519PublicKey string   `json:"ed25519-public-key"`
+	SealPublicKey    string   `json:"seal-public-key"`
+	LogIndex         int      `json:"log-index"`
+}
+
+// logHead serves the current head of the key transparency log, signed by the
+// server identity.
+func (h *handler) logHead(w http.ResponseWriter, r *http.Request) {
+	head, err := h.IdentityStore.LogHead()
+	if err != nil {
+		log.Printf("error while reading log head: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	head.Sign(h.identity.String(), h.identity.KeyID(), h.identity.Ed25519PrivateKey())
+	writeJSON(w, http.StatusOK, head)
+}
+
+// logEntries serves the leaves of the log from start up to end, at most
+// maxLogEntries at a time.
+func (h *handler) logEntries(w http.ResponseWriter, r *http.Request) {
+	head, err := h.IdentityStore.LogHead()
+	if err != nil {
+		log.Printf("error while reading log head: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	start, ok := intParam(w, r, "start", 0)
+	if !ok {
+		return
+	}
+	end, ok := intParam(w, r, "end", head.Size)
+	if !ok {
+		return
+	}
+	if end > head.Size {
+		end = head.Size
+	}
+	if end-start > maxLogEntries {
+		end = start + maxLogEntries
+	}
+
+	leaves, err := h.IdentityStore.LogEntries(start, end)
+	if err != nil {
+		writeLogError(w, err)
+		return
+	}
+
+	response := LogEntriesResponse{Entries: []LogEntryResponse{}}
+	for i, leaf := range leaves {
+		response.Entries = append(response.Entries,
+			LogEntryResponse{start + i, identity.EncodeToString(leaf)})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *handler) logInclusion(w http.ResponseWriter, r *http.Request) {
+	index, ok := intParam(w, r, "index", -1)
+	if !ok {
+		return
+	}
+	size, ok := intParam(w, r, "size", -1)
+	if !ok {
+		return
+	}
+
+	proof, err := h.IdentityStore.LogInclusionProof(index, size)
+	if err != nil {
+		writeLogError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK,
+		InclusionProofResponse{index, size, transparency.EncodeProof(proof)})
+}
+
+func (h *handler) logConsistency(w http.ResponseWriter, r *http.Request) {
+	first, ok := intParam(w, r, "first", -1)
+	if !ok {
+		return
+	}
+	second, ok := intParam(w, r, "second", -1)
+	if !ok {
+		return
+	}
+
+	proof, err := h.IdentityStore.LogConsistencyProof(first, second)
+	if err != nil {
+		writeLogError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK,
+		ConsistencyProofResponse{first, second, transparency.EncodeProof(proof)})
+}
+
+// keys serves the current public keys of an identity, along with the index of
+// the log entry recording them.
+func (h *handler) keys(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Only GET requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	public, err := h.IdentityStore.GetIdentity(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	index, err := h.IdentityStore.LogIndex(public.String())
+	if err != nil {
+		log.Printf("error while finding %s in the log: %s\n", public, err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	marshaledECDSAPublicKey, err := x509.MarshalPKIXPublicKey(public.ECDSAPublicKey())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	seal := public.SealPublicKey()
+
+	writeJSON(w, http.StatusOK, KeysResponse{
+		ID:               public.String(),
+		DID:              identity.DID(public),
+		SealDID:          identity.SealDID(public),
+		Aliases:          public.Aliases(),
+		KeyID:            public.KeyID(),
+		ECDSAPublicKey:   identity.EncodeToString(marshaledECDSAPublicKey),
+		Ed25519PublicKey: identity.EncodeToString(public.Ed25519PublicKey()),
+		SealPublicKey:    identity.EncodeToString(seal[:]),
+		LogIndex:         index,
+	})
+}
+
+// intParam reads an integer query parameter, writing an error response if it
+// isn't one. Parameters without a default, given as -1, are required.
+func intParam(w http.ResponseWriter, r *http.Request, name string, fallback int) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		if fallback < 0 {
+			http.Error(w, name+" is required", http.StatusBadRequest)
+			return 0, false
+		}
+		return fallback, true
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		http.Error(w, name+" must be a non-negative integer", http.StatusBadRequest)
+		return 0, false
+	}
+	return parsed, true
+}
+
+func writeLogError(w http.ResponseWriter, err error) {
+	if _, ok := err.(transparency.ErrorOutOfRange); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("error while reading log: %s\n", err.Error())
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
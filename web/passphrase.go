@@ -0,0 +1,129 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/justinas/nosurf"
+)
+
+type PassphraseChangedPage struct {
+	*Page
+	ID string
+
+	// RecoveryCodesDropped is set if the identity had recovery codes, which no
+	// longer work once the passphrase is changed.
+	RecoveryCodesDropped bool
+}
+
+func (h *handler) passphraseEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Only GET requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := &Page{
+		Encoding:     "utf-8",
+		LanguageCode: "en",
+		Title:        "identify",
+		CSRFToken:    nosurf.Token(r),
+	}
+
+	if err := h.ExecuteTemplate(w, "change-passphrase-form", page); err != nil {
+		log.Printf("error while rendering change passphrase form: %s\n", err.Error())
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func (h *handler) passphrase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PostFormValue("id")
+	passphrase := r.PostFormValue("passphrase")
+	newPassphrase := r.PostFormValue("new-passphrase")
+
+	if len(newPassphrase) == 0 {
+		http.Error(w, "A new passphrase must be provided", http.StatusBadRequest)
+		return
+	}
+
+	if newPassphrase != r.PostFormValue("confirm-passphrase") {
+		http.Error(w, "Passphrases do not match", http.StatusBadRequest)
+		return
+	}
+
+	public, err := h.IdentityStore.GetIdentity(id)
+	if err != nil {
+		log.Printf("error while retrieving identity: %s\n", err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if public.Disabled() {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	private, err := public.Authenticate(passphrase)
+	if err != nil {
+		log.Printf("error while decrypting private identity: %s\n", err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// changing the passphrase drops the recovery codes, so it takes the same
+	// factors as logging in
+	if private.TOTPEnrolled() {
+		if err := h.IdentityStore.VerifyTOTP(private, r.PostFormValue("code")); err != nil {
+			log.Printf("error while verifying authentication code: %s\n", err.Error())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	hadRecoveryCodes := private.RecoveryCodes() > 0
+	if err := h.IdentityStore.ChangePassphrase(private, newPassphrase); err != nil {
+		log.Printf("error while changing passphrase: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	page := &PassphraseChangedPage{
+		Page: &Page{
+			Encoding:     "utf-8",
+			LanguageCode: "en",
+			Title:        "identify",
+			CSRFToken:    nosurf.Token(r),
+		},
+		ID:                   public.String(),
+		RecoveryCodesDropped: hadRecoveryCodes,
+	}
+
+	if err := h.ExecuteTemplate(w, "passphrase-changed", page); err != nil {
+		log.Printf("error while rendering passphrase changed page: %s\n", err.Error())
+		http.Error(w, err.Error(), 500)
+	}
+}
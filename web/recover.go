@@ -0,0 +1,93 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/justinas/nosurf"
+)
+
+func (h *handler) recoverNew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Only GET requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := &Page{
+		Encoding:     "utf-8",
+		LanguageCode: "en",
+		Title:        "identify",
+		CSRFToken:    nosurf.Token(r),
+	}
+
+	if err := h.ExecuteTemplate(w, "recover-form", page); err != nil {
+		log.Printf("error while rendering recover form: %s\n", err.Error())
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// recover sets a new passphrase for an identity using one of its recovery codes
+// in place of the current passphrase.
+func (h *handler) recover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PostFormValue("id")
+	code := r.PostFormValue("recovery-code")
+	newPassphrase := r.PostFormValue("new-passphrase")
+
+	if len(newPassphrase) == 0 {
+		http.Error(w, "A new passphrase must be provided", http.StatusBadRequest)
+		return
+	}
+
+	if newPassphrase != r.PostFormValue("confirm-passphrase") {
+		http.Error(w, "Passphrases do not match", http.StatusBadRequest)
+		return
+	}
+
+	private, err := h.IdentityStore.Recover(id, code, newPassphrase)
+	if err != nil {
+		log.Printf("error while recovering identity: %s\n", err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	page := &PassphraseChangedPage{
+		Page: &Page{
+			Encoding:     "utf-8",
+			LanguageCode: "en",
+			Title:        "identify",
+			CSRFToken:    nosurf.Token(r),
+		},
+		ID: private.String(),
+	}
+
+	if err := h.ExecuteTemplate(w, "passphrase-changed", page); err != nil {
+		log.Printf("error while rendering passphrase changed page: %s\n", err.Error())
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -0,0 +1,136 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/akb/identify/internal/identity"
+)
+
+// NewSSHCertificateRequest asks for an SSH certificate of a public key, given
+// in authorized_keys format. Validity is in seconds.
+type NewSSHCertificateRequest struct {
+	Type            string   `json:"type"`
+	PublicKey       string   `json:"public-key"`
+	Principals      []string `json:"principals,omitempty"`
+	Validity        int      `json:"validity,omitempty"`
+	ForceCommand    string   `json:"force-command,omitempty"`
+	SourceAddresses []string `json:"source-addresses,omitempty"`
+}
+
+// SSHCertificateResponse holds a certificate in authorized_keys format, as
+// written to the -cert.pub file next to a key, along with its contents.
+type SSHCertificateResponse struct {
+	Certificate string    `json:"certificate"`
+	Serial      uint64    `json:"serial"`
+	KeyID       string    `json:"key-id"`
+	Principals  []string  `json:"principals"`
+	ValidAfter  time.Time `json:"valid-after"`
+	ValidBefore time.Time `json:"valid-before"`
+}
+
+// NewSSHCertificateResponse describes a certificate signed by an SSH
+// certificate authority.
+func NewSSHCertificateResponse(c *ssh.Certificate) SSHCertificateResponse {
+	return SSHCertificateResponse{
+		Certificate: string(ssh.MarshalAuthorizedKey(c)),
+		Serial:      c.Serial,
+		KeyID:       c.KeyId,
+		Principals:  c.ValidPrincipals,
+		ValidAfter:  time.Unix(int64(c.ValidAfter), 0).UTC(),
+		ValidBefore: time.Unix(int64(c.ValidBefore), 0).UTC(),
+	}
+}
+
+// sshCertificates issues SSH certificates signed by the server identity to
+// the requester. Whether the requester may name the principals or request a
+// host certificate is checked by the identity store.
+func (h *handler) sshCertificates(w http.ResponseWriter, r *http.Request) {
+	if !hasContentType(r, "application/json") {
+		http.Error(w, "request body must be JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var request NewSSHCertificateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "unable to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(request.PublicKey))
+	if err != nil {
+		http.Error(w, "public key must be in authorized_keys format", http.StatusBadRequest)
+		return
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		http.Error(w, "public key must not be a certificate", http.StatusBadRequest)
+		return
+	}
+
+	subject := SubjectFromContext(r.Context())
+	certificate, err := h.IdentityStore.IssueSSHCertificate(h.identity, subject,
+		identity.SSHCertificateRequest{
+			Type:            request.Type,
+			PublicKey:       key,
+			Principals:      request.Principals,
+			Validity:        time.Duration(request.Validity) * time.Second,
+			ForceCommand:    request.ForceCommand,
+			SourceAddresses: request.SourceAddresses,
+		})
+	if err != nil {
+		log.Printf("error while issuing ssh certificate to %s: %s\n", subject, err.Error())
+		switch err.(type) {
+		case identity.ErrorPrincipalNotAllowed, identity.ErrorSSHValidity, identity.ErrorSourceAddress:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch err {
+		case identity.ErrorSSHCertificateType, identity.ErrorNoHostPrincipals,
+			identity.ErrorHostCriticalOptions:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case identity.ErrorForbidden, identity.ErrorIdentityDisabled:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("issued ssh %s certificate %d to %s\n", request.Type, certificate.Serial, subject)
+	writeJSON(w, http.StatusCreated, NewSSHCertificateResponse(certificate))
+}
+
+// sshCA serves the public key of the server identity in authorized_keys
+// format, as trusted by sshd's TrustedUserCAKeys.
+func (h *handler) sshCA(w http.ResponseWriter, r *http.Request) {
+	line, err := identity.AuthorizedKey(h.identity)
+	if err != nil {
+		log.Printf("error while formatting ssh ca key: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(line + "\n"))
+}
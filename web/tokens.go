@@ -24,6 +24,7 @@ import (
 
 	"github.com/justinas/nosurf"
 
+	"github.com/akb/identify/internal/identity"
 	"github.com/akb/identify/internal/token"
 )
 
@@ -65,21 +66,32 @@ func (h *handler) tokens(w http.ResponseWriter, r *http.Request) {
 	id := r.PostFormValue("id")
 	passphrase := r.PostFormValue("passphrase")
 
-	identity, err := h.IdentityStore.GetIdentity(id)
+	public, err := h.IdentityStore.GetIdentity(id)
 	if err != nil {
 		log.Printf("error while retrieving identity: %s\n", err.Error())
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	_, err = identity.Authenticate(passphrase)
+	private, err := public.Authenticate(passphrase)
 	if err != nil {
 		log.Printf("error while decrypting private identity: %s\n", err.Error())
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	access, err := h.TokenStore.New(h.identity)
+	methods := []string{identity.MethodPassphrase}
+	if private.TOTPEnrolled() {
+		if err := h.IdentityStore.VerifyTOTP(private, r.PostFormValue("code")); err != nil {
+			log.Printf("error while verifying authentication code: %s\n", err.Error())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		methods = append(methods, identity.MethodOTP, identity.MethodMultiple)
+	}
+
+	access, err := h.TokenStore.New(h.identity, public,
+		map[string]interface{}{"amr": methods})
 	if err != nil {
 		log.Printf("error while creating token: %s\n", err.Error())
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -109,6 +121,17 @@ func (h *handler) tokens(w http.ResponseWriter, r *http.Request) {
 		Token: access,
 	}
 
+	setAuthorizationCookie(w, access)
+
+	if err := h.ExecuteTemplate(w, "new-token", page); err != nil {
+		log.Printf("error while rendering new token page: %s\n", err.Error())
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// setAuthorizationCookie stores an access token in the cookie read by
+// RequireTokenAuth.
+func setAuthorizationCookie(w http.ResponseWriter, access string) {
 	expires := time.Now().Add(token.AccessMaxAge)
 	cookie := http.Cookie{
 		Name:     "Authorization",
@@ -119,9 +142,4 @@ func (h *handler) tokens(w http.ResponseWriter, r *http.Request) {
 		HttpOnly: true,
 	}
 	http.SetCookie(w, &cookie)
-
-	if err := h.ExecuteTemplate(w, "new-token", page); err != nil {
-		log.Printf("error while rendering new token page: %s\n", err.Error())
-		http.Error(w, err.Error(), 500)
-	}
 }
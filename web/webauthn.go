@@ -0,0 +1,585 @@
+// Identify authentication and authorization service
+//
+// Copyright (C) 2020 Alexei Broner
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/justinas/nosurf"
+
+	"github.com/akb/identify/internal/identity"
+	"github.com/akb/identify/internal/webauthn"
+)
+
+// ceremonyTimeout is how long an authenticator has to respond to a challenge.
+const ceremonyTimeout = 5 * time.Minute
+
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+type ceremony struct {
+	kind      string
+	identity  string
+	challenge []byte
+	expires   time.Time
+}
+
+// ceremonies holds the challenges issued to authenticators until they are
+// answered. Each challenge may only be answered once.
+type ceremonies struct {
+	sync.Mutex
+	pending map[string]ceremony
+}
+
+func newCeremonies() *ceremonies {
+	return &ceremonies{pending: map[string]ceremony{}}
+}
+
+func (c *ceremonies) begin(kind, id string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for key, pending := range c.pending {
+		if now.After(pending.expires) {
+			delete(c.pending, key)
+		}
+	}
+
+	c.pending[webauthn.Encoding.EncodeToString(challenge)] = ceremony{
+		kind:      kind,
+		identity:  id,
+		challenge: challenge,
+		expires:   now.Add(ceremonyTimeout),
+	}
+	return challenge, nil
+}
+
+// finish returns the pending ceremony of the challenge in client data.
+func (c *ceremonies) finish(kind, id string, clientDataJSON []byte) (*ceremony, bool) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return nil, false
+	}
+	key := webauthn.Encoding.EncodeToString(challenge)
+
+	c.Lock()
+	defer c.Unlock()
+
+	pending, ok := c.pending[key]
+	if !ok {
+		return nil, false
+	}
+	delete(c.pending, key)
+
+	if pending.kind != kind || pending.identity != id || time.Now().After(pending.expires) {
+		return nil, false
+	}
+	return &pending, true
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CredentialCreationOptions are passed to navigator.credentials.create as the
+// publicKey option once challenge, user.id and the excluded credential ids
+// have been decoded from base64url.
+type CredentialCreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RelyingParty           RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Parameters             []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// CredentialRequestOptions are passed to navigator.credentials.get as the
+// publicKey option once challenge and the allowed credential ids have been
+// decoded from base64url.
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RelyingPartyID   string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	Timeout          int64                  `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AuthenticatorResponse holds the base64url encoded fields of the response of
+// an authenticator to either ceremony.
+type AuthenticatorResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject,omitempty"`
+	AuthenticatorData string `json:"authenticatorData,omitempty"`
+	Signature         string `json:"signature,omitempty"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// PublicKeyCredential is a credential returned by the browser, with its
+// response encoded as base64url.
+type PublicKeyCredential struct {
+	ID       string                `json:"id"`
+	Type     string                `json:"type"`
+	Response AuthenticatorResponse `json:"response"`
+}
+
+type LoginOptionsRequest struct {
+	Identity string `json:"identity"`
+}
+
+type LoginRequest struct {
+	Identity   string              `json:"identity"`
+	Credential PublicKeyCredential `json:"credential"`
+}
+
+type CredentialResponse struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+type CredentialsResponse struct {
+	ID          string               `json:"id"`
+	Credentials []CredentialResponse `json:"credentials"`
+}
+
+// requireOrigin refuses WebAuthn requests unless an origin is configured.
+func (h *handler) requireOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.origin == "" {
+			http.Error(w, "Passkeys are not enabled on this server", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// relyingParty returns the site credentials are registered to: the configured
+// origin.
+func (h *handler) relyingParty() webauthn.RelyingParty {
+	var id string
+	if u, err := url.Parse(h.origin); err == nil {
+		id = u.Hostname()
+	}
+	return webauthn.RelyingParty{ID: id, Origin: h.origin}
+}
+
+// webauthnRegister serves the passkey registration page, and registers the
+// credential created by an authenticator.
+func (h *handler) webauthnRegister(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				h.renderWebAuthnPage(w, r, "webauthn-register")
+				return
+			}
+
+			public, ok := h.authorizeOwner(w, r, SubjectFromContext(r.Context()))
+			if !ok {
+				return
+			}
+
+			var request PublicKeyCredential
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "unable to parse request body", http.StatusBadRequest)
+				return
+			}
+
+			clientDataJSON, err1 := webauthn.Encoding.DecodeString(request.Response.ClientDataJSON)
+			attestationObject, err2 := webauthn.Encoding.DecodeString(request.Response.AttestationObject)
+			if err1 != nil || err2 != nil {
+				http.Error(w, "unable to parse request body", http.StatusBadRequest)
+				return
+			}
+
+			pending, ok := h.ceremonies.finish(ceremonyRegistration, public.String(), clientDataJSON)
+			if !ok {
+				http.Error(w, "Registration has expired or was not started", http.StatusBadRequest)
+				return
+			}
+
+			credential, err := h.relyingParty().VerifyRegistration(
+				pending.challenge, clientDataJSON, attestationObject)
+			if err != nil {
+				log.Printf("error while verifying webauthn registration: %s\n", err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := h.IdentityStore.AddCredential(public.String(), *credential); err != nil {
+				writeCredentialError(w, err)
+				return
+			}
+
+			writeJSON(w, http.StatusCreated, newCredentialResponse(*credential))
+		},
+	)).ServeHTTP(w, r)
+}
+
+// webauthnRegisterOptions begins registering a credential for the identity the
+// request's access token was issued to.
+func (h *handler) webauthnRegisterOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			public, ok := h.authorizeOwner(w, r, SubjectFromContext(r.Context()))
+			if !ok {
+				return
+			}
+
+			challenge, err := h.ceremonies.begin(ceremonyRegistration, public.String())
+			if err != nil {
+				log.Printf("error while creating challenge: %s\n", err.Error())
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			id, err := uuid.Parse(public.String())
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			name := public.String()
+			if aliases := public.Aliases(); len(aliases) > 0 {
+				name = aliases[0]
+			}
+
+			rp := h.relyingParty()
+			options := CredentialCreationOptions{
+				Challenge:    webauthn.Encoding.EncodeToString(challenge),
+				RelyingParty: RelyingPartyEntity{ID: rp.ID, Name: "identify"},
+				User: UserEntity{
+					ID:          webauthn.Encoding.EncodeToString(id[:]),
+					Name:        name,
+					DisplayName: name,
+				},
+				Timeout:            ceremonyTimeout.Milliseconds(),
+				Attestation:        "none",
+				ExcludeCredentials: credentialDescriptors(public),
+				AuthenticatorSelection: AuthenticatorSelection{
+					ResidentKey:      "preferred",
+					UserVerification: "required",
+				},
+			}
+			for _, alg := range webauthn.Algorithms {
+				options.Parameters = append(options.Parameters,
+					CredentialParameter{Type: "public-key", Algorithm: alg})
+			}
+
+			writeJSON(w, http.StatusOK, options)
+		},
+	)).ServeHTTP(w, r)
+}
+
+// webauthnLogin serves the passkey login page, and issues an access token for
+// an assertion signed by one of an identity's credentials. Like a token issued
+// for a passphrase, the token is also set as the Authorization cookie.
+func (h *handler) webauthnLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.renderWebAuthnPage(w, r, "webauthn-login")
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "unable to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	response := request.Credential.Response
+	credentialID, err1 := webauthn.Encoding.DecodeString(request.Credential.ID)
+	clientDataJSON, err2 := webauthn.Encoding.DecodeString(response.ClientDataJSON)
+	authenticatorData, err3 := webauthn.Encoding.DecodeString(response.AuthenticatorData)
+	signature, err4 := webauthn.Encoding.DecodeString(response.Signature)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		http.Error(w, "unable to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	public, err := h.IdentityStore.GetIdentity(request.Identity)
+	if err != nil {
+		log.Printf("error while retrieving identity: %s\n", err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	pending, ok := h.ceremonies.finish(ceremonyLogin, public.String(), clientDataJSON)
+	if !ok {
+		log.Printf("webauthn login for %s has expired or was not started\n", public)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var credential *webauthn.Credential
+	for _, c := range public.Credentials() {
+		if webauthn.Encoding.EncodeToString(c.ID) == request.Credential.ID {
+			credential = &c
+			break
+		}
+	}
+	if credential == nil {
+		log.Printf("unknown webauthn credential for identity: %s\n", public)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := h.relyingParty().VerifyAssertion(*credential,
+		pending.challenge, clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		log.Printf("error while verifying webauthn assertion: %s\n", err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// user presence alone only proves someone is holding the authenticator; a
+	// login must also be verified with a pin or biometric, standing in for the
+	// passphrase and any second factor
+	if !data.UserVerified() {
+		log.Printf("webauthn login for %s was not verified by the authenticator\n", public)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = h.IdentityStore.UpdateSignCount(public.String(), credentialID, data.SignCount)
+	if err != nil {
+		log.Printf("error while updating signature counter: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// an authenticator that verified its user with a pin or biometric is a
+	// second factor in itself
+	methods := []string{identity.MethodHardwareKey, identity.MethodMultiple}
+
+	access, err := h.TokenStore.New(h.identity, public,
+		map[string]interface{}{"amr": methods})
+	if err != nil {
+		log.Printf("error while creating token: %s\n", err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	log.Printf("new token created for user with webauthn: %s\n", public)
+
+	setAuthorizationCookie(w, access)
+	writeJSON(w, http.StatusCreated, NewTokenResponse{access})
+}
+
+// webauthnLoginOptions begins a login by asking for an assertion from one of an
+// identity's credentials.
+func (h *handler) webauthnLoginOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request LoginOptionsRequest
+	if hasContentType(r, "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "unable to parse request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		request.Identity = r.PostFormValue("identity")
+	}
+
+	public, err := h.IdentityStore.GetIdentity(request.Identity)
+	if err != nil || public.Disabled() || len(public.Credentials()) == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	challenge, err := h.ceremonies.begin(ceremonyLogin, public.String())
+	if err != nil {
+		log.Printf("error while creating challenge: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CredentialRequestOptions{
+		Challenge:        webauthn.Encoding.EncodeToString(challenge),
+		RelyingPartyID:   h.relyingParty().ID,
+		AllowCredentials: credentialDescriptors(public),
+		Timeout:          ceremonyTimeout.Milliseconds(),
+		UserVerification: "required",
+	})
+}
+
+// credentials lists the WebAuthn credentials registered to an identity.
+func (h *handler) credentials(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Only GET requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			public, ok := h.authorizeOwner(w, r, id)
+			if !ok {
+				return
+			}
+
+			response := CredentialsResponse{
+				ID:          public.String(),
+				Credentials: []CredentialResponse{},
+			}
+			for _, c := range public.Credentials() {
+				response.Credentials = append(response.Credentials, newCredentialResponse(c))
+			}
+			writeJSON(w, http.StatusOK, response)
+		},
+	)).ServeHTTP(w, r)
+}
+
+func (h *handler) credential(w http.ResponseWriter, r *http.Request, id, credentialID string) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Only DELETE requests are allowed for this endpoint.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	RequireTokenAuth(h.identity, h.IdentityStore, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			public, ok := h.authorizeOwner(w, r, id)
+			if !ok {
+				return
+			}
+
+			decoded, err := webauthn.Encoding.DecodeString(credentialID)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+
+			if err := h.IdentityStore.RemoveCredential(public.String(), decoded); err != nil {
+				writeCredentialError(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)).ServeHTTP(w, r)
+}
+
+func (h *handler) renderWebAuthnPage(w http.ResponseWriter, r *http.Request, name string) {
+	page := &Page{
+		Encoding:     "utf-8",
+		LanguageCode: "en",
+		Title:        "identify",
+		CSRFToken:    nosurf.Token(r),
+	}
+
+	if err := h.ExecuteTemplate(w, name, page); err != nil {
+		log.Printf("error while rendering %s page: %s\n", name, err.Error())
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func credentialDescriptors(public identity.PublicIdentity) []CredentialDescriptor {
+	descriptors := []CredentialDescriptor{}
+	for _, c := range public.Credentials() {
+		descriptors = append(descriptors, CredentialDescriptor{
+			Type: "public-key",
+			ID:   webauthn.Encoding.EncodeToString(c.ID),
+		})
+	}
+	return descriptors
+}
+
+func newCredentialResponse(c webauthn.Credential) CredentialResponse {
+	return CredentialResponse{
+		ID:      webauthn.Encoding.EncodeToString(c.ID),
+		Created: c.Created,
+	}
+}
+
+func writeCredentialError(w http.ResponseWriter, err error) {
+	switch err {
+	case identity.ErrorCredentialExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case identity.ErrorUnknownCredential:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("error while updating credentials: %s\n", err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}